@@ -51,6 +51,25 @@ func BindAppHooks(app core.App, loadCerts bool) {
 		routes.RegisterAuthRoutes(se)
 		routes.RegisterAIRoutes(se)
 		routes.RegisterConversationRoutes(se)
+		routes.RegisterConversationTagRoutes(se)
+		routes.RegisterDocumentRoutes(se)
+		routes.RegisterShareRoutes(se)
+		routes.RegisterCommentRoutes(se)
+
+		// Keep the chunks used for document retrieval in sync in the background
+		services.InitializeDocumentIndexer(se.App)
+
+		// Relay real-time edits between the clients of a document and save them periodically
+		services.InitializeCollaboration(se.App)
+
+		// Delete archived conversations once their retention period is over
+		services.InitializeConversationPurge(se.App)
+
+		// Store the links of documents written before links between documents were tracked
+		services.InitializeDocumentLinks(se.App)
+
+		// Delete documents once they have been in the trash for the retention period
+		services.InitializeDocumentTrashPurge(se.App)
 
 		// // Load TLS certificate
 		// if loadCerts {
@@ -83,8 +102,158 @@ func BindAppHooks(app core.App, loadCerts bool) {
 			return err
 		}
 
+		before := e.Record.Original().GetString("content")
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		// Edits count as writing for the user who made them, whether they own the document
+		// or edit it as a collaborator. Saves made by the server do not go through here.
+		if e.Auth != nil && !e.HasSuperuserAuth() {
+			if err := services.RecordDocumentWriting(e.App, e.Auth.Id, e.Record.Id, before, e.Record.GetString("content")); err != nil {
+				log.Printf("Failed to record writing activity of document %s: %v", e.Record.Id, err)
+			}
+		}
+
+		return nil
+	})
+
+	// Deleting a document through the API moves it to the trash with everything below it,
+	// from where it is restored or deleted permanently
+	app.OnRecordDeleteRequest("documents").BindFunc(func(e *core.RecordRequestEvent) error {
+		if _, err := services.TrashDocument(e.App, e.Record.Id); err != nil {
+			return e.InternalServerError("Failed to move document to the trash", err)
+		}
+
+		return e.NoContent(http.StatusNoContent)
+	})
+
+	// New documents, and documents moved to another folder without a position, go last
+	app.OnRecordCreate("documents").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("order_key") == "" {
+			if err := services.AppendDocumentOrderKey(e.App, e.Record); err != nil {
+				return err
+			}
+		}
+
 		return e.Next()
 	})
+
+	app.OnRecordUpdate("documents").BindFunc(func(e *core.RecordEvent) error {
+		original := e.Record.Original()
+		if e.Record.GetString("parent") != original.GetString("parent") && e.Record.GetString("order_key") == original.GetString("order_key") {
+			if err := services.AppendDocumentOrderKey(e.App, e.Record); err != nil {
+				return err
+			}
+		}
+
+		return e.Next()
+	})
+
+	app.OnRecordAfterCreateSuccess("documents").BindFunc(func(e *core.RecordEvent) error {
+		services.EnqueueDocumentIndex(e.Record.Id)
+
+		// Wikilinks to the title of a new document find it
+		if err := services.SyncDocumentLinks(e.App, e.Record.Id); err != nil {
+			log.Printf("Failed to sync links of document %s: %v", e.Record.Id, err)
+		}
+		if err := queries.ResolveWikilinks(e.App, e.Record.GetString("user"), e.Record.GetString("title"), e.Record.Id); err != nil {
+			log.Printf("Failed to resolve links to document %s: %v", e.Record.Id, err)
+		}
+
+		// Documents created in a shared folder inherit its collaborators
+		if e.Record.GetString("parent") != "" {
+			if err := services.SyncDocumentAccess(e.App, e.Record.Id); err != nil {
+				log.Printf("Failed to sync access of document %s: %v", e.Record.Id, err)
+			}
+		}
+
+		return e.Next()
+	})
+
+	app.OnRecordAfterUpdateSuccess("documents").BindFunc(func(e *core.RecordEvent) error {
+		services.EnqueueDocumentIndex(e.Record.Id)
+
+		// Saves made outside of a collaboration session are merged into it, and comments
+		// follow the text they point at
+		if e.Record.GetString("content") != e.Record.Original().GetString("content") {
+			services.NotifyDocumentChanged(e.Record.Id, e.Record.GetString("content"))
+
+			if err := services.RemapDocumentComments(e.App, e.Record.Id, e.Record.Original().GetString("content"), e.Record.GetString("content")); err != nil {
+				log.Printf("Failed to move comments of document %s: %v", e.Record.Id, err)
+			}
+
+			if err := services.SyncDocumentLinks(e.App, e.Record.Id); err != nil {
+				log.Printf("Failed to sync links of document %s: %v", e.Record.Id, err)
+			}
+		}
+
+		// Links to a renamed document follow its new title
+		if e.Record.GetString("title") != e.Record.Original().GetString("title") {
+			if err := services.RenameDocumentLinks(e.App, e.Record.Id, e.Record.GetString("user"), e.Record.Original().GetString("title"), e.Record.GetString("title")); err != nil {
+				log.Printf("Failed to rename links to document %s: %v", e.Record.Id, err)
+			}
+		}
+
+		if e.Record.GetString("parent") != e.Record.Original().GetString("parent") {
+			if err := services.SyncDocumentAccess(e.App, e.Record.Id); err != nil {
+				log.Printf("Failed to sync access of document %s: %v", e.Record.Id, err)
+			}
+		}
+
+		return e.Next()
+	})
+
+	// Templates are checked before they are saved, so that creating documents from them
+	// only fails on missing values
+	app.OnRecordCreateRequest("document_templates").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := services.ValidateDocumentTemplate(e.Record); err != nil {
+			return e.BadRequestError("Invalid template: "+err.Error(), err)
+		}
+
+		return e.Next()
+	})
+
+	app.OnRecordUpdateRequest("document_templates").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := services.ValidateDocumentTemplate(e.Record); err != nil {
+			return e.BadRequestError("Invalid template: "+err.Error(), err)
+		}
+
+		return e.Next()
+	})
+
+	syncSharedDocument := func(e *core.RecordEvent) error {
+		documentId := e.Record.GetString("document")
+		if err := services.SyncDocumentAccess(e.App, documentId); err != nil {
+			log.Printf("Failed to sync access of document %s: %v", documentId, err)
+		}
+
+		return e.Next()
+	}
+
+	app.OnRecordAfterCreateSuccess("document_shares").BindFunc(syncSharedDocument)
+	app.OnRecordAfterUpdateSuccess("document_shares").BindFunc(syncSharedDocument)
+	app.OnRecordAfterDeleteSuccess("document_shares").BindFunc(syncSharedDocument)
+
+	// Invitations sent before the user signed up are claimed with their email address, once
+	// it is verified. Users created verified, like OAuth2 sign ups, claim them right away.
+	claimDocumentShares := func(e *core.RecordEvent) error {
+		if err := services.ClaimDocumentShares(e.App, e.Record); err != nil {
+			log.Printf("Failed to claim document shares of user %s: %v", e.Record.Id, err)
+		}
+
+		return e.Next()
+	}
+
+	app.OnRecordAfterCreateSuccess("users").BindFunc(claimDocumentShares)
+	app.OnRecordAfterUpdateSuccess("users").BindFunc(func(e *core.RecordEvent) error {
+		original := e.Record.Original()
+		if original.Verified() && original.Email() == e.Record.Email() {
+			return e.Next()
+		}
+
+		return claimDocumentShares(e)
+	})
 }
 
 func StatusHandler(e *core.RequestEvent) error {
@@ -2,7 +2,9 @@ package encryption_test
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 	"textly/authorization/encryption"
 	"time"
@@ -58,3 +60,43 @@ func TestEncryptionDecryption(t *testing.T) {
 
 	t.Log("Encryption/decryption test passed successfully")
 }
+
+func TestShareTokenRoundTrip(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "share-token-test-key")
+
+	token, err := encryption.GenerateShareToken(encryption.ShareToken{
+		LinkId:      "link",
+		DocumentId:  "document",
+		Permissions: []string{"html"},
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.ContainsAny(token, "+/=") {
+		t.Fatalf("token is not URL safe: %s", token)
+	}
+
+	decoded, err := encryption.DecryptShareToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.DocumentId != "document" || !decoded.Allows("html") || decoded.Allows("markdown") {
+		t.Fatalf("unexpected token %+v", decoded)
+	}
+
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := encryption.DecryptShareToken(string(tampered)); err == nil {
+		t.Fatalf("tampered token was accepted")
+	}
+
+	expired, err := encryption.GenerateShareToken(encryption.ShareToken{LinkId: "link", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encryption.DecryptShareToken(expired); !errors.Is(err, encryption.ErrShareTokenExpired) {
+		t.Fatalf("expected the expired error, got %v", err)
+	}
+}
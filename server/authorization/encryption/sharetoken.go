@@ -0,0 +1,84 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrShareTokenExpired is returned for share tokens past their expiry
+var ErrShareTokenExpired = errors.New("share token has expired")
+
+// ShareToken is the payload of a public document link. GCM authenticates it, so a token
+// that decrypts was issued by this server and has not been altered.
+type ShareToken struct {
+	LinkId      string   `json:"link_id"`
+	DocumentId  string   `json:"document_id"`
+	Permissions []string `json:"permissions"`
+	ExpiresAt   int64    `json:"expires_at,omitempty"`
+}
+
+// Allows reports whether the token grants the permission
+func (t ShareToken) Allows(permission string) bool {
+	for _, p := range t.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateShareToken encrypts the share token into a URL safe string
+func GenerateShareToken(token ShareToken) (string, error) {
+	key := []byte(os.Getenv("ENCRYPTION_KEY"))
+	if len(key) == 0 {
+		return "", fmt.Errorf("ENCRYPTION_KEY environment variable not set")
+	}
+
+	encrypted, err := Encrypt(token, key)
+	if err != nil {
+		return "", err
+	}
+
+	// Encrypt returns standard base64, which may contain '/' and cannot be used in a path
+	raw, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// DecryptShareToken decrypts a token made by GenerateShareToken and checks its expiry.
+// Whether the link was revoked is up to the caller.
+func DecryptShareToken(value string) (ShareToken, error) {
+	var token ShareToken
+
+	key := []byte(os.Getenv("ENCRYPTION_KEY"))
+	if len(key) == 0 {
+		return token, fmt.Errorf("ENCRYPTION_KEY environment variable not set")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return token, fmt.Errorf("invalid share token: %v", err)
+	}
+
+	decrypted, err := Decrypt(base64.StdEncoding.EncodeToString(raw), key)
+	if err != nil {
+		return token, fmt.Errorf("failed to decrypt share token: %v", err)
+	}
+
+	if err := json.Unmarshal(decrypted, &token); err != nil {
+		return token, fmt.Errorf("failed to parse share token: %v", err)
+	}
+
+	if token.ExpiresAt > 0 && time.Now().Unix() > token.ExpiresAt {
+		return token, ErrShareTokenExpired
+	}
+
+	return token, nil
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3709231855")
+		if err != nil {
+			return err
+		}
+
+		// add index for the paginated listing
+		collection.AddIndex("idx_conversations_user_updated", false, "`user`, `active`, `updated`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3709231855")
+		if err != nil {
+			return err
+		}
+
+		// remove index
+		collection.RemoveIndex("idx_conversations_user_updated")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3709231855")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(13, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "pbc_2487362218",
+			"hidden": false,
+			"id": "relation1874629670",
+			"maxSelect": 999,
+			"minSelect": 0,
+			"name": "tags",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(14, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "pbc_3332084752",
+			"hidden": false,
+			"id": "relation1147285946",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "folder",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3709231855")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("relation1874629670")
+
+		// remove field
+		collection.Fields.RemoveById("relation1147285946")
+
+		return app.Save(collection)
+	})
+}
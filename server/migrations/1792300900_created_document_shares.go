@@ -0,0 +1,143 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_3332084752",
+					"hidden": false,
+					"id": "relation1724089487",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "document",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "_pb_users_auth_",
+					"hidden": false,
+					"id": "relation2375276105",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "user",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"exceptDomains": null,
+					"hidden": false,
+					"id": "email3885137012",
+					"name": "email",
+					"onlyDomains": null,
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "email"
+				},
+				{
+					"hidden": false,
+					"id": "select1466534506",
+					"maxSelect": 1,
+					"name": "role",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "select",
+					"values": [
+						"viewer",
+						"commenter",
+						"editor"
+					]
+				},
+				{
+					"cascadeDelete": false,
+					"collectionId": "_pb_users_auth_",
+					"hidden": false,
+					"id": "relation3441806164",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "invited_by",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_1530294816",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_document_shares_document_email` + "`" + ` ON ` + "`" + `document_shares` + "`" + ` (` + "`" + `document` + "`" + `, ` + "`" + `email` + "`" + ` COLLATE NOCASE)",
+				"CREATE INDEX ` + "`" + `idx_document_shares_user` + "`" + ` ON ` + "`" + `document_shares` + "`" + ` (` + "`" + `user` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "document_shares",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1530294816")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
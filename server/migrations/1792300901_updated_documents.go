@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3332084752")
+		if err != nil {
+			return err
+		}
+
+		// viewers and editors are maintained by the server from document_shares, including
+		// the shares inherited from parent folders, so clients may never set them. Documents
+		// may only be created in or moved to folders the user can edit.
+		if err := json.Unmarshal([]byte(`{
+			"createRule": "@request.auth.id = user.id && (parent = \"\" || parent.user = @request.auth.id || parent.editors.id ?= @request.auth.id) && @request.body.viewers:isset = false && @request.body.editors:isset = false",
+			"deleteRule": "@request.auth.id = user.id",
+			"listRule": "@request.auth.id = user.id || viewers.id ?= @request.auth.id",
+			"updateRule": "(@request.auth.id = user.id || (editors.id ?= @request.auth.id && @request.body.user:isset = false && @request.body.parent:isset = false)) && (@request.body.parent:isset = false || @request.body.parent = \"\" || @request.body.parent.user = @request.auth.id || @request.body.parent.editors.id ?= @request.auth.id) && @request.body.viewers:isset = false && @request.body.editors:isset = false",
+			"viewRule": "@request.auth.id = user.id || viewers.id ?= @request.auth.id"
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "_pb_users_auth_",
+			"hidden": false,
+			"id": "relation3216904102",
+			"maxSelect": 999,
+			"minSelect": 0,
+			"name": "viewers",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "_pb_users_auth_",
+			"hidden": false,
+			"id": "relation1452539620",
+			"maxSelect": 999,
+			"minSelect": 0,
+			"name": "editors",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3332084752")
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(`{
+			"createRule": "@request.auth.id = user.id",
+			"deleteRule": "@request.auth.id = user.id",
+			"listRule": "@request.auth.id = user.id",
+			"updateRule": "@request.auth.id = user.id",
+			"viewRule": "@request.auth.id = user.id"
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("relation3216904102")
+
+		// remove field
+		collection.Fields.RemoveById("relation1452539620")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1852930744")
+		if err != nil {
+			return err
+		}
+
+		// add parent field (self-referencing relation), replies point at the first comment
+		// of their thread and are deleted with it
+		if err := collection.Fields.AddMarshaledJSONAt(3, []byte(`{
+			"cascadeDelete": true,
+			"collectionId": "pbc_1852930744",
+			"hidden": false,
+			"id": "relation1032740943",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "parent",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		collection.AddIndex("idx_document_comments_parent", false, "`parent`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1852930744")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_document_comments_parent")
+		collection.Fields.RemoveById("relation1032740943")
+
+		return app.Save(collection)
+	})
+}
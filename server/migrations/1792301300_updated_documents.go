@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3332084752")
+		if err != nil {
+			return err
+		}
+
+		// add order_key field, a fractional key that sorts the documents of a folder. Keys
+		// compare as plain strings, so one can always be made between two others.
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3281459135",
+			"max": 0,
+			"min": 0,
+			"name": "order_key",
+			"pattern": "^[0-9A-Za-z]*$",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		collection.AddIndex("idx_documents_parent_order", false, "`parent`, `order_key`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3332084752")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_documents_parent_order")
+		collection.Fields.RemoveById("text3281459135")
+
+		return app.Save(collection)
+	})
+}
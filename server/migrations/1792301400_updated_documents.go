@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3332084752")
+		if err != nil {
+			return err
+		}
+
+		// Documents in the trash are hidden and read only. They are moved there and back by
+		// the server only, and nothing may be created in or moved to a folder in the trash.
+		if err := json.Unmarshal([]byte(`{
+			"createRule": "@request.auth.id = user.id && (parent = \"\" || parent.user = @request.auth.id || parent.editors.id ?= @request.auth.id) && (parent = \"\" || parent.deleted_at = \"\") && @request.body.viewers:isset = false && @request.body.editors:isset = false && @request.body.deleted_at:isset = false",
+			"deleteRule": "@request.auth.id = user.id && deleted_at = \"\"",
+			"listRule": "(@request.auth.id = user.id || viewers.id ?= @request.auth.id) && deleted_at = \"\"",
+			"updateRule": "(@request.auth.id = user.id || (editors.id ?= @request.auth.id && @request.body.user:isset = false && @request.body.parent:isset = false)) && (@request.body.parent:isset = false || @request.body.parent = \"\" || @request.body.parent.user = @request.auth.id || @request.body.parent.editors.id ?= @request.auth.id) && (@request.body.parent:isset = false || @request.body.parent = \"\" || @request.body.parent.deleted_at = \"\") && @request.body.viewers:isset = false && @request.body.editors:isset = false && @request.body.deleted_at:isset = false && deleted_at = \"\"",
+			"viewRule": "(@request.auth.id = user.id || viewers.id ?= @request.auth.id) && deleted_at = \"\""
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// add deleted_at field, set on a document and everything below it when it is moved
+		// to the trash
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"hidden": false,
+			"id": "date2396794881",
+			"max": "",
+			"min": "",
+			"name": "deleted_at",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "date"
+		}`)); err != nil {
+			return err
+		}
+
+		collection.AddIndex("idx_documents_deleted_at", false, "`deleted_at`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3332084752")
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(`{
+			"createRule": "@request.auth.id = user.id && (parent = \"\" || parent.user = @request.auth.id || parent.editors.id ?= @request.auth.id) && @request.body.viewers:isset = false && @request.body.editors:isset = false",
+			"deleteRule": "@request.auth.id = user.id",
+			"listRule": "@request.auth.id = user.id || viewers.id ?= @request.auth.id",
+			"updateRule": "(@request.auth.id = user.id || (editors.id ?= @request.auth.id && @request.body.user:isset = false && @request.body.parent:isset = false)) && (@request.body.parent:isset = false || @request.body.parent = \"\" || @request.body.parent.user = @request.auth.id || @request.body.parent.editors.id ?= @request.auth.id) && @request.body.viewers:isset = false && @request.body.editors:isset = false",
+			"viewRule": "@request.auth.id = user.id || viewers.id ?= @request.auth.id"
+		}`), &collection); err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_documents_deleted_at")
+		collection.Fields.RemoveById("date2396794881")
+
+		return app.Save(collection)
+	})
+}
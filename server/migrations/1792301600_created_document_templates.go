@@ -0,0 +1,174 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// Users manage their own templates. Templates without a user are shared by
+		// everyone and are managed by superusers from the dashboard.
+		jsonData := `{
+			"createRule": "@request.auth.id != \"\" && user = @request.auth.id",
+			"deleteRule": "@request.auth.id != \"\" && user = @request.auth.id",
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "_pb_users_auth_",
+					"hidden": false,
+					"id": "relation2375276105",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "user",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 200,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": true,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1843675174",
+					"max": 1000,
+					"min": 0,
+					"name": "description",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text724990059",
+					"max": 0,
+					"min": 0,
+					"name": "title",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"convertURLs": false,
+					"hidden": false,
+					"id": "editor4274335913",
+					"maxSize": 0,
+					"name": "content",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "editor"
+				},
+				{
+					"hidden": false,
+					"id": "bool3169551378",
+					"name": "is_folder",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				},
+				{
+					"hidden": false,
+					"id": "json2711073210",
+					"maxSize": 0,
+					"name": "children",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "json"
+				},
+				{
+					"hidden": false,
+					"id": "json4126492856",
+					"maxSize": 0,
+					"name": "placeholders",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "json"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_2788651504",
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_document_templates_user` + "`" + ` ON ` + "`" + `document_templates` + "`" + ` (` + "`" + `user` + "`" + `)"
+			],
+			"listRule": "@request.auth.id != \"\" && (user = \"\" || user = @request.auth.id)",
+			"name": "document_templates",
+			"system": false,
+			"type": "base",
+			"updateRule": "@request.auth.id != \"\" && user = @request.auth.id && (@request.body.user:isset = false || @request.body.user = @request.auth.id)",
+			"viewRule": "@request.auth.id != \"\" && (user = \"\" || user = @request.auth.id)"
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_2788651504")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// Conversations used to be updated with RFC 3339 dates in local time, which do not
+		// sort or compare with the UTC dates written everywhere else
+		_, err := app.DB().NewQuery(`
+			UPDATE conversations SET updated = strftime('%Y-%m-%d %H:%M:%fZ', updated)
+			WHERE updated LIKE '%T%' AND strftime('%Y-%m-%d %H:%M:%fZ', updated) IS NOT NULL`).
+			Execute()
+		return err
+	}, func(app core.App) error {
+		// The normalized dates are the same instants, there is nothing to undo
+		return nil
+	})
+}
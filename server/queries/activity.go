@@ -0,0 +1,57 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Activity queries take the app because activity is recorded from the record hooks of
+// documents.
+
+// RecordWritingActivity adds the words written in a document to the day in a single
+// statement, so concurrent saves are not lost
+func RecordWritingActivity(app core.App, userId, documentId, day string, added, removed int) error {
+	now := types.NowDateTime().String()
+	_, err := app.DB().NewQuery(`
+		INSERT INTO writing_activity (id, user, document, day, words_added, words_removed, created, updated)
+		VALUES ({:id}, {:user}, {:document}, {:day}, {:added}, {:removed}, {:now}, {:now})
+		ON CONFLICT (user, day, document) DO UPDATE SET
+			words_added = words_added + excluded.words_added,
+			words_removed = words_removed + excluded.words_removed,
+			updated = excluded.updated`).
+		Bind(dbx.Params{
+			"id":       core.GenerateDefaultRandomId(),
+			"user":     userId,
+			"document": documentId,
+			"day":      day,
+			"added":    added,
+			"removed":  removed,
+			"now":      now,
+		}).
+		Execute()
+	return err
+}
+
+// GetWritingActivity sums the writing activity of the user per day, from the given day on,
+// oldest first. Days without activity are left out.
+func GetWritingActivity(app core.App, userId, from string) ([]*WritingActivityDay, error) {
+	query := app.DB().Select(
+		"day",
+		"(SUM(words_added)) AS words_added",
+		"(SUM(words_removed)) AS words_removed",
+		"(COUNT(DISTINCT document)) AS documents",
+	).
+		From("writing_activity").
+		Where(dbx.HashExp{"user": userId}).
+		AndWhere(dbx.NewExp("day >= {:from}", dbx.Params{"from": from})).
+		GroupBy("day").
+		OrderBy("day ASC")
+
+	var days []*WritingActivityDay
+	if err := query.All(&days); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
@@ -0,0 +1,147 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Chunk queries take the app instead of a request event because the index is
+// maintained by a background worker outside of any request.
+
+type DocumentChunk struct {
+	Id          string  `db:"id"`
+	DocumentId  string  `db:"document"`
+	UserId      string  `db:"user"`
+	ChunkIndex  int     `db:"chunk_index"`
+	StartOffset int     `db:"start_offset"`
+	EndOffset   int     `db:"end_offset"`
+	Content     string  `db:"content"`
+	ContentHash string  `db:"content_hash"`
+	Model       string  `db:"model"`
+	Embedding   string  `db:"embedding"`
+	Score       float64 `db:"score"`
+	Created     string  `db:"created"`
+}
+
+func GetDocumentForIndexing(app core.App, id string) (*Document, error) {
+	query := app.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"id": id})
+
+	var document Document
+	if err := query.One(&document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetDocumentChunkState returns the content hash and model the document was last indexed with
+func GetDocumentChunkState(app core.App, documentId string) (contentHash string, model string, err error) {
+	query := app.DB().Select("content_hash", "model").
+		From("document_chunks").
+		Where(dbx.HashExp{"document": documentId}).
+		Limit(1)
+
+	var chunk DocumentChunk
+	if err := query.One(&chunk); err != nil {
+		return "", "", err
+	}
+
+	return chunk.ContentHash, chunk.Model, nil
+}
+
+// ReplaceDocumentChunks swaps the indexed chunks of a document in a single transaction
+func ReplaceDocumentChunks(app core.App, documentId string, chunks []*DocumentChunk) error {
+	collection, err := app.FindCachedCollectionByNameOrId("document_chunks")
+	if err != nil {
+		return err
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		if _, err := txApp.DB().Delete("document_chunks", dbx.HashExp{"document": documentId}).Execute(); err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			record := core.NewRecord(collection)
+			record.Set("document", documentId)
+			record.Set("user", chunk.UserId)
+			record.Set("chunk_index", chunk.ChunkIndex)
+			record.Set("start_offset", chunk.StartOffset)
+			record.Set("end_offset", chunk.EndOffset)
+			record.Set("content", chunk.Content)
+			record.Set("content_hash", chunk.ContentHash)
+			record.Set("model", chunk.Model)
+			record.Set("embedding", chunk.Embedding)
+
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetClosestChunks ranks the chunks of the user embedded with the model by their cosine
+// similarity to the encoded vector and loads the closest ones scoring at least minScore.
+// The ranking runs in the database with the cosine_similarity function registered by the
+// services package, so the other chunks are never loaded.
+func GetClosestChunks(app core.App, userId, model, vector string, minScore float64, limit int) ([]*DocumentChunk, error) {
+	query := app.DB().NewQuery(`
+		SELECT id, document, user, chunk_index, start_offset, end_offset, content, content_hash, model, created, score
+		FROM (
+			SELECT *, cosine_similarity(embedding, {:vector}) AS score
+			FROM document_chunks
+			WHERE user = {:user} AND model = {:model}
+				AND NOT EXISTS (SELECT 1 FROM documents WHERE documents.id = document_chunks.document AND documents.deleted_at != '')
+		)
+		WHERE score >= {:min}
+		ORDER BY score DESC
+		LIMIT {:limit}`).
+		Bind(dbx.Params{"vector": vector, "user": userId, "model": model, "min": minScore, "limit": limit})
+
+	var chunks []*DocumentChunk
+	if err := query.All(&chunks); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// GetUnindexedDocumentIds lists documents with content that were changed after their
+// chunks were embedded with the model, or never embedded with it
+func GetUnindexedDocumentIds(app core.App, model string) ([]string, error) {
+	query := app.DB().Select("documents.id").
+		From("documents").
+		Where(dbx.HashExp{"documents.is_folder": false, "documents.deleted_at": ""}).
+		AndWhere(dbx.NewExp("documents.content != ''")).
+		AndWhere(dbx.NewExp(`NOT EXISTS (
+			SELECT 1 FROM document_chunks
+			WHERE document_chunks.document = documents.id
+				AND document_chunks.model = {:model}
+				AND document_chunks.created >= documents.updated
+		)`, dbx.Params{"model": model}))
+
+	var ids []string
+	if err := query.Column(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetDocumentIdsByUserId lists the ids of every document of the user, folders excluded
+func GetDocumentIdsByUserId(app core.App, userId string) ([]string, error) {
+	query := app.DB().Select("id").
+		From("documents").
+		Where(dbx.HashExp{"user": userId, "is_folder": false, "deleted_at": ""})
+
+	var ids []string
+	if err := query.Column(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,146 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Comment queries take the app because anchors are remapped from the documents hooks.
+
+var documentCommentColumns = []string{"id", "document", "user", "parent", "author_type", "content", "anchor_start", "anchor_end", "quote", "orphaned", "resolved_at", "resolved_by", "mentions", "created", "updated"}
+
+func GetDocumentCommentById(app core.App, id string) (*DocumentComment, error) {
+	query := app.DB().Select(documentCommentColumns...).
+		From("document_comments").
+		Where(dbx.HashExp{"id": id})
+
+	var comment DocumentComment
+	if err := query.One(&comment); err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// GetDocumentCommentsByDocumentId lists every comment and reply on the document, oldest first
+func GetDocumentCommentsByDocumentId(app core.App, documentId string) ([]*DocumentComment, error) {
+	query := app.DB().Select(documentCommentColumns...).
+		From("document_comments").
+		Where(dbx.HashExp{"document": documentId}).
+		OrderBy("created ASC")
+
+	var comments []*DocumentComment
+	if err := query.All(&comments); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// GetAnchoredDocumentComments lists the comments of the document that point at a range
+// of its content. Replies and comments on the whole document have no range.
+func GetAnchoredDocumentComments(app core.App, documentId string) ([]*DocumentComment, error) {
+	query := app.DB().Select(documentCommentColumns...).
+		From("document_comments").
+		Where(dbx.HashExp{"document": documentId, "parent": ""}).
+		AndWhere(dbx.NewExp("quote != ''"))
+
+	var comments []*DocumentComment
+	if err := query.All(&comments); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// GetDocumentCommentsMentioningUserId lists the comments that mention the user, newest first
+func GetDocumentCommentsMentioningUserId(app core.App, userId string, limit int64) ([]*DocumentComment, error) {
+	query := app.DB().Select(documentCommentColumns...).
+		From("document_comments").
+		Where(dbx.NewExp("EXISTS (SELECT 1 FROM json_each(document_comments.mentions) WHERE json_each.value = {:user})", dbx.Params{"user": userId})).
+		OrderBy("created DESC").
+		Limit(limit)
+
+	var comments []*DocumentComment
+	if err := query.All(&comments); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func CreateDocumentComment(app core.App, comment *DocumentComment) (*DocumentComment, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("document_comments")
+	if err != nil {
+		return nil, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("document", comment.DocumentId)
+	record.Set("user", comment.UserId)
+	record.Set("parent", comment.Parent)
+	record.Set("author_type", comment.AuthorType)
+	record.Set("content", comment.Content)
+	record.Set("anchor_start", comment.AnchorStart)
+	record.Set("anchor_end", comment.AnchorEnd)
+	record.Set("quote", comment.Quote)
+	record.Set("mentions", []string(comment.Mentions))
+
+	if err := app.Save(record); err != nil {
+		return nil, err
+	}
+
+	return GetDocumentCommentById(app, record.Id)
+}
+
+// UpdateDocumentCommentContent replaces the text of the comment and who it mentions
+func UpdateDocumentCommentContent(app core.App, id, content string, mentions []string) error {
+	record, err := app.FindRecordById("document_comments", id)
+	if err != nil {
+		return err
+	}
+
+	record.Set("content", content)
+	record.Set("mentions", mentions)
+
+	return app.Save(record)
+}
+
+// SetDocumentCommentResolved resolves the comment as the user, or reopens it when the
+// user is empty
+func SetDocumentCommentResolved(app core.App, id, userId string) error {
+	resolvedAt := ""
+	if userId != "" {
+		resolvedAt = types.NowDateTime().String()
+	}
+
+	_, err := app.DB().Update("document_comments", dbx.Params{
+		"resolved_at": resolvedAt,
+		"resolved_by": userId,
+		"updated":     types.NowDateTime().String(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// UpdateDocumentCommentAnchor moves the range of the comment after its document changed.
+// It leaves the updated date alone since the comment itself did not change.
+func UpdateDocumentCommentAnchor(app core.App, id string, start, end int, quote string, orphaned bool) error {
+	_, err := app.DB().Update("document_comments", dbx.Params{
+		"anchor_start": start,
+		"anchor_end":   end,
+		"quote":        quote,
+		"orphaned":     orphaned,
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// DeleteDocumentComment deletes the comment record, and its replies with it
+func DeleteDocumentComment(app core.App, id string) error {
+	record, err := app.FindRecordById("document_comments", id)
+	if err != nil {
+		return err
+	}
+
+	return app.Delete(record)
+}
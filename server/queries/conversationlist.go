@@ -0,0 +1,144 @@
+package queries
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const messagePreviewLength = 400
+
+// ConversationSortColumns maps the sort options of the listing to their columns
+var ConversationSortColumns = map[string]string{
+	"updated":     "updated",
+	"created":     "created",
+	"archived_at": "archived_at",
+	"title":       "(title COLLATE NOCASE)",
+}
+
+// ConversationCursor is the position after the last conversation of a page. Pinned
+// conversations always come first, so the pinned state is part of the position.
+type ConversationCursor struct {
+	Pinned bool   `json:"p"`
+	Value  string `json:"v"`
+	Id     string `json:"i"`
+}
+
+type ConversationListFilter struct {
+	Type       string
+	Model      string
+	Tag        string
+	FolderId   string
+	From       string
+	To         string
+	Pinned     *bool
+	Archived   bool
+	Sort       string
+	Descending bool
+	Limit      int64
+	After      *ConversationCursor
+}
+
+// ConversationListItem is the lightweight projection used by the paginated listing
+type ConversationListItem struct {
+	Id            string                  `db:"id"`
+	Title         string                  `db:"title"`
+	Type          string                  `db:"type"`
+	Pinned        bool                    `db:"pinned"`
+	ArchivedAt    string                  `db:"archived_at"`
+	Tags          types.JSONArray[string] `db:"tags"`
+	FolderId      string                  `db:"folder"`
+	Created       string                  `db:"created"`
+	Updated       string                  `db:"updated"`
+	SortValue     string                  `db:"sort_value"`
+	MessageCount  int                     `db:"message_count"`
+	LastMessage   string                  `db:"last_message"`
+	LastModel     string                  `db:"last_model"`
+	LastMessageAt string                  `db:"last_message_at"`
+}
+
+// ListConversations returns one page of the user's conversations matching the filter.
+// From and To bound the last update of the conversation.
+func ListConversations(e *core.RequestEvent, userId string, filter ConversationListFilter) ([]*ConversationListItem, error) {
+	column, ok := ConversationSortColumns[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+
+	direction, operator := "ASC", ">"
+	if filter.Descending {
+		direction, operator = "DESC", "<"
+	}
+
+	// Conversations without messages get empty values instead of NULL
+	lastMessage := `COALESCE((SELECT %s FROM conversation_messages
+		WHERE conversation_messages.conversation = conversations.id AND conversation_messages.active = TRUE
+		ORDER BY conversation_messages.created DESC LIMIT 1), '')`
+
+	query := e.App.DB().Select("id", "title", "type", "pinned", "archived_at", "tags", "folder", "created", "updated").
+		AndSelect(column + " AS sort_value").
+		AndSelect(`(SELECT COUNT(*) FROM conversation_messages
+			WHERE conversation_messages.conversation = conversations.id AND conversation_messages.active = TRUE) AS message_count`).
+		AndSelect(fmt.Sprintf(lastMessage, fmt.Sprintf(
+			"COALESCE(NULLIF(SUBSTR(response_message, 1, %d), ''), SUBSTR(user_message, 1, %d))",
+			messagePreviewLength, messagePreviewLength)) + " AS last_message").
+		AndSelect(fmt.Sprintf(lastMessage, "model") + " AS last_model").
+		AndSelect(fmt.Sprintf(lastMessage, "created") + " AS last_message_at").
+		From("conversations").
+		Where(dbx.HashExp{"user": userId, "active": true})
+
+	if filter.Archived {
+		query.AndWhere(dbx.Not(dbx.HashExp{"archived_at": ""}))
+	} else {
+		query.AndWhere(dbx.HashExp{"archived_at": ""})
+	}
+
+	if filter.Type != "" {
+		query.AndWhere(dbx.HashExp{"type": filter.Type})
+	}
+
+	if filter.Pinned != nil {
+		query.AndWhere(dbx.HashExp{"pinned": *filter.Pinned})
+	}
+
+	if filter.Tag != "" {
+		query.AndWhere(dbx.NewExp("EXISTS (SELECT 1 FROM json_each(conversations.tags) WHERE json_each.value = {:tag})", dbx.Params{"tag": filter.Tag}))
+	}
+
+	if filter.FolderId != "" {
+		query.AndWhere(dbx.HashExp{"folder": filter.FolderId})
+	}
+
+	if filter.Model != "" {
+		query.AndWhere(dbx.NewExp(`EXISTS (SELECT 1 FROM conversation_messages
+			WHERE conversation_messages.conversation = conversations.id AND conversation_messages.active = TRUE
+				AND conversation_messages.model = {:model})`,
+			dbx.Params{"model": filter.Model}))
+	}
+
+	if filter.From != "" {
+		query.AndWhere(dbx.NewExp("updated >= {:from}", dbx.Params{"from": filter.From}))
+	}
+
+	if filter.To != "" {
+		query.AndWhere(dbx.NewExp("updated <= {:to}", dbx.Params{"to": filter.To}))
+	}
+
+	if filter.After != nil {
+		query.AndWhere(dbx.NewExp(fmt.Sprintf(
+			"(pinned < {:pinned} OR (pinned = {:pinned} AND (%[1]s %[2]s {:value} OR (%[1]s = {:value} AND id %[2]s {:id}))))",
+			column, operator,
+		), dbx.Params{"pinned": filter.After.Pinned, "value": filter.After.Value, "id": filter.After.Id}))
+	}
+
+	query.OrderBy("pinned DESC", column+" "+direction, "id "+direction).Limit(filter.Limit)
+
+	var items []*ConversationListItem
+	if err := query.All(&items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
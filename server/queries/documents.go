@@ -0,0 +1,181 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+var documentColumns = []string{"id", "user", "title", "content", "metadata", "parent", "is_folder", "order_key", "deleted_at", "created", "updated"}
+
+// documentOrder sorts siblings by their order key. Documents created before there were
+// keys have none and come first, folders before documents.
+var documentOrder = []string{"order_key ASC", "is_folder DESC", "title ASC"}
+
+func GetDocumentById(e *core.RequestEvent, id string) (*Document, error) {
+	query := e.App.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"id": id, "deleted_at": ""})
+
+	var document Document
+	if err := query.One(&document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetDocumentByTitle finds a document of the user by its title, ignoring case
+func GetDocumentByTitle(e *core.RequestEvent, userId, title string) (*Document, error) {
+	query := e.App.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"user": userId, "deleted_at": ""}).
+		AndWhere(dbx.NewExp("LOWER(title) = LOWER({:title})", dbx.Params{"title": title})).
+		OrderBy("updated DESC").
+		Limit(1)
+
+	var document Document
+	if err := query.One(&document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// SearchDocumentsByUserId matches the term against the title and content of the user's documents
+func SearchDocumentsByUserId(e *core.RequestEvent, userId, term string, limit int64) ([]*Document, error) {
+	query := e.App.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"user": userId, "is_folder": false, "deleted_at": ""}).
+		AndWhere(dbx.Or(dbx.Like("title", term), dbx.Like("content", term))).
+		OrderBy("updated DESC").
+		Limit(limit)
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// GetDocumentsByParent lists the direct children of a folder, or the root when parentId is empty
+func GetDocumentsByParent(e *core.RequestEvent, userId, parentId string) ([]*Document, error) {
+	query := e.App.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"user": userId, "parent": parentId, "deleted_at": ""}).
+		OrderBy(documentOrder...)
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// GetDocumentChildren lists the documents in a folder whoever created them, or the top
+// level documents of the user when parentId is empty, in order
+func GetDocumentChildren(e *core.RequestEvent, userId, parentId string) ([]*Document, error) {
+	query := e.App.DB().Select(documentColumns...).
+		From("documents").
+		Where(siblingsExp(userId, parentId)).
+		OrderBy(documentOrder...)
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// GetDocumentsByIds loads the documents in the order siblings are listed
+func GetDocumentsByIds(e *core.RequestEvent, ids []string) ([]*Document, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	query := e.App.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.In("id", values...)).
+		AndWhere(dbx.HashExp{"deleted_at": ""}).
+		OrderBy(documentOrder...)
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+func CreateDocument(e *core.RequestEvent, document *Document) (*Document, error) {
+	collection, err := e.App.FindCollectionByNameOrId("documents")
+	if err != nil {
+		return nil, err
+	}
+
+	record := core.NewRecord(collection)
+	if document.Id != "" {
+		record.Id = document.Id
+	}
+	record.Set("user", document.UserId)
+	record.Set("title", document.Title)
+	record.Set("content", document.Content)
+	record.Set("metadata", document.Metadata)
+	record.Set("parent", document.Parent)
+	record.Set("is_folder", document.IsFolder)
+
+	if err := e.App.Save(record); err != nil {
+		return nil, err
+	}
+
+	return &Document{
+		Id:       record.Id,
+		UserId:   document.UserId,
+		Title:    document.Title,
+		Content:  document.Content,
+		Metadata: document.Metadata,
+		Parent:   document.Parent,
+		IsFolder: document.IsFolder,
+		OrderKey: record.GetString("order_key"),
+		Created:  record.GetString("created"),
+		Updated:  record.GetString("updated"),
+	}, nil
+}
+
+// UpdateDocumentRecord saves the fields through the record so the indexing hooks run,
+// unlike the plain DB updates used for conversations
+func UpdateDocumentRecord(e *core.RequestEvent, id string, fields map[string]any) (*Document, error) {
+	record, err := e.App.FindRecordById("documents", id)
+	if err != nil {
+		return nil, err
+	}
+
+	for field, value := range fields {
+		record.Set(field, value)
+	}
+
+	if err := e.App.Save(record); err != nil {
+		return nil, err
+	}
+
+	return &Document{
+		Id:       record.Id,
+		UserId:   record.GetString("user"),
+		Title:    record.GetString("title"),
+		Content:  record.GetString("content"),
+		Metadata: types.JSONRaw(record.GetString("metadata")),
+		Parent:   record.GetString("parent"),
+		IsFolder: record.GetBool("is_folder"),
+		OrderKey: record.GetString("order_key"),
+		Created:  record.GetString("created"),
+		Updated:  record.GetString("updated"),
+	}, nil
+}
@@ -0,0 +1,192 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Link queries take the app because links are kept in sync from the record hooks of
+// documents.
+
+// visibleDocumentExp matches the documents under the alias that the user owns or may view
+func visibleDocumentExp(alias, userId string) dbx.Expression {
+	return dbx.NewExp("("+alias+".user = {:user} OR EXISTS (SELECT 1 FROM json_each("+alias+".viewers) WHERE json_each.value = {:user}))", dbx.Params{"user": userId})
+}
+
+// GetDocumentLinksBySourceId lists the links stored for a document, in the order they
+// appear in its content
+func GetDocumentLinksBySourceId(app core.App, sourceId string) ([]*DocumentLink, error) {
+	query := app.DB().Select("id", "source", "kind", "target", "title", "position", "created").
+		From("document_links").
+		Where(dbx.HashExp{"source": sourceId}).
+		OrderBy("position ASC")
+
+	var links []*DocumentLink
+	if err := query.All(&links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// GetOutgoingDocumentLinks lists the links of a document in order. A link is broken when
+// its target does not exist or is in the trash. The title of the target is only given
+// when the user may view it.
+func GetOutgoingDocumentLinks(app core.App, sourceId, userId string) ([]*DocumentLink, error) {
+	query := app.DB().Select(
+		"document_links.id", "document_links.source", "document_links.kind", "document_links.target",
+		"document_links.title", "document_links.position", "document_links.created",
+		"(CASE WHEN targets.user = {:user} OR EXISTS (SELECT 1 FROM json_each(targets.viewers) WHERE json_each.value = {:user}) THEN targets.title ELSE '' END) AS target_title",
+		"(targets.id IS NULL) AS broken",
+	).
+		From("document_links").
+		LeftJoin("documents AS targets", dbx.NewExp("targets.id = document_links.target AND targets.deleted_at = ''")).
+		Where(dbx.HashExp{"document_links.source": sourceId}).
+		OrderBy("document_links.position ASC").
+		Bind(dbx.Params{"user": userId})
+
+	var links []*DocumentLink
+	if err := query.All(&links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// GetDocumentBacklinks lists the links to a document from the documents the user may view,
+// most recently edited sources first
+func GetDocumentBacklinks(app core.App, targetId, userId string) ([]*DocumentLink, error) {
+	query := app.DB().Select(
+		"document_links.id", "document_links.source", "document_links.kind", "document_links.target",
+		"document_links.title", "document_links.position", "document_links.created",
+		"sources.title AS source_title",
+	).
+		From("document_links").
+		InnerJoin("documents AS sources", dbx.NewExp("sources.id = document_links.source AND sources.deleted_at = ''")).
+		Where(dbx.HashExp{"document_links.target": targetId}).
+		AndWhere(visibleDocumentExp("sources", userId)).
+		OrderBy("sources.updated DESC", "document_links.position ASC")
+
+	var links []*DocumentLink
+	if err := query.All(&links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// GetBrokenDocumentLinks lists the links in the user's documents that point to no
+// document, or to one in the trash
+func GetBrokenDocumentLinks(app core.App, userId string) ([]*DocumentLink, error) {
+	query := app.DB().Select(
+		"document_links.id", "document_links.source", "document_links.kind", "document_links.target",
+		"document_links.title", "document_links.position", "document_links.created",
+		"sources.title AS source_title", "(1) AS broken",
+	).
+		From("document_links").
+		InnerJoin("documents AS sources", dbx.NewExp("sources.id = document_links.source AND sources.deleted_at = ''")).
+		LeftJoin("documents AS targets", dbx.NewExp("targets.id = document_links.target AND targets.deleted_at = ''")).
+		Where(dbx.HashExp{"sources.user": userId}).
+		AndWhere(dbx.NewExp("targets.id IS NULL")).
+		OrderBy("sources.title ASC", "document_links.position ASC")
+
+	var links []*DocumentLink
+	if err := query.All(&links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// FindDocumentIdByTitle returns the document of the user a wikilink to the title points
+// to, ignoring case and preferring the most recently edited. It is empty when none has it.
+func FindDocumentIdByTitle(app core.App, userId, title string) (string, error) {
+	var ids []string
+	err := app.DB().Select("id").
+		From("documents").
+		Where(dbx.HashExp{"user": userId, "deleted_at": ""}).
+		AndWhere(dbx.NewExp("LOWER(title) = LOWER({:title})", dbx.Params{"title": title})).
+		OrderBy("updated DESC").
+		Limit(1).
+		Column(&ids)
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+
+	return ids[0], nil
+}
+
+// ReplaceDocumentLinks swaps the links of a document in a single transaction
+func ReplaceDocumentLinks(app core.App, sourceId string, links []*DocumentLink) error {
+	collection, err := app.FindCachedCollectionByNameOrId("document_links")
+	if err != nil {
+		return err
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		if _, err := txApp.DB().Delete("document_links", dbx.HashExp{"source": sourceId}).Execute(); err != nil {
+			return err
+		}
+
+		for _, link := range links {
+			record := core.NewRecord(collection)
+			record.Set("source", sourceId)
+			record.Set("kind", link.Kind)
+			record.Set("target", link.TargetId)
+			record.Set("title", link.Title)
+			record.Set("position", link.Position)
+
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ResolveWikilinks points the wikilinks to the title in the user's documents that did not
+// find a document yet at the given one
+func ResolveWikilinks(app core.App, userId, title, targetId string) error {
+	_, err := app.DB().NewQuery(`
+		UPDATE document_links SET target = {:target}
+		WHERE kind = 'wiki' AND target = '' AND LOWER(title) = LOWER({:title})
+			AND source IN (SELECT id FROM documents WHERE user = {:user})`).
+		Bind(dbx.Params{"target": targetId, "title": title, "user": userId}).
+		Execute()
+	return err
+}
+
+// GetLinkingDocumentIds lists the documents of the user outside the trash with links to
+// the target
+func GetLinkingDocumentIds(app core.App, targetId, userId string) ([]string, error) {
+	query := app.DB().Select("document_links.source").
+		Distinct(true).
+		From("document_links").
+		InnerJoin("documents AS sources", dbx.NewExp("sources.id = document_links.source")).
+		Where(dbx.HashExp{"document_links.target": targetId, "sources.user": userId, "sources.deleted_at": ""})
+
+	var ids []string
+	if err := query.Column(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetDocumentIdsWithUnsyncedLinks lists the documents whose content looks like it has
+// links while none are stored, such as documents written before links were tracked
+func GetDocumentIdsWithUnsyncedLinks(app core.App) ([]string, error) {
+	query := app.DB().Select("id").
+		From("documents").
+		Where(dbx.HashExp{"is_folder": false, "deleted_at": ""}).
+		AndWhere(dbx.Or(dbx.Like("content", "[["), dbx.Like("content", "](doc:"))).
+		AndWhere(dbx.NewExp("NOT EXISTS (SELECT 1 FROM document_links WHERE document_links.source = documents.id)"))
+
+	var ids []string
+	if err := query.Column(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -2,62 +2,192 @@ package queries
 
 import (
 	"encoding/json"
+	"slices"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 type Conversation struct {
-	Id              string                `db:"id"`
-	UserId          string                `db:"user"`
-	Title           string                `db:"title"`
-	Type            string                `db:"type"`
-	TotalRequests   string                `db:"total_requests"`
-	InputTokens     string                `db:"input_tokens"`
-	OutputTokens    string                `db:"output_tokens"`
-	ReasoningTokens string                `db:"reasoning_tokens"`
-	Cost            string                `db:"cost"`
-	Active          bool                  `db:"active"`
-	Created         string                `db:"created"`
-	Updated         string                `db:"updated"`
-	Messages        []ConversationMessage `db:"-"`
+	Id              string                  `db:"id"`
+	UserId          string                  `db:"user"`
+	Title           string                  `db:"title"`
+	Type            string                  `db:"type"`
+	TotalRequests   string                  `db:"total_requests"`
+	InputTokens     string                  `db:"input_tokens"`
+	OutputTokens    string                  `db:"output_tokens"`
+	ReasoningTokens string                  `db:"reasoning_tokens"`
+	Cost            string                  `db:"cost"`
+	Active          bool                    `db:"active"`
+	Settings        types.JSONRaw           `db:"settings"`
+	Pinned          bool                    `db:"pinned"`
+	ArchivedAt      string                  `db:"archived_at"`
+	Tags            types.JSONArray[string] `db:"tags"`
+	FolderId        string                  `db:"folder"`
+	Created         string                  `db:"created"`
+	Updated         string                  `db:"updated"`
+	Messages        []ConversationMessage   `db:"-"`
+}
+
+type ConversationTag struct {
+	Id      string `db:"id" json:"id"`
+	UserId  string `db:"user" json:"-"`
+	Name    string `db:"name" json:"name"`
+	Color   string `db:"color" json:"color"`
+	Created string `db:"created" json:"created"`
+	Updated string `db:"updated" json:"updated"`
+}
+
+type ConversationTagWithCount struct {
+	ConversationTag
+	ConversationCount int `db:"conversation_count" json:"conversation_count"`
 }
 
 type ConversationMessage struct {
-	Id              string `db:"id"`
-	UserId          string `db:"user"`
-	ConversationId  string `db:"conversation"`
-	UserMessage     string `db:"user_message"`
-	ResponseMessage string `db:"response_message"`
-	ThinkingContent string `db:"thinking_content"`
-	Model           string `db:"model"`
-	InputTokens     string `db:"input_tokens"`
-	OutputTokens    string `db:"output_tokens"`
-	ReasoningTokens string `db:"reasoning_tokens"`
-	Cost            string `db:"cost"`
-	Active          bool   `db:"active"`
-	Created         string `db:"created"`
+	Id               string                  `db:"id"`
+	UserId           string                  `db:"user"`
+	ConversationId   string                  `db:"conversation"`
+	UserMessage      string                  `db:"user_message"`
+	ResponseMessage  string                  `db:"response_message"`
+	ThinkingContent  string                  `db:"thinking_content"`
+	Model            string                  `db:"model"`
+	InputTokens      string                  `db:"input_tokens"`
+	OutputTokens     string                  `db:"output_tokens"`
+	ReasoningTokens  string                  `db:"reasoning_tokens"`
+	Cost             string                  `db:"cost"`
+	Active           bool                    `db:"active"`
+	CandidateGroup   string                  `db:"candidate_group"`
+	GenerationParams types.JSONRaw           `db:"generation_params"`
+	Attachments      types.JSONArray[string] `db:"attachments"`
+	ToolCalls        types.JSONRaw           `db:"tool_calls"`
+	Citations        types.JSONRaw           `db:"citations"`
+	Created          string                  `db:"created"`
+	Uploads          []*filesystem.File      `db:"-"`
 }
 
 type Document struct {
-	Id       string `db:"id"`
-	Parent   string `db:"parent"`
-	IsFolder bool   `db:"is_folder"`
-	Created  string `db:"created"`
-	Updated  string `db:"updated"`
+	Id        string        `db:"id"`
+	UserId    string        `db:"user"`
+	Title     string        `db:"title"`
+	Content   string        `db:"content"`
+	Metadata  types.JSONRaw `db:"metadata"`
+	Parent    string        `db:"parent"`
+	IsFolder  bool          `db:"is_folder"`
+	OrderKey  string        `db:"order_key"`
+	DeletedAt string        `db:"deleted_at"`
+	Created   string        `db:"created"`
+	Updated   string        `db:"updated"`
+}
+
+// DocumentComment is a comment on a range of a document, or a reply to one. Anchors
+// count Unicode code points, like collaborative editing operations.
+type DocumentComment struct {
+	Id          string                  `db:"id"`
+	DocumentId  string                  `db:"document"`
+	UserId      string                  `db:"user"`
+	Parent      string                  `db:"parent"`
+	AuthorType  string                  `db:"author_type"`
+	Content     string                  `db:"content"`
+	AnchorStart int                     `db:"anchor_start"`
+	AnchorEnd   int                     `db:"anchor_end"`
+	Quote       string                  `db:"quote"`
+	Orphaned    bool                    `db:"orphaned"`
+	ResolvedAt  string                  `db:"resolved_at"`
+	ResolvedBy  string                  `db:"resolved_by"`
+	Mentions    types.JSONArray[string] `db:"mentions"`
+	Created     string                  `db:"created"`
+	Updated     string                  `db:"updated"`
+}
+
+// DocumentReview is an AI review of a document's content, identified by the hash of the
+// content it reviewed so an unchanged document is not reviewed twice
+type DocumentReview struct {
+	Id            string        `db:"id"`
+	DocumentId    string        `db:"document"`
+	UserId        string        `db:"user"`
+	ContentHash   string        `db:"content_hash"`
+	Status        string        `db:"status"`
+	Suggestions   types.JSONRaw `db:"suggestions"`
+	SectionsTotal int           `db:"sections_total"`
+	SectionsDone  int           `db:"sections_done"`
+	Error         string        `db:"error"`
+	Model         string        `db:"model"`
+	Cost          float64       `db:"cost"`
+	Created       string        `db:"created"`
+	Updated       string        `db:"updated"`
+}
+
+// DocumentLink is a link from one document to another found in its content, either a
+// wikilink to a title or a markdown link to doc:ID. Target is empty while no document
+// has the title of a wikilink. SourceTitle, TargetTitle and Broken are filled by the
+// queries that list links.
+type DocumentLink struct {
+	Id          string `db:"id"`
+	SourceId    string `db:"source"`
+	Kind        string `db:"kind"`
+	TargetId    string `db:"target"`
+	Title       string `db:"title"`
+	Position    int    `db:"position"`
+	SourceTitle string `db:"source_title"`
+	TargetTitle string `db:"target_title"`
+	Broken      bool   `db:"broken"`
+	Created     string `db:"created"`
+}
+
+// DocumentTemplate is the starting point of a new document, or of a folder with the
+// documents and folders in Children. Templates without a user are shared by everyone.
+// Placeholders declare the {{variables}} the title and content use.
+type DocumentTemplate struct {
+	Id           string        `db:"id"`
+	UserId       string        `db:"user"`
+	Name         string        `db:"name"`
+	Description  string        `db:"description"`
+	Title        string        `db:"title"`
+	Content      string        `db:"content"`
+	IsFolder     bool          `db:"is_folder"`
+	Children     types.JSONRaw `db:"children"`
+	Placeholders types.JSONRaw `db:"placeholders"`
+	Created      string        `db:"created"`
+	Updated      string        `db:"updated"`
+}
+
+// WritingActivityDay sums the words a user added to and removed from their documents
+// on a day, in UTC
+type WritingActivityDay struct {
+	Day          string `db:"day"`
+	WordsAdded   int    `db:"words_added"`
+	WordsRemoved int    `db:"words_removed"`
+	Documents    int    `db:"documents"`
+}
+
+type DocumentShareLink struct {
+	Id           string                  `db:"id"`
+	DocumentId   string                  `db:"document"`
+	UserId       string                  `db:"user"`
+	Permissions  types.JSONArray[string] `db:"permissions"`
+	ExpiresAt    string                  `db:"expires_at"`
+	RevokedAt    string                  `db:"revoked_at"`
+	ViewCount    int                     `db:"view_count"`
+	LastViewedAt string                  `db:"last_viewed_at"`
+	Created      string                  `db:"created"`
+	Updated      string                  `db:"updated"`
 }
 
 type AIModel struct {
-	Id           string `db:"id" json:"id"`
-	Identifier   string `db:"identifier" json:"identifier"`
-	Name         string `db:"name" json:"name"`
-	Description  string `db:"description" json:"description"`
-	Icon         string `db:"icon" json:"icon"`
-	Capabilities string `db:"capabilities" json:"capabilities"`
-	Provider     string `db:"provider" json:"provider"`
-	Default      bool   `db:"default" json:"default"`
-	Created      string `db:"created" json:"created"`
-	Updated      string `db:"updated" json:"updated"`
+	Id              string        `db:"id" json:"id"`
+	Identifier      string        `db:"identifier" json:"identifier"`
+	Name            string        `db:"name" json:"name"`
+	Description     string        `db:"description" json:"description"`
+	Icon            string        `db:"icon" json:"icon"`
+	Capabilities    string        `db:"capabilities" json:"capabilities"`
+	Provider        string        `db:"provider" json:"provider"`
+	Default         bool          `db:"default" json:"default"`
+	Fallbacks       types.JSONRaw `db:"fallbacks" json:"fallbacks"`
+	MaxOutputTokens int64         `db:"max_output_tokens" json:"max_output_tokens"`
+	Created         string        `db:"created" json:"created"`
+	Updated         string        `db:"updated" json:"updated"`
 }
 
 func (m *AIModel) GetCapabilities() ([]string, error) {
@@ -72,6 +202,19 @@ func (m *AIModel) GetCapabilities() ([]string, error) {
 	return capabilities, nil
 }
 
+// GetFallbacks returns the ordered list of model identifiers to try when this model fails
+func (m *AIModel) GetFallbacks() ([]string, error) {
+	var fallbacks []string
+	if len(m.Fallbacks) == 0 || m.Fallbacks.String() == "null" {
+		return nil, nil
+	}
+
+	if err := json.Unmarshal(m.Fallbacks, &fallbacks); err != nil {
+		return nil, err
+	}
+	return fallbacks, nil
+}
+
 func CreateAIModel(e *core.RequestEvent, model *AIModel) (*AIModel, error) {
 	collection, err := e.App.FindCollectionByNameOrId("ai_models")
 	if err != nil {
@@ -86,22 +229,26 @@ func CreateAIModel(e *core.RequestEvent, model *AIModel) (*AIModel, error) {
 	record.Set("capabilities", model.Capabilities)
 	record.Set("provider", model.Provider)
 	record.Set("default", model.Default)
+	record.Set("fallbacks", model.Fallbacks)
+	record.Set("max_output_tokens", model.MaxOutputTokens)
 
 	if err := e.App.Save(record); err != nil {
 		return nil, err
 	}
 
 	return &AIModel{
-		Id:           record.Id,
-		Identifier:   record.GetString("identifier"),
-		Name:         model.Name,
-		Description:  model.Description,
-		Icon:         model.Icon,
-		Capabilities: model.Capabilities,
-		Provider:     model.Provider,
-		Default:      model.Default,
-		Created:      record.GetString("created"),
-		Updated:      record.GetString("updated"),
+		Id:              record.Id,
+		Identifier:      record.GetString("identifier"),
+		Name:            model.Name,
+		Description:     model.Description,
+		Icon:            model.Icon,
+		Capabilities:    model.Capabilities,
+		Provider:        model.Provider,
+		Default:         model.Default,
+		Fallbacks:       model.Fallbacks,
+		MaxOutputTokens: model.MaxOutputTokens,
+		Created:         record.GetString("created"),
+		Updated:         record.GetString("updated"),
 	}, nil
 }
 
@@ -159,6 +306,48 @@ func getAIModelByIdentifier(se *core.ServeEvent, identifier string) (*AIModel, e
 	return &model, nil
 }
 
+// ModelHasCapability reports whether the model is known and lists the capability
+func ModelHasCapability(e *core.RequestEvent, identifier, capability string) bool {
+	model, err := GetAIModelByIdentifier(e, identifier)
+	if err != nil {
+		return false
+	}
+
+	capabilities, err := model.GetCapabilities()
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(capabilities, capability)
+}
+
+// GetModelFallbackChain returns the identifier followed by its configured fallbacks,
+// in the order they should be attempted. Unknown models have no fallbacks.
+func GetModelFallbackChain(e *core.RequestEvent, identifier string) []string {
+	chain := []string{identifier}
+
+	model, err := GetAIModelByIdentifier(e, identifier)
+	if err != nil {
+		return chain
+	}
+
+	fallbacks, err := model.GetFallbacks()
+	if err != nil {
+		return chain
+	}
+
+	seen := map[string]bool{identifier: true}
+	for _, fallback := range fallbacks {
+		if fallback == "" || seen[fallback] {
+			continue
+		}
+		seen[fallback] = true
+		chain = append(chain, fallback)
+	}
+
+	return chain
+}
+
 func GetAllAIModels(e *core.RequestEvent) ([]*AIModel, error) {
 	query := e.App.DB().Select("*").From("ai_models").OrderBy("provider DESC")
 
@@ -195,7 +384,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "GPT-4.1",
 			Description:  "Most capable model with advanced reasoning",
 			Icon:         "🤖",
-			Capabilities: `["internet"]`,
+			Capabilities: `["internet", "vision"]`,
 			Provider:     "OpenAI",
 			Default:      false,
 		},
@@ -204,7 +393,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "GPT-4.1 Mini",
 			Description:  "Fast and efficient for everyday tasks",
 			Icon:         "🚀",
-			Capabilities: `["internet"]`,
+			Capabilities: `["internet", "vision"]`,
 			Provider:     "OpenAI",
 			Default:      false,
 		},
@@ -213,7 +402,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "GPT-4o",
 			Description:  "More capable model with advanced reasoning",
 			Icon:         "🤖",
-			Capabilities: `["internet"]`,
+			Capabilities: `["internet", "vision"]`,
 			Provider:     "OpenAI",
 			Default:      false,
 		},
@@ -222,7 +411,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "GPT-4o Mini",
 			Description:  "Fast and efficient for everyday tasks",
 			Icon:         "⚡",
-			Capabilities: `["internet"]`,
+			Capabilities: `["internet", "vision"]`,
 			Provider:     "OpenAI",
 			Default:      false,
 		},
@@ -231,7 +420,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "Claude Sonnet 4",
 			Description:  "Anthropic's latest and most capable model",
 			Icon:         "🎭",
-			Capabilities: `["reasoning"]`,
+			Capabilities: `["reasoning", "vision"]`,
 			Provider:     "Anthropic",
 			Default:      false,
 		},
@@ -240,7 +429,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "Claude 3.5 Sonnet",
 			Description:  "Anthropic's older but reliable model",
 			Icon:         "🎪",
-			Capabilities: "",
+			Capabilities: `["vision"]`,
 			Provider:     "Anthropic",
 			Default:      false,
 		},
@@ -249,7 +438,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "Llama 4 Maverick",
 			Description:  "Meta's latest and most capable model",
 			Icon:         "🦙",
-			Capabilities: "",
+			Capabilities: `["vision"]`,
 			Provider:     "Meta",
 			Default:      false,
 		},
@@ -294,7 +483,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "Gemini 2.5 Pro Preview",
 			Description:  "Google's latest and most capable model",
 			Icon:         "✨",
-			Capabilities: `["reasoning"]`,
+			Capabilities: `["reasoning", "vision"]`,
 			Provider:     "Google",
 			Default:      false,
 		},
@@ -303,7 +492,7 @@ func SeedDefaultModels(e *core.ServeEvent) error {
 			Name:         "Gemini 2.5 Flash Preview",
 			Description:  "Google's latest and most capable flash model",
 			Icon:         "💎",
-			Capabilities: `["reasoningsuffix"]`,
+			Capabilities: `["reasoningsuffix", "vision"]`,
 			Provider:     "Google",
 			Default:      false,
 		},
@@ -0,0 +1,49 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Ordering queries take the app because new documents get their order key in a record hook.
+
+// siblingsExp matches the documents of a folder, or the top level documents of the user
+// when parentId is empty. Documents in the trash are left out.
+func siblingsExp(userId, parentId string) dbx.Expression {
+	if parentId == "" {
+		return dbx.HashExp{"user": userId, "parent": "", "deleted_at": ""}
+	}
+	return dbx.HashExp{"parent": parentId, "deleted_at": ""}
+}
+
+// GetDocumentSiblings lists the ids and order keys of the documents in a folder, in order
+func GetDocumentSiblings(app core.App, userId, parentId string) ([]*Document, error) {
+	query := app.DB().Select("id", "order_key").
+		From("documents").
+		Where(siblingsExp(userId, parentId)).
+		OrderBy(documentOrder...)
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// GetLastDocumentOrderKey returns the highest order key in a folder, empty when it has none
+func GetLastDocumentOrderKey(app core.App, userId, parentId string) (string, error) {
+	var key string
+	err := app.DB().Select("(COALESCE(MAX(order_key), ''))").
+		From("documents").
+		Where(siblingsExp(userId, parentId)).
+		Row(&key)
+	return key, err
+}
+
+// UpdateDocumentOrderKey writes to the table directly so that reordering does not count
+// as an edit of the document
+func UpdateDocumentOrderKey(app core.App, documentId, key string) error {
+	_, err := app.DB().Update("documents", dbx.Params{"order_key": key}, dbx.HashExp{"id": documentId}).Execute()
+	return err
+}
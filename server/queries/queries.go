@@ -2,12 +2,14 @@ package queries
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
-	"time"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 // Conversation queries
@@ -28,6 +30,7 @@ func CreateConversation(e *core.RequestEvent, conversation *Conversation) (*Conv
 	record.Set("reasoning_tokens", conversation.ReasoningTokens)
 	record.Set("cost", conversation.Cost)
 	record.Set("active", conversation.Active)
+	record.Set("settings", conversation.Settings)
 	record.Set("created", conversation.Created)
 	record.Set("updated", conversation.Updated)
 
@@ -46,13 +49,14 @@ func CreateConversation(e *core.RequestEvent, conversation *Conversation) (*Conv
 		ReasoningTokens: conversation.ReasoningTokens,
 		Cost:            conversation.Cost,
 		Active:          conversation.Active,
+		Settings:        conversation.Settings,
 		Created:         conversation.Created,
 		Updated:         conversation.Updated,
 	}, nil
 }
 
 func GetConversationById(e *core.RequestEvent, id string) (*Conversation, error) {
-	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created", "updated").From("conversations").Where(dbx.HashExp{"id": id})
+	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "settings", "pinned", "archived_at", "tags", "folder", "created", "updated").From("conversations").Where(dbx.HashExp{"id": id})
 
 	var conversation Conversation
 	if err := query.One(&conversation); err != nil {
@@ -63,7 +67,7 @@ func GetConversationById(e *core.RequestEvent, id string) (*Conversation, error)
 }
 
 func GetConversationsByUserId(e *core.RequestEvent, userId string, includeMessages bool) ([]*Conversation, error) {
-	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "created", "updated").
+	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "settings", "pinned", "archived_at", "tags", "folder", "created", "updated").
 		From("conversations").
 		Where(dbx.HashExp{"user": userId}).
 		OrderBy("updated DESC")
@@ -73,23 +77,17 @@ func GetConversationsByUserId(e *core.RequestEvent, userId string, includeMessag
 		return nil, err
 	}
 
-	conversationMessages := make([]ConversationMessage, 0)
-	for _, conversation := range conversations {
-		if includeMessages {
-			messages, err := GetConversationMessagesByConversationId(e, conversation.Id)
-			if err != nil {
-				return nil, err
-			}
-			conversationMessages = append(conversationMessages, messages...)
+	if includeMessages {
+		if err := loadActiveMessages(e, conversations); err != nil {
+			return nil, err
 		}
-		conversation.Messages = conversationMessages
 	}
 
 	return conversations, nil
 }
 
 func GetConversationsByUserIdAndType(e *core.RequestEvent, userId string, conversationType string, includeMessages bool) ([]*Conversation, error) {
-	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "created", "updated").
+	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "settings", "pinned", "archived_at", "tags", "folder", "created", "updated").
 		From("conversations").
 		Where(dbx.HashExp{"user": userId, "type": conversationType}).
 		OrderBy("updated DESC")
@@ -99,21 +97,52 @@ func GetConversationsByUserIdAndType(e *core.RequestEvent, userId string, conver
 		return nil, err
 	}
 
-	conversationMessages := make([]ConversationMessage, 0)
-	for _, conversation := range conversations {
-		if includeMessages {
-			messages, err := GetConversationMessagesByConversationId(e, conversation.Id)
-			if err != nil {
-				return nil, err
-			}
-			conversationMessages = append(conversationMessages, messages...)
+	if includeMessages {
+		if err := loadActiveMessages(e, conversations); err != nil {
+			return nil, err
 		}
-		conversation.Messages = conversationMessages
 	}
 
 	return conversations, nil
 }
 
+// loadActiveMessages fills in the active messages of every conversation with a single query
+func loadActiveMessages(e *core.RequestEvent, conversations []*Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.Id
+	}
+
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
+		From("conversation_messages").
+		Where(dbx.In("conversation", ids...)).
+		AndWhere(dbx.HashExp{"active": true}).
+		OrderBy("conversation ASC", "created ASC")
+
+	var messages []ConversationMessage
+	if err := query.All(&messages); err != nil {
+		return err
+	}
+
+	byConversation := make(map[string][]ConversationMessage, len(conversations))
+	for _, message := range messages {
+		byConversation[message.ConversationId] = append(byConversation[message.ConversationId], message)
+	}
+
+	for _, conversation := range conversations {
+		conversation.Messages = byConversation[conversation.Id]
+		if conversation.Messages == nil {
+			conversation.Messages = make([]ConversationMessage, 0)
+		}
+	}
+
+	return nil
+}
+
 func UpdateConversation(e *core.RequestEvent, fields map[string]interface{}, where dbx.Expression) (sql.Result, error) {
 	query := e.App.DB().Update("conversations", fields, where)
 	return query.Execute()
@@ -144,31 +173,43 @@ func CreateConversationMessage(e *core.RequestEvent, message *ConversationMessag
 	record.Set("reasoning_tokens", message.ReasoningTokens)
 	record.Set("cost", message.Cost)
 	record.Set("active", message.Active)
+	record.Set("candidate_group", message.CandidateGroup)
+	record.Set("generation_params", message.GenerationParams)
+	record.Set("tool_calls", message.ToolCalls)
+	record.Set("citations", message.Citations)
 	record.Set("created", message.Created)
+	if len(message.Uploads) > 0 {
+		record.Set("attachments", message.Uploads)
+	}
 
 	if err := e.App.Save(record); err != nil {
 		return nil, err
 	}
 
 	return &ConversationMessage{
-		Id:              record.Id,
-		UserId:          message.UserId,
-		ConversationId:  message.ConversationId,
-		UserMessage:     message.UserMessage,
-		ResponseMessage: message.ResponseMessage,
-		ThinkingContent: message.ThinkingContent,
-		Model:           message.Model,
-		InputTokens:     message.InputTokens,
-		OutputTokens:    message.OutputTokens,
-		ReasoningTokens: message.ReasoningTokens,
-		Cost:            message.Cost,
-		Active:          message.Active,
-		Created:         message.Created,
+		Id:               record.Id,
+		UserId:           message.UserId,
+		ConversationId:   message.ConversationId,
+		UserMessage:      message.UserMessage,
+		ResponseMessage:  message.ResponseMessage,
+		ThinkingContent:  message.ThinkingContent,
+		Model:            message.Model,
+		InputTokens:      message.InputTokens,
+		OutputTokens:     message.OutputTokens,
+		ReasoningTokens:  message.ReasoningTokens,
+		Cost:             message.Cost,
+		Active:           message.Active,
+		CandidateGroup:   message.CandidateGroup,
+		GenerationParams: message.GenerationParams,
+		Attachments:      record.GetStringSlice("attachments"),
+		ToolCalls:        message.ToolCalls,
+		Citations:        message.Citations,
+		Created:          record.GetString("created"),
 	}, nil
 }
 
 func GetConversationMessageById(e *core.RequestEvent, id string) (*ConversationMessage, error) {
-	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created").
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
 		From("conversation_messages").
 		Where(dbx.HashExp{"id": id})
 
@@ -181,7 +222,7 @@ func GetConversationMessageById(e *core.RequestEvent, id string) (*ConversationM
 }
 
 func GetConversationMessagesByConversationId(e *core.RequestEvent, conversationId string) ([]ConversationMessage, error) {
-	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created").
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
 		From("conversation_messages").
 		Where(dbx.HashExp{"conversation": conversationId}).
 		OrderBy("created ASC")
@@ -195,7 +236,7 @@ func GetConversationMessagesByConversationId(e *core.RequestEvent, conversationI
 }
 
 func GetConversationMessagesByUserId(e *core.RequestEvent, userId string) ([]*ConversationMessage, error) {
-	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created").
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
 		From("conversation_messages").
 		Where(dbx.HashExp{"user": userId}).
 		OrderBy("created DESC")
@@ -209,7 +250,7 @@ func GetConversationMessagesByUserId(e *core.RequestEvent, userId string) ([]*Co
 }
 
 func GetActiveConversationMessagesByConversationId(e *core.RequestEvent, conversationId string) ([]*ConversationMessage, error) {
-	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created").
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
 		From("conversation_messages").
 		Where(dbx.HashExp{"conversation": conversationId, "active": true}).
 		OrderBy("created ASC")
@@ -260,7 +301,7 @@ func DeactivateMessagesFromTimestamp(e *core.RequestEvent, conversationId string
 }
 
 func GetActiveMessagesByConversationIdOrdered(e *core.RequestEvent, conversationId string) ([]*ConversationMessage, error) {
-	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created").
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
 		From("conversation_messages").
 		Where(dbx.HashExp{"conversation": conversationId, "active": true}).
 		OrderBy("created ASC")
@@ -273,6 +314,98 @@ func GetActiveMessagesByConversationIdOrdered(e *core.RequestEvent, conversation
 	return messages, nil
 }
 
+func GetMessagesByCandidateGroup(e *core.RequestEvent, conversationId string, candidateGroup string) ([]*ConversationMessage, error) {
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
+		From("conversation_messages").
+		Where(dbx.HashExp{"conversation": conversationId, "candidate_group": candidateGroup}).
+		OrderBy("created ASC", "id ASC")
+
+	var messages []*ConversationMessage
+	if err := query.All(&messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetMessagesByCandidateGroups loads the messages of several candidate groups in one
+// query, grouped by candidate group in the order they were created
+func GetMessagesByCandidateGroups(e *core.RequestEvent, conversationId string, candidateGroups []string) (map[string][]*ConversationMessage, error) {
+	groups := make(map[string][]*ConversationMessage, len(candidateGroups))
+	if len(candidateGroups) == 0 {
+		return groups, nil
+	}
+
+	values := make([]interface{}, len(candidateGroups))
+	for i, candidateGroup := range candidateGroups {
+		values[i] = candidateGroup
+	}
+
+	query := e.App.DB().Select("id", "user", "conversation", "user_message", "response_message", "thinking_content", "model", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "candidate_group", "generation_params", "attachments", "tool_calls", "citations", "created").
+		From("conversation_messages").
+		Where(dbx.HashExp{"conversation": conversationId}).
+		AndWhere(dbx.In("candidate_group", values...)).
+		OrderBy("created ASC", "id ASC")
+
+	var messages []*ConversationMessage
+	if err := query.All(&messages); err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		groups[message.CandidateGroup] = append(groups[message.CandidateGroup], message)
+	}
+
+	return groups, nil
+}
+
+// ErrCandidateNotOnBranch is returned when selecting a candidate of a group that is not
+// part of the active branch of the conversation, for example after an earlier message
+// was edited
+var ErrCandidateNotOnBranch = errors.New("candidate group is not on the active branch")
+
+// SelectCandidateMessage makes the message the only active response of its candidate group.
+// The active messages after the previous choice are its follow-ups, so they are deactivated
+// as well. Messages of other branches are left as they are.
+func SelectCandidateMessage(e *core.RequestEvent, message *ConversationMessage) error {
+	return e.App.RunInTransaction(func(txApp core.App) error {
+		var selected ConversationMessage
+		err := txApp.DB().Select("id", "created").
+			From("conversation_messages").
+			Where(dbx.HashExp{"conversation": message.ConversationId, "candidate_group": message.CandidateGroup, "active": true}).
+			OrderBy("created ASC").
+			Limit(1).
+			One(&selected)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCandidateNotOnBranch
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := txApp.DB().Update("conversation_messages",
+			dbx.Params{"active": false},
+			dbx.And(
+				dbx.HashExp{"conversation": message.ConversationId, "active": true},
+				dbx.NewExp("created > {:timestamp}", dbx.Params{"timestamp": selected.Created}),
+				dbx.Not(dbx.HashExp{"candidate_group": message.CandidateGroup}),
+			)).Execute(); err != nil {
+			return err
+		}
+
+		if _, err := txApp.DB().Update("conversation_messages",
+			dbx.Params{"active": false},
+			dbx.HashExp{"conversation": message.ConversationId, "candidate_group": message.CandidateGroup}).Execute(); err != nil {
+			return err
+		}
+
+		_, err = txApp.DB().Update("conversation_messages",
+			dbx.Params{"active": true},
+			dbx.HashExp{"id": message.Id}).Execute()
+		return err
+	})
+}
+
 func UpdateConversationTotals(e *core.RequestEvent, conversationId string, additionalInputTokens, additionalOutputTokens, additionalReasoningTokens int64, additionalCost float64) error {
 	// Get current conversation
 	conversation, err := GetConversationById(e, conversationId)
@@ -301,7 +434,7 @@ func UpdateConversationTotals(e *core.RequestEvent, conversationId string, addit
 		"reasoning_tokens": strconv.FormatInt(newReasoningTokens, 10),
 		"cost":             fmt.Sprintf("%.6f", newCost),
 		"total_requests":   strconv.FormatInt(newRequests, 10),
-		"updated":          time.Now().Format(time.RFC3339),
+		"updated":          types.NowDateTime().String(),
 	}
 
 	_, err = UpdateConversation(e, fields, dbx.HashExp{"id": conversationId})
@@ -309,52 +442,40 @@ func UpdateConversationTotals(e *core.RequestEvent, conversationId string, addit
 }
 
 func GetActiveConversationsByUserId(e *core.RequestEvent, userId string, includeMessages bool) ([]*Conversation, error) {
-	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created", "updated").
+	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "settings", "pinned", "archived_at", "tags", "folder", "created", "updated").
 		From("conversations").
-		Where(dbx.HashExp{"user": userId, "active": true}).
-		OrderBy("updated DESC")
+		Where(dbx.HashExp{"user": userId, "active": true, "archived_at": ""}).
+		OrderBy("pinned DESC", "updated DESC")
 
 	var conversations []*Conversation
 	if err := query.All(&conversations); err != nil {
 		return nil, err
 	}
 
-	conversationMessages := make([]ConversationMessage, 0)
-	for _, conversation := range conversations {
-		if includeMessages {
-			messages, err := GetConversationMessagesByConversationId(e, conversation.Id)
-			if err != nil {
-				return nil, err
-			}
-			conversationMessages = append(conversationMessages, messages...)
+	if includeMessages {
+		if err := loadActiveMessages(e, conversations); err != nil {
+			return nil, err
 		}
-		conversation.Messages = conversationMessages
 	}
 
 	return conversations, nil
 }
 
 func GetActiveConversationsByUserIdAndType(e *core.RequestEvent, userId string, conversationType string, includeMessages bool) ([]*Conversation, error) {
-	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "created", "updated").
+	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "settings", "pinned", "archived_at", "tags", "folder", "created", "updated").
 		From("conversations").
-		Where(dbx.HashExp{"user": userId, "type": conversationType, "active": true}).
-		OrderBy("updated DESC")
+		Where(dbx.HashExp{"user": userId, "type": conversationType, "active": true, "archived_at": ""}).
+		OrderBy("pinned DESC", "updated DESC")
 
 	var conversations []*Conversation
 	if err := query.All(&conversations); err != nil {
 		return nil, err
 	}
 
-	conversationMessages := make([]ConversationMessage, 0)
-	for _, conversation := range conversations {
-		if includeMessages {
-			messages, err := GetConversationMessagesByConversationId(e, conversation.Id)
-			if err != nil {
-				return nil, err
-			}
-			conversationMessages = append(conversationMessages, messages...)
+	if includeMessages {
+		if err := loadActiveMessages(e, conversations); err != nil {
+			return nil, err
 		}
-		conversation.Messages = conversationMessages
 	}
 
 	return conversations, nil
@@ -363,9 +484,88 @@ func GetActiveConversationsByUserIdAndType(e *core.RequestEvent, userId string,
 func DeactivateConversation(e *core.RequestEvent, conversationId string) error {
 	fields := map[string]interface{}{
 		"active":  false,
-		"updated": time.Now().Format(time.RFC3339),
+		"updated": types.NowDateTime().String(),
 	}
 
 	_, err := UpdateConversation(e, fields, dbx.HashExp{"id": conversationId})
 	return err
 }
+
+// GetArchivedConversationsByUserId lists the archived conversations of the user, most recently archived first
+func GetArchivedConversationsByUserId(e *core.RequestEvent, userId string) ([]*Conversation, error) {
+	query := e.App.DB().Select("id", "user", "title", "type", "total_requests", "input_tokens", "output_tokens", "reasoning_tokens", "cost", "active", "settings", "pinned", "archived_at", "tags", "folder", "created", "updated").
+		From("conversations").
+		Where(dbx.HashExp{"user": userId, "active": true}).
+		AndWhere(dbx.Not(dbx.HashExp{"archived_at": ""})).
+		OrderBy("archived_at DESC")
+
+	var conversations []*Conversation
+	if err := query.All(&conversations); err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+// DeleteConversationPermanently removes the conversation and all of its messages in one
+// transaction, then the attachment files of the removed messages
+func DeleteConversationPermanently(app core.App, conversationId string) error {
+	collection, err := app.FindCachedCollectionByNameOrId("conversation_messages")
+	if err != nil {
+		return err
+	}
+
+	var withAttachments []string
+	err = app.DB().Select("id").
+		From("conversation_messages").
+		Where(dbx.HashExp{"conversation": conversationId}).
+		AndWhere(dbx.NewExp("attachments NOT IN ('', '[]')")).
+		Column(&withAttachments)
+	if err != nil {
+		return err
+	}
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		// The message queries only use the app of the event, so bind them to the transaction
+		txEvent := &core.RequestEvent{App: txApp}
+
+		if _, err := DeleteConversationMessagesByConversationId(txEvent, conversationId); err != nil {
+			return err
+		}
+
+		_, err := DeleteConversation(txEvent, conversationId)
+		return err
+	})
+	if err != nil || len(withAttachments) == 0 {
+		return err
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	for _, messageId := range withAttachments {
+		if errs := fsys.DeletePrefix(collection.Id + "/" + messageId + "/"); len(errs) > 0 {
+			log.Printf("Failed to delete attachments of message %s: %v", messageId, errs)
+		}
+	}
+
+	return nil
+}
+
+// GetConversationIdsArchivedBefore lists the conversations archived before the cutoff date
+func GetConversationIdsArchivedBefore(app core.App, cutoff string) ([]string, error) {
+	query := app.DB().Select("id").
+		From("conversations").
+		Where(dbx.Not(dbx.HashExp{"archived_at": ""})).
+		AndWhere(dbx.NewExp("archived_at < {:cutoff}", dbx.Params{"cutoff": cutoff}))
+
+	var ids []string
+	if err := query.Column(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,414 @@
+//go:build !goexperiment.jsonv2
+
+// PocketBase v0.27 overflows the stack when built with the encoding/json v2 experiment,
+// so on toolchains where it is the default these tests need GOEXPERIMENT=nojsonv2.
+
+package queries_test
+
+import (
+	"fmt"
+	"testing"
+	"textly/queries"
+	"textly/services"
+	"time"
+
+	_ "textly/migrations"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// seedConversations creates a user with the given number of conversations, each with
+// activeMessages active messages and one inactive message
+func seedConversations(tb testing.TB, conversationCount, activeMessages int) (*tests.TestApp, *core.RequestEvent, string) {
+	tb.Helper()
+
+	app, err := tests.NewTestApp("../test_pb_data")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(app.Cleanup)
+
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	user := core.NewRecord(users)
+	user.SetEmail("seed@example.com")
+	user.SetPassword("seed-password-123")
+	if err := app.Save(user); err != nil {
+		tb.Fatal(err)
+	}
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		txEvent := &core.RequestEvent{App: txApp}
+
+		for i := range conversationCount {
+			conversation, err := queries.CreateConversation(txEvent, &queries.Conversation{
+				UserId: user.Id,
+				Title:  fmt.Sprintf("Conversation %d", i),
+				Type:   "chat",
+				Active: true,
+			})
+			if err != nil {
+				return err
+			}
+
+			for j := range activeMessages + 1 {
+				_, err := queries.CreateConversationMessage(txEvent, &queries.ConversationMessage{
+					UserId:          user.Id,
+					ConversationId:  conversation.Id,
+					UserMessage:     fmt.Sprintf("Question %d of %s", j, conversation.Id),
+					ResponseMessage: fmt.Sprintf("Answer %d of %s", j, conversation.Id),
+					Active:          j < activeMessages,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return app, &core.RequestEvent{App: app}, user.Id
+}
+
+func TestGetActiveConversationsByUserIdIncludesOwnActiveMessages(t *testing.T) {
+	_, e, userId := seedConversations(t, 3, 2)
+
+	conversations, err := queries.GetActiveConversationsByUserId(e, userId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conversations) != 3 {
+		t.Fatalf("expected 3 conversations, got %d", len(conversations))
+	}
+
+	for _, conversation := range conversations {
+		if len(conversation.Messages) != 2 {
+			t.Fatalf("conversation %s has %d messages, expected its 2 active ones", conversation.Id, len(conversation.Messages))
+		}
+
+		for _, message := range conversation.Messages {
+			if message.ConversationId != conversation.Id {
+				t.Fatalf("conversation %s received message %s of conversation %s", conversation.Id, message.Id, message.ConversationId)
+			}
+			if !message.Active {
+				t.Fatalf("conversation %s received inactive message %s", conversation.Id, message.Id)
+			}
+		}
+
+		if conversation.Messages[0].UserMessage != "Question 0 of "+conversation.Id {
+			t.Fatalf("messages of conversation %s are out of order", conversation.Id)
+		}
+	}
+
+	withoutMessages, err := queries.GetActiveConversationsByUserIdAndType(e, userId, "chat", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, conversation := range withoutMessages {
+		if len(conversation.Messages) != 0 {
+			t.Fatalf("messages were loaded without include_messages")
+		}
+	}
+}
+
+func BenchmarkGetActiveConversationsByUserIdWithMessages(b *testing.B) {
+	_, e, userId := seedConversations(b, 200, 10)
+
+	b.ResetTimer()
+	for range b.N {
+		conversations, err := queries.GetActiveConversationsByUserId(e, userId, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(conversations) != 200 || len(conversations[0].Messages) != 10 {
+			b.Fatalf("unexpected result: %d conversations", len(conversations))
+		}
+	}
+}
+
+func TestListConversationsPaginatesAndFilters(t *testing.T) {
+	_, e, userId := seedConversations(t, 5, 1)
+
+	conversations, err := queries.GetActiveConversationsByUserId(e, userId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Conversations updated one after the other like a request does, the first pinned, the
+	// second answered by a model that only an inactive message of the third used
+	var since types.DateTime
+	for i, conversation := range conversations {
+		time.Sleep(2 * time.Millisecond)
+		if i == 2 {
+			since = types.NowDateTime()
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		if err := queries.UpdateConversationTotals(e, conversation.Id, 10, 20, 0, 0.01); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := queries.UpdateConversation(e, map[string]interface{}{"pinned": true}, dbx.HashExp{"id": conversations[0].Id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queries.UpdateConversationMessage(e, map[string]interface{}{"model": "current-model"}, dbx.HashExp{"id": conversations[1].Messages[0].Id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queries.UpdateConversationMessage(e, map[string]interface{}{"model": "old-model"}, dbx.HashExp{"conversation": conversations[2].Id, "active": false}); err != nil {
+		t.Fatal(err)
+	}
+
+	var pages [][]string
+	var seen []string
+	filter := queries.ConversationListFilter{Sort: "updated", Descending: true, Limit: 2}
+	for {
+		items, err := queries.ListConversations(e, userId, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var page []string
+		for _, item := range items {
+			page = append(page, item.Id)
+			seen = append(seen, item.Id)
+		}
+		pages = append(pages, page)
+
+		if len(items) < int(filter.Limit) || len(pages) > 5 {
+			break
+		}
+		last := items[len(items)-1]
+		filter.After = &queries.ConversationCursor{Pinned: last.Pinned, Value: last.SortValue, Id: last.Id}
+	}
+
+	want := []string{conversations[0].Id, conversations[4].Id, conversations[3].Id, conversations[2].Id, conversations[1].Id}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Fatalf("expected the pinned conversation and then the most recent ones, got %v in pages %v", seen, pages)
+	}
+
+	pinned := false
+	items, err := queries.ListConversations(e, userId, queries.ConversationListFilter{Sort: "updated", Pinned: &pinned, From: since.String(), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[0].Id != conversations[2].Id {
+		t.Fatalf("expected the 3 unpinned conversations updated since the 3rd, got %d items", len(items))
+	}
+
+	items, err = queries.ListConversations(e, userId, queries.ConversationListFilter{Sort: "updated", To: since.String(), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected the 2 conversations updated before the 3rd, got %d items", len(items))
+	}
+
+	// The upper bound of a plain date, as the route sets it, includes the whole day
+	now := types.NowDateTime().Time().UTC()
+	endOfDay, err := types.ParseDateTime(now.Truncate(24 * time.Hour).Add(24*time.Hour - time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err = queries.ListConversations(e, userId, queries.ConversationListFilter{Sort: "updated", To: endOfDay.String(), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 5 {
+		t.Fatalf("expected the 5 conversations updated by the end of the day, got %d items", len(items))
+	}
+
+	for model, expected := range map[string]int{"current-model": 1, "old-model": 0} {
+		items, err := queries.ListConversations(e, userId, queries.ConversationListFilter{Sort: "updated", Model: model, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != expected {
+			t.Fatalf("expected %d conversations answered by %s, got %d", expected, model, len(items))
+		}
+	}
+
+	if _, err := queries.UpdateConversation(e, map[string]interface{}{"archived_at": "2026-02-01 10:00:00.000Z"}, dbx.HashExp{"id": conversations[3].Id}); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := queries.ListConversations(e, userId, queries.ConversationListFilter{Sort: "archived_at", Archived: true, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].Id != conversations[3].Id {
+		t.Fatalf("expected only the archived conversation, got %d items", len(archived))
+	}
+}
+
+func TestListConversationsFiltersByTag(t *testing.T) {
+	_, e, userId := seedConversations(t, 3, 1)
+
+	tag, err := queries.CreateConversationTag(e, &queries.ConversationTag{UserId: userId, Name: "Research"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversations, err := queries.GetActiveConversationsByUserId(e, userId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagged := conversations[1]
+	if _, err := queries.UpdateConversation(e, map[string]interface{}{"tags": types.JSONArray[string]{tag.Id}}, dbx.HashExp{"id": tagged.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := queries.ListConversations(e, userId, queries.ConversationListFilter{Sort: "updated", Tag: tag.Id, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Id != tagged.Id {
+		t.Fatalf("expected only the tagged conversation, got %d items", len(items))
+	}
+	if items[0].MessageCount != 1 || items[0].LastMessage == "" {
+		t.Fatalf("expected the message count and preview, got %+v", items[0])
+	}
+
+	if err := queries.DeleteConversationTag(e, tag.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := queries.GetConversationById(e, tagged.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversation.Tags) != 0 {
+		t.Fatalf("deleted tag is still on the conversation: %v", conversation.Tags)
+	}
+}
+
+func TestSelectCandidateMessageOnlyChangesTheFollowingBranch(t *testing.T) {
+	_, e, userId := seedConversations(t, 1, 0)
+
+	conversations, err := queries.GetActiveConversationsByUserId(e, userId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversationId := conversations[0].Id
+
+	messages := make(map[string]*queries.ConversationMessage)
+	add := func(name, group, created string, active bool) {
+		message, err := queries.CreateConversationMessage(e, &queries.ConversationMessage{
+			UserId:         userId,
+			ConversationId: conversationId,
+			UserMessage:    name,
+			CandidateGroup: group,
+			Active:         active,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := queries.UpdateConversationMessage(e, map[string]interface{}{"created": created}, dbx.HashExp{"id": message.Id}); err != nil {
+			t.Fatal(err)
+		}
+		message.Created = created
+		messages[name] = message
+	}
+
+	// An answer from an edited branch, the active path with a compare group and a
+	// follow-up to its first candidate, and a compare group left behind on another branch
+	add("old", "", "2026-01-01 10:00:00.000Z", false)
+	add("first", "", "2026-01-01 10:01:00.000Z", true)
+	add("a", "group", "2026-01-01 10:02:00.000Z", true)
+	add("b", "group", "2026-01-01 10:02:00.000Z", false)
+	add("followup", "", "2026-01-01 10:03:00.000Z", true)
+	add("c", "other", "2026-01-01 10:04:00.000Z", false)
+	add("d", "other", "2026-01-01 10:04:00.000Z", false)
+
+	groups, err := queries.GetMessagesByCandidateGroups(e, conversationId, []string{"group", "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups["group"]) != 2 || len(groups["other"]) != 2 {
+		t.Fatalf("expected both candidate groups with 2 messages, got %v", groups)
+	}
+
+	if err := queries.SelectCandidateMessage(e, messages["b"]); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := queries.GetActiveMessagesByConversationIdOrdered(e, conversationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 || active[0].Id != messages["first"].Id || active[1].Id != messages["b"].Id {
+		t.Fatalf("expected the first message and the selected candidate to be active, got %d messages", len(active))
+	}
+
+	if err := queries.SelectCandidateMessage(e, messages["d"]); err != queries.ErrCandidateNotOnBranch {
+		t.Fatalf("expected selecting a candidate of another branch to fail, got %v", err)
+	}
+
+	active, err = queries.GetActiveMessagesByConversationIdOrdered(e, conversationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 {
+		t.Fatalf("a failed selection changed the active branch")
+	}
+}
+
+func TestGetClosestChunksRanksInTheDatabase(t *testing.T) {
+	app, e, userId := seedConversations(t, 0, 0)
+
+	createDocument := func(title string) *queries.Document {
+		document, err := queries.CreateDocument(e, &queries.Document{UserId: userId, Title: title, Content: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return document
+	}
+
+	live, trashed := createDocument("Live"), createDocument("Trashed")
+	if _, err := app.DB().Update("documents", dbx.Params{"deleted_at": types.NowDateTime().String()}, dbx.HashExp{"id": trashed.Id}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	vectors := map[string][]float32{"close": {1, 0.1}, "far": {0, 1}, "opposite": {-1, 0}, "closest": {1, 0}}
+	var liveChunks []*queries.DocumentChunk
+	for i, name := range []string{"close", "far", "opposite"} {
+		liveChunks = append(liveChunks, &queries.DocumentChunk{UserId: userId, ChunkIndex: i, Content: name, Model: "test", Embedding: services.EncodeVector(vectors[name])})
+	}
+	if err := queries.ReplaceDocumentChunks(app, live.Id, liveChunks); err != nil {
+		t.Fatal(err)
+	}
+	trashedChunks := []*queries.DocumentChunk{{UserId: userId, Content: "closest", Model: "test", Embedding: services.EncodeVector(vectors["closest"])}}
+	if err := queries.ReplaceDocumentChunks(app, trashed.Id, trashedChunks); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := queries.GetClosestChunks(app, userId, "test", services.EncodeVector([]float32{1, 0}), 0.1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 || chunks[0].Content != "close" || chunks[0].Score < 0.99 {
+		t.Fatalf("expected only the close chunk of the live document, got %d chunks", len(chunks))
+	}
+
+	chunks, err = queries.GetClosestChunks(app, userId, "test", services.EncodeVector([]float32{1, 1}), -1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].Score < chunks[1].Score {
+		t.Fatalf("expected the 2 closest chunks in order, got %d chunks", len(chunks))
+	}
+
+	if chunks, err := queries.GetClosestChunks(app, userId, "other", services.EncodeVector([]float32{1, 0}), -1, 5); err != nil || len(chunks) != 0 {
+		t.Fatalf("chunks of another model were compared: %d chunks, %v", len(chunks), err)
+	}
+}
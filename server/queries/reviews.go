@@ -0,0 +1,106 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Review queries take the app because reviews run in the background after the request.
+
+var documentReviewColumns = []string{"id", "document", "user", "content_hash", "status", "suggestions", "sections_total", "sections_done", "error", "model", "cost", "created", "updated"}
+
+func GetDocumentReviewById(app core.App, id string) (*DocumentReview, error) {
+	query := app.DB().Select(documentReviewColumns...).
+		From("document_reviews").
+		Where(dbx.HashExp{"id": id})
+
+	var review DocumentReview
+	if err := query.One(&review); err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// GetDocumentReviewByContentHash returns the review of the document made for the given content
+func GetDocumentReviewByContentHash(app core.App, documentId, contentHash string) (*DocumentReview, error) {
+	query := app.DB().Select(documentReviewColumns...).
+		From("document_reviews").
+		Where(dbx.HashExp{"document": documentId, "content_hash": contentHash})
+
+	var review DocumentReview
+	if err := query.One(&review); err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// StartDocumentReview creates the running review of the content, or restarts the review
+// already stored for it
+func StartDocumentReview(app core.App, review *DocumentReview) (*DocumentReview, error) {
+	record, err := app.FindFirstRecordByFilter("document_reviews", "document = {:document} && content_hash = {:hash}", dbx.Params{
+		"document": review.DocumentId,
+		"hash":     review.ContentHash,
+	})
+	if err != nil {
+		collection, err := app.FindCachedCollectionByNameOrId("document_reviews")
+		if err != nil {
+			return nil, err
+		}
+
+		record = core.NewRecord(collection)
+		record.Set("document", review.DocumentId)
+		record.Set("content_hash", review.ContentHash)
+	}
+
+	record.Set("user", review.UserId)
+	record.Set("status", review.Status)
+	record.Set("suggestions", []any{})
+	record.Set("sections_total", review.SectionsTotal)
+	record.Set("sections_done", 0)
+	record.Set("error", "")
+	record.Set("model", "")
+	record.Set("cost", 0)
+
+	if err := app.Save(record); err != nil {
+		return nil, err
+	}
+
+	return GetDocumentReviewById(app, record.Id)
+}
+
+// UpdateDocumentReviewProgress stores how many sections of the review are done
+func UpdateDocumentReviewProgress(app core.App, id string, sectionsDone int) error {
+	_, err := app.DB().Update("document_reviews", dbx.Params{
+		"sections_done": sectionsDone,
+		"updated":       types.NowDateTime().String(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// FinishDocumentReview stores the outcome of a review, its suggestions when it completed
+// or the error when it failed
+func FinishDocumentReview(app core.App, review *DocumentReview) error {
+	_, err := app.DB().Update("document_reviews", dbx.Params{
+		"status":        review.Status,
+		"suggestions":   review.Suggestions,
+		"sections_done": review.SectionsDone,
+		"error":         review.Error,
+		"model":         review.Model,
+		"cost":          review.Cost,
+		"updated":       types.NowDateTime().String(),
+	}, dbx.HashExp{"id": review.Id}).Execute()
+	return err
+}
+
+// UpdateDocumentReviewSuggestions replaces the suggestions of the review, which records
+// the comments added for them
+func UpdateDocumentReviewSuggestions(app core.App, id string, suggestions types.JSONRaw) error {
+	_, err := app.DB().Update("document_reviews", dbx.Params{
+		"suggestions": suggestions,
+		"updated":     types.NowDateTime().String(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
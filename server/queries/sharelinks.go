@@ -0,0 +1,85 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+var documentShareLinkColumns = []string{"id", "document", "user", "permissions", "expires_at", "revoked_at", "view_count", "last_viewed_at", "created", "updated"}
+
+func GetDocumentShareLinkById(e *core.RequestEvent, id string) (*DocumentShareLink, error) {
+	query := e.App.DB().Select(documentShareLinkColumns...).
+		From("document_share_links").
+		Where(dbx.HashExp{"id": id})
+
+	var link DocumentShareLink
+	if err := query.One(&link); err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// GetDocumentShareLinksByDocumentId lists the public links of a document, newest first,
+// including the revoked ones so their view counts stay visible
+func GetDocumentShareLinksByDocumentId(e *core.RequestEvent, documentId string) ([]*DocumentShareLink, error) {
+	query := e.App.DB().Select(documentShareLinkColumns...).
+		From("document_share_links").
+		Where(dbx.HashExp{"document": documentId}).
+		OrderBy("created DESC")
+
+	var links []*DocumentShareLink
+	if err := query.All(&links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func CreateDocumentShareLink(e *core.RequestEvent, link *DocumentShareLink) (*DocumentShareLink, error) {
+	collection, err := e.App.FindCollectionByNameOrId("document_share_links")
+	if err != nil {
+		return nil, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("document", link.DocumentId)
+	record.Set("user", link.UserId)
+	record.Set("permissions", link.Permissions)
+	record.Set("expires_at", link.ExpiresAt)
+
+	if err := e.App.Save(record); err != nil {
+		return nil, err
+	}
+
+	return &DocumentShareLink{
+		Id:          record.Id,
+		DocumentId:  link.DocumentId,
+		UserId:      link.UserId,
+		Permissions: link.Permissions,
+		ExpiresAt:   record.GetString("expires_at"),
+		Created:     record.GetString("created"),
+		Updated:     record.GetString("updated"),
+	}, nil
+}
+
+// RevokeDocumentShareLink marks the link as revoked, after which its tokens stop working
+func RevokeDocumentShareLink(e *core.RequestEvent, id string) error {
+	now := types.NowDateTime().String()
+	_, err := e.App.DB().Update("document_share_links", dbx.Params{
+		"revoked_at": now,
+		"updated":    now,
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// RecordDocumentShareLinkView counts a view of the link in a single statement, so
+// concurrent views are not lost
+func RecordDocumentShareLinkView(e *core.RequestEvent, id string) error {
+	_, err := e.App.DB().Update("document_share_links", dbx.Params{
+		"view_count":     dbx.NewExp("view_count + 1"),
+		"last_viewed_at": types.NowDateTime().String(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
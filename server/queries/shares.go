@@ -0,0 +1,230 @@
+package queries
+
+import (
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Share queries take the app because document access is also synced from record hooks.
+
+// maxDocumentDepth bounds the parent walks in case the tree contains a cycle
+const maxDocumentDepth = 100
+
+var documentShareColumns = []string{"id", "document", "user", "email", "role", "invited_by", "created", "updated"}
+
+type DocumentShare struct {
+	Id         string `db:"id"`
+	DocumentId string `db:"document"`
+	UserId     string `db:"user"`
+	Email      string `db:"email"`
+	Role       string `db:"role"`
+	InvitedBy  string `db:"invited_by"`
+	Created    string `db:"created"`
+	Updated    string `db:"updated"`
+}
+
+// DocumentNode is a document id with its owner and parent, enough to walk the tree
+type DocumentNode struct {
+	Id     string `db:"id"`
+	UserId string `db:"user"`
+	Parent string `db:"parent"`
+}
+
+func GetDocumentShareById(app core.App, id string) (*DocumentShare, error) {
+	query := app.DB().Select(documentShareColumns...).
+		From("document_shares").
+		Where(dbx.HashExp{"id": id})
+
+	var share DocumentShare
+	if err := query.One(&share); err != nil {
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+// GetDocumentSharesByDocumentIds lists the shares granted directly on any of the documents
+func GetDocumentSharesByDocumentIds(app core.App, documentIds []string) ([]*DocumentShare, error) {
+	if len(documentIds) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]interface{}, len(documentIds))
+	for i, id := range documentIds {
+		ids[i] = id
+	}
+
+	query := app.DB().Select(documentShareColumns...).
+		From("document_shares").
+		Where(dbx.In("document", ids...)).
+		OrderBy("created ASC")
+
+	var shares []*DocumentShare
+	if err := query.All(&shares); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// GetDocumentSharesByUserId lists the shares granted to the user
+func GetDocumentSharesByUserId(app core.App, userId string) ([]*DocumentShare, error) {
+	query := app.DB().Select(documentShareColumns...).
+		From("document_shares").
+		Where(dbx.HashExp{"user": userId}).
+		OrderBy("created DESC")
+
+	var shares []*DocumentShare
+	if err := query.All(&shares); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// SaveDocumentShare grants the role on the document to the email address, replacing the
+// role of an existing share for the same address
+func SaveDocumentShare(app core.App, share *DocumentShare) (*DocumentShare, error) {
+	record, err := app.FindFirstRecordByFilter("document_shares", "document = {:document} && email = {:email}", dbx.Params{
+		"document": share.DocumentId,
+		"email":    strings.ToLower(share.Email),
+	})
+	if err != nil {
+		collection, err := app.FindCachedCollectionByNameOrId("document_shares")
+		if err != nil {
+			return nil, err
+		}
+
+		record = core.NewRecord(collection)
+		record.Set("document", share.DocumentId)
+		record.Set("email", strings.ToLower(share.Email))
+		record.Set("invited_by", share.InvitedBy)
+	}
+
+	record.Set("user", share.UserId)
+	record.Set("role", share.Role)
+
+	if err := app.Save(record); err != nil {
+		return nil, err
+	}
+
+	return &DocumentShare{
+		Id:         record.Id,
+		DocumentId: record.GetString("document"),
+		UserId:     record.GetString("user"),
+		Email:      record.GetString("email"),
+		Role:       record.GetString("role"),
+		InvitedBy:  record.GetString("invited_by"),
+		Created:    record.GetString("created"),
+		Updated:    record.GetString("updated"),
+	}, nil
+}
+
+// DeleteDocumentShare deletes the share record so the access hooks run
+func DeleteDocumentShare(app core.App, id string) error {
+	record, err := app.FindRecordById("document_shares", id)
+	if err != nil {
+		return err
+	}
+
+	return app.Delete(record)
+}
+
+// ClaimDocumentShares attaches the invitations sent to the email before the user signed
+// up and returns the ids of the shared documents
+func ClaimDocumentShares(app core.App, userId, email string) ([]string, error) {
+	var documentIds []string
+	err := app.DB().Select("document").
+		From("document_shares").
+		Where(dbx.HashExp{"user": ""}).
+		AndWhere(dbx.NewExp("LOWER(email) = LOWER({:email})", dbx.Params{"email": email})).
+		Column(&documentIds)
+	if err != nil || len(documentIds) == 0 {
+		return nil, err
+	}
+
+	_, err = app.DB().Update("document_shares", dbx.Params{"user": userId}, dbx.And(
+		dbx.HashExp{"user": ""},
+		dbx.NewExp("LOWER(email) = LOWER({:email})", dbx.Params{"email": email}),
+	)).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return documentIds, nil
+}
+
+// GetDocumentAncestors returns the parent, grandparent and so on of the document,
+// nearest first
+func GetDocumentAncestors(app core.App, documentId string) ([]*DocumentNode, error) {
+	var nodes []*DocumentNode
+	err := app.DB().NewQuery(`
+		WITH RECURSIVE ancestors(id, user, parent, depth) AS (
+			SELECT id, user, parent, 0 FROM documents WHERE id = {:id}
+			UNION
+			SELECT documents.id, documents.user, documents.parent, ancestors.depth + 1
+			FROM documents JOIN ancestors ON documents.id = ancestors.parent
+			WHERE ancestors.depth < {:maxDepth}
+		)
+		SELECT id, user, parent FROM ancestors WHERE depth > 0 ORDER BY depth ASC`).
+		Bind(dbx.Params{"id": documentId, "maxDepth": maxDocumentDepth}).
+		All(&nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// GetDocumentSubtree returns the document and every document below it
+func GetDocumentSubtree(app core.App, documentId string) ([]*DocumentNode, error) {
+	var nodes []*DocumentNode
+	err := app.DB().NewQuery(`
+		WITH RECURSIVE subtree(id, user, parent, depth) AS (
+			SELECT id, user, parent, 0 FROM documents WHERE id = {:id}
+			UNION
+			SELECT documents.id, documents.user, documents.parent, subtree.depth + 1
+			FROM documents JOIN subtree ON documents.parent = subtree.id
+			WHERE subtree.depth < {:maxDepth}
+		)
+		SELECT id, user, parent FROM subtree ORDER BY depth ASC`).
+		Bind(dbx.Params{"id": documentId, "maxDepth": maxDocumentDepth}).
+		All(&nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// UpdateDocumentAccess stores the users that may view and edit the document. It writes
+// to the table directly so that syncing access does not count as an edit of the document.
+func UpdateDocumentAccess(app core.App, documentId string, viewers, editors []string) error {
+	_, err := app.DB().Update("documents", dbx.Params{
+		"viewers": types.JSONArray[string](viewers),
+		"editors": types.JSONArray[string](editors),
+	}, dbx.HashExp{"id": documentId}).Execute()
+	return err
+}
+
+// GetSharedDocumentsByUserId lists the documents and folders shared with the user whose
+// parent is not shared with them as well, so each shared tree is listed once
+func GetSharedDocumentsByUserId(app core.App, userId string) ([]*Document, error) {
+	query := app.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.NewExp("EXISTS (SELECT 1 FROM json_each(documents.viewers) WHERE json_each.value = {:user})", dbx.Params{"user": userId})).
+		AndWhere(dbx.NewExp(`NOT EXISTS (SELECT 1 FROM documents AS parents, json_each(parents.viewers)
+			WHERE parents.id = documents.parent AND json_each.value = {:user})`, dbx.Params{"user": userId})).
+		AndWhere(dbx.HashExp{"deleted_at": ""}).
+		OrderBy("is_folder DESC", "title ASC")
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,90 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var conversationTagColumns = []string{"id", "user", "name", "color", "created", "updated"}
+
+// GetConversationTagsByUserId lists the tags of the user with the number of current
+// conversations carrying each of them
+func GetConversationTagsByUserId(e *core.RequestEvent, userId string) ([]*ConversationTagWithCount, error) {
+	query := e.App.DB().Select(conversationTagColumns...).
+		AndSelect(`(SELECT COUNT(*) FROM conversations, json_each(conversations.tags)
+			WHERE json_each.value = conversation_tags.id AND conversations.active = TRUE) AS conversation_count`).
+		From("conversation_tags").
+		Where(dbx.HashExp{"user": userId}).
+		OrderBy("(name COLLATE NOCASE) ASC")
+
+	var tags []*ConversationTagWithCount
+	if err := query.All(&tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func GetConversationTagById(e *core.RequestEvent, id string) (*ConversationTag, error) {
+	query := e.App.DB().Select(conversationTagColumns...).
+		From("conversation_tags").
+		Where(dbx.HashExp{"id": id})
+
+	var tag ConversationTag
+	if err := query.One(&tag); err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// GetConversationTagByName finds a tag of the user by its name, ignoring case
+func GetConversationTagByName(e *core.RequestEvent, userId, name string) (*ConversationTag, error) {
+	query := e.App.DB().Select(conversationTagColumns...).
+		From("conversation_tags").
+		Where(dbx.HashExp{"user": userId}).
+		AndWhere(dbx.NewExp("LOWER(name) = LOWER({:name})", dbx.Params{"name": name}))
+
+	var tag ConversationTag
+	if err := query.One(&tag); err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+func CreateConversationTag(e *core.RequestEvent, tag *ConversationTag) (*ConversationTag, error) {
+	collection, err := e.App.FindCollectionByNameOrId("conversation_tags")
+	if err != nil {
+		return nil, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user", tag.UserId)
+	record.Set("name", tag.Name)
+	record.Set("color", tag.Color)
+
+	if err := e.App.Save(record); err != nil {
+		return nil, err
+	}
+
+	return &ConversationTag{
+		Id:      record.Id,
+		UserId:  tag.UserId,
+		Name:    tag.Name,
+		Color:   tag.Color,
+		Created: record.GetString("created"),
+		Updated: record.GetString("updated"),
+	}, nil
+}
+
+// DeleteConversationTag deletes the tag record, which also removes it from the
+// conversations that carry it
+func DeleteConversationTag(e *core.RequestEvent, id string) error {
+	record, err := e.App.FindRecordById("conversation_tags", id)
+	if err != nil {
+		return err
+	}
+
+	return e.App.Delete(record)
+}
@@ -0,0 +1,21 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var documentTemplateColumns = []string{"id", "user", "name", "description", "title", "content", "is_folder", "children", "placeholders", "created", "updated"}
+
+func GetDocumentTemplateById(e *core.RequestEvent, id string) (*DocumentTemplate, error) {
+	query := e.App.DB().Select(documentTemplateColumns...).
+		From("document_templates").
+		Where(dbx.HashExp{"id": id})
+
+	var template DocumentTemplate
+	if err := query.One(&template); err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
@@ -0,0 +1,128 @@
+package queries
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Trash queries take the app because documents are moved to the trash from the record
+// delete hook and purged by a cron job.
+
+// trashRootExp matches the documents that were moved to the trash themselves, not along
+// with a folder: their parent is gone, still in use, or was moved to the trash at another time
+var trashRootExp = dbx.NewExp(`documents.deleted_at != '' AND NOT EXISTS (
+	SELECT 1 FROM documents AS parents
+	WHERE parents.id = documents.parent AND parents.deleted_at = documents.deleted_at
+)`)
+
+// GetTrashedDocumentById finds a document that is in the trash
+func GetTrashedDocumentById(app core.App, id string) (*Document, error) {
+	query := app.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"id": id}).
+		AndWhere(dbx.Not(dbx.HashExp{"deleted_at": ""}))
+
+	var document Document
+	if err := query.One(&document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GetTrashedDocumentsByUserId lists the documents and folders the user moved to the trash,
+// most recent first. Documents that went with a folder are listed with the folder only.
+func GetTrashedDocumentsByUserId(app core.App, userId string) ([]*Document, error) {
+	query := app.DB().Select(documentColumns...).
+		From("documents").
+		Where(dbx.HashExp{"user": userId}).
+		AndWhere(trashRootExp).
+		OrderBy("deleted_at DESC", "title ASC")
+
+	var documents []*Document
+	if err := query.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// GetDocumentIdsTrashedBefore lists the documents moved to the trash before the cutoff date,
+// without those that went with a folder. The oldest come first, so a document trashed on
+// its own is purged before the folder it was in.
+func GetDocumentIdsTrashedBefore(app core.App, cutoff string) ([]string, error) {
+	query := app.DB().Select("id").
+		From("documents").
+		Where(trashRootExp).
+		AndWhere(dbx.NewExp("deleted_at < {:cutoff}", dbx.Params{"cutoff": cutoff})).
+		OrderBy("deleted_at ASC")
+
+	var ids []string
+	if err := query.Column(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// SetDocumentDeletedAt marks the document and every document below it that has the
+// current date, so that documents moved to the trash before keep theirs. It writes to the
+// table directly so that moving to and from the trash does not count as an edit.
+func SetDocumentDeletedAt(app core.App, documentId, current, deletedAt string) ([]string, error) {
+	var ids []string
+	err := app.DB().NewQuery(`
+		WITH RECURSIVE subtree(id, depth) AS (
+			SELECT id, 0 FROM documents WHERE id = {:id} AND deleted_at = {:current}
+			UNION
+			SELECT documents.id, subtree.depth + 1
+			FROM documents JOIN subtree ON documents.parent = subtree.id
+			WHERE documents.deleted_at = {:current} AND subtree.depth < {:maxDepth}
+		)
+		SELECT id FROM subtree`).
+		Bind(dbx.Params{"id": documentId, "current": current, "maxDepth": maxDocumentDepth}).
+		Column(&ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	_, err = app.DB().Update("documents", dbx.Params{"deleted_at": deletedAt}, dbx.In("id", values...)).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// DeleteDocumentPermanently removes the document and every document below it in one
+// transaction, deepest first so that no document is left with a missing parent. Shares,
+// links, comments, reviews and chunks go with them.
+func DeleteDocumentPermanently(app core.App, documentId string) error {
+	subtree, err := GetDocumentSubtree(app, documentId)
+	if err != nil {
+		return err
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		for i := len(subtree) - 1; i >= 0; i-- {
+			record, err := txApp.FindRecordById("documents", subtree[i].Id)
+			if err != nil {
+				return err
+			}
+
+			if err := txApp.Delete(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -15,16 +15,18 @@ import (
 
 // AIModelDTO represents the AI model data transfer object
 type AIModelDTO struct {
-	Id           string   `json:"id"`
-	Identifier   string   `json:"identifier"`
-	Name         string   `json:"name"`
-	Description  string   `json:"description"`
-	Icon         string   `json:"icon"`
-	Capabilities []string `json:"capabilities"`
-	Provider     string   `json:"provider"`
-	Default      bool     `json:"default"`
-	Created      string   `json:"created"`
-	Updated      string   `json:"updated"`
+	Id              string   `json:"id"`
+	Identifier      string   `json:"identifier"`
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	Icon            string   `json:"icon"`
+	Capabilities    []string `json:"capabilities"`
+	Provider        string   `json:"provider"`
+	Default         bool     `json:"default"`
+	Fallbacks       []string `json:"fallbacks"`
+	MaxOutputTokens int64    `json:"max_output_tokens"`
+	Created         string   `json:"created"`
+	Updated         string   `json:"updated"`
 }
 
 // ToDTO converts an AIModel to AIModelDTO
@@ -35,17 +37,25 @@ func ToDTO(model *queries.AIModel) (*AIModelDTO, error) {
 		return nil, err
 	}
 
+	fallbacks, err := model.GetFallbacks()
+	if err != nil {
+		log.Println("Failed to get fallbacks for model:", model.Id, err)
+		return nil, err
+	}
+
 	return &AIModelDTO{
-		Id:           model.Id,
-		Identifier:   model.Identifier,
-		Name:         model.Name,
-		Description:  model.Description,
-		Icon:         model.Icon,
-		Capabilities: capabilities,
-		Provider:     model.Provider,
-		Default:      model.Default,
-		Created:      model.Created,
-		Updated:      model.Updated,
+		Id:              model.Id,
+		Identifier:      model.Identifier,
+		Name:            model.Name,
+		Description:     model.Description,
+		Icon:            model.Icon,
+		Capabilities:    capabilities,
+		Provider:        model.Provider,
+		Default:         model.Default,
+		Fallbacks:       fallbacks,
+		MaxOutputTokens: model.MaxOutputTokens,
+		Created:         model.Created,
+		Updated:         model.Updated,
 	}, nil
 }
 
@@ -199,6 +209,15 @@ func UpdateModelHandler(e *core.RequestEvent) error {
 		return e.Error(http.StatusBadRequest, "Invalid request body", err)
 	}
 
+	// JSON fields have to be stored encoded
+	if fallbacks, ok := fields["fallbacks"]; ok {
+		encoded, err := json.Marshal(fallbacks)
+		if err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid fallbacks", err)
+		}
+		fields["fallbacks"] = string(encoded)
+	}
+
 	if err := queries.UpdateAIModel(e, id, fields); err != nil {
 		return e.Error(http.StatusInternalServerError, "Failed to update model", err)
 	}
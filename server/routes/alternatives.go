@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/google/uuid"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type RegenerateConversationRequest struct {
+	Model        string                    `json:"model,omitempty"`
+	UseReasoning bool                      `json:"use_reasoning,omitempty"`
+	UseTools     bool                      `json:"use_tools,omitempty"`
+	UseDocuments bool                      `json:"use_documents,omitempty"`
+	Generation   services.GenerationParams `json:"generation,omitempty"`
+}
+
+type CycleAlternativeRequest struct {
+	MessageId string `json:"message_id"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// RegenerateConversationHandler streams a new answer to the last user message. The previous
+// answer is kept as an alternative in the same candidate group instead of being replaced.
+func RegenerateConversationHandler(e *core.RequestEvent) error {
+	setConversationStreamHeaders(e)
+
+	var req RegenerateConversationRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	// The body is optional, regenerating with the same model needs no options
+	if len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid request body", err)
+		}
+	}
+
+	conversationId := e.Request.PathValue("id")
+	userId := e.Auth.Id
+
+	// Verify conversation exists and belongs to user
+	conversation, err := queries.GetConversationById(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != userId {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	messages, err := queries.GetActiveMessagesByConversationIdOrdered(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get conversation history", err)
+	}
+
+	if len(messages) == 0 {
+		return e.Error(http.StatusBadRequest, "Conversation has no messages to regenerate", nil)
+	}
+
+	last := messages[len(messages)-1]
+
+	// The first regeneration turns the original answer into the first alternative
+	candidateGroup := last.CandidateGroup
+	if candidateGroup == "" {
+		candidateGroup = uuid.New().String()
+		if _, err := queries.UpdateConversationMessage(e, map[string]interface{}{"candidate_group": candidateGroup}, dbx.HashExp{"id": last.Id}); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to keep previous response", err)
+		}
+	}
+
+	model := req.Model
+	if model == "" {
+		model = last.Model
+	}
+
+	generation, err := resolveGenerationParams(e, conversation, []string{model}, req.UseReasoning, req.Generation)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
+	// Build message history for AI without the answer being regenerated
+	aiMessages, err := buildConversationHistory(e, messages[:len(messages)-1])
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to load attachments", err)
+	}
+
+	attachments, err := loadMessageAttachments(e, last)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to load attachments", err)
+	}
+	aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: last.UserMessage, Attachments: attachments})
+
+	return streamAndSaveConversation(e, conversationId, last.UserMessage, aiMessages, userId, last.Created, model, req.UseReasoning, req.UseTools, req.UseDocuments, generation, candidateGroup)
+}
+
+// CycleAlternativeHandler activates the next (or previous) alternative of a message
+func CycleAlternativeHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req CycleAlternativeRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	conversationId := e.Request.PathValue("id")
+
+	// Verify conversation exists and belongs to user
+	conversation, err := queries.GetConversationById(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	message, err := queries.GetConversationMessageById(e, req.MessageId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Message not found", err)
+	}
+
+	if message.ConversationId != conversationId {
+		return e.Error(http.StatusBadRequest, "Message does not belong to conversation", nil)
+	}
+
+	if message.CandidateGroup == "" {
+		return e.Error(http.StatusBadRequest, "Message has no alternatives", nil)
+	}
+
+	alternatives, err := queries.GetMessagesByCandidateGroup(e, conversationId, message.CandidateGroup)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get alternatives", err)
+	}
+
+	current := 0
+	for i, alternative := range alternatives {
+		if alternative.Id == message.Id {
+			current = i
+			break
+		}
+	}
+
+	step := 1
+	if req.Direction == "previous" {
+		step = -1
+	}
+	position := (current + step + len(alternatives)) % len(alternatives)
+	next := alternatives[position]
+
+	if err := queries.SelectCandidateMessage(e, next); errors.Is(err, queries.ErrCandidateNotOnBranch) {
+		return e.Error(http.StatusConflict, "Alternative is not on the current branch", err)
+	} else if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to select alternative", err)
+	}
+	next.Active = true
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"message":      toConversationMessageResponse(next),
+		"position":     position,
+		"alternatives": len(alternatives),
+	})
+}
@@ -0,0 +1,231 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/core/validators"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// maxAttachmentBodySize leaves room for the JSON payload next to the largest allowed uploads
+const maxAttachmentBodySize = services.MaxAttachments*services.MaxAttachmentSize + 1<<20
+
+// isMultipartRequest reports whether the request carries uploaded files
+func isMultipartRequest(e *core.RequestEvent) bool {
+	mediaType, _, _ := mime.ParseMediaType(e.Request.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// readConversationRequest decodes a chat request body. Requests with attachments are sent
+// as multipart forms with the JSON body in the @jsonPayload field, like the PocketBase API.
+func readConversationRequest(e *core.RequestEvent, req interface{}) error {
+	var bodyBytes []byte
+
+	if isMultipartRequest(e) {
+		if err := e.Request.ParseMultipartForm(32 << 20); err != nil {
+			return err
+		}
+		bodyBytes = []byte(e.Request.FormValue("@jsonPayload"))
+	} else {
+		var err error
+		bodyBytes, err = io.ReadAll(e.Request.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(bodyBytes, req)
+}
+
+// requestAttachments reads and validates the files uploaded in the attachments field
+func requestAttachments(e *core.RequestEvent) ([]services.Attachment, error) {
+	if !isMultipartRequest(e) {
+		return nil, nil
+	}
+
+	files, err := e.FindUploadedFiles("attachments")
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) > services.MaxAttachments {
+		return nil, fmt.Errorf("at most %d attachments are allowed per message", services.MaxAttachments)
+	}
+
+	// Messages are saved once the answer has streamed, so files the attachments field would
+	// reject are refused now with the same detection and types the field validates with
+	collection, err := e.App.FindCachedCollectionByNameOrId("conversation_messages")
+	if err != nil {
+		return nil, err
+	}
+	field, ok := collection.Fields.GetByName("attachments").(*core.FileField)
+	if !ok {
+		return nil, errors.New("conversation messages have no attachments field")
+	}
+	validateType := validators.UploadedFileMimeType(field.MimeTypes)
+
+	var attachments []services.Attachment
+	for _, file := range files {
+		if err := validateType(file); err != nil {
+			return nil, fmt.Errorf("%s is not a supported attachment type", file.OriginalName)
+		}
+
+		data, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		attachment, err := services.NewAttachment(file.OriginalName, data)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+func readFile(file *filesystem.File) ([]byte, error) {
+	reader, err := file.Reader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// attachmentUploads converts attachments into files that can be saved on a message record
+func attachmentUploads(attachments []services.Attachment) ([]*filesystem.File, error) {
+	var uploads []*filesystem.File
+	for _, attachment := range attachments {
+		file, err := filesystem.NewFileFromBytes(attachment.Data, attachment.Name)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, file)
+	}
+	return uploads, nil
+}
+
+// loadMessageAttachments reads the stored attachments of a message back from storage
+func loadMessageAttachments(e *core.RequestEvent, message *queries.ConversationMessage) ([]services.Attachment, error) {
+	if len(message.Attachments) == 0 {
+		return nil, nil
+	}
+
+	collection, err := e.App.FindCachedCollectionByNameOrId("conversation_messages")
+	if err != nil {
+		return nil, err
+	}
+
+	fsys, err := e.App.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	var attachments []services.Attachment
+	for _, name := range message.Attachments {
+		reader, err := fsys.GetFile(collection.Id + "/" + message.Id + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		attachment, err := services.NewAttachment(name, data)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+// buildConversationHistory turns saved messages into the alternating history sent to the model
+func buildConversationHistory(e *core.RequestEvent, messages []*queries.ConversationMessage) ([]services.Message, error) {
+	var aiMessages []services.Message
+	for _, msg := range messages {
+		attachments, err := loadMessageAttachments(e, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: msg.UserMessage, Attachments: attachments})
+		aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleAssistant, Content: msg.ResponseMessage})
+	}
+	return aiMessages, nil
+}
+
+// lastMessageUploads returns the attachments of the message being answered as files to save
+func lastMessageUploads(messages []services.Message) []*filesystem.File {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	uploads, err := attachmentUploads(messages[len(messages)-1].Attachments)
+	if err != nil {
+		log.Printf("Failed to prepare attachments: %v", err)
+		return nil
+	}
+	return uploads
+}
+
+// GetMessageAttachmentHandler serves one attachment of a message to its owner
+func GetMessageAttachmentHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	conversationId := e.Request.PathValue("id")
+	name := e.Request.PathValue("name")
+
+	// Verify conversation exists and belongs to user
+	conversation, err := queries.GetConversationById(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	message, err := queries.GetConversationMessageById(e, e.Request.PathValue("messageId"))
+	if err != nil || message.ConversationId != conversationId {
+		return e.Error(http.StatusNotFound, "Message not found", err)
+	}
+
+	if !slices.Contains(message.Attachments, name) {
+		return e.Error(http.StatusNotFound, "Attachment not found", nil)
+	}
+
+	collection, err := e.App.FindCachedCollectionByNameOrId("conversation_messages")
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to find attachment", err)
+	}
+
+	fsys, err := e.App.NewFilesystem()
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to open storage", err)
+	}
+	defer fsys.Close()
+
+	return fsys.Serve(e.Response, e.Request, collection.Id+"/"+message.Id+"/"+name, name)
+}
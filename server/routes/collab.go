@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"textly/services"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const collabHeartbeatInterval = 25 * time.Second
+
+type CollabOperationRequest struct {
+	ClientId  string                  `json:"client_id"`
+	Revision  int                     `json:"revision"`
+	Operation *services.TextOperation `json:"operation"`
+}
+
+type CollabPresenceRequest struct {
+	ClientId       string `json:"client_id"`
+	Cursor         int    `json:"cursor"`
+	SelectionStart int    `json:"selection_start"`
+	SelectionEnd   int    `json:"selection_end"`
+}
+
+// CollabStreamHandler joins the editing session of a document and streams its events.
+// The first event is "init" with the client id, the content, its revision and the
+// cursors of the other clients. It is followed by "operation" events, including the
+// client's own operations once they are applied, and "presence" and "leave" events.
+func CollabStreamHandler(e *core.RequestEvent) error {
+	hub := services.GetCollabHub()
+	if hub == nil {
+		return e.Error(http.StatusServiceUnavailable, "Collaboration is not running", nil)
+	}
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if document.IsFolder {
+		return e.Error(http.StatusBadRequest, "Folders cannot be edited", nil)
+	}
+
+	name := e.Auth.GetString("name")
+	if name == "" {
+		name = e.Auth.Email()
+	}
+
+	session, client, err := hub.Join(document.Id, e.Auth.Id, name)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to join document", err)
+	}
+	defer session.Leave(client)
+
+	setDocumentStreamHeaders(e)
+
+	heartbeat := time.NewTicker(collabHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-e.Request.Context().Done():
+			return nil
+		case event, ok := <-client.Events:
+			if !ok {
+				return nil
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			if _, err := e.Response.Write([]byte("data: " + string(data) + "\n\n")); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := e.Response.Write([]byte(": heartbeat\n\n")); err != nil {
+				return nil
+			}
+		}
+
+		if flusher, ok := e.Response.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// CollabOperationHandler submits an operation made on the given revision. It needs the
+// editor role. A 409 means the revision is too old and the client has to reconnect.
+func CollabOperationHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req CollabOperationRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	if req.Operation == nil {
+		return e.Error(http.StatusBadRequest, "Operation is required", nil)
+	}
+
+	session, err := collabSessionClient(e, req.ClientId)
+	if err != nil {
+		return err
+	}
+
+	role, err := services.DocumentRole(e.App, e.Request.PathValue("id"), e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+	}
+
+	if !services.RoleAllows(role, services.RoleEditor) {
+		return e.Error(http.StatusForbidden, "You cannot edit this document", nil)
+	}
+
+	revision, err := session.Submit(req.ClientId, req.Revision, req.Operation)
+	if errors.Is(err, services.ErrCollabRevision) {
+		return e.Error(http.StatusConflict, "Revision is out of date, reconnect to continue", err)
+	}
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid operation", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"revision": revision,
+	})
+}
+
+// CollabPresenceHandler shares the client's cursor and selection with the others
+func CollabPresenceHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req CollabPresenceRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	session, err := collabSessionClient(e, req.ClientId)
+	if err != nil {
+		return err
+	}
+
+	err = session.UpdatePresence(req.ClientId, services.CollabPresence{
+		Cursor:         req.Cursor,
+		SelectionStart: req.SelectionStart,
+		SelectionEnd:   req.SelectionEnd,
+	})
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Client is not connected", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// collabSessionClient finds the open session of the document and checks that the client
+// connected to it belongs to the current user
+func collabSessionClient(e *core.RequestEvent, clientId string) (*services.CollabSession, error) {
+	hub := services.GetCollabHub()
+	if hub == nil {
+		return nil, e.Error(http.StatusServiceUnavailable, "Collaboration is not running", nil)
+	}
+
+	session := hub.Session(e.Request.PathValue("id"))
+	if session == nil {
+		return nil, e.Error(http.StatusNotFound, "Client is not connected", nil)
+	}
+
+	client, ok := session.Client(clientId)
+	if !ok {
+		return nil, e.Error(http.StatusNotFound, "Client is not connected", nil)
+	}
+
+	if client.UserId != e.Auth.Id {
+		return nil, e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	return session, nil
+}
+
+func setDocumentStreamHeaders(e *core.RequestEvent) {
+	e.Response.Header().Set("Content-Type", "text/event-stream")
+	e.Response.Header().Set("Cache-Control", "no-cache")
+	e.Response.Header().Set("Connection", "keep-alive")
+	e.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	e.Response.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	e.Response.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+}
@@ -0,0 +1,438 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"textly/queries"
+	"textly/routes/middleware"
+	"textly/services"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+const (
+	maxCommentLength     = 10_000
+	defaultMentionsLimit = 50
+)
+
+type CreateCommentRequest struct {
+	DocumentId  string `json:"document"`
+	Parent      string `json:"parent,omitempty"`
+	Content     string `json:"content"`
+	AnchorStart int    `json:"anchor_start"`
+	AnchorEnd   int    `json:"anchor_end"`
+}
+
+type EditCommentRequest struct {
+	Content string `json:"content"`
+}
+
+type CommentResponse struct {
+	Id          string            `json:"id"`
+	DocumentId  string            `json:"document"`
+	UserId      string            `json:"user"`
+	AuthorType  string            `json:"author_type"`
+	AuthorName  string            `json:"author_name"`
+	Parent      string            `json:"parent,omitempty"`
+	Content     string            `json:"content"`
+	AnchorStart int               `json:"anchor_start"`
+	AnchorEnd   int               `json:"anchor_end"`
+	Quote       string            `json:"quote"`
+	Orphaned    bool              `json:"orphaned"`
+	Resolved    bool              `json:"resolved"`
+	ResolvedAt  string            `json:"resolved_at,omitempty"`
+	ResolvedBy  string            `json:"resolved_by,omitempty"`
+	Mentions    []string          `json:"mentions"`
+	Created     string            `json:"created"`
+	Updated     string            `json:"updated"`
+	Replies     []CommentResponse `json:"replies,omitempty"`
+}
+
+func RegisterCommentRoutes(s *core.ServeEvent) *router.RouterGroup[*core.RequestEvent] {
+	commentGroup := s.Router.Group("/comments")
+
+	// Add OPTIONS handlers for CORS preflight (without auth middleware)
+	commentGroup.OPTIONS("/", commentOptionsHandler)
+	commentGroup.OPTIONS("/mentions", commentOptionsHandler)
+	commentGroup.OPTIONS("/{id}", commentOptionsHandler)
+	commentGroup.OPTIONS("/{id}/edit", commentOptionsHandler)
+	commentGroup.OPTIONS("/{id}/resolve", commentOptionsHandler)
+	commentGroup.OPTIONS("/{id}/reopen", commentOptionsHandler)
+
+	// Add auth middleware for actual endpoints
+	commentGroup.Bind(middleware.AuthMiddleware())
+	commentGroup.GET("/", ListCommentsHandler)
+	commentGroup.POST("/", CreateCommentHandler)
+	commentGroup.GET("/mentions", ListMentionsHandler)
+	commentGroup.POST("/{id}/edit", EditCommentHandler)
+	commentGroup.POST("/{id}/resolve", ResolveCommentHandler)
+	commentGroup.POST("/{id}/reopen", ReopenCommentHandler)
+	commentGroup.DELETE("/{id}", DeleteCommentHandler)
+
+	return commentGroup
+}
+
+// ListCommentsHandler lists the comment threads of ?document=, oldest first, each with
+// its replies. ?resolved=true or false only returns the resolved or open threads.
+func ListCommentsHandler(e *core.RequestEvent) error {
+	setCommentCORSHeaders(e)
+
+	documentId := e.Request.URL.Query().Get("document")
+	if documentId == "" {
+		return e.Error(http.StatusBadRequest, "Document is required", nil)
+	}
+
+	if _, _, err := accessibleDocument(e, documentId); err != nil {
+		return err
+	}
+
+	comments, err := queries.GetDocumentCommentsByDocumentId(e.App, documentId)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get comments", err)
+	}
+
+	resolved := e.Request.URL.Query().Get("resolved")
+	names := commentAuthorNames(e, comments)
+
+	threads := make([]CommentResponse, 0)
+	index := make(map[string]int)
+	for _, comment := range comments {
+		if comment.Parent != "" {
+			continue
+		}
+		if (resolved == "true" && comment.ResolvedAt == "") || (resolved == "false" && comment.ResolvedAt != "") {
+			continue
+		}
+
+		index[comment.Id] = len(threads)
+		threads = append(threads, toCommentResponse(comment, names))
+	}
+
+	for _, comment := range comments {
+		if i, ok := index[comment.Parent]; ok {
+			threads[i].Replies = append(threads[i].Replies, toCommentResponse(comment, names))
+		}
+	}
+
+	return e.JSON(http.StatusOK, threads)
+}
+
+// CreateCommentHandler comments on the range of the document between anchor_start and
+// anchor_end, counted in characters, or on the whole document when the range is empty.
+// With a parent it replies to that thread instead. It needs the commenter role.
+func CreateCommentHandler(e *core.RequestEvent) error {
+	setCommentCORSHeaders(e)
+
+	var req CreateCommentRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	content := strings.TrimSpace(req.Content)
+	if content == "" || utf8.RuneCountInString(content) > maxCommentLength {
+		return e.Error(http.StatusBadRequest, "Comment must be between 1 and 10000 characters", nil)
+	}
+
+	comment := &queries.DocumentComment{
+		DocumentId: req.DocumentId,
+		UserId:     e.Auth.Id,
+		AuthorType: services.CommentAuthorUser,
+		Content:    content,
+	}
+
+	if req.Parent != "" {
+		parent, err := queries.GetDocumentCommentById(e.App, req.Parent)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Comment not found", err)
+		}
+
+		// Replies always belong to the first comment of the thread
+		comment.DocumentId = parent.DocumentId
+		comment.Parent = parent.Id
+		if parent.Parent != "" {
+			comment.Parent = parent.Parent
+		}
+	}
+
+	if comment.DocumentId == "" {
+		return e.Error(http.StatusBadRequest, "Document is required", nil)
+	}
+
+	document, role, err := accessibleDocument(e, comment.DocumentId)
+	if err != nil {
+		return err
+	}
+
+	if !services.RoleAllows(role, services.RoleCommenter) {
+		return e.Error(http.StatusForbidden, "You cannot comment on this document", nil)
+	}
+
+	if document.IsFolder {
+		return e.Error(http.StatusBadRequest, "Folders cannot be commented on", nil)
+	}
+
+	if comment.Parent == "" && req.AnchorEnd > req.AnchorStart {
+		runes := []rune(document.Content)
+		if req.AnchorStart < 0 || req.AnchorEnd > len(runes) {
+			return e.Error(http.StatusBadRequest, "Range is outside of the document", nil)
+		}
+
+		comment.AnchorStart = req.AnchorStart
+		comment.AnchorEnd = req.AnchorEnd
+		comment.Quote = string(runes[req.AnchorStart:req.AnchorEnd])
+	}
+
+	comment.Mentions, err = commentMentions(e, comment.DocumentId, content)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get collaborators", err)
+	}
+
+	comment, err = queries.CreateDocumentComment(e.App, comment)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to create comment", err)
+	}
+
+	return e.JSON(http.StatusCreated, toCommentResponse(comment, commentAuthorNames(e, []*queries.DocumentComment{comment})))
+}
+
+// EditCommentHandler changes the text of one of the user's own comments
+func EditCommentHandler(e *core.RequestEvent) error {
+	setCommentCORSHeaders(e)
+
+	var req EditCommentRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	content := strings.TrimSpace(req.Content)
+	if content == "" || utf8.RuneCountInString(content) > maxCommentLength {
+		return e.Error(http.StatusBadRequest, "Comment must be between 1 and 10000 characters", nil)
+	}
+
+	comment, role, err := accessibleComment(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if comment.UserId != e.Auth.Id || comment.AuthorType != services.CommentAuthorUser || !services.RoleAllows(role, services.RoleCommenter) {
+		return e.Error(http.StatusForbidden, "You can only edit your own comments", nil)
+	}
+
+	mentions, err := commentMentions(e, comment.DocumentId, content)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get collaborators", err)
+	}
+
+	if err := queries.UpdateDocumentCommentContent(e.App, comment.Id, content, mentions); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to update comment", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ResolveCommentHandler resolves the thread the comment belongs to
+func ResolveCommentHandler(e *core.RequestEvent) error {
+	return setCommentResolved(e, true)
+}
+
+// ReopenCommentHandler reopens a resolved thread
+func ReopenCommentHandler(e *core.RequestEvent) error {
+	return setCommentResolved(e, false)
+}
+
+func setCommentResolved(e *core.RequestEvent, resolved bool) error {
+	setCommentCORSHeaders(e)
+
+	comment, role, err := accessibleComment(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if !services.RoleAllows(role, services.RoleCommenter) {
+		return e.Error(http.StatusForbidden, "You cannot resolve comments on this document", nil)
+	}
+
+	threadId := comment.Id
+	if comment.Parent != "" {
+		threadId = comment.Parent
+	}
+
+	resolvedBy := ""
+	if resolved {
+		resolvedBy = e.Auth.Id
+	}
+
+	if err := queries.SetDocumentCommentResolved(e.App, threadId, resolvedBy); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to update comment", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// DeleteCommentHandler deletes a comment and its replies. Authors can delete their
+// comments, including those the assistant added for them, and the owner of the
+// document can delete any comment.
+func DeleteCommentHandler(e *core.RequestEvent) error {
+	setCommentCORSHeaders(e)
+
+	comment, role, err := accessibleComment(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if comment.UserId != e.Auth.Id && role != services.RoleOwner {
+		return e.Error(http.StatusForbidden, "You cannot delete this comment", nil)
+	}
+
+	if err := queries.DeleteDocumentComment(e.App, comment.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to delete comment", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ListMentionsHandler lists the latest comments that mention the user, on documents
+// they can still see
+func ListMentionsHandler(e *core.RequestEvent) error {
+	setCommentCORSHeaders(e)
+
+	comments, err := queries.GetDocumentCommentsMentioningUserId(e.App, e.Auth.Id, defaultMentionsLimit)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get mentions", err)
+	}
+
+	names := commentAuthorNames(e, comments)
+	roles := make(map[string]string)
+
+	mentions := make([]CommentResponse, 0, len(comments))
+	for _, comment := range comments {
+		role, ok := roles[comment.DocumentId]
+		if !ok {
+			role, _ = services.DocumentRole(e.App, comment.DocumentId, e.Auth.Id)
+			roles[comment.DocumentId] = role
+		}
+
+		if role != "" {
+			mentions = append(mentions, toCommentResponse(comment, names))
+		}
+	}
+
+	return e.JSON(http.StatusOK, mentions)
+}
+
+// accessibleComment loads a comment on a document the current user can at least view,
+// with their role on the document
+func accessibleComment(e *core.RequestEvent, commentId string) (*queries.DocumentComment, string, error) {
+	comment, err := queries.GetDocumentCommentById(e.App, commentId)
+	if err != nil {
+		return nil, "", e.Error(http.StatusNotFound, "Comment not found", err)
+	}
+
+	_, role, err := accessibleDocument(e, comment.DocumentId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return comment, role, nil
+}
+
+// commentMentions finds the collaborators of the document mentioned in the comment
+func commentMentions(e *core.RequestEvent, documentId, content string) ([]string, error) {
+	if !strings.Contains(content, "@") {
+		return []string{}, nil
+	}
+
+	candidates, err := services.DocumentMentionCandidates(e.App, documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return services.ParseMentions(content, candidates), nil
+}
+
+// commentAuthorNames maps the authors of the comments to their display names
+func commentAuthorNames(e *core.RequestEvent, comments []*queries.DocumentComment) map[string]string {
+	var userIds []string
+	for _, comment := range comments {
+		userIds = append(userIds, comment.UserId)
+	}
+
+	names := make(map[string]string)
+	users, err := e.App.FindRecordsByIds("users", userIds)
+	if err != nil {
+		return names
+	}
+
+	for _, user := range users {
+		name := user.GetString("name")
+		if name == "" {
+			name = user.Email()
+		}
+		names[user.Id] = name
+	}
+
+	return names
+}
+
+func toCommentResponse(comment *queries.DocumentComment, names map[string]string) CommentResponse {
+	authorName := names[comment.UserId]
+	if comment.AuthorType == services.CommentAuthorAssistant {
+		authorName = "Assistant"
+	}
+
+	mentions := []string(comment.Mentions)
+	if mentions == nil {
+		mentions = []string{}
+	}
+
+	return CommentResponse{
+		Id:          comment.Id,
+		DocumentId:  comment.DocumentId,
+		UserId:      comment.UserId,
+		AuthorType:  comment.AuthorType,
+		AuthorName:  authorName,
+		Parent:      comment.Parent,
+		Content:     comment.Content,
+		AnchorStart: comment.AnchorStart,
+		AnchorEnd:   comment.AnchorEnd,
+		Quote:       comment.Quote,
+		Orphaned:    comment.Orphaned,
+		Resolved:    comment.ResolvedAt != "",
+		ResolvedAt:  comment.ResolvedAt,
+		ResolvedBy:  comment.ResolvedBy,
+		Mentions:    mentions,
+		Created:     comment.Created,
+		Updated:     comment.Updated,
+	}
+}
+
+func setCommentCORSHeaders(e *core.RequestEvent) {
+	e.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	e.Response.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	e.Response.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+}
+
+func commentOptionsHandler(e *core.RequestEvent) error {
+	setCommentCORSHeaders(e)
+	return e.NoContent(http.StatusOK)
+}
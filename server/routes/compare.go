@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/google/uuid"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const maxCompareModels = 3
+
+// compareCandidate collects the streamed answer of one model in compare mode
+type compareCandidate struct {
+	index    int
+	model    string
+	response strings.Builder
+	thinking strings.Builder
+	stream   *services.ChatStream
+	err      error
+}
+
+func validateCompareModels(e *core.RequestEvent, models []string) error {
+	if len(models) < 2 || len(models) > maxCompareModels {
+		return fmt.Errorf("compare mode needs between 2 and %d models", maxCompareModels)
+	}
+
+	seen := make(map[string]bool)
+	for _, model := range models {
+		if seen[model] {
+			return fmt.Errorf("model %s is listed more than once", model)
+		}
+		seen[model] = true
+
+		if _, err := queries.GetAIModelByIdentifier(e, model); err != nil {
+			return fmt.Errorf("unknown model %s", model)
+		}
+	}
+
+	return nil
+}
+
+// streamAndSaveComparison fans the same history out to several models concurrently and
+// multiplexes their streams into one SSE response, tagging every event with the candidate
+// index and model. Each answer is saved as a candidate of the same group; the first one
+// is the active continuation until the user selects another.
+func streamAndSaveComparison(e *core.RequestEvent, conversationId, userMessage string, messages []services.Message, userId, timestamp string, models []string, useReasoning bool, generation services.GenerationParams) error {
+	candidateGroup := uuid.New().String()
+
+	// Events from the model goroutines must not interleave on the wire
+	var mu sync.Mutex
+	send := func(payload map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		writeConversationEvent(e, payload)
+	}
+
+	candidates := make([]*compareCandidate, len(models))
+	announced := make([]map[string]interface{}, len(models))
+	for i, model := range models {
+		candidates[i] = &compareCandidate{index: i, model: model}
+		announced[i] = map[string]interface{}{"candidate": i, "model": model}
+	}
+
+	send(map[string]interface{}{"candidate_group": candidateGroup, "candidates": announced})
+	if useReasoning {
+		send(map[string]interface{}{"thinking": true})
+	}
+
+	policy := services.DefaultRetryPolicy()
+
+	generationParams, err := json.Marshal(generation.Effective(useReasoning))
+	if err != nil {
+		log.Printf("Failed to encode generation params: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, candidate := range candidates {
+		wg.Add(1)
+		go func(candidate *compareCandidate) {
+			defer wg.Done()
+
+			// Each candidate sticks to its own model, falling back would defeat the comparison
+			stream, err := services.ChatWithFallback(e, messages, []string{candidate.model}, useReasoning, generation, policy, nil)
+			if err != nil {
+				candidate.err = err
+				log.Printf("Compare candidate %s failed: %v", candidate.model, err)
+				send(map[string]interface{}{"candidate": candidate.index, "model": candidate.model, "error": "Failed to stream response"})
+				return
+			}
+			defer stream.Close()
+			candidate.stream = stream
+
+			for stream.Next() {
+				chunk := stream.Current()
+
+				if reasoningContent := services.ReasoningDelta(chunk); reasoningContent != "" {
+					candidate.thinking.WriteString(reasoningContent)
+					if useReasoning {
+						send(map[string]interface{}{"candidate": candidate.index, "model": candidate.model, "thinking_content": reasoningContent})
+					}
+				}
+
+				if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+					content := chunk.Choices[0].Delta.Content
+					candidate.response.WriteString(content)
+					send(map[string]interface{}{"candidate": candidate.index, "model": candidate.model, "content": content})
+				}
+			}
+
+			if err := stream.Err(); err != nil {
+				candidate.err = err
+				log.Printf("Compare candidate %s failed mid-stream: %v", candidate.model, err)
+				send(map[string]interface{}{"candidate": candidate.index, "model": candidate.model, "error": "Failed to stream response"})
+			}
+		}(candidate)
+	}
+	wg.Wait()
+
+	if useReasoning {
+		writeConversationEvent(e, map[string]interface{}{"thinking": false})
+	}
+
+	// The candidates are saved before the completion event, so that clients reading up to
+	// it get the message ids to select one of them
+	hasActive := false
+	for _, candidate := range candidates {
+		if candidate.err != nil {
+			continue
+		}
+
+		inputTokens, outputTokens, reasoningTokens, totalCost := candidate.stream.Totals()
+
+		message := &queries.ConversationMessage{
+			UserId:           userId,
+			ConversationId:   conversationId,
+			UserMessage:      userMessage,
+			ResponseMessage:  candidate.response.String(),
+			ThinkingContent:  candidate.thinking.String(),
+			Model:            candidate.model,
+			InputTokens:      strconv.FormatInt(inputTokens, 10),
+			OutputTokens:     strconv.FormatInt(outputTokens, 10),
+			ReasoningTokens:  strconv.FormatInt(reasoningTokens, 10),
+			Cost:             strconv.FormatFloat(totalCost, 'f', 6, 64),
+			Active:           !hasActive,
+			CandidateGroup:   candidateGroup,
+			GenerationParams: generationParams,
+			Created:          timestamp,
+			Uploads:          lastMessageUploads(messages),
+		}
+
+		createdMessage, err := queries.CreateConversationMessage(e, message)
+		if err != nil {
+			log.Printf("Failed to save compare candidate %s: %v", candidate.model, err)
+			continue
+		}
+		hasActive = true
+
+		writeConversationEvent(e, map[string]interface{}{
+			"candidate":  candidate.index,
+			"model":      candidate.model,
+			"message_id": createdMessage.Id,
+			"active":     createdMessage.Active,
+		})
+
+		if err := queries.UpdateConversationTotals(e, conversationId, inputTokens, outputTokens, reasoningTokens, totalCost); err != nil {
+			log.Printf("Failed to update conversation totals: %v", err)
+		}
+	}
+
+	if !hasActive {
+		writeConversationEvent(e, map[string]interface{}{"error": "All compared models failed"})
+	}
+
+	// Send completion event
+	e.Response.Write([]byte("data: [DONE]\n\n"))
+
+	if flusher, ok := e.Response.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,37 +14,55 @@ import (
 	"textly/services"
 	"time"
 
-	"github.com/openai/openai-go"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 type StartConversationRequest struct {
-	Message      string `json:"message"`
-	Title        string `json:"title,omitempty"`
-	Model        string `json:"model,omitempty"`
-	UseReasoning bool   `json:"use_reasoning,omitempty"`
+	Message       string                    `json:"message"`
+	Title         string                    `json:"title,omitempty"`
+	Model         string                    `json:"model,omitempty"`
+	UseReasoning  bool                      `json:"use_reasoning,omitempty"`
+	CompareModels []string                  `json:"compare_models,omitempty"`
+	UseTools      bool                      `json:"use_tools,omitempty"`
+	UseDocuments  bool                      `json:"use_documents,omitempty"`
+	Generation    services.GenerationParams `json:"generation,omitempty"`
 }
 
 type ContinueConversationRequest struct {
-	ConversationId string `json:"conversation_id"`
-	Message        string `json:"message"`
-	Model          string `json:"model,omitempty"`
-	UseReasoning   bool   `json:"use_reasoning,omitempty"`
+	ConversationId string                    `json:"conversation_id"`
+	Message        string                    `json:"message"`
+	Model          string                    `json:"model,omitempty"`
+	UseReasoning   bool                      `json:"use_reasoning,omitempty"`
+	CompareModels  []string                  `json:"compare_models,omitempty"`
+	UseTools       bool                      `json:"use_tools,omitempty"`
+	UseDocuments   bool                      `json:"use_documents,omitempty"`
+	Generation     services.GenerationParams `json:"generation,omitempty"`
 }
 
 type EditConversationRequest struct {
-	ConversationId string `json:"conversation_id"`
-	MessageId      string `json:"message_id"`
-	NewMessage     string `json:"new_message"`
-	Model          string `json:"model,omitempty"`
-	UseReasoning   bool   `json:"use_reasoning,omitempty"`
+	ConversationId string                    `json:"conversation_id"`
+	MessageId      string                    `json:"message_id"`
+	NewMessage     string                    `json:"new_message"`
+	Model          string                    `json:"model,omitempty"`
+	UseReasoning   bool                      `json:"use_reasoning,omitempty"`
+	UseTools       bool                      `json:"use_tools,omitempty"`
+	UseDocuments   bool                      `json:"use_documents,omitempty"`
+	Generation     services.GenerationParams `json:"generation,omitempty"`
 }
 
 type DeactivateConversationRequest struct {
 	ConversationId string `json:"conversation_id"`
 }
 
+type SelectCandidateRequest struct {
+	ConversationId string `json:"conversation_id"`
+	MessageId      string `json:"message_id"`
+}
+
 type ConversationResponse struct {
 	Id              string                        `json:"id"`
 	Title           string                        `json:"title"`
@@ -53,23 +72,34 @@ type ConversationResponse struct {
 	OutputTokens    int64                         `json:"output_tokens"`
 	ReasoningTokens int64                         `json:"reasoning_tokens"`
 	Cost            float64                       `json:"cost"`
+	Settings        types.JSONRaw                 `json:"settings,omitempty"`
+	Pinned          bool                          `json:"pinned"`
+	ArchivedAt      string                        `json:"archived_at,omitempty"`
+	Tags            []string                      `json:"tags"`
+	FolderId        string                        `json:"folder,omitempty"`
 	Messages        []ConversationMessageResponse `json:"messages"`
 	Created         string                        `json:"created"`
 	Updated         string                        `json:"updated"`
 }
 
 type ConversationMessageResponse struct {
-	Id              string  `json:"id"`
-	UserMessage     string  `json:"user_message"`
-	ResponseMessage string  `json:"response_message"`
-	ThinkingContent string  `json:"thinking_content"`
-	Model           string  `json:"model"`
-	InputTokens     int64   `json:"input_tokens"`
-	OutputTokens    int64   `json:"output_tokens"`
-	ReasoningTokens int64   `json:"reasoning_tokens"`
-	Cost            float64 `json:"cost"`
-	Active          bool    `json:"active"`
-	Created         string  `json:"created"`
+	Id               string                        `json:"id"`
+	UserMessage      string                        `json:"user_message"`
+	ResponseMessage  string                        `json:"response_message"`
+	ThinkingContent  string                        `json:"thinking_content"`
+	Model            string                        `json:"model"`
+	InputTokens      int64                         `json:"input_tokens"`
+	OutputTokens     int64                         `json:"output_tokens"`
+	ReasoningTokens  int64                         `json:"reasoning_tokens"`
+	Cost             float64                       `json:"cost"`
+	Active           bool                          `json:"active"`
+	CandidateGroup   string                        `json:"candidate_group,omitempty"`
+	Candidates       []ConversationMessageResponse `json:"candidates,omitempty"`
+	GenerationParams types.JSONRaw                 `json:"generation_params,omitempty"`
+	ToolCalls        types.JSONRaw                 `json:"tool_calls,omitempty"`
+	Citations        types.JSONRaw                 `json:"citations,omitempty"`
+	Attachments      []string                      `json:"attachments,omitempty"`
+	Created          string                        `json:"created"`
 }
 
 func RegisterConversationRoutes(s *core.ServeEvent) *router.RouterGroup[*core.RequestEvent] {
@@ -80,16 +110,51 @@ func RegisterConversationRoutes(s *core.ServeEvent) *router.RouterGroup[*core.Re
 	conversationGroup.OPTIONS("/continue", conversationOptionsHandler)
 	conversationGroup.OPTIONS("/edit", conversationOptionsHandler)
 	conversationGroup.OPTIONS("/deactivate", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/candidates/select", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/regenerate", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/settings", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/alternatives/cycle", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/messages/{messageId}/attachments/{name}", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/export", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/save-to-document", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/rename", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/pin", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/unpin", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/archive", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/unarchive", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/export", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/list", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/tag", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/untag", conversationOptionsHandler)
+	conversationGroup.OPTIONS("/{id}/move", conversationOptionsHandler)
 	conversationGroup.OPTIONS("/{id}", conversationOptionsHandler)
 	conversationGroup.OPTIONS("/", conversationOptionsHandler)
 
 	// Add auth middleware for actual endpoints
 	conversationGroup.Bind(middleware.AuthMiddleware())
-	conversationGroup.POST("/start", StartConversationHandler)
-	conversationGroup.POST("/continue", ContinueConversationHandler)
-	conversationGroup.POST("/edit", EditConversationHandler)
+	conversationGroup.POST("/start", StartConversationHandler).Bind(apis.BodyLimit(maxAttachmentBodySize))
+	conversationGroup.POST("/continue", ContinueConversationHandler).Bind(apis.BodyLimit(maxAttachmentBodySize))
+	conversationGroup.POST("/edit", EditConversationHandler).Bind(apis.BodyLimit(maxAttachmentBodySize))
 	conversationGroup.POST("/deactivate", DeactivateConversationHandler)
+	conversationGroup.POST("/candidates/select", SelectCandidateHandler)
+	conversationGroup.POST("/{id}/regenerate", RegenerateConversationHandler)
+	conversationGroup.POST("/{id}/settings", UpdateConversationSettingsHandler)
+	conversationGroup.POST("/{id}/alternatives/cycle", CycleAlternativeHandler)
+	conversationGroup.GET("/{id}/messages/{messageId}/attachments/{name}", GetMessageAttachmentHandler)
+	conversationGroup.GET("/{id}/export", ExportConversationHandler)
+	conversationGroup.POST("/{id}/save-to-document", SaveToDocumentHandler)
+	conversationGroup.POST("/{id}/rename", RenameConversationHandler)
+	conversationGroup.POST("/{id}/pin", PinConversationHandler(true))
+	conversationGroup.POST("/{id}/unpin", PinConversationHandler(false))
+	conversationGroup.POST("/{id}/archive", ArchiveConversationHandler(true))
+	conversationGroup.POST("/{id}/unarchive", ArchiveConversationHandler(false))
+	conversationGroup.GET("/export", ExportConversationsHandler)
+	conversationGroup.GET("/list", ListConversationsHandler)
+	conversationGroup.POST("/{id}/tag", TagConversationHandler)
+	conversationGroup.POST("/{id}/untag", UntagConversationHandler)
+	conversationGroup.POST("/{id}/move", MoveConversationHandler)
 	conversationGroup.GET("/{id}", GetConversationHandler)
+	conversationGroup.DELETE("/{id}", DeleteConversationHandler)
 	conversationGroup.GET("/", GetConversationsHandler)
 
 	return conversationGroup
@@ -100,13 +165,30 @@ func StartConversationHandler(e *core.RequestEvent) error {
 	setConversationStreamHeaders(e)
 
 	var req StartConversationRequest
-	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err := readConversationRequest(e, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	attachments, err := requestAttachments(e)
 	if err != nil {
-		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+		return e.Error(http.StatusBadRequest, err.Error(), err)
 	}
 
-	if err := json.Unmarshal(bodyBytes, &req); err != nil {
-		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	if len(req.CompareModels) > 0 {
+		if err := validateCompareModels(e, req.CompareModels); err != nil {
+			return e.Error(http.StatusBadRequest, err.Error(), err)
+		}
+	}
+
+	generation, err := resolveGenerationParams(e, nil, answeringModels(req.Model, req.CompareModels), req.UseReasoning, req.Generation)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
+	// The generation params of the first request become the conversation settings
+	settings, err := json.Marshal(req.Generation)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid generation params", err)
 	}
 
 	userId := e.Auth.Id
@@ -132,6 +214,7 @@ func StartConversationHandler(e *core.RequestEvent) error {
 		ReasoningTokens: "0",
 		Cost:            "0.000000",
 		Active:          true,
+		Settings:        settings,
 		Created:         now,
 		Updated:         now,
 	}
@@ -150,10 +233,14 @@ func StartConversationHandler(e *core.RequestEvent) error {
 
 	// Generate AI response with streaming
 	messages := []services.Message{
-		{Role: services.MessageRoleUser, Content: req.Message},
+		{Role: services.MessageRoleUser, Content: req.Message, Attachments: attachments},
+	}
+
+	if len(req.CompareModels) > 0 {
+		return streamAndSaveComparison(e, createdConversation.Id, req.Message, messages, userId, now, req.CompareModels, req.UseReasoning, generation)
 	}
 
-	return streamAndSaveConversation(e, createdConversation.Id, req.Message, messages, userId, now, req.Model, req.UseReasoning)
+	return streamAndSaveConversation(e, createdConversation.Id, req.Message, messages, userId, now, req.Model, req.UseReasoning, req.UseTools, req.UseDocuments, generation, "")
 }
 
 // ContinueConversationHandler adds a message to existing conversation and streams the response
@@ -161,13 +248,19 @@ func ContinueConversationHandler(e *core.RequestEvent) error {
 	setConversationStreamHeaders(e)
 
 	var req ContinueConversationRequest
-	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err := readConversationRequest(e, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	attachments, err := requestAttachments(e)
 	if err != nil {
-		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+		return e.Error(http.StatusBadRequest, err.Error(), err)
 	}
 
-	if err := json.Unmarshal(bodyBytes, &req); err != nil {
-		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	if len(req.CompareModels) > 0 {
+		if err := validateCompareModels(e, req.CompareModels); err != nil {
+			return e.Error(http.StatusBadRequest, err.Error(), err)
+		}
 	}
 
 	userId := e.Auth.Id
@@ -183,6 +276,11 @@ func ContinueConversationHandler(e *core.RequestEvent) error {
 		return e.Error(http.StatusForbidden, "Access denied", nil)
 	}
 
+	generation, err := resolveGenerationParams(e, conversation, answeringModels(req.Model, req.CompareModels), req.UseReasoning, req.Generation)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
 	// Get conversation history
 	messages, err := queries.GetActiveMessagesByConversationIdOrdered(e, req.ConversationId)
 	if err != nil {
@@ -190,15 +288,18 @@ func ContinueConversationHandler(e *core.RequestEvent) error {
 	}
 
 	// Build message history for AI
-	var aiMessages []services.Message
-	for _, msg := range messages {
-		aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: msg.UserMessage})
-		aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleAssistant, Content: msg.ResponseMessage})
+	aiMessages, err := buildConversationHistory(e, messages)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to load attachments", err)
 	}
 	// Add the new user message
-	aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: req.Message})
+	aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: req.Message, Attachments: attachments})
+
+	if len(req.CompareModels) > 0 {
+		return streamAndSaveComparison(e, req.ConversationId, req.Message, aiMessages, userId, now, req.CompareModels, req.UseReasoning, generation)
+	}
 
-	return streamAndSaveConversation(e, req.ConversationId, req.Message, aiMessages, userId, now, req.Model, req.UseReasoning)
+	return streamAndSaveConversation(e, req.ConversationId, req.Message, aiMessages, userId, now, req.Model, req.UseReasoning, req.UseTools, req.UseDocuments, generation, "")
 }
 
 // EditConversationHandler edits a message and streams the new response
@@ -206,13 +307,13 @@ func EditConversationHandler(e *core.RequestEvent) error {
 	setConversationStreamHeaders(e)
 
 	var req EditConversationRequest
-	bodyBytes, err := io.ReadAll(e.Request.Body)
-	if err != nil {
-		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	if err := readConversationRequest(e, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
 	}
 
-	if err := json.Unmarshal(bodyBytes, &req); err != nil {
-		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	attachments, err := requestAttachments(e)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
 	}
 
 	userId := e.Auth.Id
@@ -239,6 +340,19 @@ func EditConversationHandler(e *core.RequestEvent) error {
 		return e.Error(http.StatusBadRequest, "Message does not belong to conversation", nil)
 	}
 
+	generation, err := resolveGenerationParams(e, conversation, answeringModels(req.Model, nil), req.UseReasoning, req.Generation)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
+	// Editing only the text keeps the files of the original message
+	if attachments == nil {
+		attachments, err = loadMessageAttachments(e, messageToEdit)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to load attachments", err)
+		}
+	}
+
 	// Deactivate the edited message and all messages after it
 	_, err = queries.DeactivateMessagesFromTimestamp(e, req.ConversationId, messageToEdit.Created)
 	if err != nil {
@@ -252,19 +366,19 @@ func EditConversationHandler(e *core.RequestEvent) error {
 	}
 
 	// Build message history for AI (edited message and subsequent messages are already deactivated)
-	var aiMessages []services.Message
-	for _, msg := range messages {
-		aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: msg.UserMessage})
-		aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleAssistant, Content: msg.ResponseMessage})
+	aiMessages, err := buildConversationHistory(e, messages)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to load attachments", err)
 	}
 	// Add the edited message
-	aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: req.NewMessage})
+	aiMessages = append(aiMessages, services.Message{Role: services.MessageRoleUser, Content: req.NewMessage, Attachments: attachments})
 
-	return streamAndSaveConversation(e, req.ConversationId, req.NewMessage, aiMessages, userId, now, req.Model, req.UseReasoning)
+	return streamAndSaveConversation(e, req.ConversationId, req.NewMessage, aiMessages, userId, now, req.Model, req.UseReasoning, req.UseTools, req.UseDocuments, generation, "")
 }
 
 // streamAndSaveConversation handles the streaming and saving logic
-func streamAndSaveConversation(e *core.RequestEvent, conversationId, userMessage string, messages []services.Message, userId, timestamp, model string, useReasoning bool) error {
+// When candidateGroup is set the saved response becomes the active alternative of that group.
+func streamAndSaveConversation(e *core.RequestEvent, conversationId, userMessage string, messages []services.Message, userId, timestamp, model string, useReasoning, useTools, useDocuments bool, generation services.GenerationParams, candidateGroup string) error {
 	// Send thinking state only when reasoning is explicitly enabled
 	if useReasoning {
 		thinkingData := "data: {\"thinking\": true}\n\n"
@@ -274,48 +388,69 @@ func streamAndSaveConversation(e *core.RequestEvent, conversationId, userMessage
 		}
 	}
 
-	// Start streaming
-	stream := services.Chat(messages, model, useReasoning, e.Request.Context())
+	// Relevant excerpts of the user's documents are added to the prompt and sent to the client
+	var citations []byte
+	if useDocuments && len(messages) > 0 {
+		retrieved, err := services.RetrieveCitations(e, userId, messages[len(messages)-1].Content)
+		if err != nil {
+			log.Printf("Failed to retrieve document context: %v", err)
+		}
+
+		if len(retrieved) > 0 {
+			writeConversationEvent(e, map[string]interface{}{"citations": retrieved})
+			messages = services.AddCitationsToMessages(messages, retrieved)
+			if citations, err = json.Marshal(retrieved); err != nil {
+				log.Printf("Failed to encode citations: %v", err)
+			}
+		}
+	}
+
+	// Tool calls and their results are streamed as they happen and saved with the message
+	var toolbox *services.Toolbox
+	if useTools {
+		toolbox = services.NewToolbox(e, userId)
+		toolbox.OnCall = func(call services.ToolCall) {
+			writeConversationEvent(e, map[string]interface{}{"tool_call": call})
+		}
+		toolbox.OnResult = func(call services.ToolCall) {
+			writeConversationEvent(e, map[string]interface{}{"tool_result": call})
+		}
+	}
+
+	// Start streaming, retrying and falling back to other models before the first token
+	models := queries.GetModelFallbackChain(e, services.ResolveModel(model))
+	stream, err := services.ChatWithFallback(e, messages, models, useReasoning, generation, services.DefaultRetryPolicy(), toolbox)
+	if err != nil {
+		log.Printf("Failed to stream response: %v", err)
+		writeConversationEvent(e, map[string]interface{}{"error": "Failed to stream response"})
+		return nil
+	}
+	defer stream.Close()
+
+	// Let the client know which model is actually answering
+	writeConversationEvent(e, map[string]interface{}{"model": stream.Model})
 
 	var responseBuilder strings.Builder
 	var thinkingBuilder strings.Builder
-	var usage *openai.CompletionUsage
 	var hasStartedContent bool
 
-	if stream.Err() != nil {
-		return e.Error(http.StatusInternalServerError, "Failed to stream response", stream.Err())
-	}
-
 	// Stream the response
 	for stream.Next() {
-		if stream.Err() != nil {
-			return e.Error(http.StatusInternalServerError, "Failed to stream response", stream.Err())
-		}
-
 		chunk := stream.Current()
 
 		// Check for reasoning content first (for models that support it)
 		if len(chunk.Choices) > 0 {
-			if chunkJSON := chunk.Choices[0].Delta.JSON.ExtraFields; chunkJSON != nil {
-				if reasoningField, exists := chunkJSON["reasoning"]; exists {
-					reasoningContent := reasoningField.Raw()
-					if reasoningContent != "" && reasoningContent != "null" {
-						// Remove quotes if present
-						if strings.HasPrefix(reasoningContent, "\"") && strings.HasSuffix(reasoningContent, "\"") {
-							reasoningContent = reasoningContent[1 : len(reasoningContent)-1]
-						}
-						thinkingBuilder.WriteString(reasoningContent)
-
-						// Send thinking content to client
-						escapedThinking := strings.ReplaceAll(reasoningContent, "\n", "\\n")
-						escapedThinking = strings.ReplaceAll(escapedThinking, "\"", "\\\"")
-						thinkingData := fmt.Sprintf("data: {\"thinking_content\": \"%s\"}\n\n", escapedThinking)
-						if useReasoning {
-							e.Response.Write([]byte(thinkingData))
-							if flusher, ok := e.Response.(http.Flusher); ok {
-								flusher.Flush()
-							}
-						}
+			if reasoningContent := services.ReasoningDelta(chunk); reasoningContent != "" {
+				thinkingBuilder.WriteString(reasoningContent)
+
+				// Send thinking content to client
+				escapedThinking := strings.ReplaceAll(reasoningContent, "\n", "\\n")
+				escapedThinking = strings.ReplaceAll(escapedThinking, "\"", "\\\"")
+				thinkingData := fmt.Sprintf("data: {\"thinking_content\": \"%s\"}\n\n", escapedThinking)
+				if useReasoning {
+					e.Response.Write([]byte(thinkingData))
+					if flusher, ok := e.Response.(http.Flusher); ok {
+						flusher.Flush()
 					}
 				}
 			}
@@ -347,8 +482,13 @@ func streamAndSaveConversation(e *core.RequestEvent, conversationId, userMessage
 				}
 			}
 		}
+	}
 
-		usage = &chunk.Usage
+	// Headers are already sent, so a mid-stream failure can only be reported as an event
+	if err := stream.Err(); err != nil {
+		log.Printf("Stream failed for model %s: %v", stream.Model, err)
+		writeConversationEvent(e, map[string]interface{}{"error": "Failed to stream response"})
+		return nil
 	}
 
 	// Send completion event
@@ -358,38 +498,40 @@ func streamAndSaveConversation(e *core.RequestEvent, conversationId, userMessage
 	response := responseBuilder.String()
 	thinkingContent := thinkingBuilder.String()
 
-	reasoningTokens := int64(0)
-	inputTokens := int64(0)
-	outputTokens := int64(0)
-	totalCost := float64(0)
+	inputTokens, outputTokens, reasoningTokens, totalCost := stream.Totals()
 
-	if usage != nil {
-		reasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
-		inputTokens = usage.PromptTokens
-		outputTokens = usage.CompletionTokens
+	// Keep the exact params that were sent so the response can be reproduced
+	generationParams, err := json.Marshal(generation.Effective(useReasoning))
+	if err != nil {
+		log.Printf("Failed to encode generation params: %v", err)
+	}
 
-		if costField, exists := usage.JSON.ExtraFields["cost"]; exists {
-			costStr := costField.Raw()
-			if cost, err := strconv.ParseFloat(costStr, 64); err == nil {
-				totalCost = cost
-			}
+	var toolCalls []byte
+	if toolbox != nil && len(toolbox.Calls) > 0 {
+		if toolCalls, err = json.Marshal(toolbox.Calls); err != nil {
+			log.Printf("Failed to encode tool calls: %v", err)
 		}
 	}
 
 	// Create conversation message
 	message := &queries.ConversationMessage{
-		UserId:          userId,
-		ConversationId:  conversationId,
-		UserMessage:     userMessage,
-		ResponseMessage: response,
-		ThinkingContent: thinkingContent,
-		Model:           model,
-		InputTokens:     strconv.FormatInt(inputTokens, 10),
-		OutputTokens:    strconv.FormatInt(outputTokens, 10),
-		ReasoningTokens: strconv.FormatInt(reasoningTokens, 10),
-		Cost:            strconv.FormatFloat(totalCost, 'f', 6, 64),
-		Active:          true,
-		Created:         timestamp,
+		UserId:           userId,
+		ConversationId:   conversationId,
+		UserMessage:      userMessage,
+		ResponseMessage:  response,
+		ThinkingContent:  thinkingContent,
+		Model:            stream.Model,
+		InputTokens:      strconv.FormatInt(inputTokens, 10),
+		OutputTokens:     strconv.FormatInt(outputTokens, 10),
+		ReasoningTokens:  strconv.FormatInt(reasoningTokens, 10),
+		Cost:             strconv.FormatFloat(totalCost, 'f', 6, 64),
+		Active:           true,
+		CandidateGroup:   candidateGroup,
+		GenerationParams: generationParams,
+		ToolCalls:        toolCalls,
+		Citations:        citations,
+		Created:          timestamp,
+		Uploads:          lastMessageUploads(messages),
 	}
 
 	createdMessage, err := queries.CreateConversationMessage(e, message)
@@ -397,6 +539,13 @@ func streamAndSaveConversation(e *core.RequestEvent, conversationId, userMessage
 		log.Printf("Failed to save conversation message: %v", err)
 		// Don't return error as the response was already streamed
 	} else {
+		// Previous alternatives are kept but no longer part of the active history
+		if candidateGroup != "" {
+			if err := queries.SelectCandidateMessage(e, createdMessage); err != nil {
+				log.Printf("Failed to activate regenerated message: %v", err)
+			}
+		}
+
 		// Send the message ID to the client
 		messageIdData := fmt.Sprintf(`{"message_id": "%s"}`, createdMessage.Id)
 		sseData := "data: " + messageIdData + "\n\n"
@@ -453,6 +602,108 @@ func DeactivateConversationHandler(e *core.RequestEvent) error {
 	})
 }
 
+// UpdateConversationSettingsHandler replaces the default generation params of a conversation
+func UpdateConversationSettingsHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req services.GenerationParams
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	conversationId := e.Request.PathValue("id")
+
+	// Verify conversation exists and belongs to user
+	conversation, err := queries.GetConversationById(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	// Model specific limits are checked per request, the model can change between turns
+	if err := req.Validate(nil, false); err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
+	settings, err := json.Marshal(req)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid generation params", err)
+	}
+
+	fields := map[string]interface{}{
+		"settings": string(settings),
+		"updated":  types.NowDateTime().String(),
+	}
+
+	if _, err := queries.UpdateConversation(e, fields, dbx.HashExp{"id": conversationId}); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to update settings", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"settings": req,
+	})
+}
+
+// SelectCandidateHandler picks one compare mode candidate as the active continuation
+func SelectCandidateHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req SelectCandidateRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	// Verify conversation exists and belongs to user
+	conversation, err := queries.GetConversationById(e, req.ConversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	message, err := queries.GetConversationMessageById(e, req.MessageId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Message not found", err)
+	}
+
+	if message.ConversationId != req.ConversationId {
+		return e.Error(http.StatusBadRequest, "Message does not belong to conversation", nil)
+	}
+
+	if message.CandidateGroup == "" {
+		return e.Error(http.StatusBadRequest, "Message is not a candidate response", nil)
+	}
+
+	if !message.Active {
+		if err := queries.SelectCandidateMessage(e, message); errors.Is(err, queries.ErrCandidateNotOnBranch) {
+			return e.Error(http.StatusConflict, "Candidate is not on the current branch", err)
+		} else if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to select candidate", err)
+		}
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"message_id": message.Id,
+	})
+}
+
 func GetConversationHandler(e *core.RequestEvent) error {
 	setConversationCORSHeaders(e)
 
@@ -475,27 +726,29 @@ func GetConversationHandler(e *core.RequestEvent) error {
 		return e.Error(http.StatusInternalServerError, "Failed to get messages", err)
 	}
 
+	// Compare mode responses carry the other candidates so the user can pick one
+	var candidateGroups []string
+	for _, msg := range messages {
+		if msg.CandidateGroup != "" {
+			candidateGroups = append(candidateGroups, msg.CandidateGroup)
+		}
+	}
+
+	candidates, err := queries.GetMessagesByCandidateGroups(e, conversationId, candidateGroups)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get candidates", err)
+	}
+
 	// Convert to response format
 	messageResponses := make([]ConversationMessageResponse, 0)
 	for _, msg := range messages {
-		inputTokens, _ := strconv.ParseInt(msg.InputTokens, 10, 64)
-		outputTokens, _ := strconv.ParseInt(msg.OutputTokens, 10, 64)
-		reasoningTokens, _ := strconv.ParseInt(msg.ReasoningTokens, 10, 64)
-		cost, _ := strconv.ParseFloat(msg.Cost, 64)
-
-		messageResponses = append(messageResponses, ConversationMessageResponse{
-			Id:              msg.Id,
-			UserMessage:     msg.UserMessage,
-			ResponseMessage: msg.ResponseMessage,
-			ThinkingContent: msg.ThinkingContent,
-			Model:           msg.Model,
-			InputTokens:     inputTokens,
-			OutputTokens:    outputTokens,
-			ReasoningTokens: reasoningTokens,
-			Cost:            cost,
-			Active:          msg.Active,
-			Created:         msg.Created,
-		})
+		messageResponse := toConversationMessageResponse(msg)
+
+		for _, candidate := range candidates[msg.CandidateGroup] {
+			messageResponse.Candidates = append(messageResponse.Candidates, toConversationMessageResponse(candidate))
+		}
+
+		messageResponses = append(messageResponses, messageResponse)
 	}
 
 	totalRequests, _ := strconv.Atoi(conversation.TotalRequests)
@@ -513,6 +766,11 @@ func GetConversationHandler(e *core.RequestEvent) error {
 		OutputTokens:    outputTokens,
 		ReasoningTokens: reasoningTokens,
 		Cost:            cost,
+		Settings:        conversation.Settings,
+		Pinned:          conversation.Pinned,
+		ArchivedAt:      conversation.ArchivedAt,
+		Tags:            conversation.Tags,
+		FolderId:        conversation.FolderId,
 		Messages:        messageResponses,
 		Created:         conversation.Created,
 		Updated:         conversation.Updated,
@@ -531,8 +789,10 @@ func GetConversationsHandler(e *core.RequestEvent) error {
 	var conversations []*queries.Conversation
 	var err error
 
-	// Only get active conversations
-	if conversationType != "" {
+	// Only get active conversations, archived ones are listed on their own
+	if e.Request.URL.Query().Get("archived") == "true" {
+		conversations, err = queries.GetArchivedConversationsByUserId(e, userId)
+	} else if conversationType != "" {
 		conversations, err = queries.GetActiveConversationsByUserIdAndType(e, userId, conversationType, includeMessages == "true")
 	} else {
 		conversations, err = queries.GetActiveConversationsByUserId(e, userId, includeMessages == "true")
@@ -553,24 +813,7 @@ func GetConversationsHandler(e *core.RequestEvent) error {
 
 		messageResponses := make([]ConversationMessageResponse, 0)
 		for _, msg := range conv.Messages {
-			inputTokens, _ := strconv.ParseInt(msg.InputTokens, 10, 64)
-			outputTokens, _ := strconv.ParseInt(msg.OutputTokens, 10, 64)
-			reasoningTokens, _ := strconv.ParseInt(msg.ReasoningTokens, 10, 64)
-			cost, _ := strconv.ParseFloat(msg.Cost, 64)
-
-			messageResponses = append(messageResponses, ConversationMessageResponse{
-				Id:              msg.Id,
-				UserMessage:     msg.UserMessage,
-				ResponseMessage: msg.ResponseMessage,
-				ThinkingContent: msg.ThinkingContent,
-				Model:           msg.Model,
-				InputTokens:     inputTokens,
-				OutputTokens:    outputTokens,
-				ReasoningTokens: reasoningTokens,
-				Cost:            cost,
-				Active:          msg.Active,
-				Created:         msg.Created,
-			})
+			messageResponses = append(messageResponses, toConversationMessageResponse(&msg))
 		}
 
 		responses = append(responses, ConversationResponse{
@@ -582,6 +825,11 @@ func GetConversationsHandler(e *core.RequestEvent) error {
 			OutputTokens:    outputTokens,
 			ReasoningTokens: reasoningTokens,
 			Cost:            cost,
+			Settings:        conv.Settings,
+			Pinned:          conv.Pinned,
+			ArchivedAt:      conv.ArchivedAt,
+			Tags:            conv.Tags,
+			FolderId:        conv.FolderId,
 			Messages:        messageResponses,
 			Created:         conv.Created,
 			Updated:         conv.Updated,
@@ -591,6 +839,81 @@ func GetConversationsHandler(e *core.RequestEvent) error {
 	return e.JSON(http.StatusOK, responses)
 }
 
+// writeConversationEvent sends a JSON payload as a single SSE data event
+func writeConversationEvent(e *core.RequestEvent, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode stream event: %v", err)
+		return
+	}
+
+	e.Response.Write([]byte("data: " + string(data) + "\n\n"))
+	if flusher, ok := e.Response.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// answeringModels lists the models a request is going to be answered by
+func answeringModels(model string, compareModels []string) []string {
+	if len(compareModels) > 0 {
+		return compareModels
+	}
+	return []string{model}
+}
+
+// resolveGenerationParams merges the request params over the conversation settings and
+// validates the result against every model that is going to answer
+func resolveGenerationParams(e *core.RequestEvent, conversation *queries.Conversation, models []string, useReasoning bool, override services.GenerationParams) (services.GenerationParams, error) {
+	var generation services.GenerationParams
+	if conversation != nil && len(conversation.Settings) > 0 {
+		if err := json.Unmarshal(conversation.Settings, &generation); err != nil {
+			return generation, fmt.Errorf("invalid conversation settings: %w", err)
+		}
+	}
+
+	generation = generation.Merge(override)
+
+	for _, model := range models {
+		// Models missing from ai_models only get the generic range checks
+		aiModel, err := queries.GetAIModelByIdentifier(e, services.ResolveModel(model))
+		if err != nil {
+			aiModel = nil
+		}
+
+		if err := generation.Validate(aiModel, useReasoning); err != nil {
+			return generation, err
+		}
+	}
+
+	return generation, nil
+}
+
+func toConversationMessageResponse(msg *queries.ConversationMessage) ConversationMessageResponse {
+	inputTokens, _ := strconv.ParseInt(msg.InputTokens, 10, 64)
+	outputTokens, _ := strconv.ParseInt(msg.OutputTokens, 10, 64)
+	reasoningTokens, _ := strconv.ParseInt(msg.ReasoningTokens, 10, 64)
+	cost, _ := strconv.ParseFloat(msg.Cost, 64)
+
+	return ConversationMessageResponse{
+		Id:               msg.Id,
+		UserMessage:      msg.UserMessage,
+		ResponseMessage:  msg.ResponseMessage,
+		ThinkingContent:  msg.ThinkingContent,
+		Model:            msg.Model,
+		InputTokens:      inputTokens,
+		OutputTokens:     outputTokens,
+		ReasoningTokens:  reasoningTokens,
+		Cost:             cost,
+		Active:           msg.Active,
+		CandidateGroup:   msg.CandidateGroup,
+		GenerationParams: msg.GenerationParams,
+		ToolCalls:        msg.ToolCalls,
+		Citations:        msg.Citations,
+		Attachments:      msg.Attachments,
+		Created:          msg.Created,
+	}
+}
+
 func setConversationStreamHeaders(e *core.RequestEvent) {
 	e.Response.Header().Set("Content-Type", "text/event-stream")
 	e.Response.Header().Set("Cache-Control", "no-cache")
@@ -602,7 +925,7 @@ func setConversationStreamHeaders(e *core.RequestEvent) {
 
 func setConversationCORSHeaders(e *core.RequestEvent) {
 	e.Response.Header().Set("Access-Control-Allow-Origin", "*")
-	e.Response.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	e.Response.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 	e.Response.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 }
 
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"net/http"
+	"textly/queries"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// DocumentLinkResponse is a link between two documents. Target is empty for a wikilink
+// to a title no document has, and target_title is only given for documents the user may view.
+type DocumentLinkResponse struct {
+	Id          string `json:"id"`
+	Source      string `json:"source"`
+	SourceTitle string `json:"source_title"`
+	Kind        string `json:"kind"`
+	Target      string `json:"target"`
+	TargetTitle string `json:"target_title"`
+	Title       string `json:"title"`
+	Broken      bool   `json:"broken"`
+}
+
+// ListBacklinksHandler lists the links to a document from the documents the user may view
+func ListBacklinksHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	links, err := queries.GetDocumentBacklinks(e.App, document.Id, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get backlinks", err)
+	}
+
+	response := make([]DocumentLinkResponse, 0, len(links))
+	for _, link := range links {
+		link.TargetTitle = document.Title
+		response = append(response, toDocumentLinkResponse(link))
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// ListOutgoingLinksHandler lists the links in a document in order, with the broken ones
+func ListOutgoingLinksHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	links, err := queries.GetOutgoingDocumentLinks(e.App, document.Id, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get links", err)
+	}
+
+	response := make([]DocumentLinkResponse, 0, len(links))
+	for _, link := range links {
+		link.SourceTitle = document.Title
+		response = append(response, toDocumentLinkResponse(link))
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// ListBrokenLinksHandler lists the links in the user's documents that point to no
+// document, or to one in the trash
+func ListBrokenLinksHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	links, err := queries.GetBrokenDocumentLinks(e.App, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get broken links", err)
+	}
+
+	response := make([]DocumentLinkResponse, 0, len(links))
+	for _, link := range links {
+		response = append(response, toDocumentLinkResponse(link))
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+func toDocumentLinkResponse(link *queries.DocumentLink) DocumentLinkResponse {
+	return DocumentLinkResponse{
+		Id:          link.Id,
+		Source:      link.SourceId,
+		SourceTitle: link.SourceTitle,
+		Kind:        link.Kind,
+		Target:      link.TargetId,
+		TargetTitle: link.TargetTitle,
+		Title:       link.Title,
+		Broken:      link.Broken,
+	}
+}
@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"net/http"
+	"textly/queries"
+	"textly/routes/middleware"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+type DocumentResponse struct {
+	Id       string        `json:"id"`
+	Title    string        `json:"title"`
+	Content  string        `json:"content"`
+	Metadata types.JSONRaw `json:"metadata"`
+	Parent   string        `json:"parent"`
+	IsFolder bool          `json:"is_folder"`
+	OrderKey string        `json:"order_key"`
+	Created  string        `json:"created"`
+	Updated  string        `json:"updated"`
+}
+
+func RegisterDocumentRoutes(s *core.ServeEvent) *router.RouterGroup[*core.RequestEvent] {
+	documentGroup := s.Router.Group("/documents")
+
+	// Add OPTIONS handlers for CORS preflight (without auth middleware)
+	documentGroup.OPTIONS("/reindex", documentOptionsHandler)
+	documentGroup.OPTIONS("/shared", documentOptionsHandler)
+	documentGroup.OPTIONS("/import", documentOptionsHandler)
+	documentGroup.OPTIONS("/children", documentOptionsHandler)
+	documentGroup.OPTIONS("/trash", documentOptionsHandler)
+	documentGroup.OPTIONS("/broken-links", documentOptionsHandler)
+	documentGroup.OPTIONS("/from-template", documentOptionsHandler)
+	documentGroup.OPTIONS("/activity", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/shares", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/shares/{shareId}", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/links", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/links/{linkId}", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/collab", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/collab/operations", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/collab/presence", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/review", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/export", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/move", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/restore", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/purge", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/backlinks", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/outgoing", documentOptionsHandler)
+	documentGroup.OPTIONS("/{id}/stats", documentOptionsHandler)
+
+	// Add auth middleware for actual endpoints
+	documentGroup.Bind(middleware.AuthMiddleware())
+	documentGroup.POST("/reindex", ReindexDocumentsHandler)
+	documentGroup.GET("/shared", ListSharedDocumentsHandler)
+	documentGroup.GET("/children", ListDocumentChildrenHandler)
+	documentGroup.GET("/trash", ListTrashHandler)
+	documentGroup.DELETE("/trash", EmptyTrashHandler)
+	documentGroup.GET("/broken-links", ListBrokenLinksHandler)
+	documentGroup.POST("/from-template", CreateFromTemplateHandler)
+	documentGroup.GET("/activity", GetWritingActivityHandler)
+	documentGroup.POST("/import", ImportDocumentsHandler).Bind(apis.BodyLimit(services.MaxImportSize + 1<<20))
+	documentGroup.GET("/{id}/shares", ListDocumentSharesHandler)
+	documentGroup.POST("/{id}/shares", ShareDocumentHandler)
+	documentGroup.DELETE("/{id}/shares/{shareId}", RevokeDocumentShareHandler)
+	documentGroup.GET("/{id}/links", ListShareLinksHandler)
+	documentGroup.POST("/{id}/links", CreateShareLinkHandler)
+	documentGroup.DELETE("/{id}/links/{linkId}", RevokeShareLinkHandler)
+	documentGroup.GET("/{id}/collab", CollabStreamHandler)
+	documentGroup.POST("/{id}/collab/operations", CollabOperationHandler)
+	documentGroup.POST("/{id}/collab/presence", CollabPresenceHandler)
+	documentGroup.GET("/{id}/review", GetDocumentReviewHandler)
+	documentGroup.POST("/{id}/review", ReviewDocumentHandler)
+	documentGroup.GET("/{id}/export", ExportDocumentHandler)
+	documentGroup.POST("/{id}/move", MoveDocumentHandler)
+	documentGroup.POST("/{id}/restore", RestoreDocumentHandler)
+	documentGroup.DELETE("/{id}/purge", PurgeDocumentHandler)
+	documentGroup.GET("/{id}/backlinks", ListBacklinksHandler)
+	documentGroup.GET("/{id}/outgoing", ListOutgoingLinksHandler)
+	documentGroup.GET("/{id}/stats", GetDocumentStatsHandler)
+
+	return documentGroup
+}
+
+// ReindexDocumentsHandler queues every document of the user for chunking and embedding
+func ReindexDocumentsHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	indexer := services.GetDocumentIndexer()
+	if indexer == nil {
+		return e.Error(http.StatusServiceUnavailable, "Document indexing is not running", nil)
+	}
+
+	ids, err := queries.GetDocumentIdsByUserId(e.App, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get documents", err)
+	}
+
+	for _, id := range ids {
+		indexer.Enqueue(id)
+	}
+
+	return e.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"queued":  len(ids),
+	})
+}
+
+func toDocumentResponse(document *queries.Document) DocumentResponse {
+	return DocumentResponse{
+		Id:       document.Id,
+		Title:    document.Title,
+		Content:  document.Content,
+		Metadata: document.Metadata,
+		Parent:   document.Parent,
+		IsFolder: document.IsFolder,
+		OrderKey: document.OrderKey,
+		Created:  document.Created,
+		Updated:  document.Updated,
+	}
+}
+
+func setDocumentCORSHeaders(e *core.RequestEvent) {
+	e.Response.Header().Set("Access-Control-Allow-Origin", "*")
+	e.Response.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	e.Response.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+}
+
+func documentOptionsHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+	return e.NoContent(http.StatusOK)
+}
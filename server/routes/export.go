@@ -0,0 +1,145 @@
+package routes
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ExportConversationHandler downloads one conversation as a markdown, JSON or HTML transcript.
+// The include query parameter picks the optional parts, e.g. include=thinking,models,usage.
+func ExportConversationHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	conversationId := e.Request.PathValue("id")
+	format := exportFormat(e)
+	options := services.ParseTranscriptOptions(e.Request.URL.Query().Get("include"))
+
+	conversation, err := queries.GetConversationById(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	messages, err := queries.GetActiveMessagesByConversationIdOrdered(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get messages", err)
+	}
+
+	content, contentType, err := services.RenderTranscript(format, conversation, messages, options)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid export format", err)
+	}
+
+	fileName := services.ExportFileName(conversation.Title, conversation.Id, services.TranscriptExtension(format))
+	e.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	return e.Blob(http.StatusOK, contentType, content)
+}
+
+// ExportConversationsHandler downloads every active conversation of the user as a zip
+// with one transcript per conversation
+func ExportConversationsHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	format := exportFormat(e)
+	options := services.ParseTranscriptOptions(e.Request.URL.Query().Get("include"))
+
+	// Reject unknown formats before the zip response is started
+	if _, _, err := services.RenderTranscript(format, &queries.Conversation{}, nil, options); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid export format", err)
+	}
+
+	conversations, err := queries.GetActiveConversationsByUserId(e, e.Auth.Id, false)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get conversations", err)
+	}
+
+	e.Response.Header().Set("Content-Type", "application/zip")
+	e.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "conversations-"+services.ExportTimestamp()+".zip"))
+	e.Response.WriteHeader(http.StatusOK)
+
+	// The archive is streamed, so failures past this point can only end the download early
+	archive := zip.NewWriter(e.Response)
+	extension := services.TranscriptExtension(format)
+
+	for _, conversation := range conversations {
+		messages, err := queries.GetActiveMessagesByConversationIdOrdered(e, conversation.Id)
+		if err != nil {
+			return err
+		}
+
+		content, _, err := services.RenderTranscript(format, conversation, messages, options)
+		if err != nil {
+			return err
+		}
+
+		file, err := archive.Create(services.ExportFileName(conversation.Title, conversation.Id, extension))
+		if err != nil {
+			return err
+		}
+
+		if _, err := file.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// ExportDocumentHandler downloads a document as markdown, printable HTML, DOCX or EPUB.
+// A folder is exported as one book with every document below it, in the order they are
+// listed.
+func ExportDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	format := exportFormat(e)
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	var documents []*queries.Document
+	if document.IsFolder {
+		nodes, err := queries.GetDocumentSubtree(e.App, document.Id)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get documents", err)
+		}
+
+		ids := make([]string, len(nodes))
+		for i, node := range nodes {
+			ids[i] = node.Id
+		}
+
+		documents, err = queries.GetDocumentsByIds(e, ids)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get documents", err)
+		}
+	}
+
+	content, contentType, err := services.RenderDocumentExport(format, services.BuildExportBook(document, documents))
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid export format", err)
+	}
+
+	fileName := services.DocumentExportFileName(document.Title, document.Id, services.TranscriptExtension(format))
+	e.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	return e.Blob(http.StatusOK, contentType, content)
+}
+
+// exportFormat reads the format query parameter, markdown when it is missing
+func exportFormat(e *core.RequestEvent) string {
+	if format := e.Request.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	return "md"
+}
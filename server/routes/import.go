@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type ImportedDocumentResponse struct {
+	Id       string `json:"id"`
+	Path     string `json:"path"`
+	Title    string `json:"title"`
+	Parent   string `json:"parent"`
+	IsFolder bool   `json:"is_folder"`
+}
+
+type ImportDocumentsResponse struct {
+	Success   bool                       `json:"success"`
+	Documents []ImportedDocumentResponse `json:"documents"`
+	Skipped   []string                   `json:"skipped"`
+	Warnings  []services.ImportError     `json:"warnings"`
+	Errors    []services.ImportError     `json:"errors"`
+}
+
+var errImportFailed = errors.New("import failed")
+
+// ImportDocumentsHandler creates documents and folders from a zip of markdown files sent
+// in the file field of a multipart form, optionally into the folder given as parent.
+// Everything is created in one transaction: if any file cannot be imported nothing is,
+// and the response lists the errors per file.
+func ImportDocumentsHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	if !isMultipartRequest(e) {
+		return e.Error(http.StatusBadRequest, "Expected a multipart form with a zip file", nil)
+	}
+
+	files, err := e.FindUploadedFiles("file")
+	if err != nil || len(files) == 0 {
+		return e.Error(http.StatusBadRequest, "Missing zip file", err)
+	}
+
+	if files[0].Size > services.MaxImportSize {
+		return e.Error(http.StatusBadRequest, "Zip file is too large", nil)
+	}
+
+	userId := e.Auth.Id
+	parentId := e.Request.FormValue("parent")
+
+	if parentId != "" {
+		parent, err := queries.GetDocumentById(e, parentId)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Parent folder not found", err)
+		}
+
+		role, err := services.DocumentRole(e.App, parent.Id, userId)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+		}
+
+		if !services.RoleAllows(role, services.RoleEditor) {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+
+		if !parent.IsFolder {
+			return e.Error(http.StatusBadRequest, "Parent must be a folder", nil)
+		}
+	}
+
+	data, err := readFile(files[0])
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read zip file", err)
+	}
+
+	plan, err := services.PlanMarkdownImport(data)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid zip file", err)
+	}
+
+	response := ImportDocumentsResponse{
+		Documents: []ImportedDocumentResponse{},
+		Skipped:   plan.Skipped,
+		Warnings:  plan.Warnings,
+		Errors:    plan.Errors,
+	}
+	if response.Skipped == nil {
+		response.Skipped = []string{}
+	}
+	if response.Warnings == nil {
+		response.Warnings = []services.ImportError{}
+	}
+	if response.Errors == nil {
+		response.Errors = []services.ImportError{}
+	}
+
+	if len(plan.Errors) > 0 {
+		return e.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		// The document queries only use the app of the event, so bind them to the transaction
+		txEvent := &core.RequestEvent{App: txApp}
+
+		for _, item := range plan.Items {
+			parent := item.Parent
+			if parent == "" {
+				parent = parentId
+			}
+
+			document, err := queries.CreateDocument(txEvent, &queries.Document{
+				Id:       item.Id,
+				UserId:   userId,
+				Title:    item.Title,
+				Content:  item.Content,
+				Metadata: item.Metadata,
+				Parent:   parent,
+				IsFolder: item.IsFolder,
+			})
+			if err != nil {
+				response.Errors = append(response.Errors, services.ImportError{Path: item.Path, Error: err.Error()})
+				continue
+			}
+
+			response.Documents = append(response.Documents, ImportedDocumentResponse{
+				Id:       document.Id,
+				Path:     item.Path,
+				Title:    document.Title,
+				Parent:   document.Parent,
+				IsFolder: document.IsFolder,
+			})
+		}
+
+		if len(response.Errors) > 0 {
+			return errImportFailed
+		}
+		return nil
+	})
+	if errors.Is(err, errImportFailed) {
+		response.Documents = []ImportedDocumentResponse{}
+		return e.JSON(http.StatusUnprocessableEntity, response)
+	}
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to import documents", err)
+	}
+
+	response.Success = true
+	return e.JSON(http.StatusCreated, response)
+}
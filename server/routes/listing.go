@@ -0,0 +1,204 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"textly/queries"
+	"textly/services"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	defaultConversationPageSize = 30
+	maxConversationPageSize     = 100
+	previewLength               = 160
+)
+
+type ConversationListItemResponse struct {
+	Id            string   `json:"id"`
+	Title         string   `json:"title"`
+	Type          string   `json:"type"`
+	Pinned        bool     `json:"pinned"`
+	ArchivedAt    string   `json:"archived_at,omitempty"`
+	Tags          []string `json:"tags"`
+	FolderId      string   `json:"folder,omitempty"`
+	MessageCount  int      `json:"message_count"`
+	LastMessage   string   `json:"last_message"`
+	LastModel     string   `json:"last_model,omitempty"`
+	LastMessageAt string   `json:"last_message_at,omitempty"`
+	Created       string   `json:"created"`
+	Updated       string   `json:"updated"`
+}
+
+type ConversationListResponse struct {
+	Items      []ConversationListItemResponse `json:"items"`
+	NextCursor string                         `json:"next_cursor,omitempty"`
+	HasMore    bool                           `json:"has_more"`
+}
+
+// ListConversationsHandler returns one page of the user's conversations without their
+// messages, for the sidebar. Supported query parameters:
+//
+//	limit     page size, 30 by default and at most 100
+//	cursor    next_cursor of the previous page
+//	type      conversation type
+//	model     only conversations with a message answered by the model
+//	tag       only conversations with the tag id
+//	folder    only conversations filed under the document folder id
+//	from, to  bounds of the last update, as dates or date times
+//	pinned    true or false
+//	archived  true to list archived conversations instead of the current ones
+//	sort      updated (default), created, title or archived_at
+//	order     asc or desc, descending by default except for title
+//
+// Pinned conversations are always listed first.
+func ListConversationsHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	filter, err := parseConversationListFilter(e)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	items, err := queries.ListConversations(e, e.Auth.Id, filter)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get conversations", err)
+	}
+
+	response := ConversationListResponse{
+		Items: make([]ConversationListItemResponse, 0, len(items)),
+	}
+
+	if int64(len(items)) > pageSize {
+		items = items[:pageSize]
+		last := items[len(items)-1]
+
+		response.HasMore = true
+		response.NextCursor = encodeConversationCursor(queries.ConversationCursor{
+			Pinned: last.Pinned,
+			Value:  last.SortValue,
+			Id:     last.Id,
+		})
+	}
+
+	for _, item := range items {
+		response.Items = append(response.Items, ConversationListItemResponse{
+			Id:            item.Id,
+			Title:         item.Title,
+			Type:          item.Type,
+			Pinned:        item.Pinned,
+			ArchivedAt:    item.ArchivedAt,
+			Tags:          item.Tags,
+			FolderId:      item.FolderId,
+			MessageCount:  item.MessageCount,
+			LastMessage:   services.MarkdownPreview(item.LastMessage, previewLength),
+			LastModel:     item.LastModel,
+			LastMessageAt: item.LastMessageAt,
+			Created:       item.Created,
+			Updated:       item.Updated,
+		})
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+func parseConversationListFilter(e *core.RequestEvent) (queries.ConversationListFilter, error) {
+	params := e.Request.URL.Query()
+
+	filter := queries.ConversationListFilter{
+		Type:     params.Get("type"),
+		Model:    params.Get("model"),
+		Tag:      params.Get("tag"),
+		FolderId: params.Get("folder"),
+		Archived: params.Get("archived") == "true",
+		Sort:     params.Get("sort"),
+		Limit:    defaultConversationPageSize,
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = "updated"
+	}
+	if _, ok := queries.ConversationSortColumns[filter.Sort]; !ok {
+		return filter, fmt.Errorf("invalid sort %q, use updated, created, title or archived_at", filter.Sort)
+	}
+
+	switch params.Get("order") {
+	case "":
+		filter.Descending = filter.Sort != "title"
+	case "asc":
+		filter.Descending = false
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("invalid order %q, use asc or desc", params.Get("order"))
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+		filter.Limit = min(limit, maxConversationPageSize)
+	}
+
+	if value := params.Get("pinned"); value != "" {
+		pinned, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid pinned %q, use true or false", value)
+		}
+		filter.Pinned = &pinned
+	}
+
+	for name, target := range map[string]*string{"from": &filter.From, "to": &filter.To} {
+		if value := params.Get(name); value != "" {
+			date, err := types.ParseDateTime(value)
+			if err != nil || date.IsZero() {
+				return filter, fmt.Errorf("invalid %s date %q", name, value)
+			}
+
+			// A plain date as the upper bound includes the whole day
+			if name == "to" && len(value) == len(time.DateOnly) {
+				date = date.Add(24*time.Hour - time.Millisecond)
+			}
+			*target = date.String()
+		}
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeConversationCursor(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cursor")
+		}
+		filter.After = cursor
+	}
+
+	return filter, nil
+}
+
+func encodeConversationCursor(cursor queries.ConversationCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeConversationCursor(value string) (*queries.ConversationCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor queries.ConversationCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
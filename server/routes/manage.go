@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"textly/queries"
+	"textly/services"
+	"unicode/utf8"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const maxConversationTitleLength = 200
+
+type RenameConversationRequest struct {
+	Title string `json:"title"`
+}
+
+// RenameConversationHandler changes the title of a conversation
+func RenameConversationHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req RenameConversationRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		return e.Error(http.StatusBadRequest, "Title is required", nil)
+	}
+
+	if utf8.RuneCountInString(title) > maxConversationTitleLength {
+		return e.Error(http.StatusBadRequest, "Title is too long", nil)
+	}
+
+	conversation, err := ownedConversation(e)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"title":   title,
+		"updated": types.NowDateTime().String(),
+	}
+
+	if _, err := queries.UpdateConversation(e, fields, dbx.HashExp{"id": conversation.Id}); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to rename conversation", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"title":   title,
+	})
+}
+
+// PinConversationHandler returns the handler that pins or unpins a conversation.
+// Pinned conversations are listed first.
+func PinConversationHandler(pinned bool) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		setConversationCORSHeaders(e)
+
+		conversation, err := ownedConversation(e)
+		if err != nil {
+			return err
+		}
+
+		if _, err := queries.UpdateConversation(e, map[string]interface{}{"pinned": pinned}, dbx.HashExp{"id": conversation.Id}); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to update conversation", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success": true,
+			"pinned":  pinned,
+		})
+	}
+}
+
+// ArchiveConversationHandler returns the handler that archives or restores a conversation.
+// Archived conversations are only listed with archived=true and are purged after the
+// retention period.
+func ArchiveConversationHandler(archived bool) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		setConversationCORSHeaders(e)
+
+		conversation, err := ownedConversation(e)
+		if err != nil {
+			return err
+		}
+
+		archivedAt := ""
+		if archived {
+			archivedAt = types.NowDateTime().String()
+		}
+
+		if _, err := queries.UpdateConversation(e, map[string]interface{}{"archived_at": archivedAt}, dbx.HashExp{"id": conversation.Id}); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to update conversation", err)
+		}
+
+		response := map[string]interface{}{
+			"success":     true,
+			"archived_at": archivedAt,
+		}
+		if archived {
+			response["purge_after"] = services.ConversationPurgeDate(archivedAt)
+		}
+
+		return e.JSON(http.StatusOK, response)
+	}
+}
+
+// DeleteConversationHandler permanently removes a conversation with all of its messages
+func DeleteConversationHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	conversation, err := ownedConversation(e)
+	if err != nil {
+		return err
+	}
+
+	if err := queries.DeleteConversationPermanently(e.App, conversation.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to delete conversation", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Conversation deleted permanently",
+	})
+}
+
+// ownedConversation loads the conversation of the path and checks that it belongs to the user
+func ownedConversation(e *core.RequestEvent) (*queries.Conversation, error) {
+	conversation, err := queries.GetConversationById(e, e.Request.PathValue("id"))
+	if err != nil {
+		return nil, e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != e.Auth.Id {
+		return nil, e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	return conversation, nil
+}
@@ -0,0 +1,201 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"textly/hooks"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// MoveDocumentRequest places a document right before or after a sibling, which may be in
+// another folder, or last in the given folder. An empty parent is the top level.
+type MoveDocumentRequest struct {
+	Before string  `json:"before"`
+	After  string  `json:"after"`
+	Parent *string `json:"parent"`
+}
+
+// ListDocumentChildrenHandler lists the documents and folders of a folder in order, or the
+// top level of the user when no parent is given
+func ListDocumentChildrenHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	parentId := e.Request.URL.Query().Get("parent")
+	if parentId != "" {
+		parent, _, err := accessibleDocument(e, parentId)
+		if err != nil {
+			return err
+		}
+
+		if !parent.IsFolder {
+			return e.Error(http.StatusBadRequest, "Parent must be a folder", nil)
+		}
+	}
+
+	documents, err := queries.GetDocumentChildren(e, e.Auth.Id, parentId)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get documents", err)
+	}
+
+	response := make([]DocumentResponse, 0, len(documents))
+	for _, document := range documents {
+		response = append(response, toDocumentResponse(document))
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// MoveDocumentHandler reorders a document among its siblings or moves it to another
+// folder. Reordering needs the editor role on the folder, moving to another folder is
+// left to the owner of the document, like with the parent field of the record.
+func MoveDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req MoveDocumentRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	if req.Before != "" && req.After != "" {
+		return e.Error(http.StatusBadRequest, "Give either before or after", nil)
+	}
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	userId := e.Auth.Id
+
+	var parentId string
+	sibling := req.Before + req.After
+	switch {
+	case sibling != "":
+		if sibling == document.Id {
+			return e.Error(http.StatusBadRequest, "A document cannot be placed next to itself", nil)
+		}
+
+		anchor, err := queries.GetDocumentById(e, sibling)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Sibling not found", err)
+		}
+
+		// The top level is per user
+		if anchor.Parent == "" && anchor.UserId != document.UserId {
+			return e.Error(http.StatusBadRequest, "Sibling is not in a folder of the document owner", nil)
+		}
+
+		if req.Parent != nil && *req.Parent != anchor.Parent {
+			return e.Error(http.StatusBadRequest, "Sibling is not in the given folder", nil)
+		}
+
+		parentId = anchor.Parent
+	case req.Parent != nil:
+		parentId = *req.Parent
+	default:
+		return e.Error(http.StatusBadRequest, "Give before, after or parent", nil)
+	}
+
+	moved := parentId != document.Parent
+	if moved && document.UserId != userId {
+		return e.Error(http.StatusForbidden, "Only the owner can move a document to another folder", nil)
+	}
+
+	if parentId == "" {
+		if document.UserId != userId {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+	} else {
+		parent, err := queries.GetDocumentById(e, parentId)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Parent folder not found", err)
+		}
+
+		role, err := services.DocumentRole(e.App, parent.Id, userId)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+		}
+
+		if !services.RoleAllows(role, services.RoleEditor) {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+
+		if !parent.IsFolder {
+			return e.Error(http.StatusBadRequest, "Parent must be a folder", nil)
+		}
+	}
+
+	record, err := e.App.FindRecordById("documents", document.Id)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	record.Set("parent", parentId)
+	if err := hooks.PreventCircularReference(e.App, record); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid parent", err)
+	}
+
+	siblings, err := queries.GetDocumentSiblings(e.App, document.UserId, parentId)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get documents", err)
+	}
+
+	var ids, keys []string
+	index := -1
+	for _, node := range siblings {
+		if node.Id == document.Id {
+			continue
+		}
+		if node.Id == req.Before {
+			index = len(ids)
+		}
+		if node.Id == req.After {
+			index = len(ids) + 1
+		}
+		ids = append(ids, node.Id)
+		keys = append(keys, node.OrderKey)
+	}
+	if index < 0 {
+		index = len(ids)
+	}
+
+	key, renumbered, err := services.OrderKeyAt(keys, index)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to order documents", err)
+	}
+
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		for i, siblingKey := range renumbered {
+			if err := queries.UpdateDocumentOrderKey(txApp, ids[i], siblingKey); err != nil {
+				return err
+			}
+		}
+
+		// Only a move to another folder goes through the record, so that access is synced
+		if !moved {
+			return queries.UpdateDocumentOrderKey(txApp, document.Id, key)
+		}
+
+		record.Set("order_key", key)
+		return txApp.Save(record)
+	})
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to move document", err)
+	}
+
+	document, err = queries.GetDocumentById(e, document.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get document", err)
+	}
+
+	return e.JSON(http.StatusOK, toDocumentResponse(document))
+}
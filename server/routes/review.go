@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+type ReviewDocumentRequest struct {
+	Force    bool `json:"force"`
+	Comments bool `json:"comments"`
+}
+
+type DocumentReviewResponse struct {
+	Id            string        `json:"id"`
+	DocumentId    string        `json:"document"`
+	Status        string        `json:"status"`
+	Suggestions   types.JSONRaw `json:"suggestions"`
+	SectionsTotal int           `json:"sections_total"`
+	SectionsDone  int           `json:"sections_done"`
+	Error         string        `json:"error,omitempty"`
+	Model         string        `json:"model,omitempty"`
+	Cost          float64       `json:"cost"`
+	Created       string        `json:"created"`
+	Updated       string        `json:"updated"`
+}
+
+// ReviewDocumentHandler starts an AI review of the document, which looks for clarity,
+// grammar, consistency and factual issues section by section. It answers right away with
+// the running review, to be followed with GET. The review of unchanged content is reused
+// unless force is set. With comments, the suggestions are added as assistant comments
+// once the review is complete, so it needs the commenter role.
+func ReviewDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req ReviewDocumentRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid request body", err)
+		}
+	}
+
+	document, role, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if !services.RoleAllows(role, services.RoleCommenter) {
+		return e.Error(http.StatusForbidden, "You cannot review this document", nil)
+	}
+
+	if document.IsFolder {
+		return e.Error(http.StatusBadRequest, "Folders cannot be reviewed", nil)
+	}
+
+	models := queries.GetModelFallbackChain(e, services.ResolveModel(""))
+	review, err := services.StartDocumentReview(e.App, document.Id, e.Auth.Id, models, req.Force, req.Comments)
+	if errors.Is(err, services.ErrReviewBusy) {
+		return e.Error(http.StatusConflict, "The review is busy, try again", err)
+	}
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to review document", err)
+	}
+
+	status := http.StatusOK
+	if review.Status == services.ReviewStatusRunning {
+		status = http.StatusAccepted
+	}
+
+	return e.JSON(status, toDocumentReviewResponse(review))
+}
+
+// GetDocumentReviewHandler returns the review of the current content of the document
+func GetDocumentReviewHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	review, err := queries.GetDocumentReviewByContentHash(e.App, document.Id, services.ReviewContentHash(document.Content))
+	if errors.Is(err, sql.ErrNoRows) {
+		return e.Error(http.StatusNotFound, "The current content has not been reviewed", err)
+	}
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get review", err)
+	}
+
+	return e.JSON(http.StatusOK, toDocumentReviewResponse(review))
+}
+
+func toDocumentReviewResponse(review *queries.DocumentReview) DocumentReviewResponse {
+	suggestions := review.Suggestions
+	if len(suggestions) == 0 || string(suggestions) == "null" {
+		suggestions = types.JSONRaw("[]")
+	}
+
+	return DocumentReviewResponse{
+		Id:            review.Id,
+		DocumentId:    review.DocumentId,
+		Status:        review.Status,
+		Suggestions:   suggestions,
+		SectionsTotal: review.SectionsTotal,
+		SectionsDone:  review.SectionsDone,
+		Error:         review.Error,
+		Model:         review.Model,
+		Cost:          review.Cost,
+		Created:       review.Created,
+		Updated:       review.Updated,
+	}
+}
@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"textly/queries"
+	"textly/services"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type SaveToDocumentRequest struct {
+	MessageId     string                  `json:"message_id,omitempty"`
+	DocumentId    string                  `json:"document_id,omitempty"`
+	Title         string                  `json:"title,omitempty"`
+	Parent        string                  `json:"parent,omitempty"`
+	Position      services.InsertPosition `json:"position,omitempty"`
+	IncludePrompt bool                    `json:"include_prompt,omitempty"`
+}
+
+// SaveToDocumentHandler saves an assistant message, or the whole conversation when no
+// message is given, into a new document or into an existing one at the chosen position.
+// The conversation and message ids are recorded in the document metadata.
+func SaveToDocumentHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req SaveToDocumentRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid request body", err)
+		}
+	}
+
+	conversationId := e.Request.PathValue("id")
+	userId := e.Auth.Id
+
+	conversation, err := queries.GetConversationById(e, conversationId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Conversation not found", err)
+	}
+
+	if conversation.UserId != userId {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	var content string
+	var messageIds []string
+
+	if req.MessageId != "" {
+		message, err := queries.GetConversationMessageById(e, req.MessageId)
+		if err != nil || message.ConversationId != conversationId {
+			return e.Error(http.StatusNotFound, "Message not found", err)
+		}
+
+		content = services.MessageMarkdown(message, req.IncludePrompt)
+		messageIds = []string{message.Id}
+	} else {
+		messages, err := queries.GetActiveMessagesByConversationIdOrdered(e, conversationId)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get messages", err)
+		}
+
+		if len(messages) == 0 {
+			return e.Error(http.StatusBadRequest, "Conversation has no messages", nil)
+		}
+
+		content = services.RenderTranscriptMarkdown(conversation, messages, services.TranscriptOptions{})
+		for _, message := range messages {
+			messageIds = append(messageIds, message.Id)
+		}
+	}
+
+	source := services.DocumentSource{
+		ConversationId: conversationId,
+		MessageIds:     messageIds,
+		SavedAt:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if req.DocumentId != "" {
+		document, err := queries.GetDocumentById(e, req.DocumentId)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Document not found", err)
+		}
+
+		role, err := services.DocumentRole(e.App, document.Id, userId)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+		}
+
+		if !services.RoleAllows(role, services.RoleEditor) {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+
+		if document.IsFolder {
+			return e.Error(http.StatusBadRequest, "Cannot save into a folder, choose a document", nil)
+		}
+
+		updated, offset, err := services.InsertIntoDocument(document.Content, content, req.Position)
+		if err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid position", err)
+		}
+
+		source.Offset = offset
+		source.Length = utf8.RuneCountInString(updated) - utf8.RuneCountInString(document.Content)
+
+		metadata, err := services.AddDocumentSource(document.Metadata, source)
+		if err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid document metadata", err)
+		}
+
+		document, err = queries.UpdateDocumentRecord(e, document.Id, map[string]any{
+			"content":  updated,
+			"metadata": metadata,
+		})
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to update document", err)
+		}
+
+		return e.JSON(http.StatusOK, toDocumentResponse(document))
+	}
+
+	if req.Parent != "" {
+		parent, err := queries.GetDocumentById(e, req.Parent)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Parent folder not found", err)
+		}
+
+		role, err := services.DocumentRole(e.App, parent.Id, userId)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+		}
+
+		if !services.RoleAllows(role, services.RoleEditor) {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+
+		if !parent.IsFolder {
+			return e.Error(http.StatusBadRequest, "Parent must be a folder", nil)
+		}
+	}
+
+	title := req.Title
+	if title == "" {
+		title = conversation.Title
+	}
+
+	content, _, _ = services.InsertIntoDocument("", content, services.InsertPosition{})
+	source.Length = utf8.RuneCountInString(content)
+
+	metadata, err := services.AddDocumentSource(nil, source)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to record document source", err)
+	}
+
+	document, err := queries.CreateDocument(e, &queries.Document{
+		UserId:   userId,
+		Title:    title,
+		Content:  content,
+		Metadata: metadata,
+		Parent:   req.Parent,
+	})
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to create document", err)
+	}
+
+	return e.JSON(http.StatusCreated, toDocumentResponse(document))
+}
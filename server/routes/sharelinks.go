@@ -0,0 +1,296 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"textly/authorization/encryption"
+	"textly/queries"
+	"textly/services"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Permissions of a public link, each allowing one of the formats of GET /share/{token}
+const (
+	sharePermissionHTML     = "html"
+	sharePermissionMarkdown = "markdown"
+)
+
+type CreateShareLinkRequest struct {
+	Permissions   []string `json:"permissions,omitempty"`
+	ExpiresAt     string   `json:"expires_at,omitempty"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+type ShareLinkResponse struct {
+	Id           string   `json:"id"`
+	DocumentId   string   `json:"document"`
+	Permissions  []string `json:"permissions"`
+	ExpiresAt    string   `json:"expires_at,omitempty"`
+	RevokedAt    string   `json:"revoked_at,omitempty"`
+	ViewCount    int      `json:"view_count"`
+	LastViewedAt string   `json:"last_viewed_at,omitempty"`
+	Token        string   `json:"token,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Created      string   `json:"created"`
+}
+
+// RegisterShareRoutes registers the public document links, which need no authentication
+func RegisterShareRoutes(s *core.ServeEvent) *router.RouterGroup[*core.RequestEvent] {
+	shareGroup := s.Router.Group("/share")
+
+	shareGroup.OPTIONS("/{token}", documentOptionsHandler)
+	shareGroup.GET("/{token}", ViewSharedDocumentHandler)
+
+	return shareGroup
+}
+
+// CreateShareLinkHandler creates a public link to a document. Permissions choose the
+// formats it can be opened in and default to html only. The link expires at expires_at,
+// or after expires_in_days, and never when neither is given.
+func CreateShareLinkHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req CreateShareLinkRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			return e.Error(http.StatusBadRequest, "Invalid request body", err)
+		}
+	}
+
+	permissions := []string{sharePermissionHTML}
+	if len(req.Permissions) > 0 {
+		permissions = nil
+		for _, permission := range req.Permissions {
+			if permission != sharePermissionHTML && permission != sharePermissionMarkdown {
+				return e.Error(http.StatusBadRequest, "Permissions must be html or markdown", nil)
+			}
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	var expiresAt string
+	switch {
+	case req.ExpiresAt != "":
+		date, err := types.ParseDateTime(req.ExpiresAt)
+		if err != nil || date.IsZero() {
+			return e.Error(http.StatusBadRequest, "Invalid expires_at date", err)
+		}
+		if !date.Time().After(time.Now()) {
+			return e.Error(http.StatusBadRequest, "expires_at must be in the future", nil)
+		}
+		expiresAt = date.String()
+	case req.ExpiresInDays < 0:
+		return e.Error(http.StatusBadRequest, "expires_in_days must be positive", nil)
+	case req.ExpiresInDays > 0:
+		expiresAt = types.NowDateTime().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour).String()
+	}
+
+	document, err := queries.GetDocumentById(e, e.Request.PathValue("id"))
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	if document.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Only the owner can create public links", nil)
+	}
+
+	if document.IsFolder {
+		return e.Error(http.StatusBadRequest, "Folders cannot be shared with a public link", nil)
+	}
+
+	link, err := queries.CreateDocumentShareLink(e, &queries.DocumentShareLink{
+		DocumentId:  document.Id,
+		UserId:      e.Auth.Id,
+		Permissions: permissions,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to create link", err)
+	}
+
+	response, err := toShareLinkResponse(e, link)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to create link token", err)
+	}
+
+	return e.JSON(http.StatusCreated, response)
+}
+
+// ListShareLinksHandler lists the public links of a document with their view counts
+func ListShareLinksHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, err := queries.GetDocumentById(e, e.Request.PathValue("id"))
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	if document.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	links, err := queries.GetDocumentShareLinksByDocumentId(e, document.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get links", err)
+	}
+
+	response := make([]ShareLinkResponse, 0, len(links))
+	for _, link := range links {
+		item, err := toShareLinkResponse(e, link)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to create link token", err)
+		}
+		response = append(response, item)
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// RevokeShareLinkHandler revokes a public link. It is kept so its views stay listed.
+func RevokeShareLinkHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	link, err := queries.GetDocumentShareLinkById(e, e.Request.PathValue("linkId"))
+	if err != nil || link.DocumentId != e.Request.PathValue("id") {
+		return e.Error(http.StatusNotFound, "Link not found", err)
+	}
+
+	if link.UserId != e.Auth.Id {
+		document, err := queries.GetDocumentById(e, link.DocumentId)
+		if err != nil || document.UserId != e.Auth.Id {
+			return e.Error(http.StatusForbidden, "Access denied", err)
+		}
+	}
+
+	if link.RevokedAt == "" {
+		if err := queries.RevokeDocumentShareLink(e, link.Id); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to revoke link", err)
+		}
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ViewSharedDocumentHandler serves a document through a public link, as a rendered page
+// or with format=markdown as its source, and counts the view
+func ViewSharedDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	token, err := encryption.DecryptShareToken(e.Request.PathValue("token"))
+	if errors.Is(err, encryption.ErrShareTokenExpired) {
+		return e.Error(http.StatusGone, "This link has expired", err)
+	}
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Link not found", err)
+	}
+
+	link, err := queries.GetDocumentShareLinkById(e, token.LinkId)
+	if err != nil || link.DocumentId != token.DocumentId {
+		return e.Error(http.StatusNotFound, "Link not found", err)
+	}
+
+	if link.RevokedAt != "" {
+		return e.Error(http.StatusGone, "This link has been revoked", nil)
+	}
+
+	if expires, err := types.ParseDateTime(link.ExpiresAt); err == nil && !expires.IsZero() && expires.Time().Before(time.Now()) {
+		return e.Error(http.StatusGone, "This link has expired", nil)
+	}
+
+	format := e.Request.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = sharePermissionHTML
+		if !token.Allows(sharePermissionHTML) {
+			format = sharePermissionMarkdown
+		}
+	case "md":
+		format = sharePermissionMarkdown
+	}
+
+	if !token.Allows(format) || !slices.Contains(link.Permissions, format) {
+		return e.Error(http.StatusForbidden, fmt.Sprintf("This link does not allow the %s format", format), nil)
+	}
+
+	document, err := queries.GetDocumentById(e, link.DocumentId)
+	if err != nil || document.IsFolder {
+		return e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	if err := queries.RecordDocumentShareLinkView(e, link.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to record view", err)
+	}
+
+	// Shared pages are self-contained, so nothing but inline styles and remote images may load
+	e.Response.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src https: data:")
+	e.Response.Header().Set("Referrer-Policy", "no-referrer")
+	e.Response.Header().Set("X-Robots-Tag", "noindex")
+	e.Response.Header().Set("Cache-Control", "no-store")
+
+	if format == sharePermissionMarkdown {
+		fileName := services.DocumentExportFileName(document.Title, document.Id, "md")
+		e.Response.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fileName))
+		return e.Blob(http.StatusOK, "text/markdown; charset=utf-8", []byte(document.Content))
+	}
+
+	return e.HTML(http.StatusOK, services.RenderDocumentHTML(document.Title, document.Content))
+}
+
+// toShareLinkResponse issues a fresh token for active links. Every token of a link stays
+// valid until the link is revoked or expires.
+func toShareLinkResponse(e *core.RequestEvent, link *queries.DocumentShareLink) (ShareLinkResponse, error) {
+	response := ShareLinkResponse{
+		Id:           link.Id,
+		DocumentId:   link.DocumentId,
+		Permissions:  link.Permissions,
+		ExpiresAt:    link.ExpiresAt,
+		RevokedAt:    link.RevokedAt,
+		ViewCount:    link.ViewCount,
+		LastViewedAt: link.LastViewedAt,
+		Created:      link.Created,
+	}
+
+	if link.RevokedAt != "" {
+		return response, nil
+	}
+
+	token := encryption.ShareToken{
+		LinkId:      link.Id,
+		DocumentId:  link.DocumentId,
+		Permissions: link.Permissions,
+	}
+
+	if expires, err := types.ParseDateTime(link.ExpiresAt); err == nil && !expires.IsZero() {
+		if expires.Time().Before(time.Now()) {
+			return response, nil
+		}
+		token.ExpiresAt = expires.Time().Unix()
+	}
+
+	value, err := encryption.GenerateShareToken(token)
+	if err != nil {
+		return response, err
+	}
+
+	response.Token = value
+	response.URL = strings.TrimSuffix(e.App.Settings().Meta.AppURL, "/") + "/share/" + value
+	return response, nil
+}
@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type ShareDocumentRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type DocumentShareResponse struct {
+	Id         string `json:"id"`
+	DocumentId string `json:"document"`
+	UserId     string `json:"user,omitempty"`
+	Email      string `json:"email"`
+	Role       string `json:"role"`
+	Pending    bool   `json:"pending"`
+	Inherited  bool   `json:"inherited"`
+	Created    string `json:"created"`
+}
+
+type DocumentCollaboratorsResponse struct {
+	OwnerId    string                  `json:"owner"`
+	OwnerEmail string                  `json:"owner_email"`
+	Role       string                  `json:"role"`
+	Shares     []DocumentShareResponse `json:"shares"`
+}
+
+type SharedDocumentResponse struct {
+	DocumentResponse
+	OwnerId string `json:"owner"`
+	Role    string `json:"role"`
+}
+
+// ShareDocumentHandler invites a user by email to a document, or to a folder and
+// everything in it. Inviting the same address again changes their role. Addresses
+// without an account get a pending share that is claimed when they sign up.
+func ShareDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req ShareDocumentRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || address.Name != "" {
+		return e.Error(http.StatusBadRequest, "Invalid email address", err)
+	}
+	email := strings.ToLower(address.Address)
+
+	if !services.IsShareRole(req.Role) {
+		return e.Error(http.StatusBadRequest, "Role must be viewer, commenter or editor", nil)
+	}
+
+	document, err := queries.GetDocumentById(e, e.Request.PathValue("id"))
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	if document.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Only the owner can share a document", nil)
+	}
+
+	if strings.EqualFold(email, e.Auth.Email()) {
+		return e.Error(http.StatusBadRequest, "You already own this document", nil)
+	}
+
+	share := &queries.DocumentShare{
+		DocumentId: document.Id,
+		Email:      email,
+		Role:       req.Role,
+		InvitedBy:  e.Auth.Id,
+	}
+
+	// Unverified accounts claim the invitation once they verify their address, so that
+	// signing up with someone else's address does not give access
+	if user, err := e.App.FindAuthRecordByEmail("users", email); err == nil && user.Verified() {
+		share.UserId = user.Id
+	}
+
+	share, err = queries.SaveDocumentShare(e.App, share)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to share document", err)
+	}
+
+	return e.JSON(http.StatusCreated, toDocumentShareResponse(share, document.Id))
+}
+
+// ListDocumentSharesHandler lists the collaborators of a document, including those
+// invited to one of its folders
+func ListDocumentSharesHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	documentId := e.Request.PathValue("id")
+
+	document, role, err := accessibleDocument(e, documentId)
+	if err != nil {
+		return err
+	}
+
+	ancestors, err := queries.GetDocumentAncestors(e.App, documentId)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get folders", err)
+	}
+
+	ids := []string{documentId}
+	for _, ancestor := range ancestors {
+		ids = append(ids, ancestor.Id)
+	}
+
+	shares, err := queries.GetDocumentSharesByDocumentIds(e.App, ids)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get collaborators", err)
+	}
+
+	response := DocumentCollaboratorsResponse{
+		OwnerId: document.UserId,
+		Role:    role,
+		Shares:  make([]DocumentShareResponse, 0, len(shares)),
+	}
+
+	if owner, err := e.App.FindRecordById("users", document.UserId); err == nil {
+		response.OwnerEmail = owner.Email()
+	}
+
+	for _, share := range shares {
+		response.Shares = append(response.Shares, toDocumentShareResponse(share, documentId))
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// RevokeDocumentShareHandler removes a share. The owner can revoke any share of the
+// document and collaborators can remove themselves.
+func RevokeDocumentShareHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	share, err := queries.GetDocumentShareById(e.App, e.Request.PathValue("shareId"))
+	if err != nil || share.DocumentId != e.Request.PathValue("id") {
+		return e.Error(http.StatusNotFound, "Share not found", err)
+	}
+
+	document, err := queries.GetDocumentById(e, share.DocumentId)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	if document.UserId != e.Auth.Id && share.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	if err := queries.DeleteDocumentShare(e.App, share.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to revoke access", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ListSharedDocumentsHandler lists the documents and folders other users shared with
+// the current user
+func ListSharedDocumentsHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	documents, err := queries.GetSharedDocumentsByUserId(e.App, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get shared documents", err)
+	}
+
+	response := make([]SharedDocumentResponse, 0, len(documents))
+	for _, document := range documents {
+		role, err := services.DocumentRole(e.App, document.Id, e.Auth.Id)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+		}
+
+		response = append(response, SharedDocumentResponse{
+			DocumentResponse: toDocumentResponse(document),
+			OwnerId:          document.UserId,
+			Role:             role,
+		})
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// accessibleDocument loads a document the current user can at least view, with their role
+func accessibleDocument(e *core.RequestEvent, documentId string) (*queries.Document, string, error) {
+	document, err := queries.GetDocumentById(e, documentId)
+	if err != nil {
+		return nil, "", e.Error(http.StatusNotFound, "Document not found", err)
+	}
+
+	role, err := services.DocumentRole(e.App, documentId, e.Auth.Id)
+	if err != nil {
+		return nil, "", e.Error(http.StatusInternalServerError, "Failed to get access", err)
+	}
+
+	if role == "" {
+		return nil, "", e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	return document, role, nil
+}
+
+func toDocumentShareResponse(share *queries.DocumentShare, documentId string) DocumentShareResponse {
+	return DocumentShareResponse{
+		Id:         share.Id,
+		DocumentId: share.DocumentId,
+		UserId:     share.UserId,
+		Email:      share.Email,
+		Role:       share.Role,
+		Pending:    share.UserId == "",
+		Inherited:  share.DocumentId != documentId,
+		Created:    share.Created,
+	}
+}
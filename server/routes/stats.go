@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"textly/queries"
+	"textly/services"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	defaultActivityDays = 30
+	maxActivityDays     = 366
+)
+
+type DocumentStatsResponse struct {
+	DocumentId string `json:"document_id"`
+	services.DocumentStats
+}
+
+type WritingActivityResponse struct {
+	From         string                `json:"from"`
+	To           string                `json:"to"`
+	Days         []services.WritingDay `json:"days"`
+	WordsAdded   int                   `json:"words_added"`
+	WordsRemoved int                   `json:"words_removed"`
+	Net          int                   `json:"net"`
+	ActiveDays   int                   `json:"active_days"`
+	Streak       int                   `json:"streak"`
+}
+
+// GetDocumentStatsHandler computes the counts, reading time, readability scores and
+// outline of a document the user may view
+func GetDocumentStatsHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, _, err := accessibleDocument(e, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if document.IsFolder {
+		return e.Error(http.StatusBadRequest, "Folders have no stats", nil)
+	}
+
+	return e.JSON(http.StatusOK, DocumentStatsResponse{
+		DocumentId:    document.Id,
+		DocumentStats: services.ComputeDocumentStats(document.Content),
+	})
+}
+
+// GetWritingActivityHandler lists the words the user added and removed per day over the
+// last days, 30 by default, up to today in UTC
+func GetWritingActivityHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	days := defaultActivityDays
+	if value := e.Request.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxActivityDays {
+			return e.Error(http.StatusBadRequest, "Days must be between 1 and "+strconv.Itoa(maxActivityDays), err)
+		}
+		days = parsed
+	}
+
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, 1-days)
+
+	activity, err := queries.GetWritingActivity(e.App, e.Auth.Id, from.Format("2006-01-02"))
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get writing activity", err)
+	}
+
+	response := WritingActivityResponse{
+		From: from.Format("2006-01-02"),
+		To:   to.Format("2006-01-02"),
+		Days: services.WritingTimeline(activity, from, to),
+	}
+	for _, day := range response.Days {
+		response.WordsAdded += day.WordsAdded
+		response.WordsRemoved += day.WordsRemoved
+		if day.WordsAdded > 0 || day.WordsRemoved > 0 {
+			response.ActiveDays++
+		}
+	}
+	response.Net = response.WordsAdded - response.WordsRemoved
+	response.Streak = services.WritingStreak(response.Days)
+
+	return e.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,296 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"textly/queries"
+	"textly/routes/middleware"
+	"unicode/utf8"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const maxTagNameLength = 50
+
+var tagColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+type CreateConversationTagRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+}
+
+type TagConversationRequest struct {
+	TagId string `json:"tag_id,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+type MoveConversationRequest struct {
+	FolderId string `json:"folder_id"`
+}
+
+// RegisterConversationTagRoutes registers the tag management routes. They live outside of
+// /conversations because /conversations/tags/{tagId} would overlap /conversations/{id}/...
+func RegisterConversationTagRoutes(s *core.ServeEvent) *router.RouterGroup[*core.RequestEvent] {
+	tagGroup := s.Router.Group("/conversation-tags")
+
+	// Add OPTIONS handlers for CORS preflight (without auth middleware)
+	tagGroup.OPTIONS("/{tagId}", conversationOptionsHandler)
+	tagGroup.OPTIONS("/", conversationOptionsHandler)
+
+	// Add auth middleware for actual endpoints
+	tagGroup.Bind(middleware.AuthMiddleware())
+	tagGroup.GET("/", ListConversationTagsHandler)
+	tagGroup.POST("/", CreateConversationTagHandler)
+	tagGroup.DELETE("/{tagId}", DeleteConversationTagHandler)
+
+	return tagGroup
+}
+
+// ListConversationTagsHandler returns the tags of the user with their usage counts
+func ListConversationTagsHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	tags, err := queries.GetConversationTagsByUserId(e, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get tags", err)
+	}
+
+	if tags == nil {
+		tags = make([]*queries.ConversationTagWithCount, 0)
+	}
+
+	return e.JSON(http.StatusOK, tags)
+}
+
+// CreateConversationTagHandler creates a tag. Names are unique per user, ignoring case.
+func CreateConversationTagHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req CreateConversationTagRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	name, err := validTagName(req.Name)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, err.Error(), err)
+	}
+
+	if req.Color != "" && !tagColorPattern.MatchString(req.Color) {
+		return e.Error(http.StatusBadRequest, "Color must be a hex color such as #3b82f6", nil)
+	}
+
+	if _, err := queries.GetConversationTagByName(e, e.Auth.Id, name); err == nil {
+		return e.Error(http.StatusConflict, "A tag with this name already exists", nil)
+	}
+
+	tag, err := queries.CreateConversationTag(e, &queries.ConversationTag{
+		UserId: e.Auth.Id,
+		Name:   name,
+		Color:  req.Color,
+	})
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to create tag", err)
+	}
+
+	return e.JSON(http.StatusCreated, tag)
+}
+
+// DeleteConversationTagHandler deletes a tag and removes it from every conversation
+func DeleteConversationTagHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	tag, err := queries.GetConversationTagById(e, e.Request.PathValue("tagId"))
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Tag not found", err)
+	}
+
+	if tag.UserId != e.Auth.Id {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	if err := queries.DeleteConversationTag(e, tag.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to delete tag", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// TagConversationHandler adds a tag to a conversation. The tag is given by id, or by name
+// in which case it is created when the user has no tag with that name yet.
+func TagConversationHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req TagConversationRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	conversation, err := ownedConversation(e)
+	if err != nil {
+		return err
+	}
+
+	var tag *queries.ConversationTag
+	if req.TagId != "" {
+		tag, err = queries.GetConversationTagById(e, req.TagId)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Tag not found", err)
+		}
+
+		if tag.UserId != e.Auth.Id {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+	} else {
+		name, err := validTagName(req.Name)
+		if err != nil {
+			return e.Error(http.StatusBadRequest, err.Error(), err)
+		}
+
+		tag, err = queries.GetConversationTagByName(e, e.Auth.Id, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			tag, err = queries.CreateConversationTag(e, &queries.ConversationTag{UserId: e.Auth.Id, Name: name})
+		}
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get tag", err)
+		}
+	}
+
+	tags := conversation.Tags
+	if !slices.Contains(tags, tag.Id) {
+		tags = append(tags, tag.Id)
+		if err := updateConversationTags(e, conversation.Id, tags); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to tag conversation", err)
+		}
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tag":     tag,
+		"tags":    tags,
+	})
+}
+
+// UntagConversationHandler removes a tag from a conversation
+func UntagConversationHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req TagConversationRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	if req.TagId == "" {
+		return e.Error(http.StatusBadRequest, "Tag id is required", nil)
+	}
+
+	conversation, err := ownedConversation(e)
+	if err != nil {
+		return err
+	}
+
+	tags := slices.DeleteFunc(slices.Clone(conversation.Tags), func(id string) bool {
+		return id == req.TagId
+	})
+
+	if len(tags) != len(conversation.Tags) {
+		if err := updateConversationTags(e, conversation.Id, tags); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to untag conversation", err)
+		}
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// MoveConversationHandler files a conversation under one of the user's document folders,
+// so it is listed next to the documents it relates to. An empty folder id removes it from
+// its folder.
+func MoveConversationHandler(e *core.RequestEvent) error {
+	setConversationCORSHeaders(e)
+
+	var req MoveConversationRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	conversation, err := ownedConversation(e)
+	if err != nil {
+		return err
+	}
+
+	if req.FolderId != "" {
+		folder, err := queries.GetDocumentById(e, req.FolderId)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Folder not found", err)
+		}
+
+		if folder.UserId != e.Auth.Id {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+
+		if !folder.IsFolder {
+			return e.Error(http.StatusBadRequest, "Conversations can only be moved into folders", nil)
+		}
+	}
+
+	if _, err := queries.UpdateConversation(e, map[string]interface{}{"folder": req.FolderId}, dbx.HashExp{"id": conversation.Id}); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to move conversation", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"folder":  req.FolderId,
+	})
+}
+
+func updateConversationTags(e *core.RequestEvent, conversationId string, tags []string) error {
+	_, err := queries.UpdateConversation(e, map[string]interface{}{"tags": types.JSONArray[string](tags)}, dbx.HashExp{"id": conversationId})
+	return err
+}
+
+func validTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Tag name is required")
+	}
+
+	if utf8.RuneCountInString(name) > maxTagNameLength {
+		return "", errors.New("Tag name is too long")
+	}
+
+	return name, nil
+}
@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"textly/queries"
+	"textly/services"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// CreateFromTemplateRequest creates a document, or a folder structure, from a template in
+// the given folder or at the top level. Values fill the placeholders of the template.
+type CreateFromTemplateRequest struct {
+	Template string            `json:"template"`
+	Parent   string            `json:"parent"`
+	Title    string            `json:"title"`
+	Values   map[string]string `json:"values"`
+}
+
+type CreateFromTemplateResponse struct {
+	Success   bool               `json:"success"`
+	Documents []DocumentResponse `json:"documents"`
+}
+
+// CreateFromTemplateHandler creates the documents of a template with its placeholders
+// filled. Placeholders without a value use their default, or are written by the assistant
+// when they have a prompt. The documents are created in one transaction, the root first.
+func CreateFromTemplateHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	var req CreateFromTemplateRequest
+	bodyBytes, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		return e.Error(http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	userId := e.Auth.Id
+
+	template, err := queries.GetDocumentTemplateById(e, req.Template)
+	if err != nil {
+		return e.Error(http.StatusNotFound, "Template not found", err)
+	}
+
+	if template.UserId != "" && template.UserId != userId {
+		return e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	if req.Parent != "" {
+		parent, err := queries.GetDocumentById(e, req.Parent)
+		if err != nil {
+			return e.Error(http.StatusNotFound, "Parent folder not found", err)
+		}
+
+		role, err := services.DocumentRole(e.App, parent.Id, userId)
+		if err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to get access", err)
+		}
+
+		if !services.RoleAllows(role, services.RoleEditor) {
+			return e.Error(http.StatusForbidden, "Access denied", nil)
+		}
+
+		if !parent.IsFolder {
+			return e.Error(http.StatusBadRequest, "Parent must be a folder", nil)
+		}
+	}
+
+	root, placeholders, err := services.ParseDocumentTemplate(template)
+	if err != nil {
+		return e.Error(http.StatusUnprocessableEntity, "Invalid template", err)
+	}
+
+	userName := e.Auth.GetString("name")
+	if userName == "" {
+		userName = e.Auth.Email()
+	}
+
+	values, generated, missing := services.TemplateValues(placeholders, req.Values, services.TemplateBuiltinValues(time.Now().UTC(), userName))
+	if len(missing) > 0 {
+		return e.Error(http.StatusBadRequest, "Missing values for "+strings.Join(missing, ", "), nil)
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if len(generated) > 0 {
+		context := title
+		if context == "" {
+			context = services.FillTemplate(template.Title, values)
+		}
+
+		if err := services.GenerateTemplateValues(e, generated, values, context, userId); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to fill placeholders with AI", err)
+		}
+	}
+
+	if title == "" {
+		title = services.FillTemplate(template.Title, values)
+	}
+	if _, ok := values["title"]; !ok {
+		values["title"] = title
+	}
+
+	items := services.PlanTemplateDocuments(root, values, title)
+
+	response := CreateFromTemplateResponse{Documents: []DocumentResponse{}}
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		// The document queries only use the app of the event, so bind them to the transaction
+		txEvent := &core.RequestEvent{App: txApp}
+
+		for _, item := range items {
+			parent := item.Parent
+			if parent == "" {
+				parent = req.Parent
+			}
+
+			document, err := queries.CreateDocument(txEvent, &queries.Document{
+				Id:       item.Id,
+				UserId:   userId,
+				Title:    item.Title,
+				Content:  item.Content,
+				Parent:   parent,
+				IsFolder: item.IsFolder,
+			})
+			if err != nil {
+				return err
+			}
+
+			response.Documents = append(response.Documents, toDocumentResponse(document))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to create documents", err)
+	}
+
+	response.Success = true
+	return e.JSON(http.StatusCreated, response)
+}
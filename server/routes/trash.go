@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"net/http"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// TrashedDocumentResponse is a document or folder in the trash, with the date it will be
+// deleted permanently, empty when the trash is kept forever
+type TrashedDocumentResponse struct {
+	DocumentResponse
+	DeletedAt  string `json:"deleted_at"`
+	PurgeAfter string `json:"purge_after"`
+}
+
+// ListTrashHandler lists the documents and folders the user moved to the trash. Deleting a
+// document through the records API moves it there with everything below it.
+func ListTrashHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	documents, err := queries.GetTrashedDocumentsByUserId(e.App, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get trash", err)
+	}
+
+	response := make([]TrashedDocumentResponse, 0, len(documents))
+	for _, document := range documents {
+		response = append(response, TrashedDocumentResponse{
+			DocumentResponse: toDocumentResponse(document),
+			DeletedAt:        document.DeletedAt,
+			PurgeAfter:       services.DocumentPurgeDate(document.DeletedAt),
+		})
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
+
+// RestoreDocumentHandler takes a document out of the trash, a folder with its contents
+func RestoreDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, err := trashedDocument(e)
+	if err != nil {
+		return err
+	}
+
+	if err := services.RestoreDocument(e.App, document.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to restore document", err)
+	}
+
+	document, err = queries.GetDocumentById(e, document.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get document", err)
+	}
+
+	return e.JSON(http.StatusOK, toDocumentResponse(document))
+}
+
+// PurgeDocumentHandler permanently deletes a document in the trash with everything below it
+func PurgeDocumentHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	document, err := trashedDocument(e)
+	if err != nil {
+		return err
+	}
+
+	if err := queries.DeleteDocumentPermanently(e.App, document.Id); err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to delete document", err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Document deleted permanently",
+	})
+}
+
+// EmptyTrashHandler permanently deletes everything the user moved to the trash
+func EmptyTrashHandler(e *core.RequestEvent) error {
+	setDocumentCORSHeaders(e)
+
+	documents, err := queries.GetTrashedDocumentsByUserId(e.App, e.Auth.Id)
+	if err != nil {
+		return e.Error(http.StatusInternalServerError, "Failed to get trash", err)
+	}
+
+	// Oldest first, so a document trashed on its own goes before the folder it was in
+	deleted := 0
+	for i := len(documents) - 1; i >= 0; i-- {
+		if err := queries.DeleteDocumentPermanently(e.App, documents[i].Id); err != nil {
+			return e.Error(http.StatusInternalServerError, "Failed to empty trash", err)
+		}
+		deleted++
+	}
+
+	return e.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"deleted": deleted,
+	})
+}
+
+// trashedDocument loads the document in the trash from the path, which only its owner
+// may restore or delete
+func trashedDocument(e *core.RequestEvent) (*queries.Document, error) {
+	document, err := queries.GetTrashedDocumentById(e.App, e.Request.PathValue("id"))
+	if err != nil {
+		return nil, e.Error(http.StatusNotFound, "Document not found in trash", err)
+	}
+
+	if document.UserId != e.Auth.Id {
+		return nil, e.Error(http.StatusForbidden, "Access denied", nil)
+	}
+
+	return document, nil
+}
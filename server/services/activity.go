@@ -0,0 +1,100 @@
+package services
+
+import (
+	"textly/queries"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// WritingDay is the writing activity of a user on a day, in UTC
+type WritingDay struct {
+	Date         string `json:"date"`
+	WordsAdded   int    `json:"words_added"`
+	WordsRemoved int    `json:"words_removed"`
+	Net          int    `json:"net"`
+	Documents    int    `json:"documents"`
+}
+
+// RecordDocumentWriting records the words the user added and removed by changing the
+// content of the document. Only edits made by a user count, not the content the server
+// writes, such as renamed links, chat answers saved into documents, imports and templates.
+func RecordDocumentWriting(app core.App, userId, documentId, before, after string) error {
+	added, removed := WordChanges(before, after)
+	return recordWriting(app, userId, documentId, added, removed)
+}
+
+// RecordCollabWriting records the words changed between two snapshots of a collaboration
+// session. Operations do not follow word boundaries, so the words are shared among the
+// users in proportion to the characters each of them changed, rounded. The share of
+// saves merged from outside the session, under an empty user, is left out: they are
+// recorded when they are made.
+func RecordCollabWriting(app core.App, documentId, before, after string, edits map[string]int) error {
+	added, removed := WordChanges(before, after)
+	if added == 0 && removed == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, changed := range edits {
+		total += changed
+	}
+
+	for userId, changed := range edits {
+		if userId == "" || changed == 0 {
+			continue
+		}
+
+		if err := recordWriting(app, userId, documentId, (added*changed+total/2)/total, (removed*changed+total/2)/total); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func recordWriting(app core.App, userId, documentId string, added, removed int) error {
+	if added == 0 && removed == 0 {
+		return nil
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	return queries.RecordWritingActivity(app, userId, documentId, day, added, removed)
+}
+
+// WritingTimeline lists every day from the first to the last one with its activity,
+// including the days without any
+func WritingTimeline(activity []*queries.WritingActivityDay, from, to time.Time) []WritingDay {
+	byDay := make(map[string]*queries.WritingActivityDay, len(activity))
+	for _, day := range activity {
+		byDay[day.Day] = day
+	}
+
+	timeline := make([]WritingDay, 0, int(to.Sub(from).Hours()/24)+1)
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		day := WritingDay{Date: date.Format("2006-01-02")}
+		if activity, ok := byDay[day.Date]; ok {
+			day.WordsAdded = activity.WordsAdded
+			day.WordsRemoved = activity.WordsRemoved
+			day.Net = activity.WordsAdded - activity.WordsRemoved
+			day.Documents = activity.Documents
+		}
+		timeline = append(timeline, day)
+	}
+
+	return timeline
+}
+
+// WritingStreak counts the days in a row the user added words, up to the last day of the
+// timeline. A last day without words yet does not break the streak.
+func WritingStreak(timeline []WritingDay) int {
+	streak := 0
+	for i := len(timeline) - 1; i >= 0; i-- {
+		if timeline[i].WordsAdded > 0 {
+			streak++
+		} else if i < len(timeline)-1 {
+			break
+		}
+	}
+	return streak
+}
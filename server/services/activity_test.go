@@ -0,0 +1,84 @@
+//go:build !goexperiment.jsonv2
+
+package services_test
+
+import (
+	"testing"
+	"textly/queries"
+	"textly/services"
+	"time"
+)
+
+func TestCollabSnapshotsCreditTheirAuthors(t *testing.T) {
+	app := newTestApp(t)
+
+	owner := createTestUser(t, app, "owner@example.com", true)
+	editor := createTestUser(t, app, "editor@example.com", true)
+	document := createTestDocument(t, app, owner.Id, "Draft", "Start", "", false)
+
+	hub := services.NewCollabHub(app)
+	session, ownerClient, err := hub.Join(document.Id, owner.Id, "Owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, editorClient, err := hub.Join(document.Id, editor.Id, "Editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(clientId, text string) {
+		t.Helper()
+		content, revision := session.Content()
+		if _, err := session.Submit(clientId, revision, services.DiffOperation(content, content+text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wordsOf := func(userId string) (int, int) {
+		t.Helper()
+		days, err := queries.GetWritingActivity(app, userId, time.Now().UTC().Format("2006-01-02"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		added, removed := 0, 0
+		for _, day := range days {
+			added += day.WordsAdded
+			removed += day.WordsRemoved
+		}
+		return added, removed
+	}
+
+	write(ownerClient.Id, " alpha beta")
+	write(editorClient.Id, " gamma delta")
+	// A save made outside of the session is recorded by the request that made it
+	hub.DocumentChanged(document.Id, "Start alpha beta gamma delta from outside")
+
+	hub.SnapshotAll()
+
+	if content := reloadTestRecord(t, app, "documents", document.Id).GetString("content"); content != "Start alpha beta gamma delta from outside" {
+		t.Fatalf("unexpected snapshot %q", content)
+	}
+	if added, removed := wordsOf(owner.Id); added != 2 || removed != 0 {
+		t.Fatalf("expected the owner to be credited with their 2 words, got %d added and %d removed", added, removed)
+	}
+	if added, removed := wordsOf(editor.Id); added != 2 || removed != 0 {
+		t.Fatalf("expected the editor to be credited with their 2 words, got %d added and %d removed", added, removed)
+	}
+
+	// A snapshot without changes records nothing more
+	hub.SnapshotAll()
+	if added, _ := wordsOf(owner.Id); added != 2 {
+		t.Fatalf("expected no writing without changes, got %d words", added)
+	}
+
+	// Direct saves are credited to the user who made them, not to the owner
+	if err := services.RecordDocumentWriting(app, editor.Id, document.Id, "One two", "One two three"); err != nil {
+		t.Fatal(err)
+	}
+	if added, _ := wordsOf(editor.Id); added != 3 {
+		t.Fatalf("expected the edit to count for the editor, got %d words", added)
+	}
+	if added, _ := wordsOf(owner.Id); added != 2 {
+		t.Fatalf("expected the edit not to count for the owner, got %d words", added)
+	}
+}
@@ -11,6 +11,8 @@ func InitializeOpenAI(baseURL, apiKey string) {
 	openAiClient = openai.NewClient(
 		option.WithBaseURL(baseURL),
 		option.WithAPIKey(apiKey),
+		// Retries are handled by RetryPolicy so that failures can fall back to other models
+		option.WithMaxRetries(0),
 	)
 }
 
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+)
+
+const (
+	MaxAttachments        = 5
+	MaxAttachmentSize     = 10 << 20
+	maxAttachmentTextSize = 100_000
+)
+
+var imageMimeTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// Attachment is a file sent along with a user message
+type Attachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+// NewAttachment detects the type of the file and checks it against the attachment limits
+func NewAttachment(name string, data []byte) (Attachment, error) {
+	if len(data) > MaxAttachmentSize {
+		return Attachment{}, fmt.Errorf("%s is larger than %d MB", name, MaxAttachmentSize>>20)
+	}
+
+	mimeType := detectAttachmentType(name, data)
+	if mimeType == "" {
+		return Attachment{}, fmt.Errorf("%s is not a supported attachment type", name)
+	}
+
+	return Attachment{Name: name, MimeType: mimeType, Data: data}, nil
+}
+
+func detectAttachmentType(name string, data []byte) string {
+	detected := strings.Split(http.DetectContentType(data), ";")[0]
+
+	if slices.Contains(imageMimeTypes, detected) || detected == "application/pdf" {
+		return detected
+	}
+
+	if detected == "text/plain" || (utf8.Valid(data) && !strings.ContainsRune(string(data), 0)) {
+		if strings.HasSuffix(strings.ToLower(name), ".md") {
+			return "text/markdown"
+		}
+		return "text/plain"
+	}
+
+	return ""
+}
+
+func (a Attachment) IsImage() bool {
+	return slices.Contains(imageMimeTypes, a.MimeType)
+}
+
+// Text returns the textual content of the attachment, extracting it from PDFs
+func (a Attachment) Text() string {
+	var text string
+	switch {
+	case a.MimeType == "application/pdf":
+		text = ExtractPDFText(a.Data)
+	case strings.HasPrefix(a.MimeType, "text/"):
+		text = string(a.Data)
+	}
+
+	if len(text) > maxAttachmentTextSize {
+		text = text[:maxAttachmentTextSize] + "\n[truncated]"
+	}
+	return text
+}
+
+// contentParts converts a user message with attachments into provider content parts.
+// Vision models receive images and PDFs as files, other models get the extracted text.
+func contentParts(message Message, vision bool) []openai.ChatCompletionContentPartUnionParam {
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextContentPart(message.Content)}
+
+	for _, attachment := range message.Attachments {
+		dataURL := "data:" + attachment.MimeType + ";base64," + base64.StdEncoding.EncodeToString(attachment.Data)
+
+		switch {
+		case vision && attachment.IsImage():
+			parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: dataURL}))
+		case vision && attachment.MimeType == "application/pdf":
+			parts = append(parts, openai.FileContentPart(openai.ChatCompletionContentPartFileFileParam{
+				FileData: param.NewOpt(dataURL),
+				Filename: param.NewOpt(attachment.Name),
+			}))
+		case attachment.IsImage():
+			parts = append(parts, openai.TextContentPart(fmt.Sprintf("[Attached image %s cannot be viewed by this model]", attachment.Name)))
+		default:
+			parts = append(parts, openai.TextContentPart(fmt.Sprintf("Attached file %s:\n%s", attachment.Name, attachment.Text())))
+		}
+	}
+
+	return parts
+}
@@ -0,0 +1,55 @@
+package services_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+func TestNewAttachmentDetectsType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	cases := []struct {
+		name     string
+		data     []byte
+		wantType string
+		wantErr  bool
+	}{
+		{"photo.png", png, "image/png", false},
+		{"notes.md", []byte("# Notes\n\nSome text"), "text/markdown", false},
+		{"notes.txt", []byte("plain text"), "text/plain", false},
+		{"paper.pdf", []byte("%PDF-1.4\n"), "application/pdf", false},
+		{"binary.bin", []byte{0x00, 0x01, 0x02, 0xff}, "", true},
+		{"large.txt", bytes.Repeat([]byte("a"), services.MaxAttachmentSize+1), "", true},
+	}
+
+	for _, c := range cases {
+		attachment, err := services.NewAttachment(c.name, c.data)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: error = %v, want error %v", c.name, err, c.wantErr)
+		}
+		if err == nil && attachment.MimeType != c.wantType {
+			t.Fatalf("%s: type = %s, want %s", c.name, attachment.MimeType, c.wantType)
+		}
+	}
+}
+
+func TestExtractPDFText(t *testing.T) {
+	content := "BT /F1 12 Tf 72 712 Td (Hello) Tj [(wor) -20 (ld)] TJ 0 -14 Td <4f6e65> Tj (\\(two\\)) ' ET"
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write([]byte(content))
+	writer.Close()
+
+	pdf := "%PDF-1.4\n1 0 obj\n<< /Length 10 /Filter /FlateDecode >>\nstream\n" + compressed.String() + "\nendstream\nendobj\n%%EOF"
+
+	text := services.ExtractPDFText([]byte(pdf))
+	for _, want := range []string{"Helloworld", "One", "(two)"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("extracted text %q does not contain %q", text, want)
+		}
+	}
+}
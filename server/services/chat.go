@@ -14,7 +14,6 @@ import (
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/packages/param"
 	"github.com/openai/openai-go/packages/ssestream"
-	"github.com/openai/openai-go/shared"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -36,11 +35,15 @@ const (
 	MessageRoleUser      MessageRole = "user"
 	MessageRoleAssistant MessageRole = "assistant"
 	MessageRoleSystem    MessageRole = "system"
+	MessageRoleTool      MessageRole = "tool"
 )
 
 type Message struct {
-	Role    MessageRole `json:"role"`
-	Content string      `json:"content"`
+	Role        MessageRole  `json:"role"`
+	Content     string       `json:"content"`
+	Attachments []Attachment `json:"-"`
+	ToolCalls   []ToolCall   `json:"-"`
+	ToolCallId  string       `json:"-"`
 }
 
 type TextAssistRequest struct {
@@ -53,26 +56,158 @@ type TextAssistResponse struct {
 	Suggestion string `json:"suggestion"`
 }
 
-func Chat(messages []Message, model string, useReasoning bool, ctx context.Context) *ssestream.Stream[openai.ChatCompletionChunk] {
+// ChatStream is a provider stream that has been opened successfully, possibly after
+// retries or falling back to another model. Model is the identifier actually used.
+// With a toolbox the stream runs the tool calls requested by the model and continues
+// with its follow-up response, so callers only see the content chunks.
+type ChatStream struct {
+	Model    string
+	stream   *ssestream.Stream[openai.ChatCompletionChunk]
+	buffered bool
+
+	toolbox   *Toolbox
+	messages  []Message
+	reopen    func(messages []Message) (*ChatStream, error)
+	toolCalls toolCallAccumulator
+	content   strings.Builder
+	rounds    int
+	usage     *openai.CompletionUsage
+	usages    []*openai.CompletionUsage
+	err       error
+}
+
+func (s *ChatStream) Next() bool {
+	for {
+		// The first chunk of each response was already read while probing the provider,
+		// replay it once. Follow-up responses after tool calls are probed the same way.
+		if s.buffered {
+			s.buffered = false
+			s.observe(s.stream.Current())
+			return true
+		}
+
+		if s.stream.Next() {
+			s.observe(s.stream.Current())
+			return true
+		}
+
+		if s.stream.Err() != nil || !s.continueWithTools() {
+			return false
+		}
+	}
+}
+
+func (s *ChatStream) Current() openai.ChatCompletionChunk {
+	return s.stream.Current()
+}
+
+func (s *ChatStream) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.stream.Err()
+}
+
+func (s *ChatStream) Close() error {
+	return s.stream.Close()
+}
+
+// Totals sums the usage of every response streamed so far, tool calling rounds included
+func (s *ChatStream) Totals() (inputTokens, outputTokens, reasoningTokens int64, cost float64) {
+	for _, usage := range append(s.usages, s.usage) {
+		input, output, reasoning, usageCost := UsageTotals(usage)
+		inputTokens += input
+		outputTokens += output
+		reasoningTokens += reasoning
+		cost += usageCost
+	}
+	return inputTokens, outputTokens, reasoningTokens, cost
+}
+
+func (s *ChatStream) observe(chunk openai.ChatCompletionChunk) {
+	if chunk.JSON.Usage.Valid() {
+		s.usage = &chunk.Usage
+	}
+
+	if s.toolbox == nil {
+		return
+	}
+
+	s.toolCalls.add(chunk)
+	if len(chunk.Choices) > 0 {
+		s.content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+}
+
+// continueWithTools runs the calls requested by the finished response and opens the
+// follow-up response. It reports false when there is nothing left to stream.
+func (s *ChatStream) continueWithTools() bool {
+	if s.toolbox == nil || len(s.toolCalls.calls) == 0 {
+		return false
+	}
+
+	s.rounds++
+	if s.rounds > maxToolRounds {
+		s.err = fmt.Errorf("model kept calling tools after %d rounds", maxToolRounds)
+		return false
+	}
+
+	request := Message{Role: MessageRoleAssistant, Content: s.content.String()}
+	var results []Message
+	for _, pending := range s.toolCalls.calls {
+		call := s.toolbox.Run(*pending)
+		request.ToolCalls = append(request.ToolCalls, call)
+		results = append(results, Message{Role: MessageRoleTool, Content: call.toolMessageContent(), ToolCallId: call.Id})
+	}
+	s.messages = append(append(s.messages, request), results...)
+
+	s.toolCalls.reset()
+	s.content.Reset()
+	s.usages = append(s.usages, s.usage)
+	s.usage = nil
+
+	next, err := s.reopen(s.messages)
+	if err != nil {
+		s.err = err
+		return false
+	}
+
+	s.stream.Close()
+	s.stream = next.stream
+	s.buffered = next.buffered
+	return true
+}
+
+// ResolveModel returns the model to use, defaulting to OPENAI_BASE_MODEL
+func ResolveModel(model string) string {
+	if model == "" {
+		return os.Getenv("OPENAI_BASE_MODEL")
+	}
+	return model
+}
+
+func Chat(messages []Message, model string, useReasoning bool, generation GenerationParams, vision bool, toolbox *Toolbox, ctx context.Context) *ssestream.Stream[openai.ChatCompletionChunk] {
 	var client = GetOpenAiClient()
 
-	selectedModel := model
-	if selectedModel == "" {
-		selectedModel = os.Getenv("OPENAI_BASE_MODEL")
+	selectedModel := ResolveModel(model)
+
+	systemRules := rules
+	if toolbox != nil {
+		systemRules = append(append([]string(nil), rules...), toolRules...)
 	}
 
 	params := openai.ChatCompletionNewParams{
-		Messages:    convertToChatMessage(messages, rules),
-		Temperature: param.NewOpt(0.7),
+		Messages: convertToChatMessage(messages, systemRules, vision),
 		StreamOptions: openai.ChatCompletionStreamOptionsParam{
 			IncludeUsage: param.NewOpt(true),
 		},
-		Model:     selectedModel,
-		MaxTokens: param.NewOpt(int64(8000)),
+		Model: selectedModel,
 	}
 
-	if useReasoning {
-		params.ReasoningEffort = shared.ReasoningEffortMedium
+	generation.apply(&params, useReasoning)
+
+	if toolbox != nil {
+		params.Tools = toolbox.params()
 	}
 
 	params.SetExtraFields(map[string]any{
@@ -82,15 +217,140 @@ func Chat(messages []Message, model string, useReasoning bool, ctx context.Conte
 	return client.Chat.Completions.NewStreaming(ctx, params)
 }
 
-func convertToChatMessage(messages []Message, systemRules []string) []openai.ChatCompletionMessageParamUnion {
+// ChatWithFallback streams a chat completion, retrying each model according to the policy
+// and moving down the models list until one of them produces its first chunk. Attachments
+// are sent as files to models with the vision capability and as text to the others.
+// A nil toolbox disables tool calling.
+func ChatWithFallback(e *core.RequestEvent, messages []Message, models []string, useReasoning bool, generation GenerationParams, policy RetryPolicy, toolbox *Toolbox) (*ChatStream, error) {
+	ctx := e.Request.Context()
+	open := func(history []Message) func(model string) *ssestream.Stream[openai.ChatCompletionChunk] {
+		return func(model string) *ssestream.Stream[openai.ChatCompletionChunk] {
+			vision := queries.ModelHasCapability(e, model, "vision")
+			return Chat(history, model, useReasoning, generation, vision, toolbox, ctx)
+		}
+	}
+
+	stream, err := StreamWithFallback(ctx, models, policy, open(messages))
+	if err != nil || toolbox == nil {
+		return stream, err
+	}
+
+	// Follow-up responses stay on the model that started answering
+	stream.toolbox = toolbox
+	stream.messages = append([]Message(nil), messages...)
+	stream.reopen = func(history []Message) (*ChatStream, error) {
+		return StreamWithFallback(ctx, []string{stream.Model}, policy, open(history))
+	}
+
+	return stream, nil
+}
+
+// StreamWithFallback opens a stream for each model in turn until one produces its first
+// chunk, or ends without error. Rate limits, server errors and timeouts are retried on the
+// same model according to the policy. Other errors, such as a 400 for a request the model
+// cannot take or a 404 for an unknown model, are not retried but still fall back to the
+// next model, since they are usually specific to the model. Once a chunk arrived the
+// stream is returned as is: a later failure is not retried, as part of the answer may
+// already have been sent. A cancelled context stops without trying the other models.
+func StreamWithFallback(ctx context.Context, models []string, policy RetryPolicy, open func(model string) *ssestream.Stream[openai.ChatCompletionChunk]) (*ChatStream, error) {
+	if len(models) == 0 {
+		models = []string{""}
+	}
+
+	var lastErr error
+	for _, model := range models {
+		model = ResolveModel(model)
+
+		for attempt := 0; attempt < max(policy.MaxAttempts, 1); attempt++ {
+			if attempt > 0 {
+				if err := policy.wait(ctx, attempt); err != nil {
+					return nil, err
+				}
+			}
+
+			stream := open(model)
+
+			// Nothing has been sent to the client yet, so a failure here can still be retried
+			hasChunk := stream.Next()
+			if hasChunk || stream.Err() == nil {
+				return &ChatStream{Model: model, stream: stream, buffered: hasChunk}, nil
+			}
+
+			lastErr = stream.Err()
+			stream.Close()
+
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			log.Printf("Model %s failed (attempt %d/%d): %v", model, attempt+1, policy.MaxAttempts, lastErr)
+
+			if !IsRetryableError(lastErr) {
+				break
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("all models failed: %w", lastErr)
+}
+
+// ReasoningDelta extracts the reasoning text of a chunk for models that stream it
+func ReasoningDelta(chunk openai.ChatCompletionChunk) string {
+	if len(chunk.Choices) == 0 {
+		return ""
+	}
+
+	reasoningField, exists := chunk.Choices[0].Delta.JSON.ExtraFields["reasoning"]
+	if !exists {
+		return ""
+	}
+
+	reasoningContent := reasoningField.Raw()
+	if reasoningContent == "" || reasoningContent == "null" {
+		return ""
+	}
+
+	// Remove quotes if present
+	if strings.HasPrefix(reasoningContent, "\"") && strings.HasSuffix(reasoningContent, "\"") {
+		reasoningContent = reasoningContent[1 : len(reasoningContent)-1]
+	}
+	return reasoningContent
+}
+
+// UsageTotals returns the token counts and provider reported cost of a completion
+func UsageTotals(usage *openai.CompletionUsage) (inputTokens, outputTokens, reasoningTokens int64, cost float64) {
+	if usage == nil {
+		return 0, 0, 0, 0
+	}
+
+	if costField, exists := usage.JSON.ExtraFields["cost"]; exists {
+		if parsed, err := strconv.ParseFloat(costField.Raw(), 64); err == nil {
+			cost = parsed
+		}
+	}
+
+	return usage.PromptTokens, usage.CompletionTokens, usage.CompletionTokensDetails.ReasoningTokens, cost
+}
+
+func convertToChatMessage(messages []Message, systemRules []string, vision bool) []openai.ChatCompletionMessageParamUnion {
 	var openaiMessages []openai.ChatCompletionMessageParamUnion
 	openaiMessages = append(openaiMessages, openai.SystemMessage(strings.Join(systemRules, "\n")))
 	for _, message := range messages {
 		switch message.Role {
 		case MessageRoleUser:
+			if len(message.Attachments) > 0 {
+				openaiMessages = append(openaiMessages, openai.UserMessage(contentParts(message, vision)))
+				continue
+			}
 			openaiMessages = append(openaiMessages, openai.UserMessage(message.Content))
 		case MessageRoleAssistant:
+			if len(message.ToolCalls) > 0 {
+				openaiMessages = append(openaiMessages, assistantToolCallMessage(message))
+				continue
+			}
 			openaiMessages = append(openaiMessages, openai.AssistantMessage(message.Content))
+		case MessageRoleTool:
+			openaiMessages = append(openaiMessages, openai.ToolMessage(message.Content, message.ToolCallId))
 		case MessageRoleSystem:
 			openaiMessages = append(openaiMessages, openai.SystemMessage(message.Content))
 		}
@@ -98,6 +358,26 @@ func convertToChatMessage(messages []Message, systemRules []string) []openai.Cha
 	return openaiMessages
 }
 
+// assistantToolCallMessage is an assistant turn that requested tool calls
+func assistantToolCallMessage(message Message) openai.ChatCompletionMessageParamUnion {
+	var assistant openai.ChatCompletionAssistantMessageParam
+	if message.Content != "" {
+		assistant.Content.OfString = param.NewOpt(message.Content)
+	}
+
+	for _, call := range message.ToolCalls {
+		assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+			ID: call.Id,
+			Function: openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		})
+	}
+
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant}
+}
+
 func TextAssist(e *core.RequestEvent, req TextAssistRequest, userId string) (string, error) {
 	var client = GetOpenAiClient()
 
@@ -140,6 +420,18 @@ func TextAssist(e *core.RequestEvent, req TextAssistRequest, userId string) (str
 			Do not include any symbols such as quotes, citations, or symbols at the beginning or end of the text.`
 		userPrompts = append(userPrompts, openai.UserMessage("Text: "+req.Text))
 
+	case "template":
+		systemPrompt = `You are a helpful assistant that writes a part of a new document created from a template.
+			Follow the instruction and write only the requested text, formatted in markdown.
+			Do not include any other text or explanations.
+			Do not wrap the text in quotes or code fences.`
+
+		if req.Context != "" {
+			userPrompts = append(userPrompts, openai.UserMessage("Title of the new document: "+req.Context))
+		}
+
+		userPrompts = append(userPrompts, openai.UserMessage("Instruction: "+req.Text))
+
 	default:
 		return "", errors.New("invalid query type")
 	}
@@ -149,7 +441,6 @@ func TextAssist(e *core.RequestEvent, req TextAssistRequest, userId string) (str
 	// Use streaming to get usage data with cost
 	params := openai.ChatCompletionNewParams{
 		Messages:  messages,
-		Model:     os.Getenv("OPENAI_BASE_MODEL"),
 		MaxTokens: param.NewOpt(int64(4000)),
 		StreamOptions: openai.ChatCompletionStreamOptionsParam{
 			IncludeUsage: param.NewOpt(true),
@@ -160,7 +451,15 @@ func TextAssist(e *core.RequestEvent, req TextAssistRequest, userId string) (str
 		"include_reasoning": param.NewOpt(false),
 	})
 
-	stream := client.Chat.Completions.NewStreaming(context.TODO(), params)
+	models := queries.GetModelFallbackChain(e, ResolveModel(""))
+	stream, err := StreamWithFallback(e.Request.Context(), models, DefaultRetryPolicy(), func(model string) *ssestream.Stream[openai.ChatCompletionChunk] {
+		params.Model = model
+		return client.Chat.Completions.NewStreaming(e.Request.Context(), params)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
 
 	var suggestion strings.Builder
 	var usage *openai.CompletionUsage
@@ -190,23 +489,7 @@ func TextAssist(e *core.RequestEvent, req TextAssistRequest, userId string) (str
 		log.Println("Usage JSON: ", usage.JSON)
 	}
 
-	reasoningTokens := int64(0)
-	inputTokens := int64(0)
-	outputTokens := int64(0)
-	totalCost := float64(0)
-
-	if usage != nil {
-		reasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
-		inputTokens = usage.PromptTokens
-		outputTokens = usage.CompletionTokens
-
-		if costField, exists := usage.JSON.ExtraFields["cost"]; exists {
-			costStr := costField.Raw()
-			if cost, err := strconv.ParseFloat(costStr, 64); err == nil {
-				totalCost = cost
-			}
-		}
-	}
+	inputTokens, outputTokens, reasoningTokens, totalCost := UsageTotals(usage)
 
 	// Create conversation title based on request type and text
 	title := fmt.Sprintf("%s: %s", strings.Title(req.Type), req.Text)
@@ -247,7 +530,7 @@ func TextAssist(e *core.RequestEvent, req TextAssistRequest, userId string) (str
 		ConversationId:  createdConversation.Id,
 		UserMessage:     userMessage,
 		ResponseMessage: suggestionText,
-		Model:           os.Getenv("OPENAI_BASE_MODEL"),
+		Model:           stream.Model,
 		InputTokens:     strconv.FormatInt(inputTokens, 10),
 		OutputTokens:    strconv.FormatInt(outputTokens, 10),
 		Cost:            fmt.Sprintf("%.6f", totalCost),
@@ -0,0 +1,208 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"textly/services"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/ssestream"
+)
+
+// scriptedDecoder replays the events of a provider response, then ends with err
+type scriptedDecoder struct {
+	events  []ssestream.Event
+	current ssestream.Event
+	err     error
+}
+
+func (d *scriptedDecoder) Next() bool {
+	if len(d.events) == 0 {
+		return false
+	}
+	d.current, d.events = d.events[0], d.events[1:]
+	return true
+}
+
+func (d *scriptedDecoder) Event() ssestream.Event { return d.current }
+func (d *scriptedDecoder) Close() error           { return nil }
+
+func (d *scriptedDecoder) Err() error {
+	if len(d.events) > 0 {
+		return nil
+	}
+	return d.err
+}
+
+// providerResponse is what one attempt on a model returns: a status error before any
+// chunk, or the chunks of an answer followed by an optional status error
+type providerResponse struct {
+	status int
+	chunks []string
+}
+
+func providerError(status int) error {
+	return &openai.Error{
+		StatusCode: status,
+		Request:    httptest.NewRequest(http.MethodPost, "https://provider.example.com/chat/completions", nil),
+		Response:   &http.Response{StatusCode: status},
+	}
+}
+
+func (r providerResponse) stream() *ssestream.Stream[openai.ChatCompletionChunk] {
+	var err error
+	if r.status != 0 {
+		err = providerError(r.status)
+	}
+	if len(r.chunks) == 0 && err != nil {
+		return ssestream.NewStream[openai.ChatCompletionChunk](nil, err)
+	}
+
+	decoder := &scriptedDecoder{err: err}
+	for _, content := range r.chunks {
+		decoder.events = append(decoder.events, ssestream.Event{
+			Data: []byte(`{"id":"chunk","object":"chat.completion.chunk","model":"stub","choices":[{"index":0,"delta":{"content":"` + content + `"}}]}`),
+		})
+	}
+	return ssestream.NewStream[openai.ChatCompletionChunk](decoder, nil)
+}
+
+func TestStreamWithFallback(t *testing.T) {
+	answer := providerResponse{chunks: []string{"Hello", " there"}}
+	policy := services.RetryPolicy{MaxAttempts: 3, Multiplier: 2}
+
+	cases := []struct {
+		name      string
+		models    []string
+		responses map[string][]providerResponse
+		calls     []string
+		model     string
+		content   string
+		status    int
+	}{
+		{
+			name:      "first model answers",
+			models:    []string{"a", "b"},
+			responses: map[string][]providerResponse{"a": {answer}},
+			calls:     []string{"a"},
+			model:     "a",
+			content:   "Hello there",
+		},
+		{
+			name:      "rate limits and server errors are retried on the same model",
+			models:    []string{"a", "b"},
+			responses: map[string][]providerResponse{"a": {{status: 429}, {status: 500}, answer}},
+			calls:     []string{"a", "a", "a"},
+			model:     "a",
+			content:   "Hello there",
+		},
+		{
+			name:      "next model after the attempts run out",
+			models:    []string{"a", "b"},
+			responses: map[string][]providerResponse{"a": {{status: 503}, {status: 503}, {status: 503}}, "b": {{status: 429}, answer}},
+			calls:     []string{"a", "a", "a", "b", "b"},
+			model:     "b",
+			content:   "Hello there",
+		},
+		{
+			name:      "a bad request is not retried but falls back",
+			models:    []string{"a", "b"},
+			responses: map[string][]providerResponse{"a": {{status: 400}}, "b": {answer}},
+			calls:     []string{"a", "b"},
+			model:     "b",
+			content:   "Hello there",
+		},
+		{
+			name:      "every model failing reports the last error",
+			models:    []string{"a", "b"},
+			responses: map[string][]providerResponse{"a": {{status: 400}}, "b": {{status: 404}}},
+			calls:     []string{"a", "b"},
+			status:    404,
+		},
+		{
+			name:      "no switching after the first chunk",
+			models:    []string{"a", "b"},
+			responses: map[string][]providerResponse{"a": {{chunks: []string{"Hello"}, status: 500}}, "b": {answer}},
+			calls:     []string{"a"},
+			model:     "a",
+			content:   "Hello",
+			status:    500,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls []string
+			attempts := make(map[string]int)
+			open := func(model string) *ssestream.Stream[openai.ChatCompletionChunk] {
+				calls = append(calls, model)
+				responses := c.responses[model]
+				if attempts[model] >= len(responses) {
+					t.Fatalf("unexpected attempt %d on model %s", attempts[model]+1, model)
+				}
+				attempts[model]++
+				return responses[attempts[model]-1].stream()
+			}
+
+			stream, err := services.StreamWithFallback(context.Background(), c.models, policy, open)
+			if !slices.Equal(calls, c.calls) {
+				t.Fatalf("expected the attempts %v, got %v", c.calls, calls)
+			}
+
+			if c.model == "" {
+				var apiErr *openai.Error
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != c.status {
+					t.Fatalf("expected the %d of the last model, got %v", c.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+
+			if stream.Model != c.model {
+				t.Fatalf("expected the answer of model %s, got %s", c.model, stream.Model)
+			}
+
+			content := ""
+			for stream.Next() {
+				content += stream.Current().Choices[0].Delta.Content
+			}
+			if content != c.content {
+				t.Fatalf("expected %q, got %q", c.content, content)
+			}
+
+			var apiErr *openai.Error
+			if c.status == 0 && stream.Err() != nil {
+				t.Fatalf("unexpected stream error %v", stream.Err())
+			}
+			if c.status != 0 && (!errors.As(stream.Err(), &apiErr) || apiErr.StatusCode != c.status) {
+				t.Fatalf("expected the stream to end with a %d, got %v", c.status, stream.Err())
+			}
+		})
+	}
+}
+
+func TestStreamWithFallbackStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls []string
+	open := func(model string) *ssestream.Stream[openai.ChatCompletionChunk] {
+		calls = append(calls, model)
+		cancel()
+		return providerResponse{status: 503}.stream()
+	}
+
+	_, err := services.StreamWithFallback(ctx, []string{"a", "b"}, services.RetryPolicy{MaxAttempts: 3, Multiplier: 2}, open)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation, got %v", err)
+	}
+	if !slices.Equal(calls, []string{"a"}) {
+		t.Fatalf("expected no attempt after the cancellation, got %v", calls)
+	}
+}
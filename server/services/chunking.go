@@ -0,0 +1,85 @@
+package services
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultChunkSize    = 1200
+	defaultChunkOverlap = 200
+)
+
+// TextChunk is a slice of a document. Start and End are byte offsets into the content.
+type TextChunk struct {
+	Index   int
+	Start   int
+	End     int
+	Content string
+}
+
+// ChunkText splits text into chunks of at most size bytes that overlap by about overlap
+// bytes. Chunks end on a paragraph, line, sentence or word boundary when one is available
+// in the second half of the chunk, and never split a UTF-8 sequence.
+func ChunkText(text string, size, overlap int) []TextChunk {
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	if overlap < 0 || overlap >= size/2 {
+		overlap = size / 4
+	}
+
+	var chunks []TextChunk
+	for start := 0; start < len(text); {
+		end := len(text)
+		if start+size < len(text) {
+			end = chunkBoundary(text, start, start+size)
+		}
+
+		if content := text[start:end]; strings.TrimSpace(content) != "" {
+			chunks = append(chunks, TextChunk{Index: len(chunks), Start: start, End: end, Content: content})
+		}
+
+		if end == len(text) {
+			break
+		}
+
+		next := runeStart(text, end-overlap)
+		// Start the overlap on a word so the chunk does not begin mid-word
+		if space := strings.IndexAny(text[next:end], " \n\t"); space >= 0 {
+			next += space + 1
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+
+	return chunks
+}
+
+// chunkBoundary finds the best place to end a chunk that starts at start and may not
+// extend past limit
+func chunkBoundary(text string, start, limit int) int {
+	window := text[start:limit]
+	half := len(window) / 2
+
+	for _, separator := range []string{"\n\n", "\n", ". ", " "} {
+		if index := strings.LastIndex(window, separator); index >= half {
+			return start + index + len(separator)
+		}
+	}
+
+	return runeStart(text, limit)
+}
+
+// runeStart moves an offset back to the start of the UTF-8 sequence it falls in
+func runeStart(text string, offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+	for offset > 0 && offset < len(text) && !utf8.RuneStart(text[offset]) {
+		offset--
+	}
+	return offset
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+const (
+	collabSnapshotInterval = 5 * time.Second
+	collabHistoryLimit     = 1000
+	collabClientBuffer     = 256
+)
+
+// Event types sent to collaboration clients
+const (
+	CollabEventInit      = "init"
+	CollabEventOperation = "operation"
+	CollabEventPresence  = "presence"
+	CollabEventLeave     = "leave"
+)
+
+// ErrCollabRevision is returned for operations based on a revision the session no
+// longer has the history for. The client has to reconnect and start from the current
+// content.
+var ErrCollabRevision = errors.New("operation revision is out of range")
+
+var collabHub *CollabHub
+
+// CollabHub holds the editing sessions of the documents that are open, and saves their
+// content to the database every few seconds while they change
+type CollabHub struct {
+	app core.App
+
+	mu       sync.Mutex
+	sessions map[string]*CollabSession
+}
+
+// CollabSession is the server copy of a document being edited together. Clients send
+// operations based on the revision they last saw. The session transforms them against
+// the operations applied since, applies them and sends them to every client, the
+// author included so it knows its operation was accepted.
+type CollabSession struct {
+	hub        *CollabHub
+	documentId string
+
+	mu           sync.Mutex
+	content      string
+	revision     int
+	history      []*TextOperation
+	historyStart int
+	clients      map[string]*CollabClient
+	dirty        bool
+	snapshot     string
+
+	// edits counts the characters each user changed since the last snapshot, so that its
+	// words are credited to the users who wrote them
+	edits map[string]int
+}
+
+// CollabClient is one connection to a session. Events are delivered on Events, which
+// is closed when the client leaves or falls too far behind.
+type CollabClient struct {
+	Id     string
+	UserId string
+	Name   string
+	Events chan CollabEvent
+
+	presence *CollabPresence
+}
+
+// CollabPresence is where a client's cursor and selection are, in characters
+type CollabPresence struct {
+	ClientId       string `json:"client_id"`
+	UserId         string `json:"user_id"`
+	Name           string `json:"name,omitempty"`
+	Cursor         int    `json:"cursor"`
+	SelectionStart int    `json:"selection_start"`
+	SelectionEnd   int    `json:"selection_end"`
+}
+
+type CollabEvent struct {
+	Type      string           `json:"type"`
+	ClientId  string           `json:"client_id,omitempty"`
+	Revision  int              `json:"revision"`
+	Content   *string          `json:"content,omitempty"`
+	Operation *TextOperation   `json:"operation,omitempty"`
+	Presence  []CollabPresence `json:"presence,omitempty"`
+}
+
+func NewCollabHub(app core.App) *CollabHub {
+	return &CollabHub{
+		app:      app,
+		sessions: make(map[string]*CollabSession),
+	}
+}
+
+// InitializeCollaboration starts the shared hub and its periodic snapshots
+func InitializeCollaboration(app core.App) {
+	collabHub = NewCollabHub(app)
+
+	go func() {
+		ticker := time.NewTicker(collabSnapshotInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			collabHub.SnapshotAll()
+		}
+	}()
+}
+
+// GetCollabHub returns the shared hub, nil until it is initialized
+func GetCollabHub() *CollabHub {
+	return collabHub
+}
+
+// NotifyDocumentChanged passes a document saved outside of a session to the shared hub
+func NotifyDocumentChanged(documentId, content string) {
+	if collabHub != nil {
+		collabHub.DocumentChanged(documentId, content)
+	}
+}
+
+// Join connects a client to the session of the document, opening the session with the
+// stored content when nobody is editing the document yet
+func (h *CollabHub) Join(documentId, userId, name string) (*CollabSession, *CollabClient, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	session, ok := h.sessions[documentId]
+	if !ok {
+		record, err := h.app.FindRecordById("documents", documentId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		session = NewCollabSession(documentId, record.GetString("content"))
+		session.hub = h
+		h.sessions[documentId] = session
+	}
+
+	return session, session.Join(userId, name), nil
+}
+
+// Session returns the open session of the document, if any
+func (h *CollabHub) Session(documentId string) *CollabSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.sessions[documentId]
+}
+
+// DocumentChanged merges a save made outside of the session, such as a plain update of
+// the record, into the open session as an operation replacing the changed part
+func (h *CollabHub) DocumentChanged(documentId, content string) {
+	if session := h.Session(documentId); session != nil {
+		session.replace(content)
+	}
+}
+
+// SnapshotAll saves the content of every session that changed since its last snapshot
+func (h *CollabHub) SnapshotAll() {
+	h.mu.Lock()
+	sessions := make([]*CollabSession, 0, len(h.sessions))
+	for _, session := range h.sessions {
+		sessions = append(sessions, session)
+	}
+	h.mu.Unlock()
+
+	for _, session := range sessions {
+		session.mu.Lock()
+		empty := len(session.clients) == 0
+		session.mu.Unlock()
+
+		if empty {
+			h.release(session)
+		} else if err := h.snapshot(session); err != nil {
+			log.Printf("Failed to save collaboration snapshot of document %s: %v", session.documentId, err)
+		}
+	}
+}
+
+func (h *CollabHub) snapshot(session *CollabSession) error {
+	session.mu.Lock()
+	if !session.dirty {
+		session.mu.Unlock()
+		return nil
+	}
+	content, saved, edits := session.content, session.snapshot, session.edits
+	session.dirty = false
+	session.edits = make(map[string]int)
+	// The save reports the change back through DocumentChanged, which must ignore it
+	session.snapshot = content
+	session.mu.Unlock()
+
+	record, err := h.app.FindRecordById("documents", session.documentId)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The document was deleted while it was open, there is nothing left to save
+		return nil
+	}
+	if err == nil {
+		record.Set("content", content)
+		err = h.app.Save(record)
+	}
+
+	if err != nil {
+		session.mu.Lock()
+		session.dirty = true
+		if session.snapshot == content {
+			session.snapshot = saved
+		}
+		for userId, changed := range edits {
+			session.edits[userId] += changed
+		}
+		session.mu.Unlock()
+		return err
+	}
+
+	if err := RecordCollabWriting(h.app, session.documentId, saved, content, edits); err != nil {
+		log.Printf("Failed to record writing activity of document %s: %v", session.documentId, err)
+	}
+	return nil
+}
+
+// release closes the session once its last client left, saving its content first
+func (h *CollabHub) release(session *CollabSession) {
+	if err := h.snapshot(session); err != nil {
+		log.Printf("Failed to save collaboration snapshot of document %s: %v", session.documentId, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	session.mu.Lock()
+	empty := len(session.clients) == 0 && !session.dirty
+	session.mu.Unlock()
+
+	if empty && h.sessions[session.documentId] == session {
+		delete(h.sessions, session.documentId)
+	}
+}
+
+// NewCollabSession creates a session that is not attached to a hub, so nothing is saved
+func NewCollabSession(documentId, content string) *CollabSession {
+	return &CollabSession{
+		documentId: documentId,
+		content:    content,
+		clients:    make(map[string]*CollabClient),
+		snapshot:   content,
+		edits:      make(map[string]int),
+	}
+}
+
+// Join adds a client. Its first event holds the current content, revision and presence.
+func (s *CollabSession) Join(userId, name string) *CollabClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := &CollabClient{
+		Id:     security.RandomString(15),
+		UserId: userId,
+		Name:   name,
+		Events: make(chan CollabEvent, collabClientBuffer),
+	}
+
+	content := s.content
+	client.Events <- CollabEvent{
+		Type:     CollabEventInit,
+		ClientId: client.Id,
+		Revision: s.revision,
+		Content:  &content,
+		Presence: s.presenceLocked(),
+	}
+
+	s.clients[client.Id] = client
+	return client
+}
+
+// Leave disconnects the client and tells the others it is gone
+func (s *CollabSession) Leave(client *CollabClient) {
+	s.mu.Lock()
+	if _, ok := s.clients[client.Id]; ok {
+		s.removeLocked(client)
+	}
+	empty := len(s.clients) == 0
+	s.mu.Unlock()
+
+	if empty && s.hub != nil {
+		s.hub.release(s)
+	}
+}
+
+// Client returns a connected client of the session
+func (s *CollabSession) Client(clientId string) (*CollabClient, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[clientId]
+	return client, ok
+}
+
+// Content returns the current content and its revision
+func (s *CollabSession) Content() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.content, s.revision
+}
+
+// Submit applies an operation made by the client on the given revision and returns the
+// revision it produced
+func (s *CollabSession) Submit(clientId string, revision int, operation *TextOperation) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[clientId]; !ok {
+		return 0, fmt.Errorf("client %s is not connected", clientId)
+	}
+
+	if revision < s.historyStart || revision > s.revision {
+		return 0, ErrCollabRevision
+	}
+
+	for _, applied := range s.history[revision-s.historyStart:] {
+		transformed, _, err := Transform(operation, applied)
+		if err != nil {
+			return 0, err
+		}
+		operation = transformed
+	}
+
+	if err := s.applyLocked(clientId, operation); err != nil {
+		return 0, err
+	}
+
+	return s.revision, nil
+}
+
+// UpdatePresence stores the cursor and selection of the client and sends them to the others
+func (s *CollabSession) UpdatePresence(clientId string, presence CollabPresence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[clientId]
+	if !ok {
+		return fmt.Errorf("client %s is not connected", clientId)
+	}
+
+	length := len([]rune(s.content))
+	presence.ClientId = client.Id
+	presence.UserId = client.UserId
+	presence.Name = client.Name
+	presence.Cursor = min(max(presence.Cursor, 0), length)
+	presence.SelectionStart = min(max(presence.SelectionStart, 0), length)
+	presence.SelectionEnd = min(max(presence.SelectionEnd, presence.SelectionStart), length)
+	client.presence = &presence
+
+	s.broadcastLocked(CollabEvent{
+		Type:     CollabEventPresence,
+		ClientId: client.Id,
+		Revision: s.revision,
+		Presence: []CollabPresence{presence},
+	}, client.Id)
+	return nil
+}
+
+func (s *CollabSession) replace(content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if content == s.content || content == s.snapshot {
+		return
+	}
+
+	if err := s.applyLocked("", DiffOperation(s.content, content)); err != nil {
+		log.Printf("Failed to merge saved content into collaboration session %s: %v", s.documentId, err)
+	}
+}
+
+func (s *CollabSession) applyLocked(clientId string, operation *TextOperation) error {
+	content, err := operation.Apply(s.content)
+	if err != nil {
+		return err
+	}
+
+	s.content = content
+	s.revision++
+	s.dirty = true
+
+	// Saves merged from outside the session have no client and count for nobody here
+	author := ""
+	if client, ok := s.clients[clientId]; ok {
+		author = client.UserId
+	}
+	for _, component := range operation.Ops {
+		s.edits[author] += utf8.RuneCountInString(component.Insert) + component.Delete
+	}
+
+	s.history = append(s.history, operation)
+	if len(s.history) > collabHistoryLimit {
+		trimmed := len(s.history) - collabHistoryLimit
+		s.history = append([]*TextOperation(nil), s.history[trimmed:]...)
+		s.historyStart += trimmed
+	}
+
+	// Cursors move with the text around them
+	for _, client := range s.clients {
+		if client.presence != nil {
+			client.presence.Cursor = operation.TransformIndex(client.presence.Cursor)
+			client.presence.SelectionStart = operation.TransformIndex(client.presence.SelectionStart)
+			client.presence.SelectionEnd = operation.TransformIndex(client.presence.SelectionEnd)
+		}
+	}
+
+	s.broadcastLocked(CollabEvent{
+		Type:      CollabEventOperation,
+		ClientId:  clientId,
+		Revision:  s.revision,
+		Operation: operation,
+	}, "")
+	return nil
+}
+
+// broadcastLocked sends the event to every client but the excluded one. Clients whose
+// buffer is full are disconnected rather than holding up the session.
+func (s *CollabSession) broadcastLocked(event CollabEvent, excludeId string) {
+	for id, client := range s.clients {
+		if id == excludeId {
+			continue
+		}
+
+		select {
+		case client.Events <- event:
+		default:
+			log.Printf("Collaboration client %s of document %s fell behind, disconnecting", id, s.documentId)
+			s.removeLocked(client)
+		}
+	}
+}
+
+func (s *CollabSession) removeLocked(client *CollabClient) {
+	delete(s.clients, client.Id)
+	close(client.Events)
+
+	if client.presence != nil {
+		s.broadcastLocked(CollabEvent{
+			Type:     CollabEventLeave,
+			ClientId: client.Id,
+			Revision: s.revision,
+		}, "")
+	}
+}
+
+func (s *CollabSession) presenceLocked() []CollabPresence {
+	var presence []CollabPresence
+	for _, client := range s.clients {
+		if client.presence != nil {
+			presence = append(presence, *client.presence)
+		}
+	}
+	return presence
+}
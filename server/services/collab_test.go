@@ -0,0 +1,213 @@
+package services_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"textly/services"
+	"time"
+)
+
+// simulatedClient follows the usual OT client states: at most one operation waits for
+// the server at a time, and edits made meanwhile are composed into a buffer
+type simulatedClient struct {
+	session *services.CollabSession
+	client  *services.CollabClient
+
+	ready       bool
+	content     string
+	revision    int
+	outstanding *services.TextOperation
+	buffer      *services.TextOperation
+}
+
+func (c *simulatedClient) edit(rng *rand.Rand) error {
+	operation := randomOperation(rng, c.content)
+
+	content, err := operation.Apply(c.content)
+	if err != nil {
+		return err
+	}
+	c.content = content
+
+	switch {
+	case c.outstanding == nil:
+		c.outstanding = operation
+		_, err = c.session.Submit(c.client.Id, c.revision, operation)
+	case c.buffer == nil:
+		c.buffer = operation
+	default:
+		c.buffer, err = services.Compose(c.buffer, operation)
+	}
+	return err
+}
+
+func (c *simulatedClient) receive(event services.CollabEvent) error {
+	switch event.Type {
+	case services.CollabEventInit:
+		c.content = *event.Content
+		c.revision = event.Revision
+		c.ready = true
+		return nil
+	case services.CollabEventOperation:
+	default:
+		return nil
+	}
+
+	c.revision = event.Revision
+
+	// The server applied our own operation, send the buffered edits next
+	if event.ClientId == c.client.Id {
+		c.outstanding, c.buffer = c.buffer, nil
+		if c.outstanding != nil {
+			_, err := c.session.Submit(c.client.Id, c.revision, c.outstanding)
+			return err
+		}
+		return nil
+	}
+
+	operation := event.Operation
+	var err error
+	if c.outstanding != nil {
+		if c.outstanding, operation, err = services.Transform(c.outstanding, operation); err != nil {
+			return err
+		}
+	}
+	if c.buffer != nil {
+		if c.buffer, operation, err = services.Transform(c.buffer, operation); err != nil {
+			return err
+		}
+	}
+
+	c.content, err = operation.Apply(c.content)
+	return err
+}
+
+func TestCollabSessionConvergesWithConcurrentClients(t *testing.T) {
+	const clientCount, editsPerClient = 5, 40
+
+	session := services.NewCollabSession("document", "Shared draft\n")
+
+	var idle sync.WaitGroup
+	var finalRevision atomic.Int64
+	finalRevision.Store(-1)
+
+	results := make([]string, clientCount)
+	errs := make(chan error, clientCount)
+
+	for i := range clientCount {
+		idle.Add(1)
+		client := &simulatedClient{session: session, client: session.Join(fmt.Sprintf("user%d", i), "")}
+
+		go func() {
+			rng := rand.New(rand.NewSource(int64(i)))
+			edits, reportedIdle := 0, false
+
+			fail := func(err error) {
+				if !reportedIdle {
+					idle.Done()
+				}
+				errs <- err
+			}
+
+			for {
+				select {
+				case event, ok := <-client.client.Events:
+					if !ok {
+						fail(fmt.Errorf("client %d was disconnected", i))
+						return
+					}
+					if err := client.receive(event); err != nil {
+						fail(err)
+						return
+					}
+					continue
+				default:
+				}
+
+				if edits < editsPerClient && client.ready {
+					if err := client.edit(rng); err != nil {
+						fail(err)
+						return
+					}
+					edits++
+					time.Sleep(time.Duration(rng.Intn(200)) * time.Microsecond)
+					continue
+				}
+
+				if edits == editsPerClient && client.outstanding == nil && !reportedIdle {
+					reportedIdle = true
+					idle.Done()
+				}
+
+				if final := finalRevision.Load(); final >= 0 && int64(client.revision) >= final {
+					results[i] = client.content
+					session.Leave(client.client)
+					errs <- nil
+					return
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	idle.Wait()
+	content, revision := session.Content()
+	finalRevision.Store(int64(revision))
+
+	for range clientCount {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if revision == 0 {
+		t.Fatalf("no operations were applied")
+	}
+	for i, result := range results {
+		if result != content {
+			t.Fatalf("client %d ended with %q, the server has %q", i, result, content)
+		}
+	}
+}
+
+func TestCollabSessionPresenceFollowsEdits(t *testing.T) {
+	session := services.NewCollabSession("document", "hello world")
+	alice := session.Join("alice", "Alice")
+	bob := session.Join("bob", "Bob")
+	<-alice.Events
+	<-bob.Events
+
+	if err := session.UpdatePresence(bob.Id, services.CollabPresence{Cursor: 6, SelectionStart: 6, SelectionEnd: 11}); err != nil {
+		t.Fatal(err)
+	}
+
+	event := <-alice.Events
+	if event.Type != services.CollabEventPresence || event.Presence[0].Name != "Bob" || event.Presence[0].SelectionEnd != 11 {
+		t.Fatalf("unexpected presence event %+v", event)
+	}
+
+	// Alice inserts before Bob's cursor with an operation on the initial revision
+	if _, err := session.Submit(alice.Id, 0, services.NewTextOperation().Insert("oh, ").Retain(11)); err != nil {
+		t.Fatal(err)
+	}
+
+	late := session.Join("carol", "Carol")
+	init := <-late.Events
+	if *init.Content != "oh, hello world" || len(init.Presence) != 1 || init.Presence[0].Cursor != 10 {
+		t.Fatalf("unexpected init event %+v", init)
+	}
+
+	if _, err := session.Submit(alice.Id, 5, services.NewTextOperation().Retain(15)); err == nil {
+		t.Fatalf("expected an error for a revision the session has not reached")
+	}
+
+	session.Leave(bob)
+	<-alice.Events // the operation
+	if event := <-alice.Events; event.Type != services.CollabEventLeave || event.ClientId != bob.Id {
+		t.Fatalf("expected bob to leave, got %+v", event)
+	}
+}
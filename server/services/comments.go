@@ -0,0 +1,193 @@
+package services
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"textly/queries"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Authors of document comments. Assistant comments are added by the assistant on behalf
+// of the user stored with them.
+const (
+	CommentAuthorUser      = "user"
+	CommentAuthorAssistant = "assistant"
+)
+
+// mentionPattern matches @ followed by an email address or the part of one before the @,
+// as long as the @ does not belong to a word or address itself
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.+-]+(?:@[\w-]+(?:\.[\w-]+)+)?)`)
+
+// CommentAnchor is the range of a document a comment points at, in characters, with the
+// text it covered. An orphaned anchor lost its text and keeps the place it was last at.
+type CommentAnchor struct {
+	Start    int
+	End      int
+	Quote    string
+	Orphaned bool
+}
+
+// MentionCandidate is a user who can be mentioned in the comments of a document
+type MentionCandidate struct {
+	UserId string
+	Email  string
+}
+
+// RemapAnchor moves the anchor through an edit of the document, after being the content
+// the edit produced. Text typed inside the range becomes part of it, text typed at its
+// edges does not. When all of the text is gone the quote is looked up again, nearest to
+// where it was, which also brings back comments on text that was moved or restored.
+func RemapAnchor(operation *TextOperation, after string, anchor CommentAnchor) CommentAnchor {
+	if anchor.Quote == "" {
+		return anchor
+	}
+
+	runes := []rune(after)
+	start := min(operation.transformIndex(anchor.Start, true), len(runes))
+
+	if !anchor.Orphaned {
+		end := min(operation.transformIndex(anchor.End, false), len(runes))
+		if end > start {
+			return CommentAnchor{Start: start, End: end, Quote: string(runes[start:end])}
+		}
+	}
+
+	// Text that replaced the range comes after where the orphaned comment stays
+	start = min(operation.transformIndex(anchor.Start, false), len(runes))
+	if found, ok := findNearest(after, anchor.Quote, start); ok {
+		return CommentAnchor{Start: found, End: found + utf8.RuneCountInString(anchor.Quote), Quote: anchor.Quote}
+	}
+
+	return CommentAnchor{Start: start, End: start, Quote: anchor.Quote, Orphaned: true}
+}
+
+// findNearest returns the character offset of the occurrence of quote in text closest to
+// the given offset
+func findNearest(text, quote string, near int) (int, bool) {
+	best, found := 0, false
+	offset, runeOffset := 0, 0
+
+	for {
+		index := strings.Index(text[offset:], quote)
+		if index < 0 {
+			return best, found
+		}
+
+		runeOffset += utf8.RuneCountInString(text[offset : offset+index])
+		if !found || abs(runeOffset-near) < abs(best-near) {
+			best, found = runeOffset, true
+		}
+		if runeOffset > near {
+			return best, found
+		}
+
+		// Continue after the first character of the match, so overlapping matches count
+		_, size := utf8.DecodeRuneInString(text[offset+index:])
+		offset += index + size
+		runeOffset++
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// RemapDocumentComments moves the anchors of the document's comments after its content
+// changed from before to after
+func RemapDocumentComments(app core.App, documentId, before, after string) error {
+	comments, err := queries.GetAnchoredDocumentComments(app, documentId)
+	if err != nil || len(comments) == 0 {
+		return err
+	}
+
+	operation := DiffOperation(before, after)
+
+	for _, comment := range comments {
+		anchor := CommentAnchor{
+			Start:    comment.AnchorStart,
+			End:      comment.AnchorEnd,
+			Quote:    comment.Quote,
+			Orphaned: comment.Orphaned,
+		}
+
+		remapped := RemapAnchor(operation, after, anchor)
+		if remapped == anchor {
+			continue
+		}
+
+		if err := queries.UpdateDocumentCommentAnchor(app, comment.Id, remapped.Start, remapped.End, remapped.Quote, remapped.Orphaned); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseMentions returns the ids of the candidates mentioned in the content, in order. A
+// mention is either the full email address or the part before the @, when only one
+// candidate's address starts with it.
+func ParseMentions(content string, candidates []MentionCandidate) []string {
+	mentioned := []string{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		handle := strings.TrimRight(match[1], ".")
+
+		var matches []string
+		for _, candidate := range candidates {
+			local, _, _ := strings.Cut(candidate.Email, "@")
+			if strings.EqualFold(handle, candidate.Email) || (!strings.Contains(handle, "@") && strings.EqualFold(handle, local)) {
+				matches = append(matches, candidate.UserId)
+			}
+		}
+
+		if len(matches) == 1 && !slices.Contains(mentioned, matches[0]) {
+			mentioned = append(mentioned, matches[0])
+		}
+	}
+
+	return mentioned
+}
+
+// DocumentMentionCandidates returns the users who can see the document, and so can be
+// mentioned in its comments: the owner, the owners of its folders and its collaborators
+func DocumentMentionCandidates(app core.App, documentId string) ([]MentionCandidate, error) {
+	document, err := app.FindRecordById("documents", documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := inheritedGrants(app, documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := queries.GetDocumentSharesByDocumentIds(app, []string{documentId})
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := []string{document.GetString("user")}
+	for _, grant := range append(grants, shareGrants(shares)...) {
+		if !slices.Contains(userIds, grant.UserId) {
+			userIds = append(userIds, grant.UserId)
+		}
+	}
+
+	users, err := app.FindRecordsByIds("users", userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]MentionCandidate, 0, len(users))
+	for _, user := range users {
+		candidates = append(candidates, MentionCandidate{UserId: user.Id, Email: user.Email()})
+	}
+
+	return candidates, nil
+}
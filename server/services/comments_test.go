@@ -0,0 +1,71 @@
+package services_test
+
+import (
+	"slices"
+	"testing"
+	"textly/services"
+)
+
+func TestRemapAnchorFollowsEdits(t *testing.T) {
+	before := "The quick brown fox jumps over the lazy dog."
+	anchor := services.CommentAnchor{Start: 10, End: 19, Quote: "brown fox"}
+
+	cases := []struct {
+		after    string
+		expected services.CommentAnchor
+	}{
+		// Text added before the range moves it
+		{"Oh! The quick brown fox jumps over the lazy dog.", services.CommentAnchor{Start: 14, End: 23, Quote: "brown fox"}},
+		// Text typed inside the range grows it
+		{"The quick brown red fox jumps over the lazy dog.", services.CommentAnchor{Start: 10, End: 23, Quote: "brown red fox"}},
+		// Text typed right after the range is not part of it
+		{"The quick brown foxes jump over the lazy dog.", services.CommentAnchor{Start: 10, End: 19, Quote: "brown fox"}},
+		// Moved text is found again
+		{"The quick dog jumps over the lazy brown fox.", services.CommentAnchor{Start: 34, End: 43, Quote: "brown fox"}},
+		// Deleted text orphans the comment where the text was
+		{"The quick cat jumps over the lazy dog.", services.CommentAnchor{Start: 10, End: 10, Quote: "brown fox", Orphaned: true}},
+	}
+
+	for _, c := range cases {
+		remapped := services.RemapAnchor(services.DiffOperation(before, c.after), c.after, anchor)
+		if remapped != c.expected {
+			t.Fatalf("after %q: got %+v, expected %+v", c.after, remapped, c.expected)
+		}
+	}
+
+	// An orphaned comment comes back when its text is restored
+	orphaned := services.CommentAnchor{Start: 10, End: 10, Quote: "brown fox", Orphaned: true}
+	restored := services.RemapAnchor(services.DiffOperation("The quick cat", before), before, orphaned)
+	if restored != anchor {
+		t.Fatalf("restored anchor is %+v", restored)
+	}
+}
+
+func TestDiffOperationKeepsSeparateEditsApart(t *testing.T) {
+	before := "first line\nsecond line\nthird line\nfourth line\n"
+	after := "first line!\nsecond line\nthird line\nfourth line, edited\n"
+
+	operation := services.DiffOperation(before, after)
+	if result, _ := operation.Apply(before); result != after {
+		t.Fatalf("diff produced %q", result)
+	}
+
+	// A comment on the untouched middle lines keeps its range
+	anchor := services.CommentAnchor{Start: 11, End: 33, Quote: "second line\nthird line"}
+	if remapped := services.RemapAnchor(operation, after, anchor); remapped.Start != 12 || remapped.Quote != anchor.Quote {
+		t.Fatalf("middle anchor moved to %+v", remapped)
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	candidates := []services.MentionCandidate{
+		{UserId: "alice", Email: "alice@example.com"},
+		{UserId: "bob", Email: "bob@example.com"},
+		{UserId: "bob2", Email: "bob@other.org"},
+	}
+
+	mentions := services.ParseMentions("@alice, could you check this with @bob@other.org? Mail me at carol@example.com. @bob @alice.", candidates)
+	if !slices.Equal(mentions, []string{"alice", "bob2"}) {
+		t.Fatalf("unexpected mentions %v", mentions)
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"textly/queries"
+)
+
+// ExportChapter is one document or folder of an export. Level is the heading level of
+// its title, so the content of nested documents is shifted below it.
+type ExportChapter struct {
+	Id       string
+	Title    string
+	Content  string
+	Level    int
+	IsFolder bool
+}
+
+// ExportBook is what gets exported: a single document, or a folder with its subtree
+// stitched together in order
+type ExportBook struct {
+	Id       string
+	Title    string
+	IsFolder bool
+	Chapters []ExportChapter
+}
+
+// BuildExportBook orders the documents below the root depth first, in the order siblings
+// are listed. The documents are expected in that sibling order already.
+func BuildExportBook(root *queries.Document, documents []*queries.Document) ExportBook {
+	book := ExportBook{Id: root.Id, Title: exportTitle(root.Title), IsFolder: root.IsFolder}
+
+	if !root.IsFolder {
+		book.Chapters = []ExportChapter{{Id: root.Id, Title: book.Title, Content: root.Content, Level: 1}}
+		return book
+	}
+
+	children := make(map[string][]*queries.Document)
+	for _, document := range documents {
+		if document.Id != root.Id {
+			children[document.Parent] = append(children[document.Parent], document)
+		}
+	}
+
+	visited := map[string]bool{root.Id: true}
+	var walk func(parentId string, level int)
+	walk = func(parentId string, level int) {
+		for _, document := range children[parentId] {
+			if visited[document.Id] {
+				continue
+			}
+			visited[document.Id] = true
+
+			book.Chapters = append(book.Chapters, ExportChapter{
+				Id:       document.Id,
+				Title:    exportTitle(document.Title),
+				Content:  document.Content,
+				Level:    min(level, 6),
+				IsFolder: document.IsFolder,
+			})
+			walk(document.Id, level+1)
+		}
+	}
+	walk(root.Id, 1)
+
+	// An empty folder still exports as a title page
+	if len(book.Chapters) == 0 {
+		book.Chapters = []ExportChapter{{Id: root.Id, Title: book.Title, Level: 1, IsFolder: true}}
+	}
+
+	return book
+}
+
+func exportTitle(title string) string {
+	if strings.TrimSpace(title) == "" {
+		return "Untitled"
+	}
+	return strings.TrimSpace(title)
+}
+
+// ChapterMarkdown is the markdown of one chapter: its title as a heading, unless the
+// content already starts with one, followed by the content with headings moved below
+// the title
+func ChapterMarkdown(chapter ExportChapter) string {
+	content := strings.TrimSpace(strings.ReplaceAll(xmlSafeText(chapter.Content), "\r\n", "\n"))
+
+	var out strings.Builder
+	if strings.HasPrefix(content, "# ") {
+		out.WriteString(ShiftMarkdownHeadings(content, chapter.Level-1))
+	} else {
+		out.WriteString(strings.Repeat("#", chapter.Level) + " " + escapeMarkdownTitle(xmlSafeText(chapter.Title)) + "\n")
+		if content != "" {
+			out.WriteString("\n" + ShiftMarkdownHeadings(content, chapter.Level))
+		}
+	}
+	return strings.TrimRight(out.String(), "\n") + "\n"
+}
+
+// ShiftMarkdownHeadings moves every heading down by the given number of levels, leaving
+// code blocks alone. Headings never go below level 6.
+func ShiftMarkdownHeadings(content string, shift int) string {
+	if shift <= 0 {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	fence := ""
+	for i, line := range lines {
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if match := fencePattern.FindStringSubmatch(line); match != nil {
+			fence = match[1]
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if match := headingPattern.FindStringSubmatch(trimmed); match != nil {
+			lines[i] = strings.Repeat("#", min(len(match[1])+shift, 6)) + " " + match[2]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var markdownTitleEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "~", `\~`, "!", `\!`, "#", `\#`,
+)
+
+// escapeMarkdownTitle keeps a document title literal when it is used as a heading
+func escapeMarkdownTitle(title string) string {
+	return markdownTitleEscaper.Replace(strings.Join(strings.Fields(title), " "))
+}
+
+// xmlSafeText drops the control characters that XML documents cannot contain, so the
+// DOCX and EPUB exports stay readable whatever was pasted into a document
+func xmlSafeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20, r == 0xFFFE, r == 0xFFFF, r >= 0xD800 && r <= 0xDFFF:
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// RenderBookMarkdown joins the chapters into one markdown file. A folder gets its name
+// as the top heading.
+func RenderBookMarkdown(book ExportBook) string {
+	var parts []string
+	if book.IsFolder && !isTitleOnlyBook(book) {
+		parts = append(parts, "# "+escapeMarkdownTitle(xmlSafeText(book.Title))+"\n")
+	}
+	for _, chapter := range bookChapters(book) {
+		parts = append(parts, ChapterMarkdown(chapter))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// bookChapters moves the chapters of a folder one level down, below the book title
+func bookChapters(book ExportBook) []ExportChapter {
+	if !book.IsFolder || isTitleOnlyBook(book) {
+		return book.Chapters
+	}
+
+	chapters := make([]ExportChapter, len(book.Chapters))
+	for i, chapter := range book.Chapters {
+		chapter.Level = min(chapter.Level+1, 6)
+		chapters[i] = chapter
+	}
+	return chapters
+}
+
+func isTitleOnlyBook(book ExportBook) bool {
+	return len(book.Chapters) == 1 && book.Chapters[0].Id == book.Id
+}
+
+const printStyle = `.title-page{text-align:center;padding:20vh 0}
+.title-page h1{font-size:2.5rem}
+nav.toc ol{list-style:none;padding-left:1.25rem}nav.toc>ol{padding-left:0}
+nav.toc a{color:inherit;text-decoration:none}
+img{max-width:100%}
+@page{margin:2cm}
+@media print{body{max-width:none;margin:0;padding:0;font-family:Georgia,serif;font-size:11pt}
+.title-page,nav.toc{break-after:page}
+section.chapter.part{break-before:page}
+h1,h2,h3,h4,h5,h6{break-after:avoid}
+pre,blockquote,table,img{break-inside:avoid}
+pre{white-space:pre-wrap}
+a{color:inherit;text-decoration:none}}`
+
+// RenderBookHTML writes the export as a standalone page laid out for printing: a folder
+// gets a title page and a table of contents, and every top level chapter starts on a new
+// page. Browsers turn it into a PDF with their print dialog.
+func RenderBookHTML(book ExportBook) string {
+	var out strings.Builder
+
+	title := html.EscapeString(xmlSafeText(book.Title))
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n<title>%s</title>\n<style>%s\n%s</style>\n</head>\n<body>\n", title, transcriptStyle, printStyle)
+
+	chapters := bookChapters(book)
+	if book.IsFolder && !isTitleOnlyBook(book) {
+		fmt.Fprintf(&out, "<header class=\"title-page\">\n<h1>%s</h1>\n</header>\n", title)
+		out.WriteString("<nav class=\"toc\">\n<h2>Contents</h2>\n")
+		writeTableOfContents(&out, chapters, func(i int) string { return fmt.Sprintf("#chapter-%d", i+1) })
+		out.WriteString("</nav>\n")
+	}
+
+	for i, chapter := range chapters {
+		class := "chapter"
+		if book.IsFolder && chapter.Level <= 2 {
+			class += " part"
+		}
+		fmt.Fprintf(&out, "<section class=\"%s\" id=\"chapter-%d\">\n", class, i+1)
+		out.WriteString(RenderMarkdownHTML(ChapterMarkdown(chapter)))
+		out.WriteString("</section>\n")
+	}
+
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
+
+// writeTableOfContents writes the chapter titles as nested lists. Levels only ever go one
+// deeper at a time so the lists stay valid when a folder is skipped.
+func writeTableOfContents(out *strings.Builder, chapters []ExportChapter, href func(i int) string) {
+	depth := 0
+	for i, chapter := range chapters {
+		level := max(min(chapter.Level, depth+1), 1)
+		switch {
+		case level > depth:
+			out.WriteString("<ol>\n")
+		case level == depth:
+			out.WriteString("</li>\n")
+		default:
+			for ; depth > level; depth-- {
+				out.WriteString("</li>\n</ol>\n")
+			}
+			out.WriteString("</li>\n")
+		}
+		depth = level
+
+		fmt.Fprintf(out, "<li><a href=\"%s\">%s</a>", html.EscapeString(href(i)), html.EscapeString(xmlSafeText(chapter.Title)))
+	}
+	for ; depth > 0; depth-- {
+		out.WriteString("</li>\n</ol>\n")
+	}
+}
+
+// RenderDocumentExport renders a document or folder in one of the export formats and
+// returns the content with its MIME type
+func RenderDocumentExport(format string, book ExportBook) ([]byte, string, error) {
+	switch format {
+	case "md", "markdown":
+		return []byte(RenderBookMarkdown(book)), "text/markdown; charset=utf-8", nil
+	case "html":
+		return []byte(RenderBookHTML(book)), "text/html; charset=utf-8", nil
+	case "docx":
+		data, err := RenderBookDOCX(book)
+		return data, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", err
+	case "epub":
+		data, err := RenderBookEPUB(book)
+		return data, "application/epub+zip", err
+	default:
+		return nil, "", fmt.Errorf("unsupported export format %q, use md, html, docx or epub", format)
+	}
+}
@@ -0,0 +1,180 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"textly/queries"
+	"textly/services"
+)
+
+func folderFixture() (*queries.Document, []*queries.Document) {
+	root := &queries.Document{Id: "root", Title: "Handbook", IsFolder: true}
+	documents := []*queries.Document{
+		root,
+		{Id: "f1", Title: "Guides", Parent: "root", IsFolder: true},
+		{Id: "d1", Title: "Intro", Parent: "root", Content: "Welcome.\n\n## Scope\n\nEverything."},
+		{Id: "d2", Title: "Setup", Parent: "f1", Content: "# Setup\n\n```\n# not a heading\n```\n\n- [docs](https://example.com)\n- two"},
+		{Id: "d3", Title: "Tables & <tags>", Parent: "f1", Content: "| a | b |\n|---|---|\n| 1 |\n\nLine\x01 one<br>\nline two\n\n---\n\n1. first\n2. second"},
+	}
+	return root, documents
+}
+
+func TestBuildExportBookOrder(t *testing.T) {
+	root, documents := folderFixture()
+	book := services.BuildExportBook(root, documents)
+
+	var got []string
+	for _, chapter := range book.Chapters {
+		got = append(got, chapter.Id+":"+string(rune('0'+chapter.Level)))
+	}
+	if strings.Join(got, " ") != "f1:1 d2:2 d3:2 d1:1" {
+		t.Fatalf("unexpected chapter order %v", got)
+	}
+
+	single := services.BuildExportBook(documents[2], nil)
+	if len(single.Chapters) != 1 || single.Chapters[0].Content != documents[2].Content {
+		t.Fatalf("unexpected single document book %+v", single)
+	}
+
+	empty := services.BuildExportBook(&queries.Document{Id: "empty", IsFolder: true}, nil)
+	if len(empty.Chapters) != 1 || empty.Title != "Untitled" {
+		t.Fatalf("empty folders should export their title %+v", empty)
+	}
+}
+
+func TestChapterMarkdownShiftsHeadings(t *testing.T) {
+	got := services.ChapterMarkdown(services.ExportChapter{Title: "Notes *draft*", Content: "Intro\n\n# Part\n\n```\n# comment\n```\n\n###### Deep", Level: 2})
+	want := "## Notes \\*draft\\*\n\nIntro\n\n### Part\n\n```\n# comment\n```\n\n###### Deep\n"
+	if got != want {
+		t.Fatalf("unexpected chapter markdown:\n%s", got)
+	}
+
+	// Content starting with a title keeps it instead of repeating the document title
+	got = services.ChapterMarkdown(services.ExportChapter{Title: "Setup", Content: "# Setup guide\n\n## Steps", Level: 2})
+	if got != "## Setup guide\n\n### Steps\n" {
+		t.Fatalf("unexpected chapter markdown:\n%s", got)
+	}
+}
+
+func TestRenderBookFormats(t *testing.T) {
+	root, documents := folderFixture()
+	book := services.BuildExportBook(root, documents)
+
+	markdown, _, err := services.RenderDocumentExport("md", book)
+	if err != nil || !strings.HasPrefix(string(markdown), "# Handbook\n\n## Guides\n\n### Setup\n") {
+		t.Fatalf("unexpected markdown export %v:\n%s", err, markdown)
+	}
+
+	page, _, err := services.RenderDocumentExport("html", book)
+	if err != nil || !strings.Contains(string(page), `<a href="#chapter-3">Tables &amp; &lt;tags&gt;</a>`) || !strings.Contains(string(page), "@media print") {
+		t.Fatalf("unexpected html export %v:\n%s", err, page)
+	}
+
+	if _, _, err := services.RenderDocumentExport("pdf", book); err == nil {
+		t.Fatal("unknown formats should fail")
+	}
+}
+
+func TestRenderBookDOCX(t *testing.T) {
+	root, documents := folderFixture()
+	data, err := services.RenderBookDOCX(services.BuildExportBook(root, documents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := readZip(t, data)
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "word/document.xml", "word/styles.xml", "word/numbering.xml", "word/_rels/document.xml.rels", "docProps/core.xml"} {
+		content, ok := files[name]
+		if !ok {
+			t.Fatalf("missing %s", name)
+		}
+		checkXML(t, name, content)
+	}
+
+	document := files["word/document.xml"]
+	for _, part := range []string{`<w:pStyle w:val="Title"/>`, `<w:pStyle w:val="Heading3"/>`, `<w:hyperlink r:id="rId3">`, `<w:tbl>`, `<w:numId w:val="2"/>`, "Tables &amp; &lt;tags&gt;"} {
+		if !strings.Contains(document, part) {
+			t.Fatalf("document is missing %s:\n%s", part, document)
+		}
+	}
+	if !strings.Contains(files["word/_rels/document.xml.rels"], `Target="https://example.com" TargetMode="External"`) {
+		t.Fatal("hyperlink relationship is missing")
+	}
+}
+
+func TestRenderBookEPUB(t *testing.T) {
+	root, documents := folderFixture()
+	data, err := services.RenderBookEPUB(services.BuildExportBook(root, documents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := reader.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("mimetype must be the first, uncompressed entry, got %s", first.Name)
+	}
+
+	files := readZip(t, data)
+	for name, content := range files {
+		if strings.HasSuffix(name, ".xml") || strings.HasSuffix(name, ".xhtml") || strings.HasSuffix(name, ".opf") || strings.HasSuffix(name, ".ncx") {
+			checkXML(t, name, content)
+		}
+	}
+
+	if _, ok := files["OEBPS/chapter-4.xhtml"]; !ok {
+		t.Fatal("every chapter should get its own page")
+	}
+	if !strings.Contains(files["OEBPS/chapter-3.xhtml"], "<br/>") || !strings.Contains(files["OEBPS/chapter-3.xhtml"], "<hr/>") {
+		t.Fatalf("void elements should be closed:\n%s", files["OEBPS/chapter-3.xhtml"])
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(content)
+	}
+	return files
+}
+
+// checkXML fails unless the content is well formed XML
+func checkXML(t *testing.T, name, content string) {
+	t.Helper()
+
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = true
+	decoder.Entity = map[string]string{}
+	for {
+		_, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s is not well formed: %v\n%s", name, err, content)
+		}
+	}
+}
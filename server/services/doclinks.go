@@ -0,0 +1,278 @@
+package services
+
+import (
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"textly/queries"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	// LinkKindWiki is a [[Title]] link, resolved to a document of the same owner by title
+	LinkKindWiki = "wiki"
+	// LinkKindDoc is a markdown link to doc:ID, like the ones written by the importer
+	LinkKindDoc = "doc"
+)
+
+// documentLinkPattern matches wikilinks, with an optional heading and label, and markdown
+// links to doc:ID, whose labels escape brackets like escapeLinkLabel
+var documentLinkPattern = regexp.MustCompile(`\[\[([^\[\]\n]+?)\]\]|\[((?:\\.|[^\[\]\\\n])*)\]\(doc:([a-z0-9]+)(#[^()\s]*)?\)`)
+
+var linkLabelUnescaper = strings.NewReplacer(`\\`, `\`, `\[`, "[", `\]`, "]")
+
+// ParseDocumentLinks finds the wikilinks and doc:ID links in markdown, in order. Links
+// in code are ignored, and so are embedded attachments like ![[image.png]].
+func ParseDocumentLinks(content string) []*queries.DocumentLink {
+	var links []*queries.DocumentLink
+
+	mapOutsideCode(content, func(line string) string {
+		return mapOutsideInlineCode(line, func(text string) string {
+			for _, match := range documentLinkPattern.FindAllStringSubmatchIndex(text, -1) {
+				link := &queries.DocumentLink{Position: len(links)}
+
+				if match[2] >= 0 {
+					page := wikilinkPage(text[match[2]:match[3]])
+					embedded := match[0] > 0 && text[match[0]-1] == '!'
+					if page == "" || (embedded && !isNoteLink(page)) {
+						continue
+					}
+					link.Kind = LinkKindWiki
+					link.Title = page
+				} else {
+					link.Kind = LinkKindDoc
+					link.TargetId = text[match[6]:match[7]]
+					link.Title = linkLabelUnescaper.Replace(text[match[4]:match[5]])
+				}
+
+				links = append(links, link)
+			}
+			return text
+		})
+	})
+
+	return links
+}
+
+// wikilinkPage returns the title a wikilink points to, without its heading and label
+func wikilinkPage(target string) string {
+	target, _, _ = strings.Cut(target, "|")
+	page, _, _ := strings.Cut(target, "#")
+	return strings.TrimSpace(page)
+}
+
+func isNoteLink(page string) bool {
+	ext := strings.ToLower(path.Ext(page))
+	return ext == "" || ext == ".md" || ext == ".markdown"
+}
+
+// mapOutsideInlineCode applies the function to the parts of a line that are not in code
+// spans. A span closes with a run of as many backticks as it opened with.
+func mapOutsideInlineCode(line string, fn func(text string) string) string {
+	var result strings.Builder
+	start := 0
+	for i := 0; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+
+		run := i
+		for i < len(line) && line[i] == '`' {
+			i++
+		}
+		fence := line[run:i]
+
+		end := -1
+		for j := i; j < len(line); {
+			k := strings.Index(line[j:], fence)
+			if k < 0 {
+				break
+			}
+			k += j
+			after := k + len(fence)
+			if after == len(line) || line[after] != '`' {
+				end = after
+				break
+			}
+			for after < len(line) && line[after] == '`' {
+				after++
+			}
+			j = after
+		}
+		if end < 0 {
+			continue
+		}
+
+		result.WriteString(fn(line[start:run]))
+		result.WriteString(line[run:end])
+		start, i = end, end
+	}
+
+	result.WriteString(fn(line[start:]))
+	return result.String()
+}
+
+// SyncDocumentLinks stores the links found in the content of the document, resolving
+// wikilinks among the documents of its owner. Nothing is written when they did not change.
+func SyncDocumentLinks(app core.App, documentId string) error {
+	document, err := queries.GetDocumentForIndexing(app, documentId)
+	if err != nil {
+		return err
+	}
+
+	links := ParseDocumentLinks(document.Content)
+
+	resolved := make(map[string]string)
+	for _, link := range links {
+		if link.Kind != LinkKindWiki {
+			continue
+		}
+
+		key := strings.ToLower(link.Title)
+		if id, ok := resolved[key]; ok {
+			link.TargetId = id
+			continue
+		}
+
+		id, err := queries.FindDocumentIdByTitle(app, document.UserId, link.Title)
+		if err != nil {
+			return err
+		}
+		resolved[key] = id
+		link.TargetId = id
+	}
+
+	stored, err := queries.GetDocumentLinksBySourceId(app, documentId)
+	if err != nil {
+		return err
+	}
+	if sameDocumentLinks(stored, links) {
+		return nil
+	}
+
+	return queries.ReplaceDocumentLinks(app, documentId, links)
+}
+
+func sameDocumentLinks(a, b []*queries.DocumentLink) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Kind != b[i].Kind || a[i].TargetId != b[i].TargetId || a[i].Title != b[i].Title || a[i].Position != b[i].Position {
+			return false
+		}
+	}
+	return true
+}
+
+// RewriteDocumentLinks renames the links to a document in markdown: the doc:ID links to it
+// labelled with its old title and, when wiki is set, the wikilinks to the old title.
+// Headings and labels of wikilinks are kept, unless the new title cannot be written as a
+// wikilink and they become doc:ID links.
+func RewriteDocumentLinks(content, documentId, oldTitle, newTitle string, wiki bool) string {
+	return mapOutsideCode(content, func(line string) string {
+		return mapOutsideInlineCode(line, func(text string) string {
+			return documentLinkPattern.ReplaceAllStringFunc(text, func(match string) string {
+				parts := documentLinkPattern.FindStringSubmatch(match)
+
+				if parts[1] != "" {
+					if !wiki || !strings.EqualFold(wikilinkPage(parts[1]), oldTitle) {
+						return match
+					}
+					target, label, hasLabel := strings.Cut(parts[1], "|")
+					_, heading, hasHeading := strings.Cut(target, "#")
+
+					// A title a wikilink cannot hold becomes a link to the document
+					if strings.ContainsAny(newTitle, "[]|#\n") {
+						if !hasLabel || strings.TrimSpace(label) == "" {
+							label = newTitle
+						}
+						return "[" + escapeLinkLabel(strings.TrimSpace(label)) + "](doc:" + documentId + ")"
+					}
+
+					renamed := newTitle
+					if hasHeading {
+						renamed += "#" + heading
+					}
+					if hasLabel {
+						renamed += "|" + label
+					}
+					return "[[" + renamed + "]]"
+				}
+
+				if parts[3] != documentId || linkLabelUnescaper.Replace(parts[2]) != oldTitle {
+					return match
+				}
+				return "[" + escapeLinkLabel(newTitle) + "](doc:" + documentId + parts[4] + ")"
+			})
+		})
+	})
+}
+
+// RenameDocumentLinks rewrites the links to a renamed document in the documents of its
+// owner linking to it, which saves them like any edit. Documents of other users are left
+// alone even when the owner may edit them: their doc:ID links still point at the document
+// and are listed with its new title, and wikilinks only ever resolve within one owner.
+// Documents in the trash keep their content as it was. Wikilinks to the new title that
+// pointed at nothing so far now point at the document.
+func RenameDocumentLinks(app core.App, documentId, userId, oldTitle, newTitle string) error {
+	sourceIds, err := queries.GetLinkingDocumentIds(app, documentId, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, sourceId := range sourceIds {
+		links, err := queries.GetDocumentLinksBySourceId(app, sourceId)
+		if err != nil {
+			return err
+		}
+
+		// Wikilinks are only renamed where the old title meant this document
+		wiki := false
+		for _, link := range links {
+			if link.Kind == LinkKindWiki && link.TargetId == documentId {
+				wiki = true
+				break
+			}
+		}
+
+		record, err := app.FindRecordById("documents", sourceId)
+		if err != nil {
+			return err
+		}
+
+		content := record.GetString("content")
+		rewritten := RewriteDocumentLinks(content, documentId, oldTitle, newTitle, wiki)
+		if rewritten == content {
+			continue
+		}
+
+		record.Set("content", rewritten)
+		if err := app.Save(record); err != nil {
+			return err
+		}
+	}
+
+	return queries.ResolveWikilinks(app, userId, newTitle, documentId)
+}
+
+// InitializeDocumentLinks stores the links of the documents written before links were
+// tracked, in the background
+func InitializeDocumentLinks(app core.App) {
+	go func() {
+		ids, err := queries.GetDocumentIdsWithUnsyncedLinks(app)
+		if err != nil {
+			log.Printf("Failed to get documents with unsynced links: %v", err)
+			return
+		}
+
+		for _, id := range ids {
+			if err := SyncDocumentLinks(app, id); err != nil {
+				log.Printf("Failed to sync links of document %s: %v", id, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+//go:build !goexperiment.jsonv2
+
+package services_test
+
+import (
+	"slices"
+	"testing"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestDocumentLinksFollowCreatesAndRenames(t *testing.T) {
+	app := newTestApp(t)
+
+	owner := createTestUser(t, app, "owner@example.com", true)
+	reader := createTestUser(t, app, "reader@example.com", true)
+	other := createTestUser(t, app, "other@example.com", true)
+
+	// The hooks of the application are not bound, so the test syncs like they would
+	create := func(userId, title, content string) *core.Record {
+		t.Helper()
+		document := createTestDocument(t, app, userId, title, content, "", false)
+		if err := services.SyncDocumentLinks(app, document.Id); err != nil {
+			t.Fatal(err)
+		}
+		if err := queries.ResolveWikilinks(app, userId, title, document.Id); err != nil {
+			t.Fatal(err)
+		}
+		return document
+	}
+
+	targetsOf := func(documentId string) []string {
+		t.Helper()
+		links, err := queries.GetDocumentLinksBySourceId(app, documentId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets := make([]string, len(links))
+		for i, link := range links {
+			targets[i] = link.Title + ":" + link.TargetId
+		}
+		return targets
+	}
+
+	backlinksOf := func(documentId, userId string) []string {
+		t.Helper()
+		links, err := queries.GetDocumentBacklinks(app, documentId, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources := make([]string, len(links))
+		for i, link := range links {
+			sources[i] = link.SourceId
+		}
+		slices.Sort(sources)
+		return sources
+	}
+
+	roadmap := create(owner.Id, "Roadmap", "Text")
+	notes := create(owner.Id, "Notes", "See [[roadmap#Q3|the roadmap]] and [[Plans]]")
+	trashed := create(owner.Id, "Old notes", "See [[Roadmap]]")
+	foreign := create(other.Id, "Foreign", "See [[Roadmap]] and [Roadmap](doc:"+roadmap.Id+")")
+
+	if targets := targetsOf(notes.Id); !slices.Equal(targets, []string{"roadmap:" + roadmap.Id, "Plans:"}) {
+		t.Fatalf("unexpected links of the notes %v", targets)
+	}
+	if targets := targetsOf(foreign.Id); !slices.Equal(targets, []string{"Roadmap:", "Roadmap:" + roadmap.Id}) {
+		t.Fatalf("expected wikilinks to resolve within the owner only, got %v", targets)
+	}
+
+	// A document created with the title a wikilink is waiting for is found by it
+	plans := create(owner.Id, "Plans", "Text")
+	if targets := targetsOf(notes.Id); !slices.Equal(targets, []string{"roadmap:" + roadmap.Id, "Plans:" + plans.Id}) {
+		t.Fatalf("expected the new document to resolve the wikilink, got %v", targets)
+	}
+
+	notes.Set("viewers", []string{reader.Id})
+	if err := app.Save(notes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.TrashDocument(app, trashed.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if sources := backlinksOf(roadmap.Id, owner.Id); !slices.Equal(sources, []string{notes.Id}) {
+		t.Fatalf("expected the owner to see the backlink from the notes only, got %v", sources)
+	}
+	if sources := backlinksOf(roadmap.Id, reader.Id); !slices.Equal(sources, []string{notes.Id}) {
+		t.Fatalf("expected the reader to see the backlink from the shared notes, got %v", sources)
+	}
+	if sources := backlinksOf(roadmap.Id, other.Id); !slices.Equal(sources, []string{foreign.Id}) {
+		t.Fatalf("expected the other user to see the backlink from their own document, got %v", sources)
+	}
+
+	// Renaming finds the wikilinks waiting for the new title too
+	pending := create(owner.Id, "Pending", "Soon [[Roadmap 2027]]")
+
+	roadmap.Set("title", "Roadmap 2027")
+	if err := app.Save(roadmap); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.RenameDocumentLinks(app, roadmap.Id, owner.Id, "Roadmap", "Roadmap 2027"); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := reloadTestRecord(t, app, "documents", notes.Id).GetString("content"); content != "See [[Roadmap 2027#Q3|the roadmap]] and [[Plans]]" {
+		t.Fatalf("expected the notes to follow the rename, got %q", content)
+	}
+	if content := reloadTestRecord(t, app, "documents", trashed.Id).GetString("content"); content != "See [[Roadmap]]" {
+		t.Fatalf("expected the document in the trash to be left alone, got %q", content)
+	}
+	if content := reloadTestRecord(t, app, "documents", foreign.Id).GetString("content"); content != "See [[Roadmap]] and [Roadmap](doc:"+roadmap.Id+")" {
+		t.Fatalf("expected the document of the other user to be left alone, got %q", content)
+	}
+	if targets := targetsOf(pending.Id); !slices.Equal(targets, []string{"Roadmap 2027:" + roadmap.Id}) {
+		t.Fatalf("expected the rename to resolve the waiting wikilink, got %v", targets)
+	}
+
+	if err := services.SyncDocumentLinks(app, notes.Id); err != nil {
+		t.Fatal(err)
+	}
+	if targets := targetsOf(notes.Id); !slices.Equal(targets, []string{"Roadmap 2027:" + roadmap.Id, "Plans:" + plans.Id}) {
+		t.Fatalf("unexpected links of the notes after the rename %v", targets)
+	}
+}
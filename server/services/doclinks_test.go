@@ -0,0 +1,54 @@
+package services_test
+
+import (
+	"testing"
+	"textly/services"
+)
+
+func TestParseDocumentLinks(t *testing.T) {
+	content := "See [[Roadmap]] and [[ Plans#Q3 | the plans ]], plus [the \\[draft\\]](doc:abc123def456ghi).\n" +
+		"![[diagram.png]] ![[Embedded note]] [[]] `[[In code]]` ``[[Also `code`]]``\n" +
+		"```\n[[Fenced]]\n```\n" +
+		"[Web](https://example.com) [Heading](doc:abc123def456ghi#intro)"
+
+	links := services.ParseDocumentLinks(content)
+
+	want := []struct{ kind, target, title string }{
+		{services.LinkKindWiki, "", "Roadmap"},
+		{services.LinkKindWiki, "", "Plans"},
+		{services.LinkKindDoc, "abc123def456ghi", "the [draft]"},
+		{services.LinkKindWiki, "", "Embedded note"},
+		{services.LinkKindDoc, "abc123def456ghi", "Heading"},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("expected %d links, got %d", len(want), len(links))
+	}
+	for i, link := range links {
+		if link.Kind != want[i].kind || link.TargetId != want[i].target || link.Title != want[i].title || link.Position != i {
+			t.Fatalf("link %d: unexpected %+v", i, link)
+		}
+	}
+}
+
+func TestRewriteDocumentLinks(t *testing.T) {
+	content := "[[Old]], [[old#Part|label]], [[Older]], [Old](doc:abc123def456ghi#x), [Other](doc:abc123def456ghi), [Old](doc:zzz)\n`[[Old]]`"
+
+	rewritten := services.RewriteDocumentLinks(content, "abc123def456ghi", "Old", "New", true)
+	want := "[[New]], [[New#Part|label]], [[Older]], [New](doc:abc123def456ghi#x), [Other](doc:abc123def456ghi), [Old](doc:zzz)\n`[[Old]]`"
+	if rewritten != want {
+		t.Fatalf("unexpected rewrite\n%s\nwant\n%s", rewritten, want)
+	}
+
+	// Titles with brackets cannot be wikilinks
+	rewritten = services.RewriteDocumentLinks(content, "abc123def456ghi", "Old", "New [1]", true)
+	want = "[New \\[1\\]](doc:abc123def456ghi), [label](doc:abc123def456ghi), [[Older]], [New \\[1\\]](doc:abc123def456ghi#x), [Other](doc:abc123def456ghi), [Old](doc:zzz)\n`[[Old]]`"
+	if rewritten != want {
+		t.Fatalf("unexpected rewrite\n%s\nwant\n%s", rewritten, want)
+	}
+
+	rewritten = services.RewriteDocumentLinks(content, "abc123def456ghi", "Old", "New", false)
+	want = "[[Old]], [[old#Part|label]], [[Older]], [New](doc:abc123def456ghi#x), [Other](doc:abc123def456ghi), [Old](doc:zzz)\n`[[Old]]`"
+	if rewritten != want {
+		t.Fatalf("wikilinks should be left alone\n%s", rewritten)
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// wordsPerMinute is the average silent reading speed of adults used for reading times
+const wordsPerMinute = 238
+
+// DocumentStats are counts and readability scores of a document. Code blocks are left
+// out, and headings count as words but not as sentences of the readability scores.
+// Paragraphs include list items and table rows.
+type DocumentStats struct {
+	Words                 int              `json:"words"`
+	Characters            int              `json:"characters"`
+	CharactersNoSpaces    int              `json:"characters_no_spaces"`
+	Sentences             int              `json:"sentences"`
+	Paragraphs            int              `json:"paragraphs"`
+	Headings              int              `json:"headings"`
+	ReadingTimeMinutes    int              `json:"reading_time_minutes"`
+	FleschReadingEase     float64          `json:"flesch_reading_ease"`
+	FleschKincaidGrade    float64          `json:"flesch_kincaid_grade"`
+	GunningFog            float64          `json:"gunning_fog"`
+	ColemanLiauIndex      float64          `json:"coleman_liau_index"`
+	AverageSentenceLength float64          `json:"average_sentence_length"`
+	AverageWordSyllables  float64          `json:"average_word_syllables"`
+	Outline               []OutlineHeading `json:"outline"`
+}
+
+// OutlineHeading is a heading of a document, in order
+type OutlineHeading struct {
+	Level int    `json:"level"`
+	Title string `json:"title"`
+}
+
+// documentText is the plain text of a document, split into headings and the passages of
+// prose the readability scores are computed on
+type documentText struct {
+	headings []MarkdownBlock
+	prose    []string
+}
+
+func extractDocumentText(content string) documentText {
+	// Wikilinks read as their label or title, and embedded attachments are left out
+	content = wikilinkPattern.ReplaceAllStringFunc(content, func(match string) string {
+		parts := wikilinkPattern.FindStringSubmatch(match)
+		inner := parts[2]
+		if parts[1] != "" && !isNoteLink(wikilinkPage(inner)) {
+			return ""
+		}
+		if _, label, found := strings.Cut(inner, "|"); found && strings.TrimSpace(label) != "" {
+			return strings.TrimSpace(label)
+		}
+		return wikilinkPage(inner)
+	})
+
+	var text documentText
+	var walk func(blocks []MarkdownBlock)
+	walk = func(blocks []MarkdownBlock) {
+		for _, block := range blocks {
+			switch block.Kind {
+			case BlockHeading:
+				block.Text = strings.TrimSpace(PlainText(block.Text))
+				text.headings = append(text.headings, block)
+			case BlockParagraph:
+				text.prose = append(text.prose, PlainText(block.Text))
+			case BlockQuote:
+				walk(block.Children)
+			case BlockList:
+				for _, item := range block.Items {
+					walk(item)
+				}
+			case BlockTable:
+				for _, row := range block.Rows {
+					cells := make([]string, len(row))
+					for i, cell := range row {
+						cells[i] = PlainText(cell)
+					}
+					text.prose = append(text.prose, strings.Join(cells, " "))
+				}
+			}
+		}
+	}
+	walk(ParseMarkdown(content))
+
+	return text
+}
+
+// CountWords counts the words of markdown the way the document stats do
+func CountWords(content string) int {
+	return len(documentWords(content))
+}
+
+func documentWords(content string) []string {
+	text := extractDocumentText(content)
+
+	var words []string
+	for _, heading := range text.headings {
+		words = append(words, textWords(heading.Text)...)
+	}
+	for _, passage := range text.prose {
+		words = append(words, textWords(passage)...)
+	}
+	return words
+}
+
+// WordChanges counts the words added and removed between two versions of a document,
+// regardless of where they moved. Rewording a sentence adds and removes words, while
+// moving a paragraph does neither.
+func WordChanges(before, after string) (added, removed int) {
+	counts := make(map[string]int)
+	for _, word := range documentWords(before) {
+		counts[strings.ToLower(word)]--
+	}
+	for _, word := range documentWords(after) {
+		counts[strings.ToLower(word)]++
+	}
+
+	for _, count := range counts {
+		if count > 0 {
+			added += count
+		} else {
+			removed -= count
+		}
+	}
+	return added, removed
+}
+
+// ComputeDocumentStats computes the stats of a markdown document
+func ComputeDocumentStats(content string) DocumentStats {
+	text := extractDocumentText(content)
+	stats := DocumentStats{Outline: []OutlineHeading{}}
+
+	count := func(passage string) {
+		stats.Words += len(textWords(passage))
+		for _, r := range passage {
+			if r == '\n' {
+				r = ' '
+			}
+			stats.Characters++
+			if !unicode.IsSpace(r) {
+				stats.CharactersNoSpaces++
+			}
+		}
+	}
+
+	for _, heading := range text.headings {
+		count(heading.Text)
+		stats.Headings++
+		stats.Outline = append(stats.Outline, OutlineHeading{Level: heading.Level, Title: heading.Text})
+	}
+
+	proseWords, syllables, complexWords, letters := 0, 0, 0, 0
+	for _, passage := range text.prose {
+		words := textWords(passage)
+		if len(words) == 0 {
+			continue
+		}
+
+		count(passage)
+		stats.Paragraphs++
+		stats.Sentences += countSentences(passage)
+
+		proseWords += len(words)
+		for _, word := range words {
+			wordSyllables := countSyllables(word)
+			syllables += wordSyllables
+			if wordSyllables >= 3 {
+				complexWords++
+			}
+			for _, r := range word {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					letters++
+				}
+			}
+		}
+	}
+
+	if stats.Words > 0 {
+		stats.ReadingTimeMinutes = (stats.Words + wordsPerMinute - 1) / wordsPerMinute
+	}
+
+	if proseWords > 0 && stats.Sentences > 0 {
+		wordsPerSentence := float64(proseWords) / float64(stats.Sentences)
+		syllablesPerWord := float64(syllables) / float64(proseWords)
+
+		stats.AverageSentenceLength = round2(wordsPerSentence)
+		stats.AverageWordSyllables = round2(syllablesPerWord)
+		stats.FleschReadingEase = round2(206.835 - 1.015*wordsPerSentence - 84.6*syllablesPerWord)
+		stats.FleschKincaidGrade = round2(0.39*wordsPerSentence + 11.8*syllablesPerWord - 15.59)
+		stats.GunningFog = round2(0.4 * (wordsPerSentence + 100*float64(complexWords)/float64(proseWords)))
+		stats.ColemanLiauIndex = round2(0.0588*100*float64(letters)/float64(proseWords) - 0.296*100*float64(stats.Sentences)/float64(proseWords) - 15.8)
+	}
+
+	return stats
+}
+
+// textWords splits text into words: runs of letters and digits, with the apostrophes and
+// hyphens inside them, and the separators of numbers like 1.5 or 10,000
+func textWords(text string) []string {
+	runes := []rune(text)
+	isDigit := func(i int) bool {
+		return i >= 0 && i < len(runes) && unicode.IsDigit(runes[i])
+	}
+
+	var words []string
+	// A dash between spaces is not a word
+	addWord := func(word []rune) {
+		if strings.IndexFunc(string(word), func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			words = append(words, string(word))
+		}
+	}
+
+	start := -1
+	for i, r := range runes {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '’' || r == '-' ||
+			((r == '.' || r == ',') && isDigit(i-1) && isDigit(i+1))
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			addWord(runes[start:i])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		addWord(runes[start:])
+	}
+	return words
+}
+
+// countSentences counts the runs of text ended by ., ! or ?, or by the end of the passage
+func countSentences(text string) int {
+	sentences := 0
+	inSentence := false
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case r == '.' || r == '!' || r == '?' || r == '…':
+			// Decimal numbers and runs like "?!" or "..." end one sentence at most
+			if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+				continue
+			}
+			if inSentence {
+				sentences++
+				inSentence = false
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			inSentence = true
+		}
+	}
+	if inSentence {
+		sentences++
+	}
+	return sentences
+}
+
+// countSyllables estimates the syllables of an English word from its groups of vowels
+func countSyllables(word string) int {
+	word = strings.ToLower(strings.Trim(word, "'’-"))
+	if word == "" {
+		return 0
+	}
+
+	isVowel := func(r rune) bool {
+		return strings.ContainsRune("aeiouyàáâäèéêëìíîïòóôöùúûü", r)
+	}
+
+	runes := []rune(word)
+	syllables := 0
+	previousVowel := false
+	for _, r := range runes {
+		vowel := isVowel(r)
+		if vowel && !previousVowel {
+			syllables++
+		}
+		previousVowel = vowel
+	}
+
+	// A final e is usually silent, as in "make", but not in "table"
+	if n := len(runes); n > 2 && runes[n-1] == 'e' && !isVowel(runes[n-2]) && !(runes[n-2] == 'l' && !isVowel(runes[n-3])) {
+		syllables--
+	}
+
+	return max(syllables, 1)
+}
+
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -0,0 +1,83 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+	"textly/queries"
+	"textly/services"
+	"time"
+)
+
+func TestComputeDocumentStats(t *testing.T) {
+	content := "# Getting started\n\n" +
+		"The cat sat on the mat. It was happy!\n\n" +
+		"## Notes\n\n" +
+		"- See [[Other page|the other page]] for more.\n" +
+		"- Version 1.5 is out - finally\n\n" +
+		"```go\nfunc main() { fmt.Println(\"not counted\") }\n```\n\n" +
+		"![[diagram.png]]\n"
+
+	stats := services.ComputeDocumentStats(content)
+
+	// 2 + 1 heading words, 9 + 6 + 5 in the prose
+	if stats.Words != 23 {
+		t.Errorf("expected 23 words, got %d", stats.Words)
+	}
+	if stats.Sentences != 4 {
+		t.Errorf("expected 4 sentences, got %d", stats.Sentences)
+	}
+	if stats.Paragraphs != 3 || stats.Headings != 2 {
+		t.Errorf("expected 3 paragraphs and 2 headings, got %d and %d", stats.Paragraphs, stats.Headings)
+	}
+	if stats.ReadingTimeMinutes != 1 {
+		t.Errorf("expected a reading time of 1 minute, got %d", stats.ReadingTimeMinutes)
+	}
+
+	outline := []services.OutlineHeading{{Level: 1, Title: "Getting started"}, {Level: 2, Title: "Notes"}}
+	if !reflect.DeepEqual(stats.Outline, outline) {
+		t.Errorf("unexpected outline %v", stats.Outline)
+	}
+
+	if stats.FleschReadingEase < 80 || stats.FleschKincaidGrade > 5 {
+		t.Errorf("expected simple text to read easily, got ease %v and grade %v", stats.FleschReadingEase, stats.FleschKincaidGrade)
+	}
+
+	empty := services.ComputeDocumentStats("")
+	if empty.Words != 0 || empty.FleschReadingEase != 0 || empty.Outline == nil {
+		t.Errorf("unexpected stats of an empty document %+v", empty)
+	}
+}
+
+func TestWordChanges(t *testing.T) {
+	added, removed := services.WordChanges("The quick fox.\n\nSecond paragraph.", "Second paragraph.\n\nThe slow brown fox.")
+	if added != 2 || removed != 1 {
+		t.Errorf("expected 2 words added and 1 removed, got %d and %d", added, removed)
+	}
+
+	if services.CountWords("Hello `code` world\n\n```\nskipped\n```") != 3 {
+		t.Errorf("expected inline code to count and code blocks not to")
+	}
+}
+
+func TestWritingTimeline(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+
+	timeline := services.WritingTimeline([]*queries.WritingActivityDay{
+		{Day: "2026-03-02", WordsAdded: 50, WordsRemoved: 10, Documents: 2},
+		{Day: "2026-03-03", WordsAdded: 5},
+		{Day: "2026-03-04", WordsAdded: 20},
+	}, from, to)
+
+	if len(timeline) != 5 || timeline[0].Date != "2026-03-01" || timeline[4].Date != "2026-03-05" {
+		t.Fatalf("expected every day from the 1st to the 5th, got %v", timeline)
+	}
+	if timeline[1].Net != 40 || timeline[1].Documents != 2 || timeline[0].WordsAdded != 0 {
+		t.Errorf("unexpected days %v", timeline)
+	}
+
+	// Today without words yet keeps the streak of the days before
+	if streak := services.WritingStreak(timeline); streak != 3 {
+		t.Errorf("expected a streak of 3 days, got %d", streak)
+	}
+}
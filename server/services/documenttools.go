@@ -0,0 +1,350 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"textly/queries"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	defaultSearchResults = 5
+	maxSearchResults     = 20
+	maxToolDocumentSize  = 20_000
+	searchSnippetRadius  = 80
+)
+
+func init() {
+	RegisterTool(Tool{
+		Name:        "search_documents",
+		Description: "Search the user's documents by title and content. Returns matching documents with a snippet around the match.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "Text to look for"},
+				"limit": map[string]any{"type": "integer", "description": "Maximum number of results, 5 by default"},
+			},
+			"required": []string{"query"},
+		},
+		Run: searchDocumentsTool,
+	})
+
+	RegisterTool(Tool{
+		Name:        "read_document",
+		Description: "Read the full content of one of the user's documents by its title.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"title": map[string]any{"type": "string", "description": "Title of the document"},
+			},
+			"required": []string{"title"},
+		},
+		Run: readDocumentTool,
+	})
+
+	RegisterTool(Tool{
+		Name:        "list_folder",
+		Description: "List the documents and folders inside a folder. Leave the folder empty to list the top level.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"folder": map[string]any{"type": "string", "description": "Title of the folder"},
+			},
+		},
+		Run: listFolderTool,
+	})
+
+	RegisterTool(Tool{
+		Name: "propose_edit",
+		Description: "Propose a change to one of the user's documents. Either replace the whole content, or replace one exact passage with find and replace. " +
+			"The change is shown to the user for approval and is not applied by this tool.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"title":   map[string]any{"type": "string", "description": "Title of the document to change"},
+				"content": map[string]any{"type": "string", "description": "New full content of the document"},
+				"find":    map[string]any{"type": "string", "description": "Exact passage of the current content to replace"},
+				"replace": map[string]any{"type": "string", "description": "Text that replaces the passage"},
+				"summary": map[string]any{"type": "string", "description": "Short explanation of the change for the user"},
+			},
+			"required": []string{"title", "summary"},
+		},
+		Run: proposeEditTool,
+	})
+
+	RegisterTool(Tool{
+		Name: "comment_on_document",
+		Description: "Leave a review comment on one exact passage of one of the user's documents, or on the whole document when quote is empty. " +
+			"The comment is shown next to the passage as coming from the assistant.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"title":   map[string]any{"type": "string", "description": "Title of the document"},
+				"quote":   map[string]any{"type": "string", "description": "Exact passage of the current content the comment is about"},
+				"comment": map[string]any{"type": "string", "description": "The comment"},
+			},
+			"required": []string{"title", "comment"},
+		},
+		Run: commentOnDocumentTool,
+	})
+}
+
+type documentSummary struct {
+	Id       string `json:"id"`
+	Title    string `json:"title"`
+	IsFolder bool   `json:"is_folder,omitempty"`
+	Snippet  string `json:"snippet,omitempty"`
+}
+
+// PendingEdit is a document change proposed by the assistant, waiting for the user
+type PendingEdit struct {
+	Status     string `json:"status"`
+	DocumentId string `json:"document_id"`
+	Title      string `json:"title"`
+	Summary    string `json:"summary"`
+	Original   string `json:"original"`
+	Proposed   string `json:"proposed"`
+}
+
+func searchDocumentsTool(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error) {
+	var args struct {
+		Query string `json:"query"`
+		Limit int64  `json:"limit"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	if args.Limit <= 0 {
+		args.Limit = defaultSearchResults
+	}
+	args.Limit = min(args.Limit, maxSearchResults)
+
+	documents, err := queries.SearchDocumentsByUserId(e, userId, args.Query, args.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]documentSummary, 0, len(documents))
+	for _, document := range documents {
+		results = append(results, documentSummary{
+			Id:      document.Id,
+			Title:   document.Title,
+			Snippet: snippetAround(document.Content, args.Query),
+		})
+	}
+
+	return map[string]any{"results": results}, nil
+}
+
+func readDocumentTool(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error) {
+	var args struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	document, err := findToolDocument(e, userId, args.Title)
+	if err != nil {
+		return nil, err
+	}
+
+	content := document.Content
+	truncated := len(content) > maxToolDocumentSize
+	if truncated {
+		content = strings.ToValidUTF8(content[:maxToolDocumentSize], "")
+	}
+
+	return map[string]any{
+		"id":        document.Id,
+		"title":     document.Title,
+		"content":   content,
+		"truncated": truncated,
+	}, nil
+}
+
+func listFolderTool(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error) {
+	var args struct {
+		Folder string `json:"folder"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	parentId := ""
+	if args.Folder != "" {
+		folder, err := findToolDocument(e, userId, args.Folder)
+		if err != nil {
+			return nil, err
+		}
+		if !folder.IsFolder {
+			return nil, fmt.Errorf("%s is a document, not a folder", folder.Title)
+		}
+		parentId = folder.Id
+	}
+
+	documents, err := queries.GetDocumentsByParent(e, userId, parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]documentSummary, 0, len(documents))
+	for _, document := range documents {
+		items = append(items, documentSummary{Id: document.Id, Title: document.Title, IsFolder: document.IsFolder})
+	}
+
+	return map[string]any{"folder": args.Folder, "items": items}, nil
+}
+
+func proposeEditTool(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error) {
+	var args struct {
+		Title   string  `json:"title"`
+		Content *string `json:"content"`
+		Find    string  `json:"find"`
+		Replace string  `json:"replace"`
+		Summary string  `json:"summary"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	document, err := findToolDocument(e, userId, args.Title)
+	if err != nil {
+		return nil, err
+	}
+
+	if document.IsFolder {
+		return nil, fmt.Errorf("%s is a folder and has no content", document.Title)
+	}
+
+	var proposed string
+	switch {
+	case args.Content != nil:
+		proposed = *args.Content
+	case args.Find != "":
+		if !strings.Contains(document.Content, args.Find) {
+			return nil, fmt.Errorf("the passage to replace was not found in %s", document.Title)
+		}
+		proposed = strings.Replace(document.Content, args.Find, args.Replace, 1)
+	default:
+		return nil, fmt.Errorf("either content or find is required")
+	}
+
+	return PendingEdit{
+		Status:     "pending",
+		DocumentId: document.Id,
+		Title:      document.Title,
+		Summary:    args.Summary,
+		Original:   document.Content,
+		Proposed:   proposed,
+	}, nil
+}
+
+func commentOnDocumentTool(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error) {
+	var args struct {
+		Title   string `json:"title"`
+		Quote   string `json:"quote"`
+		Comment string `json:"comment"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(args.Comment) == "" {
+		return nil, fmt.Errorf("comment is required")
+	}
+
+	document, err := findToolDocument(e, userId, args.Title)
+	if err != nil {
+		return nil, err
+	}
+
+	if document.IsFolder {
+		return nil, fmt.Errorf("%s is a folder and has no content", document.Title)
+	}
+
+	comment := &queries.DocumentComment{
+		DocumentId: document.Id,
+		UserId:     userId,
+		AuthorType: CommentAuthorAssistant,
+		Content:    args.Comment,
+	}
+
+	if args.Quote != "" {
+		index := strings.Index(document.Content, args.Quote)
+		if index < 0 {
+			return nil, fmt.Errorf("the passage was not found in %s", document.Title)
+		}
+
+		comment.AnchorStart = utf8.RuneCountInString(document.Content[:index])
+		comment.AnchorEnd = comment.AnchorStart + utf8.RuneCountInString(args.Quote)
+		comment.Quote = args.Quote
+	}
+
+	comment, err = queries.CreateDocumentComment(e.App, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"status":      "added",
+		"comment_id":  comment.Id,
+		"document_id": document.Id,
+		"title":       document.Title,
+	}, nil
+}
+
+func findToolDocument(e *core.RequestEvent, userId, title string) (*queries.Document, error) {
+	if strings.TrimSpace(title) == "" {
+		return nil, fmt.Errorf("title is required")
+	}
+
+	document, err := queries.GetDocumentByTitle(e, userId, title)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no document titled %s", title)
+	}
+	return document, err
+}
+
+// snippetAround returns the text surrounding the first case insensitive match of term.
+// The match is found in the content itself, since lowercasing changes the length of some
+// characters and offsets into the lowercased text would not fit the content.
+func snippetAround(content, term string) string {
+	match := regexp.MustCompile("(?i)" + regexp.QuoteMeta(term)).FindStringIndex(content)
+	if match == nil {
+		if len(content) > 2*searchSnippetRadius {
+			return strings.ToValidUTF8(content[:2*searchSnippetRadius], "") + "..."
+		}
+		return content
+	}
+
+	// The snippet does not cut characters in half
+	start := max(match[0]-searchSnippetRadius, 0)
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	end := min(match[1]+searchSnippetRadius, len(content))
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	snippet := strings.ToValidUTF8(content[start:end], "")
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(content) {
+		snippet += "..."
+	}
+	return snippet
+}
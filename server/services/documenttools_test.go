@@ -0,0 +1,47 @@
+//go:build !goexperiment.jsonv2
+
+package services_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"textly/services"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestSearchDocumentsSnippetsNonASCIIContent(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, app, "owner@example.com", true)
+
+	// Ⱥ takes 2 bytes and its lowercase ⱥ takes 3, so offsets into lowercased text drift
+	createTestDocument(t, app, owner.Id, "Drift", strings.Repeat("Ⱥ", 300)+" NEEDLE "+strings.Repeat("é", 300), "", false)
+
+	toolbox := services.NewToolbox(&core.RequestEvent{App: app}, owner.Id)
+	call := toolbox.Run(services.ToolCall{Id: "call_1", Name: "search_documents", Arguments: `{"query":"needle"}`})
+	if call.Error != "" {
+		t.Fatalf("search failed: %s", call.Error)
+	}
+
+	var result struct {
+		Results []struct {
+			Snippet string `json:"snippet"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(call.Result, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 1 {
+		t.Fatalf("expected one result, got %d", len(result.Results))
+	}
+
+	snippet := result.Results[0].Snippet
+	if !strings.Contains(snippet, "Ⱥ NEEDLE é") || !utf8.ValidString(snippet) {
+		t.Fatalf("expected a snippet around the match, got %q", snippet)
+	}
+	if !strings.HasPrefix(snippet, "...Ⱥ") || !strings.HasSuffix(snippet, "é...") {
+		t.Fatalf("expected the snippet to cut whole characters on both sides, got %q", snippet)
+	}
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The DOCX export writes the WordprocessingML parts by hand from the parsed markdown. It
+// only uses a handful of styles, so the files open the same in Word, LibreOffice and
+// Google Docs.
+
+const docxRelationships = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+
+// docxWriter collects the body of word/document.xml along with the hyperlinks and list
+// numberings it refers to
+type docxWriter struct {
+	body bytes.Buffer
+	// links are the targets of the hyperlink relationships, rId3 onwards
+	links []string
+	// orderedLists are the list levels of the ordered lists, each numbered on its own
+	orderedLists []int
+}
+
+// RenderBookDOCX writes the export as a Word document
+func RenderBookDOCX(book ExportBook) ([]byte, error) {
+	w := &docxWriter{}
+
+	chapters := bookChapters(book)
+	if book.IsFolder && !isTitleOnlyBook(book) {
+		w.paragraph(docxParagraph{Style: "Title"}, book.Title)
+	}
+
+	for i, chapter := range chapters {
+		// Top level chapters of a folder start on a new page
+		pageBreak := book.IsFolder && !isTitleOnlyBook(book) && (i == 0 || chapter.Level <= 2)
+		w.blocks(ParseMarkdown(ChapterMarkdown(chapter)), docxContext{pageBreak: pageBreak})
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxPackageRels},
+		{"docProps/core.xml", docxCoreProperties(book.Title)},
+		{"word/document.xml", w.document()},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", w.numbering()},
+		{"word/_rels/document.xml.rels", w.documentRels()},
+	}
+
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write([]byte(file.content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// docxContext is where blocks are written: the paragraph style inside quotes, the
+// indentation inside list items and whether the next paragraph starts a new page
+type docxContext struct {
+	style     string
+	indent    int
+	listLevel int
+	pageBreak bool
+}
+
+type docxParagraph struct {
+	Style     string
+	PageBreak bool
+	NumId     int
+	NumLevel  int
+	Indent    int
+	Rule      bool
+}
+
+func (w *docxWriter) blocks(blocks []MarkdownBlock, ctx docxContext) {
+	for _, block := range blocks {
+		switch block.Kind {
+		case BlockHeading:
+			w.inlineParagraph(docxParagraph{Style: fmt.Sprintf("Heading%d", block.Level), PageBreak: ctx.pageBreak, Indent: ctx.indent}, block.Text)
+		case BlockParagraph:
+			w.inlineParagraph(docxParagraph{Style: ctx.style, PageBreak: ctx.pageBreak, Indent: ctx.indent}, block.Text)
+		case BlockCode:
+			w.paragraph(docxParagraph{Style: "Code", PageBreak: ctx.pageBreak, Indent: ctx.indent}, block.Text)
+		case BlockQuote:
+			quoted := ctx
+			quoted.style = "Quote"
+			w.blocks(block.Children, quoted)
+		case BlockList:
+			w.list(block, ctx)
+		case BlockRule:
+			w.paragraph(docxParagraph{Rule: true, PageBreak: ctx.pageBreak, Indent: ctx.indent}, "")
+		case BlockTable:
+			w.table(block.Rows)
+		}
+		ctx.pageBreak = false
+	}
+}
+
+func (w *docxWriter) list(block MarkdownBlock, ctx docxContext) {
+	level := min(ctx.listLevel, 8)
+
+	// Bullets share one numbering, ordered lists get their own so each starts at 1
+	numId := 1
+	if block.Ordered {
+		w.orderedLists = append(w.orderedLists, level)
+		numId = len(w.orderedLists) + 1
+	}
+
+	for _, item := range block.Items {
+		itemCtx := docxContext{style: ctx.style, indent: 720 * (level + 1), listLevel: ctx.listLevel + 1}
+		for i, child := range item {
+			if i == 0 && child.Kind == BlockParagraph {
+				w.inlineParagraph(docxParagraph{Style: ctx.style, NumId: numId, NumLevel: level, PageBreak: ctx.pageBreak}, child.Text)
+			} else {
+				w.blocks([]MarkdownBlock{child}, itemCtx)
+			}
+			ctx.pageBreak = false
+		}
+	}
+}
+
+func (w *docxWriter) table(rows [][]string) {
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+
+	w.body.WriteString(`<w:tbl><w:tblPr><w:tblW w:w="0" w:type="auto"/><w:tblBorders>`)
+	for _, side := range []string{"top", "left", "bottom", "right", "insideH", "insideV"} {
+		fmt.Fprintf(&w.body, `<w:%s w:val="single" w:sz="4" w:space="0" w:color="D0D7DE"/>`, side)
+	}
+	w.body.WriteString(`</w:tblBorders><w:tblCellMar><w:left w:w="100" w:type="dxa"/><w:right w:w="100" w:type="dxa"/></w:tblCellMar></w:tblPr><w:tblGrid>`)
+	for i := 0; i < columns; i++ {
+		fmt.Fprintf(&w.body, `<w:gridCol w:w="%d"/>`, 9000/columns)
+	}
+	w.body.WriteString(`</w:tblGrid>`)
+
+	for r, row := range rows {
+		w.body.WriteString(`<w:tr>`)
+		for c := 0; c < columns; c++ {
+			cell := ""
+			if c < len(row) {
+				cell = row[c]
+			}
+			fmt.Fprintf(&w.body, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/></w:tcPr><w:p><w:pPr><w:spacing w:before="40" w:after="40"/></w:pPr>`, 9000/columns)
+			for _, span := range ParseInline(cell) {
+				span.Bold = span.Bold || r == 0
+				w.span(span)
+			}
+			w.body.WriteString(`</w:p></w:tc>`)
+		}
+		w.body.WriteString(`</w:tr>`)
+	}
+	w.body.WriteString(`</w:tbl>`)
+
+	// Keeps two tables in a row from merging into one
+	w.body.WriteString(`<w:p/>`)
+}
+
+func (w *docxWriter) openParagraph(p docxParagraph) {
+	w.body.WriteString(`<w:p><w:pPr>`)
+	if p.Style != "" {
+		fmt.Fprintf(&w.body, `<w:pStyle w:val="%s"/>`, p.Style)
+	}
+	if p.PageBreak {
+		w.body.WriteString(`<w:pageBreakBefore/>`)
+	}
+	if p.NumId > 0 {
+		fmt.Fprintf(&w.body, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, p.NumLevel, p.NumId)
+	}
+	if p.Rule {
+		w.body.WriteString(`<w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="D0D7DE"/></w:pBdr>`)
+	}
+	if p.Indent > 0 {
+		fmt.Fprintf(&w.body, `<w:ind w:left="%d"/>`, p.Indent)
+	}
+	w.body.WriteString(`</w:pPr>`)
+}
+
+// paragraph writes literal text, keeping its line breaks
+func (w *docxWriter) paragraph(p docxParagraph, text string) {
+	w.openParagraph(p)
+	if text != "" {
+		w.run(InlineSpan{Text: text})
+	}
+	w.body.WriteString(`</w:p>`)
+}
+
+// inlineParagraph writes inline markdown
+func (w *docxWriter) inlineParagraph(p docxParagraph, text string) {
+	w.openParagraph(p)
+	for _, span := range ParseInline(text) {
+		w.span(span)
+	}
+	w.body.WriteString(`</w:p>`)
+}
+
+func (w *docxWriter) span(span InlineSpan) {
+	link := SafeURL(span.Link)
+	if span.Image {
+		// Images are not embedded, they link to their source instead
+		if span.Text == "" {
+			span.Text = span.Link
+		}
+		span.Text = "[" + span.Text + "]"
+	}
+
+	if !isExternalLink(link) {
+		span.Link = ""
+		w.run(span)
+		return
+	}
+
+	w.links = append(w.links, link)
+	fmt.Fprintf(&w.body, `<w:hyperlink r:id="rId%d">`, len(w.links)+2)
+	w.run(span)
+	w.body.WriteString(`</w:hyperlink>`)
+}
+
+func isExternalLink(link string) bool {
+	lower := strings.ToLower(link)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:")
+}
+
+func (w *docxWriter) run(span InlineSpan) {
+	var properties strings.Builder
+	if span.Link != "" {
+		properties.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+	} else if span.Code {
+		properties.WriteString(`<w:rStyle w:val="InlineCode"/>`)
+	}
+	if span.Bold {
+		properties.WriteString(`<w:b/>`)
+	}
+	if span.Italic {
+		properties.WriteString(`<w:i/>`)
+	}
+	if span.Strike {
+		properties.WriteString(`<w:strike/>`)
+	}
+
+	w.body.WriteString(`<w:r>`)
+	if properties.Len() > 0 {
+		w.body.WriteString(`<w:rPr>` + properties.String() + `</w:rPr>`)
+	}
+	for i, line := range strings.Split(span.Text, "\n") {
+		if i > 0 {
+			w.body.WriteString(`<w:br/>`)
+		}
+		for j, part := range strings.Split(line, "\t") {
+			if j > 0 {
+				w.body.WriteString(`<w:tab/>`)
+			}
+			if part != "" {
+				w.body.WriteString(`<w:t xml:space="preserve">` + xmlEscape(part) + `</w:t>`)
+			}
+		}
+	}
+	w.body.WriteString(`</w:r>`)
+}
+
+func (w *docxWriter) document() string {
+	return xml.Header + `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="` + docxRelationships + `"><w:body>` +
+		w.body.String() +
+		`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr></w:body></w:document>`
+}
+
+func (w *docxWriter) documentRels() string {
+	var out strings.Builder
+	out.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	out.WriteString(`<Relationship Id="rId1" Type="` + docxRelationships + `/styles" Target="styles.xml"/>`)
+	out.WriteString(`<Relationship Id="rId2" Type="` + docxRelationships + `/numbering" Target="numbering.xml"/>`)
+	for i, link := range w.links {
+		fmt.Fprintf(&out, `<Relationship Id="rId%d" Type="%s/hyperlink" Target="%s" TargetMode="External"/>`, i+3, docxRelationships, xmlEscape(link))
+	}
+	out.WriteString(`</Relationships>`)
+	return out.String()
+}
+
+// numbering defines bullets and decimal numbers for nine levels of nesting. Every ordered
+// list restarts the numbering at its own level.
+func (w *docxWriter) numbering() string {
+	var out strings.Builder
+	out.WriteString(xml.Header + `<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+
+	bullets := []string{"•", "◦", "▪"}
+	for abstractId, ordered := range []bool{false, true} {
+		fmt.Fprintf(&out, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, abstractId)
+		for level := 0; level < 9; level++ {
+			format, text := "bullet", bullets[level%len(bullets)]
+			if ordered {
+				format, text = "decimal", fmt.Sprintf("%%%d.", level+1)
+			}
+			fmt.Fprintf(&out, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`,
+				level, format, text, 720*(level+1))
+		}
+		out.WriteString(`</w:abstractNum>`)
+	}
+
+	out.WriteString(`<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>`)
+	for i, level := range w.orderedLists {
+		fmt.Fprintf(&out, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/><w:lvlOverride w:ilvl="%d"><w:startOverride w:val="1"/></w:lvlOverride></w:num>`, i+2, level)
+	}
+
+	out.WriteString(`</w:numbering>`)
+	return out.String()
+}
+
+func xmlEscape(text string) string {
+	var out strings.Builder
+	xml.EscapeText(&out, []byte(xmlSafeText(text)))
+	return out.String()
+}
+
+func docxCoreProperties(title string) string {
+	return xml.Header + `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<dc:title>` + xmlEscape(title) + `</dc:title>` +
+		`<dcterms:created xsi:type="dcterms:W3CDTF">` + time.Now().UTC().Format(time.RFC3339) + `</dcterms:created>` +
+		`</cp:coreProperties>`
+}
+
+const docxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>` +
+	`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+	`</Types>`
+
+const docxPackageRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="` + docxRelationships + `/officeDocument" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+	`</Relationships>`
+
+var docxStyles = xml.Header + `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:eastAsia="Calibri" w:cs="Calibri"/><w:sz w:val="22"/><w:szCs w:val="22"/><w:lang w:val="en-US"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:spacing w:before="2400" w:after="480"/><w:jc w:val="center"/></w:pPr><w:rPr><w:b/><w:sz w:val="56"/><w:szCs w:val="56"/></w:rPr></w:style>` +
+	docxHeadingStyle(1, 36) + docxHeadingStyle(2, 30) + docxHeadingStyle(3, 26) + docxHeadingStyle(4, 24) + docxHeadingStyle(5, 22) + docxHeadingStyle(6, 22) +
+	`<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:qFormat/><w:pPr><w:pBdr><w:left w:val="single" w:sz="18" w:space="8" w:color="D0D7DE"/></w:pBdr><w:ind w:left="360"/></w:pPr><w:rPr><w:i/><w:color w:val="656D76"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:shd w:val="clear" w:color="auto" w:fill="EEF1F4"/><w:spacing w:after="160" w:line="240" w:lineRule="auto"/></w:pPr><w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:sz w:val="20"/><w:szCs w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="character" w:styleId="InlineCode"><w:name w:val="Inline Code"/><w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:shd w:val="clear" w:color="auto" w:fill="EEF1F4"/></w:rPr></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0969DA"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`</w:styles>`
+
+func docxHeadingStyle(level, size int) string {
+	return fmt.Sprintf(`<w:style w:type="paragraph" w:styleId="Heading%[1]d"><w:name w:val="heading %[1]d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`+
+		`<w:pPr><w:keepNext/><w:spacing w:before="240" w:after="120"/><w:outlineLvl w:val="%[3]d"/></w:pPr><w:rPr><w:b/><w:sz w:val="%[2]d"/><w:szCs w:val="%[2]d"/></w:rPr></w:style>`, level, size, level-1)
+}
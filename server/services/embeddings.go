@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/openai/openai-go"
+	"modernc.org/sqlite"
+)
+
+const (
+	defaultEmbeddingModel = "text-embedding-3-small"
+	localEmbeddingSize    = 512
+)
+
+// Embedder turns texts into vectors. Model identifies the vector space, vectors of
+// different models must never be compared.
+type Embedder interface {
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// NewEmbedder returns the embedder configured by EMBEDDING_PROVIDER. The "openai" provider
+// goes through the configured OpenAI compatible client, anything else uses the local
+// embedder which needs no network access.
+func NewEmbedder() Embedder {
+	if os.Getenv("EMBEDDING_PROVIDER") == "openai" {
+		model := os.Getenv("EMBEDDING_MODEL")
+		if model == "" {
+			model = defaultEmbeddingModel
+		}
+		return &providerEmbedder{model: model}
+	}
+
+	return &localEmbedder{size: localEmbeddingSize}
+}
+
+type providerEmbedder struct {
+	model string
+}
+
+func (p *providerEmbedder) Model() string {
+	return p.model
+}
+
+func (p *providerEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var client = GetOpenAiClient()
+
+	response, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+		Model: p.model,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range response.Data {
+		if data.Index < 0 || int(data.Index) >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+
+		vector := make([]float32, len(data.Embedding))
+		for i, value := range data.Embedding {
+			vector[i] = float32(value)
+		}
+		vectors[data.Index] = normalizeVector(vector)
+	}
+
+	return vectors, nil
+}
+
+// localEmbedder hashes words and word pairs into a fixed size vector. It only captures
+// lexical overlap, which is enough to develop and run retrieval offline.
+type localEmbedder struct {
+	size int
+}
+
+func (l *localEmbedder) Model() string {
+	return fmt.Sprintf("local-hash-%d", l.size)
+}
+
+func (l *localEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vectors[i] = l.embed(text)
+	}
+	return vectors, nil
+}
+
+func (l *localEmbedder) embed(text string) []float32 {
+	vector := make([]float32, l.size)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	add := func(feature string, weight float32) {
+		hash := fnv.New64a()
+		hash.Write([]byte(feature))
+		sum := hash.Sum64()
+
+		// The sign bit keeps colliding features from always adding up
+		if sum&(1<<63) != 0 {
+			weight = -weight
+		}
+		vector[sum%uint64(l.size)] += weight
+	}
+
+	for i, word := range words {
+		add(word, 1)
+		if i > 0 {
+			add(words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	return normalizeVector(vector)
+}
+
+func normalizeVector(vector []float32) []float32 {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+
+	if norm == 0 {
+		return vector
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
+
+// CosineSimilarity compares two vectors of the same model
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func init() {
+	// Retrieval ranks chunks in the database, so that only the closest ones are loaded
+	sqlite.MustRegisterDeterministicScalarFunction("cosine_similarity", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		var vectors [2][]float32
+		for i, arg := range args {
+			encoded, ok := arg.(string)
+			if !ok {
+				return 0.0, nil
+			}
+
+			vector, err := DecodeVector(encoded)
+			if err != nil {
+				return 0.0, nil
+			}
+			vectors[i] = vector
+		}
+
+		return CosineSimilarity(vectors[0], vectors[1]), nil
+	})
+}
+
+// EncodeVector packs a vector as base64 little endian float32 values for storage
+func EncodeVector(vector []float32) string {
+	buffer := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(buffer[4*i:], math.Float32bits(value))
+	}
+	return base64.StdEncoding.EncodeToString(buffer)
+}
+
+// DecodeVector reverses EncodeVector
+func DecodeVector(encoded string) ([]float32, error) {
+	buffer, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buffer)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector length %d", len(buffer))
+	}
+
+	vector := make([]float32, len(buffer)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buffer[4*i:]))
+	}
+	return vector, nil
+}
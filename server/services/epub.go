@@ -0,0 +1,136 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The EPUB export is an EPUB 3 package with one XHTML file per chapter, plus the
+// EPUB 2 table of contents for older readers
+
+var voidTagPattern = regexp.MustCompile(`<(br|hr|img)(\s[^>]*)?>`)
+
+// RenderBookEPUB writes the export as an e-book
+func RenderBookEPUB(book ExportBook) ([]byte, error) {
+	chapters := bookChapters(book)
+	title := xmlSafeText(book.Title)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	// The mimetype comes first and uncompressed so readers can recognize the file
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mimetype.Write([]byte("application/epub+zip")); err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/content.opf", epubPackage(book, title, len(chapters))},
+		{"OEBPS/nav.xhtml", epubNav(title, chapters)},
+		{"OEBPS/toc.ncx", epubNCX(book, title, chapters)},
+		{"OEBPS/style.css", transcriptStyle + "\n" + epubStyle},
+	}
+	for i, chapter := range chapters {
+		body := toXHTML(RenderMarkdownHTML(ChapterMarkdown(chapter)))
+		files = append(files, struct {
+			name    string
+			content string
+		}{fmt.Sprintf("OEBPS/chapter-%d.xhtml", i+1), epubPage(chapter.Title, body)})
+	}
+
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write([]byte(file.content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toXHTML closes the void elements of the rendered HTML, which XHTML requires
+func toXHTML(fragment string) string {
+	return voidTagPattern.ReplaceAllStringFunc(fragment, func(tag string) string {
+		return strings.TrimSuffix(tag, ">") + "/>"
+	})
+}
+
+func epubPage(title, body string) string {
+	return xml.Header + "<!DOCTYPE html>\n" +
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en" lang="en">` + "\n" +
+		"<head>\n<meta charset=\"utf-8\"/>\n<title>" + html.EscapeString(xmlSafeText(title)) + "</title>\n" +
+		"<link rel=\"stylesheet\" type=\"text/css\" href=\"style.css\"/>\n</head>\n<body>\n" +
+		body + "</body>\n</html>\n"
+}
+
+func epubPackage(book ExportBook, title string, chapters int) string {
+	var out strings.Builder
+	out.WriteString(xml.Header + `<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="en">` + "\n")
+	out.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	fmt.Fprintf(&out, "<dc:identifier id=\"book-id\">urn:textly:document:%s</dc:identifier>\n", xmlEscape(book.Id))
+	fmt.Fprintf(&out, "<dc:title>%s</dc:title>\n<dc:language>en</dc:language>\n", xmlEscape(title))
+	fmt.Fprintf(&out, "<meta property=\"dcterms:modified\">%s</meta>\n</metadata>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+
+	out.WriteString("<manifest>\n")
+	out.WriteString("<item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	out.WriteString("<item id=\"ncx\" href=\"toc.ncx\" media-type=\"application/x-dtbncx+xml\"/>\n")
+	out.WriteString("<item id=\"style\" href=\"style.css\" media-type=\"text/css\"/>\n")
+	for i := 1; i <= chapters; i++ {
+		fmt.Fprintf(&out, "<item id=\"chapter-%d\" href=\"chapter-%d.xhtml\" media-type=\"application/xhtml+xml\"/>\n", i, i)
+	}
+	out.WriteString("</manifest>\n<spine toc=\"ncx\">\n")
+	for i := 1; i <= chapters; i++ {
+		fmt.Fprintf(&out, "<itemref idref=\"chapter-%d\"/>\n", i)
+	}
+	out.WriteString("</spine>\n</package>\n")
+	return out.String()
+}
+
+func epubNav(title string, chapters []ExportChapter) string {
+	var toc strings.Builder
+	writeTableOfContents(&toc, chapters, func(i int) string { return fmt.Sprintf("chapter-%d.xhtml", i+1) })
+
+	return xml.Header + "<!DOCTYPE html>\n" +
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en" lang="en">` + "\n" +
+		"<head>\n<meta charset=\"utf-8\"/>\n<title>" + html.EscapeString(title) + "</title>\n</head>\n<body>\n" +
+		"<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n" + toc.String() + "</nav>\n</body>\n</html>\n"
+}
+
+// epubNCX lists the chapters flat, nesting is left to the EPUB 3 navigation
+func epubNCX(book ExportBook, title string, chapters []ExportChapter) string {
+	var out strings.Builder
+	out.WriteString(xml.Header + `<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">` + "\n")
+	fmt.Fprintf(&out, "<head>\n<meta name=\"dtb:uid\" content=\"urn:textly:document:%s\"/>\n</head>\n", xmlEscape(book.Id))
+	fmt.Fprintf(&out, "<docTitle><text>%s</text></docTitle>\n<navMap>\n", xmlEscape(title))
+	for i, chapter := range chapters {
+		fmt.Fprintf(&out, "<navPoint id=\"nav-%d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"chapter-%d.xhtml\"/></navPoint>\n",
+			i+1, i+1, xmlEscape(chapter.Title), i+1)
+	}
+	out.WriteString("</navMap>\n</ncx>\n")
+	return out.String()
+}
+
+const epubContainer = xml.Header + `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">` +
+	`<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`
+
+const epubStyle = `body{max-width:none;margin:0;padding:0}
+img{max-width:100%}`
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"textly/queries"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// TranscriptOptions selects the optional parts of a conversation export
+type TranscriptOptions struct {
+	Thinking   bool
+	Models     bool
+	Timestamps bool
+	Usage      bool
+}
+
+// ParseTranscriptOptions reads a comma separated include list such as "thinking,usage".
+// Model names and timestamps are included unless the list is given without them.
+func ParseTranscriptOptions(include string) TranscriptOptions {
+	if include == "" {
+		return TranscriptOptions{Models: true, Timestamps: true}
+	}
+
+	var options TranscriptOptions
+	for _, part := range strings.Split(include, ",") {
+		switch strings.TrimSpace(strings.ToLower(part)) {
+		case "thinking":
+			options.Thinking = true
+		case "models":
+			options.Models = true
+		case "timestamps":
+			options.Timestamps = true
+		case "usage":
+			options.Usage = true
+		case "all":
+			options = TranscriptOptions{Thinking: true, Models: true, Timestamps: true, Usage: true}
+		}
+	}
+	return options
+}
+
+var fileNameUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ExportFileName builds a download name such as "design-review-abc123.md"
+func ExportFileName(title, id, extension string) string {
+	return exportFileName(title, "conversation", id, extension)
+}
+
+// DocumentExportFileName is ExportFileName for documents and folders
+func DocumentExportFileName(title, id, extension string) string {
+	return exportFileName(title, "document", id, extension)
+}
+
+func exportFileName(title, fallback, id, extension string) string {
+	slug := strings.Trim(fileNameUnsafe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > 60 {
+		slug = strings.TrimRight(slug[:60], "-")
+	}
+	if slug == "" {
+		slug = fallback
+	}
+	return slug + "-" + id + "." + extension
+}
+
+// formatExportTime turns a stored date into a readable UTC timestamp
+func formatExportTime(value string) string {
+	parsed, err := types.ParseDateTime(value)
+	if err != nil || parsed.IsZero() {
+		return value
+	}
+	return parsed.Time().UTC().Format("2006-01-02 15:04 UTC")
+}
+
+func usageLine(inputTokens, outputTokens, reasoningTokens, cost string) string {
+	line := fmt.Sprintf("%s input tokens, %s output tokens", inputTokens, outputTokens)
+	if reasoningTokens != "" && reasoningTokens != "0" {
+		line += fmt.Sprintf(", %s reasoning tokens", reasoningTokens)
+	}
+	if parsed, err := strconv.ParseFloat(cost, 64); err == nil && parsed > 0 {
+		line += fmt.Sprintf(", $%.4f", parsed)
+	}
+	return line
+}
+
+// RenderTranscriptMarkdown writes the conversation as a markdown transcript
+func RenderTranscriptMarkdown(conversation *queries.Conversation, messages []*queries.ConversationMessage, options TranscriptOptions) string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "# %s\n\n", conversation.Title)
+	if options.Timestamps {
+		fmt.Fprintf(&out, "_Started %s_\n\n", formatExportTime(conversation.Created))
+	}
+	if options.Usage {
+		fmt.Fprintf(&out, "_Total usage: %s_\n\n", usageLine(conversation.InputTokens, conversation.OutputTokens, conversation.ReasoningTokens, conversation.Cost))
+	}
+
+	for _, message := range messages {
+		out.WriteString("---\n\n")
+
+		out.WriteString("**User**")
+		if options.Timestamps {
+			fmt.Fprintf(&out, " · %s", formatExportTime(message.Created))
+		}
+		out.WriteString("\n\n")
+		out.WriteString(strings.TrimSpace(message.UserMessage) + "\n\n")
+
+		out.WriteString("**Assistant**")
+		if options.Models && message.Model != "" {
+			fmt.Fprintf(&out, " · `%s`", message.Model)
+		}
+		out.WriteString("\n\n")
+
+		if options.Thinking && strings.TrimSpace(message.ThinkingContent) != "" {
+			out.WriteString("<details>\n<summary>Thinking</summary>\n\n")
+			out.WriteString(strings.TrimSpace(message.ThinkingContent) + "\n\n")
+			out.WriteString("</details>\n\n")
+		}
+
+		out.WriteString(strings.TrimSpace(message.ResponseMessage) + "\n\n")
+
+		if options.Usage {
+			fmt.Fprintf(&out, "_%s_\n\n", usageLine(message.InputTokens, message.OutputTokens, message.ReasoningTokens, message.Cost))
+		}
+	}
+
+	return out.String()
+}
+
+const transcriptStyle = `body{font-family:system-ui,-apple-system,sans-serif;max-width:760px;margin:2rem auto;padding:0 1rem;line-height:1.6;color:#1f2328}
+.meta{color:#656d76;font-size:.875rem}
+.turn{border-top:1px solid #d0d7de;padding:1rem 0}
+.role{font-weight:600}
+.assistant{background:#f6f8fa;border-radius:6px;padding:.5rem 1rem;margin-top:.5rem}
+details{color:#656d76;margin:.5rem 0}
+pre{background:#eef1f4;padding:.75rem;overflow-x:auto;border-radius:6px}
+code{font-family:ui-monospace,monospace;font-size:.875em}
+table{border-collapse:collapse}th,td{border:1px solid #d0d7de;padding:.25rem .5rem}
+blockquote{border-left:3px solid #d0d7de;margin-left:0;padding-left:1rem;color:#656d76}`
+
+// RenderTranscriptHTML writes the conversation as a standalone HTML page
+func RenderTranscriptHTML(conversation *queries.Conversation, messages []*queries.ConversationMessage, options TranscriptOptions) string {
+	var out strings.Builder
+
+	title := html.EscapeString(conversation.Title)
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n", title, transcriptStyle)
+	fmt.Fprintf(&out, "<h1>%s</h1>\n", title)
+
+	if options.Timestamps {
+		fmt.Fprintf(&out, "<p class=\"meta\">Started %s</p>\n", html.EscapeString(formatExportTime(conversation.Created)))
+	}
+	if options.Usage {
+		fmt.Fprintf(&out, "<p class=\"meta\">Total usage: %s</p>\n", html.EscapeString(usageLine(conversation.InputTokens, conversation.OutputTokens, conversation.ReasoningTokens, conversation.Cost)))
+	}
+
+	for _, message := range messages {
+		out.WriteString("<section class=\"turn\">\n")
+
+		out.WriteString("<div class=\"role\">User")
+		if options.Timestamps {
+			fmt.Fprintf(&out, " <span class=\"meta\">%s</span>", html.EscapeString(formatExportTime(message.Created)))
+		}
+		out.WriteString("</div>\n")
+		out.WriteString(RenderMarkdownHTML(message.UserMessage))
+
+		out.WriteString("<div class=\"assistant\">\n<div class=\"role\">Assistant")
+		if options.Models && message.Model != "" {
+			fmt.Fprintf(&out, " <span class=\"meta\">%s</span>", html.EscapeString(message.Model))
+		}
+		out.WriteString("</div>\n")
+
+		if options.Thinking && strings.TrimSpace(message.ThinkingContent) != "" {
+			out.WriteString("<details>\n<summary>Thinking</summary>\n")
+			out.WriteString(RenderMarkdownHTML(message.ThinkingContent))
+			out.WriteString("</details>\n")
+		}
+
+		out.WriteString(RenderMarkdownHTML(message.ResponseMessage))
+
+		if options.Usage {
+			fmt.Fprintf(&out, "<p class=\"meta\">%s</p>\n", html.EscapeString(usageLine(message.InputTokens, message.OutputTokens, message.ReasoningTokens, message.Cost)))
+		}
+		out.WriteString("</div>\n</section>\n")
+	}
+
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
+
+// RenderDocumentHTML writes a document as a standalone HTML page. The title is shown as
+// the heading unless the content already starts with one.
+func RenderDocumentHTML(title, content string) string {
+	var out strings.Builder
+
+	escaped := html.EscapeString(title)
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n", escaped, transcriptStyle)
+
+	if !strings.HasPrefix(strings.TrimSpace(content), "# ") {
+		fmt.Fprintf(&out, "<h1>%s</h1>\n", escaped)
+	}
+	out.WriteString(RenderMarkdownHTML(content))
+
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
+
+// TranscriptUsage is the token usage of an exported conversation or message
+type TranscriptUsage struct {
+	InputTokens     int64   `json:"input_tokens"`
+	OutputTokens    int64   `json:"output_tokens"`
+	ReasoningTokens int64   `json:"reasoning_tokens"`
+	Cost            float64 `json:"cost"`
+}
+
+// TranscriptMessage is one turn of the JSON export
+type TranscriptMessage struct {
+	Role     string           `json:"role"`
+	Content  string           `json:"content"`
+	Thinking string           `json:"thinking,omitempty"`
+	Model    string           `json:"model,omitempty"`
+	Created  string           `json:"created,omitempty"`
+	Usage    *TranscriptUsage `json:"usage,omitempty"`
+}
+
+// Transcript is the documented JSON export format. Unlike the API responses it lists
+// user and assistant turns separately, the way chat transcripts are usually exchanged.
+type Transcript struct {
+	Version  int                 `json:"version"`
+	Id       string              `json:"id"`
+	Title    string              `json:"title"`
+	Created  string              `json:"created,omitempty"`
+	Updated  string              `json:"updated,omitempty"`
+	Usage    *TranscriptUsage    `json:"usage,omitempty"`
+	Messages []TranscriptMessage `json:"messages"`
+}
+
+func parseUsage(inputTokens, outputTokens, reasoningTokens, cost string) *TranscriptUsage {
+	usage := &TranscriptUsage{}
+	usage.InputTokens, _ = strconv.ParseInt(inputTokens, 10, 64)
+	usage.OutputTokens, _ = strconv.ParseInt(outputTokens, 10, 64)
+	usage.ReasoningTokens, _ = strconv.ParseInt(reasoningTokens, 10, 64)
+	usage.Cost, _ = strconv.ParseFloat(cost, 64)
+	return usage
+}
+
+// BuildTranscript converts a conversation into the JSON export format
+func BuildTranscript(conversation *queries.Conversation, messages []*queries.ConversationMessage, options TranscriptOptions) Transcript {
+	transcript := Transcript{
+		Version:  1,
+		Id:       conversation.Id,
+		Title:    conversation.Title,
+		Messages: make([]TranscriptMessage, 0, 2*len(messages)),
+	}
+
+	if options.Timestamps {
+		transcript.Created = conversation.Created
+		transcript.Updated = conversation.Updated
+	}
+	if options.Usage {
+		transcript.Usage = parseUsage(conversation.InputTokens, conversation.OutputTokens, conversation.ReasoningTokens, conversation.Cost)
+	}
+
+	for _, message := range messages {
+		user := TranscriptMessage{Role: "user", Content: message.UserMessage}
+		assistant := TranscriptMessage{Role: "assistant", Content: message.ResponseMessage}
+
+		if options.Timestamps {
+			user.Created = message.Created
+			assistant.Created = message.Created
+		}
+		if options.Models {
+			assistant.Model = message.Model
+		}
+		if options.Thinking {
+			assistant.Thinking = message.ThinkingContent
+		}
+		if options.Usage {
+			assistant.Usage = parseUsage(message.InputTokens, message.OutputTokens, message.ReasoningTokens, message.Cost)
+		}
+
+		transcript.Messages = append(transcript.Messages, user, assistant)
+	}
+
+	return transcript
+}
+
+// RenderTranscript renders the conversation in one of the export formats and returns
+// the content with its MIME type
+func RenderTranscript(format string, conversation *queries.Conversation, messages []*queries.ConversationMessage, options TranscriptOptions) ([]byte, string, error) {
+	switch format {
+	case "md", "markdown":
+		return []byte(RenderTranscriptMarkdown(conversation, messages, options)), "text/markdown; charset=utf-8", nil
+	case "html":
+		return []byte(RenderTranscriptHTML(conversation, messages, options)), "text/html; charset=utf-8", nil
+	case "json":
+		data, err := json.MarshalIndent(BuildTranscript(conversation, messages, options), "", "  ")
+		return data, "application/json", err
+	default:
+		return nil, "", fmt.Errorf("unsupported export format %q, use md, json or html", format)
+	}
+}
+
+// TranscriptExtension maps an export format to its file extension
+func TranscriptExtension(format string) string {
+	if format == "markdown" {
+		return "md"
+	}
+	return format
+}
+
+// ExportTimestamp is used to name bulk exports
+func ExportTimestamp() string {
+	return time.Now().UTC().Format("20060102-150405")
+}
@@ -0,0 +1,102 @@
+package services_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"textly/queries"
+	"textly/services"
+)
+
+func exportFixture() (*queries.Conversation, []*queries.ConversationMessage) {
+	conversation := &queries.Conversation{
+		Id:           "conv123",
+		Title:        "Plan <the> trip",
+		InputTokens:  "30",
+		OutputTokens: "50",
+		Cost:         "0.0012",
+		Created:      "2026-03-01 10:00:00.000Z",
+	}
+	messages := []*queries.ConversationMessage{
+		{
+			UserMessage:     "Where should we go?",
+			ResponseMessage: "Try **Lisbon**.",
+			ThinkingContent: "The user likes the sea.",
+			Model:           "gpt-4o",
+			InputTokens:     "30",
+			OutputTokens:    "50",
+			Created:         "2026-03-01 10:00:05.000Z",
+		},
+	}
+	return conversation, messages
+}
+
+func TestRenderTranscriptMarkdownOptions(t *testing.T) {
+	conversation, messages := exportFixture()
+
+	defaults := services.RenderTranscriptMarkdown(conversation, messages, services.ParseTranscriptOptions(""))
+	for _, expected := range []string{"# Plan <the> trip", "2026-03-01 10:00 UTC", "`gpt-4o`", "Try **Lisbon**."} {
+		if !strings.Contains(defaults, expected) {
+			t.Fatalf("expected %q in transcript:\n%s", expected, defaults)
+		}
+	}
+	if strings.Contains(defaults, "The user likes the sea.") || strings.Contains(defaults, "output tokens") {
+		t.Fatalf("thinking and usage should be left out by default:\n%s", defaults)
+	}
+
+	full := services.RenderTranscriptMarkdown(conversation, messages, services.ParseTranscriptOptions("thinking,usage"))
+	if !strings.Contains(full, "The user likes the sea.") || !strings.Contains(full, "50 output tokens") {
+		t.Fatalf("expected thinking and usage in transcript:\n%s", full)
+	}
+	if strings.Contains(full, "`gpt-4o`") {
+		t.Fatalf("model names were not requested:\n%s", full)
+	}
+}
+
+func TestRenderTranscriptHTMLEscapesTitle(t *testing.T) {
+	conversation, messages := exportFixture()
+
+	page := services.RenderTranscriptHTML(conversation, messages, services.ParseTranscriptOptions(""))
+	if !strings.Contains(page, "<h1>Plan &lt;the&gt; trip</h1>") {
+		t.Fatalf("title was not escaped:\n%s", page)
+	}
+	if !strings.Contains(page, "<strong>Lisbon</strong>") {
+		t.Fatalf("response markdown was not rendered:\n%s", page)
+	}
+}
+
+func TestBuildTranscriptJSON(t *testing.T) {
+	conversation, messages := exportFixture()
+
+	content, contentType, err := services.RenderTranscript("json", conversation, messages, services.ParseTranscriptOptions("all"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/json" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+
+	var transcript services.Transcript
+	if err := json.Unmarshal(content, &transcript); err != nil {
+		t.Fatal(err)
+	}
+	if len(transcript.Messages) != 2 || transcript.Messages[0].Role != "user" || transcript.Messages[1].Role != "assistant" {
+		t.Fatalf("unexpected messages %+v", transcript.Messages)
+	}
+	if transcript.Messages[1].Usage == nil || transcript.Messages[1].Usage.OutputTokens != 50 {
+		t.Fatalf("expected assistant usage, got %+v", transcript.Messages[1].Usage)
+	}
+
+	if _, _, err := services.RenderTranscript("pdf", conversation, messages, services.TranscriptOptions{}); err == nil {
+		t.Fatal("expected an error for an unsupported format")
+	}
+}
+
+func TestExportFileName(t *testing.T) {
+	if name := services.ExportFileName("Plan <the> trip!", "abc", "md"); name != "plan-the-trip-abc.md" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+	if name := services.ExportFileName("???", "abc", "json"); name != "conversation-abc.json" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+}
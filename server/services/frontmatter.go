@@ -0,0 +1,385 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Front matter is the YAML block between "---" lines at the top of a markdown file, as
+// written by Obsidian, Jekyll and Hugo. The parser below covers the YAML those tools
+// produce: nested mappings, block and flow sequences, quoted and block scalars. Anchors,
+// tags and multi-document streams are not supported.
+
+// ParseFrontMatter splits the front matter from the markdown body. Files without front
+// matter are returned as they are with no fields.
+func ParseFrontMatter(text string) (map[string]any, string, error) {
+	text = strings.TrimPrefix(strings.ReplaceAll(text, "\r\n", "\n"), "\ufeff")
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, text, nil
+	}
+
+	lines := strings.Split(text, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if line := strings.TrimRight(lines[i], " \t"); line == "---" || line == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, "", fmt.Errorf("front matter is not closed with ---")
+	}
+
+	fields, err := ParseYAMLMapping(strings.Join(lines[1:end], "\n"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	body := strings.TrimLeft(strings.Join(lines[end+1:], "\n"), "\n")
+	return fields, body, nil
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// ParseYAMLMapping parses a YAML document whose top level is a mapping. Values are
+// strings, int64, float64, bool, nil, []any and map[string]any, ready for JSON.
+func ParseYAMLMapping(text string) (map[string]any, error) {
+	var lines []yamlLine
+	for i, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(line) - len(strings.TrimLeft(line, " ")), text: strings.TrimRight(strings.TrimLeft(line, " "), " \t")})
+	}
+
+	p := &yamlParser{lines: lines}
+	p.skipBlank()
+	if p.done() {
+		return map[string]any{}, nil
+	}
+
+	value, err := p.block(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if !p.done() {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected key: value pairs")
+	}
+	return fields, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) done() bool {
+	return p.pos >= len(p.lines)
+}
+
+// skipBlank moves past empty lines and comments
+func (p *yamlParser) skipBlank() {
+	for !p.done() && (p.lines[p.pos].text == "" || strings.HasPrefix(p.lines[p.pos].text, "#")) {
+		p.pos++
+	}
+}
+
+// block parses the mapping or sequence starting at the current line
+func (p *yamlParser) block(indent int) (any, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	fields := make(map[string]any)
+
+	for p.skipBlank(); !p.done(); p.skipBlank() {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent || isSequenceItem(line.text) {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+
+		key, rest, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		if _, exists := fields[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		p.pos++
+
+		value, err := p.value(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+
+	return fields, nil
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	items := []any{}
+
+	for p.skipBlank(); !p.done(); p.skipBlank() {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && !isSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if _, _, err := splitYAMLKey(rest); err == nil && !strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "{") && !isQuoted(rest) {
+			// "- key: value" starts a mapping indented like its first key
+			p.lines[p.pos] = yamlLine{number: line.number, indent: line.indent + len(line.text) - len(rest), text: rest}
+			value, err := p.mapping(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+
+		p.pos++
+		value, err := p.value(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+
+	return items, nil
+}
+
+// value parses what follows a key or a sequence dash: an inline scalar, a block scalar
+// or a nested block on the following lines
+func (p *yamlParser) value(rest string, indent int) (any, error) {
+	rest = stripYAMLComment(rest)
+
+	if rest == "|" || rest == ">" || rest == "|-" || rest == ">-" || rest == "|+" || rest == ">+" {
+		return p.blockScalar(rest, indent), nil
+	}
+
+	if rest != "" {
+		return parseYAMLScalar(rest)
+	}
+
+	p.skipBlank()
+	if p.done() {
+		return nil, nil
+	}
+
+	next := p.lines[p.pos]
+	// Sequences are often written at the same indentation as their key
+	if next.indent > indent || (next.indent == indent && isSequenceItem(next.text)) {
+		return p.block(next.indent)
+	}
+	return nil, nil
+}
+
+// blockScalar reads the more indented lines that follow a | or > indicator
+func (p *yamlParser) blockScalar(indicator string, indent int) string {
+	var lines []string
+	blockIndent := -1
+	for ; !p.done(); p.pos++ {
+		line := p.lines[p.pos]
+		if line.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		lines = append(lines, strings.Repeat(" ", max(line.indent-blockIndent, 0))+line.text)
+	}
+
+	// Trailing blank lines belong to whatever follows
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	text := strings.Join(lines, "\n")
+	if indicator[0] == '>' {
+		text = foldYAMLLines(lines)
+	}
+	if !strings.HasSuffix(indicator, "-") && text != "" {
+		text += "\n"
+	}
+	return text
+}
+
+// foldYAMLLines joins the lines of a folded scalar, keeping blank lines as line breaks
+func foldYAMLLines(lines []string) string {
+	var out strings.Builder
+	for i, line := range lines {
+		switch {
+		case line == "":
+			out.WriteString("\n")
+		case i > 0 && lines[i-1] != "":
+			out.WriteString(" " + line)
+		default:
+			out.WriteString(line)
+		}
+	}
+	return out.String()
+}
+
+// splitYAMLKey splits "key: value" and "key:" lines
+func splitYAMLKey(text string) (string, string, error) {
+	if isQuoted(text) {
+		quote := text[0]
+		end := strings.IndexByte(text[1:], quote)
+		if end < 0 || !strings.HasPrefix(text[end+2:], ":") {
+			return "", "", fmt.Errorf("expected key: value")
+		}
+		key, err := parseYAMLScalar(text[:end+2])
+		if err != nil {
+			return "", "", err
+		}
+		return fmt.Sprint(key), strings.TrimSpace(text[end+3:]), nil
+	}
+
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(strings.TrimSuffix(text, ":")), "", nil
+	}
+
+	key, rest, found := strings.Cut(text, ": ")
+	if !found || strings.TrimSpace(key) == "" {
+		return "", "", fmt.Errorf("expected key: value")
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(rest), nil
+}
+
+func isQuoted(text string) bool {
+	return strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'")
+}
+
+// stripYAMLComment removes a trailing " # comment" outside of quotes
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || text[i-1] == ' ' || text[i-1] == '[' || text[i-1] == ',' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimSpace(text[:i])
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+func parseYAMLScalar(text string) (any, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "["):
+		return parseYAMLFlowSequence(text)
+	}
+
+	switch strings.ToLower(text) {
+	case "null", "~":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && strings.ContainsAny(text, "0123456789") && !strings.ContainsAny(text, "xXpP_") {
+		return f, nil
+	}
+	return text, nil
+}
+
+// parseYAMLFlowSequence parses "[a, 'b, c', 3]". Nested flow collections are not supported.
+func parseYAMLFlowSequence(text string) ([]any, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("unclosed sequence %s", text)
+	}
+
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	items := []any{}
+	if inner == "" {
+		return items, nil
+	}
+
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			return nil, fmt.Errorf("nested collections are not supported in %s", text)
+		case c == ',':
+			parts = append(parts, inner[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, inner[start:])
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		value, err := parseYAMLScalar(part)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
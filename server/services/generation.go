@@ -0,0 +1,145 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"textly/queries"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/shared"
+)
+
+const (
+	defaultTemperature     = 0.7
+	defaultMaxTokens       = int64(8000)
+	defaultReasoningEffort = "medium"
+	maxStopSequences       = 4
+)
+
+var reasoningEfforts = []string{"low", "medium", "high"}
+
+// GenerationParams are the sampling options of a chat request. Unset fields fall back
+// to the conversation settings and then to the service defaults.
+type GenerationParams struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxTokens       *int64   `json:"max_tokens,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	Seed            *int64   `json:"seed,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"`
+}
+
+// Merge returns the params with every field set in override taking precedence
+func (p GenerationParams) Merge(override GenerationParams) GenerationParams {
+	merged := p
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		merged.MaxTokens = override.MaxTokens
+	}
+	if override.Stop != nil {
+		merged.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	if override.ReasoningEffort != "" {
+		merged.ReasoningEffort = override.ReasoningEffort
+	}
+	return merged
+}
+
+// Effective fills in the defaults so the stored params describe exactly what was sent
+func (p GenerationParams) Effective(useReasoning bool) GenerationParams {
+	effective := p
+	if effective.Temperature == nil {
+		temperature := defaultTemperature
+		effective.Temperature = &temperature
+	}
+	if effective.MaxTokens == nil {
+		maxTokens := defaultMaxTokens
+		effective.MaxTokens = &maxTokens
+	}
+	if !useReasoning {
+		effective.ReasoningEffort = ""
+	} else if effective.ReasoningEffort == "" {
+		effective.ReasoningEffort = defaultReasoningEffort
+	}
+	return effective
+}
+
+// Validate checks the params against their ranges and the capabilities of the model.
+// A nil model only gets the range checks. The reasoning effort only has to suit the model
+// when reasoning is used, since it is left out of the request otherwise: settings stored
+// for a reasoning model keep working when a turn goes to another one.
+func (p GenerationParams) Validate(model *queries.AIModel, useReasoning bool) error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1")
+	}
+
+	if p.MaxTokens != nil && *p.MaxTokens < 1 {
+		return fmt.Errorf("max_tokens must be at least 1")
+	}
+
+	if len(p.Stop) > maxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed", maxStopSequences)
+	}
+
+	if p.ReasoningEffort != "" && !slices.Contains(reasoningEfforts, p.ReasoningEffort) {
+		return fmt.Errorf("reasoning_effort must be one of low, medium or high")
+	}
+
+	if model == nil {
+		return nil
+	}
+
+	if p.MaxTokens != nil && model.MaxOutputTokens > 0 && *p.MaxTokens > model.MaxOutputTokens {
+		return fmt.Errorf("max_tokens exceeds the %d tokens supported by %s", model.MaxOutputTokens, model.Identifier)
+	}
+
+	if useReasoning && p.ReasoningEffort != "" {
+		capabilities, err := model.GetCapabilities()
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(capabilities, "reasoning") && !slices.Contains(capabilities, "reasoningsuffix") {
+			return fmt.Errorf("%s does not support reasoning", model.Identifier)
+		}
+	}
+
+	return nil
+}
+
+// apply sets the params on a provider request, defaults included
+func (p GenerationParams) apply(params *openai.ChatCompletionNewParams, useReasoning bool) {
+	effective := p.Effective(useReasoning)
+
+	params.Temperature = param.NewOpt(*effective.Temperature)
+	params.MaxTokens = param.NewOpt(*effective.MaxTokens)
+
+	if effective.TopP != nil {
+		params.TopP = param.NewOpt(*effective.TopP)
+	}
+
+	if len(effective.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: effective.Stop}
+	}
+
+	if effective.Seed != nil {
+		params.Seed = param.NewOpt(*effective.Seed)
+	}
+
+	if effective.ReasoningEffort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(effective.ReasoningEffort)
+	}
+}
@@ -0,0 +1,86 @@
+package services_test
+
+import (
+	"testing"
+	"textly/queries"
+	"textly/services"
+)
+
+func TestGenerationParamsValidate(t *testing.T) {
+	temperature := 2.5
+	topP := 0.9
+	maxTokens := int64(16000)
+
+	reasoningModel := &queries.AIModel{Identifier: "reasoner", Capabilities: `["reasoning"]`, MaxOutputTokens: 8000}
+	plainModel := &queries.AIModel{Identifier: "plain", Capabilities: `["internet"]`}
+
+	cases := []struct {
+		name         string
+		params       services.GenerationParams
+		model        *queries.AIModel
+		useReasoning bool
+		wantErr      bool
+	}{
+		{"empty", services.GenerationParams{}, plainModel, false, false},
+		{"temperature out of range", services.GenerationParams{Temperature: &temperature}, nil, false, true},
+		{"top_p in range", services.GenerationParams{TopP: &topP}, nil, false, false},
+		{"too many stop sequences", services.GenerationParams{Stop: []string{"a", "b", "c", "d", "e"}}, nil, false, true},
+		{"unknown effort", services.GenerationParams{ReasoningEffort: "extreme"}, nil, false, true},
+		{"effort on reasoning model", services.GenerationParams{ReasoningEffort: "high"}, reasoningModel, true, false},
+		{"effort on plain model", services.GenerationParams{ReasoningEffort: "high"}, plainModel, true, true},
+		{"effort on plain model without reasoning", services.GenerationParams{ReasoningEffort: "high"}, plainModel, false, false},
+		{"max tokens above model limit", services.GenerationParams{MaxTokens: &maxTokens}, reasoningModel, false, true},
+		{"max tokens without model limit", services.GenerationParams{MaxTokens: &maxTokens}, plainModel, false, false},
+	}
+
+	for _, c := range cases {
+		err := c.params.Validate(c.model, c.useReasoning)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: Validate error = %v, want error %v", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestGenerationParamsMergeAndEffective(t *testing.T) {
+	low := 0.2
+	high := 0.9
+	seed := int64(42)
+
+	settings := services.GenerationParams{Temperature: &low, Seed: &seed, ReasoningEffort: "low"}
+	merged := settings.Merge(services.GenerationParams{Temperature: &high})
+
+	if *merged.Temperature != high || *merged.Seed != seed || merged.ReasoningEffort != "low" {
+		t.Fatalf("unexpected merge result: %+v", merged)
+	}
+
+	effective := merged.Effective(false)
+	if effective.MaxTokens == nil || effective.ReasoningEffort != "" {
+		t.Fatalf("defaults not applied: %+v", effective)
+	}
+
+	if defaulted := (services.GenerationParams{}).Effective(true); defaulted.ReasoningEffort != "medium" || *defaulted.Temperature != 0.7 {
+		t.Fatalf("unexpected defaults: %+v", defaulted)
+	}
+}
+
+func TestStoredReasoningEffortAllowsTurnsWithoutReasoning(t *testing.T) {
+	plainModel := &queries.AIModel{Identifier: "plain", Capabilities: `["internet"]`}
+
+	// A conversation set up for a reasoning model, continued with a plain one
+	settings := services.GenerationParams{ReasoningEffort: "high"}
+	if err := settings.Validate(nil, false); err != nil {
+		t.Fatalf("settings should be stored: %v", err)
+	}
+
+	generation := settings.Merge(services.GenerationParams{})
+	if err := generation.Validate(plainModel, false); err != nil {
+		t.Fatalf("a turn without reasoning should not check the stored effort: %v", err)
+	}
+	if effective := generation.Effective(false); effective.ReasoningEffort != "" {
+		t.Fatalf("the effort should not be sent without reasoning: %+v", effective)
+	}
+
+	if err := generation.Validate(plainModel, true); err == nil {
+		t.Fatal("asking a plain model to reason should still fail")
+	}
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// MaxImportSize bounds the uploaded zip archive
+	MaxImportSize = 50 << 20
+	// maxImportFiles and maxImportBytes bound what the archive unpacks to
+	maxImportFiles    = 5000
+	maxImportBytes    = 200 << 20
+	maxImportFileSize = 5 << 20
+)
+
+var (
+	wikilinkPattern      = regexp.MustCompile(`(!?)\[\[([^\[\]\n]+?)\]\]`)
+	markdownFileLink     = regexp.MustCompile(`\]\(([^()\s]+?\.(?:md|markdown))(#[^()\s]*)?\)`)
+	importableExtensions = []string{".md", ".markdown"}
+)
+
+// ImportError is a problem with one file of an import
+type ImportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ImportItem is a document or folder to create. Ids are chosen up front so that links
+// between the imported files can be rewritten before anything is saved.
+type ImportItem struct {
+	Id       string
+	Path     string
+	Parent   string
+	Title    string
+	Content  string
+	Metadata types.JSONRaw
+	IsFolder bool
+}
+
+// ImportPlan lists the documents and folders to create, parents before their children.
+// Parent is empty for the items at the top of the archive.
+type ImportPlan struct {
+	Items []ImportItem
+	// Skipped are the files that are not markdown, such as images in a vault
+	Skipped []string
+	// Errors are the files that cannot be imported
+	Errors []ImportError
+	// Warnings are links that point to nothing in the archive. They are left as written.
+	Warnings []ImportError
+}
+
+// PlanMarkdownImport reads a zip of markdown files, such as an exported folder of notes
+// or an Obsidian vault. Directories become folders, front matter becomes the document
+// metadata, and wikilinks and relative links to other imported files become document
+// links.
+func PlanMarkdownImport(data []byte) (*ImportPlan, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %w", err)
+	}
+
+	plan := &ImportPlan{}
+	folders := make(map[string]string)
+	var total int64
+	var files int
+
+	for _, file := range archive.File {
+		name, ok := importPath(file.Name)
+		if !ok {
+			continue
+		}
+
+		if file.FileInfo().IsDir() {
+			plan.folder(folders, name)
+			continue
+		}
+
+		if !slices.Contains(importableExtensions, strings.ToLower(path.Ext(name))) {
+			plan.Skipped = append(plan.Skipped, name)
+			continue
+		}
+
+		if files++; files > maxImportFiles {
+			return nil, fmt.Errorf("the archive has more than %d markdown files", maxImportFiles)
+		}
+
+		if file.UncompressedSize64 > maxImportFileSize {
+			plan.Errors = append(plan.Errors, ImportError{Path: name, Error: fmt.Sprintf("larger than %d MB", maxImportFileSize>>20)})
+			continue
+		}
+
+		content, err := readImportFile(file)
+		if err != nil {
+			plan.Errors = append(plan.Errors, ImportError{Path: name, Error: err.Error()})
+			continue
+		}
+
+		if total += int64(len(content)); total > maxImportBytes {
+			return nil, fmt.Errorf("the archive unpacks to more than %d MB", maxImportBytes>>20)
+		}
+
+		item, err := importDocument(name, content)
+		if err != nil {
+			plan.Errors = append(plan.Errors, ImportError{Path: name, Error: err.Error()})
+			continue
+		}
+
+		item.Parent = plan.folder(folders, path.Dir(name))
+		plan.Items = append(plan.Items, item)
+	}
+
+	plan.rewriteLinks()
+	return plan, nil
+}
+
+// importPath normalizes a path inside the archive. Hidden files and folders, like the
+// .obsidian settings of a vault, and the metadata added by macOS are left out.
+func importPath(name string) (string, bool) {
+	name = strings.Trim(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+func readImportFile(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	// The size in the header is not trusted
+	data, err := io.ReadAll(io.LimitReader(reader, maxImportFileSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxImportFileSize {
+		return "", fmt.Errorf("larger than %d MB", maxImportFileSize>>20)
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("not UTF-8 text")
+	}
+	return string(data), nil
+}
+
+// folder returns the id of the folder at the path, planning it and its parents as needed
+func (plan *ImportPlan) folder(folders map[string]string, dir string) string {
+	if dir == "." || dir == "" {
+		return ""
+	}
+	if id, ok := folders[dir]; ok {
+		return id
+	}
+
+	parent := plan.folder(folders, path.Dir(dir))
+	id := core.GenerateDefaultRandomId()
+	folders[dir] = id
+	plan.Items = append(plan.Items, ImportItem{
+		Id:       id,
+		Path:     dir,
+		Parent:   parent,
+		Title:    path.Base(dir),
+		Metadata: types.JSONRaw("{}"),
+		IsFolder: true,
+	})
+	return id
+}
+
+// importDocument turns one markdown file into a document. The title comes from the front
+// matter, or else from the file name.
+func importDocument(name, content string) (ImportItem, error) {
+	fields, body, err := ParseFrontMatter(content)
+	if err != nil {
+		return ImportItem{}, err
+	}
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+
+	title := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if value, ok := fields["title"].(string); ok && strings.TrimSpace(value) != "" {
+		title = strings.TrimSpace(value)
+		delete(fields, "title")
+	}
+	fields["imported_from"] = name
+
+	metadata, err := json.Marshal(fields)
+	if err != nil {
+		return ImportItem{}, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	return ImportItem{
+		Id:       core.GenerateDefaultRandomId(),
+		Path:     name,
+		Title:    title,
+		Content:  body,
+		Metadata: types.JSONRaw(metadata),
+	}, nil
+}
+
+// rewriteLinks points the wikilinks and relative markdown links between imported files
+// at the created documents, as "[label](doc:ID)"
+func (plan *ImportPlan) rewriteLinks() {
+	resolver := newImportResolver(plan.Items)
+
+	for i := range plan.Items {
+		item := &plan.Items[i]
+		if item.IsFolder {
+			continue
+		}
+
+		dir := path.Dir(item.Path)
+		item.Content = mapOutsideCode(item.Content, func(line string) string {
+			line = wikilinkPattern.ReplaceAllStringFunc(line, func(match string) string {
+				parts := wikilinkPattern.FindStringSubmatch(match)
+				target, label, _ := strings.Cut(parts[2], "|")
+				page, _, _ := strings.Cut(target, "#")
+				if strings.TrimSpace(page) == "" {
+					return match
+				}
+
+				linked := resolver.resolve(dir, page)
+				if linked == nil {
+					// Embedded images and attachments are not imported
+					if parts[1] == "" || slices.Contains(importableExtensions, strings.ToLower(path.Ext(page))) || path.Ext(page) == "" {
+						plan.Warnings = append(plan.Warnings, ImportError{Path: item.Path, Error: fmt.Sprintf("no file found for link %s", match)})
+					}
+					return match
+				}
+
+				if strings.TrimSpace(label) == "" {
+					label = strings.ReplaceAll(strings.TrimSpace(target), "#", " > ")
+				}
+				return "[" + escapeLinkLabel(strings.TrimSpace(label)) + "](doc:" + linked.Id + ")"
+			})
+
+			return markdownFileLink.ReplaceAllStringFunc(line, func(match string) string {
+				parts := markdownFileLink.FindStringSubmatch(match)
+				target, err := url.PathUnescape(parts[1])
+				if err != nil || strings.Contains(target, "://") {
+					return match
+				}
+
+				linked := resolver.resolvePath(path.Join(dir, target))
+				if linked == nil {
+					plan.Warnings = append(plan.Warnings, ImportError{Path: item.Path, Error: fmt.Sprintf("no file found for link %s", parts[1])})
+					return match
+				}
+				return "](doc:" + linked.Id + ")"
+			})
+		})
+	}
+}
+
+// mapOutsideCode applies the function to every line that is not inside a fenced code block
+func mapOutsideCode(content string, fn func(line string) string) string {
+	lines := strings.Split(content, "\n")
+	fence := ""
+	for i, line := range lines {
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if match := fencePattern.FindStringSubmatch(line); match != nil {
+			fence = match[1]
+			continue
+		}
+
+		lines[i] = fn(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func escapeLinkLabel(label string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(label)
+}
+
+// importResolver finds the imported document a link points to, the way Obsidian does:
+// by path when the link has one, else by file name, preferring the closest file
+type importResolver struct {
+	byPath  map[string]*ImportItem
+	byName  map[string][]*ImportItem
+	byTitle map[string][]*ImportItem
+}
+
+func newImportResolver(items []ImportItem) *importResolver {
+	resolver := &importResolver{
+		byPath:  make(map[string]*ImportItem),
+		byName:  make(map[string][]*ImportItem),
+		byTitle: make(map[string][]*ImportItem),
+	}
+
+	for i := range items {
+		item := &items[i]
+		if item.IsFolder {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSuffix(item.Path, path.Ext(item.Path)))
+		resolver.byPath[key] = item
+		resolver.byName[path.Base(key)] = append(resolver.byName[path.Base(key)], item)
+		resolver.byTitle[strings.ToLower(item.Title)] = append(resolver.byTitle[strings.ToLower(item.Title)], item)
+
+		var fields struct {
+			Aliases any `json:"aliases"`
+		}
+		if json.Unmarshal(item.Metadata, &fields) == nil {
+			for _, alias := range importAliases(fields.Aliases) {
+				resolver.byTitle[strings.ToLower(alias)] = append(resolver.byTitle[strings.ToLower(alias)], item)
+			}
+		}
+	}
+
+	return resolver
+}
+
+// importAliases reads the aliases front matter, a list or a single name
+func importAliases(value any) []string {
+	switch aliases := value.(type) {
+	case string:
+		return []string{aliases}
+	case []any:
+		var names []string
+		for _, alias := range aliases {
+			if name, ok := alias.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func (r *importResolver) resolve(dir, target string) *ImportItem {
+	key := strings.ToLower(strings.TrimSpace(target))
+	for _, extension := range importableExtensions {
+		key = strings.TrimSuffix(key, extension)
+	}
+
+	if strings.Contains(key, "/") {
+		if item := r.resolvePath(key); item != nil {
+			return item
+		}
+		if item := r.byPath[strings.ToLower(path.Join(dir, key))]; item != nil {
+			return item
+		}
+
+		// Links may give only the end of the path
+		var candidates []*ImportItem
+		for itemPath, item := range r.byPath {
+			if strings.HasSuffix(itemPath, "/"+key) {
+				candidates = append(candidates, item)
+			}
+		}
+		return closestImport(dir, candidates)
+	}
+
+	if item := closestImport(dir, r.byName[key]); item != nil {
+		return item
+	}
+	return closestImport(dir, r.byTitle[key])
+}
+
+func (r *importResolver) resolvePath(target string) *ImportItem {
+	key := strings.ToLower(strings.Trim(path.Clean("/"+target), "/"))
+	for _, extension := range importableExtensions {
+		key = strings.TrimSuffix(key, extension)
+	}
+	return r.byPath[key]
+}
+
+// closestImport prefers a file in the same folder, then the one with the shortest path
+func closestImport(dir string, candidates []*ImportItem) *ImportItem {
+	var best *ImportItem
+	for _, candidate := range candidates {
+		if path.Dir(candidate.Path) == dir {
+			return candidate
+		}
+		if best == nil || len(candidate.Path) < len(best.Path) || (len(candidate.Path) == len(best.Path) && candidate.Path < best.Path) {
+			best = candidate
+		}
+	}
+	return best
+}
@@ -0,0 +1,143 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	text := "---\n" +
+		"title: \"Trip: Lisbon\"\n" +
+		"tags:\n- travel\n- 'food'\n" +
+		"aliases: [Lisbon, \"Lisboa, PT\"]\n" +
+		"rating: 4 # out of 5\n" +
+		"draft: false\n" +
+		"author:\n  name: Ana\n  links:\n    - site: example.com\n      primary: true\n" +
+		"summary: >\n  Sun and\n  pastries.\n" +
+		"empty:\n" +
+		"---\n\n# Notes\n"
+
+	fields, body, err := services.ParseFrontMatter(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "# Notes\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	want := map[string]any{
+		"title":   "Trip: Lisbon",
+		"tags":    []any{"travel", "food"},
+		"aliases": []any{"Lisbon", "Lisboa, PT"},
+		"rating":  int64(4),
+		"draft":   false,
+		"author":  map[string]any{"name": "Ana", "links": []any{map[string]any{"site": "example.com", "primary": true}}},
+		"summary": "Sun and pastries.\n",
+		"empty":   nil,
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("unexpected fields\n got %#v\nwant %#v", fields, want)
+	}
+
+	if fields, body, err := services.ParseFrontMatter("No front matter\n---\n"); err != nil || fields != nil || body != "No front matter\n---\n" {
+		t.Fatalf("files without front matter should be left alone: %v %v %q", err, fields, body)
+	}
+
+	for _, invalid := range []string{"---\ntitle: x\n", "---\njust text\n---\n", "---\na: 1\n   b: 2\n---\n", "---\na: 1\na: 2\n---\n"} {
+		if _, _, err := services.ParseFrontMatter(invalid); err == nil {
+			t.Fatalf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPlanMarkdownImport(t *testing.T) {
+	data := zipArchive(t, map[string]string{
+		"Vault/Home.md":                  "See [[Lisbon]], [[Trips/Porto|Porto trip]], [[Old name#Food]] and [[Missing]].\n\n```\n[[Lisbon]]\n```\n\n![[photo.png]]",
+		"Vault/Trips/Lisbon.md":          "---\ntitle: Lisbon in spring\naliases: [Old name]\n---\nBack [home](../Home.md)",
+		"Vault/Trips/Porto.md":           "Porto",
+		"Vault/Trips/photo.png":          "png",
+		"Vault/.obsidian/workspace.json": "{}",
+		"Vault/Broken.md":                "---\ntitle: [unclosed\n---\n",
+		"Vault/Empty/":                   "",
+	})
+
+	plan, err := services.PlanMarkdownImport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make(map[string]services.ImportItem)
+	for _, item := range plan.Items {
+		items[item.Path] = item
+	}
+
+	for _, folder := range []string{"Vault", "Vault/Trips", "Vault/Empty"} {
+		if !items[folder].IsFolder {
+			t.Fatalf("folder %s was not planned", folder)
+		}
+	}
+	if items["Vault/Trips/Lisbon.md"].Parent != items["Vault/Trips"].Id || items["Vault/Trips"].Parent != items["Vault"].Id || items["Vault"].Parent != "" {
+		t.Fatal("folders do not follow the archive")
+	}
+
+	lisbon := items["Vault/Trips/Lisbon.md"]
+	if lisbon.Title != "Lisbon in spring" || lisbon.Content != "Back [home](doc:"+items["Vault/Home.md"].Id+")" {
+		t.Fatalf("unexpected document %+v", lisbon)
+	}
+
+	var metadata map[string]any
+	if err := json.Unmarshal(lisbon.Metadata, &metadata); err != nil || metadata["imported_from"] != "Vault/Trips/Lisbon.md" || metadata["title"] != nil {
+		t.Fatalf("unexpected metadata %s", lisbon.Metadata)
+	}
+
+	home := items["Vault/Home.md"].Content
+	for _, link := range []string{
+		"[Lisbon](doc:" + lisbon.Id + ")",
+		"[Porto trip](doc:" + items["Vault/Trips/Porto.md"].Id + ")",
+		"[Old name > Food](doc:" + lisbon.Id + ")",
+		"[[Missing]]",
+		"```\n[[Lisbon]]\n```",
+		"![[photo.png]]",
+	} {
+		if !strings.Contains(home, link) {
+			t.Fatalf("expected %s in\n%s", link, home)
+		}
+	}
+
+	if len(plan.Errors) != 1 || plan.Errors[0].Path != "Vault/Broken.md" {
+		t.Fatalf("unexpected errors %+v", plan.Errors)
+	}
+	if len(plan.Warnings) != 1 || !strings.Contains(plan.Warnings[0].Error, "[[Missing]]") {
+		t.Fatalf("unexpected warnings %+v", plan.Warnings)
+	}
+	if !reflect.DeepEqual(plan.Skipped, []string{"Vault/Trips/photo.png"}) {
+		t.Fatalf("unexpected skipped files %v", plan.Skipped)
+	}
+
+	if _, err := services.PlanMarkdownImport([]byte("not a zip")); err == nil {
+		t.Fatal("expected an error for data that is not a zip")
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"textly/queries"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	indexQueueSize      = 1024
+	indexBatchSize      = 64
+	indexMaxAttempts    = 3
+	indexRetryBaseDelay = 30 * time.Second
+)
+
+var documentIndexer *DocumentIndexer
+
+// DocumentIndexer keeps the document chunks and their embeddings in sync with the
+// documents. Changed documents are queued and indexed one at a time in the background.
+type DocumentIndexer struct {
+	app      core.App
+	embedder Embedder
+	queue    chan string
+
+	mu       sync.Mutex
+	pending  map[string]bool
+	attempts map[string]int
+}
+
+func NewDocumentIndexer(app core.App, embedder Embedder) *DocumentIndexer {
+	return &DocumentIndexer{
+		app:      app,
+		embedder: embedder,
+		queue:    make(chan string, indexQueueSize),
+		pending:  make(map[string]bool),
+		attempts: make(map[string]int),
+	}
+}
+
+// InitializeDocumentIndexer starts the shared indexer and queues the documents that
+// changed while the server was not running
+func InitializeDocumentIndexer(app core.App) {
+	documentIndexer = NewDocumentIndexer(app, NewEmbedder())
+	go documentIndexer.run()
+
+	go func() {
+		if err := documentIndexer.EnqueueUnindexed(); err != nil {
+			log.Printf("Failed to queue unindexed documents: %v", err)
+		}
+	}()
+}
+
+// GetDocumentIndexer returns the shared indexer, nil until it is initialized
+func GetDocumentIndexer() *DocumentIndexer {
+	return documentIndexer
+}
+
+// EnqueueDocumentIndex queues a document on the shared indexer, if it is running
+func EnqueueDocumentIndex(documentId string) {
+	if documentIndexer != nil {
+		documentIndexer.Enqueue(documentId)
+	}
+}
+
+// Embedder returns the embedder the index is built with
+func (i *DocumentIndexer) Embedder() Embedder {
+	return i.embedder
+}
+
+// Enqueue schedules a document for indexing. A document already waiting is not queued
+// twice, and a full queue drops the request since the startup scan catches up later.
+func (i *DocumentIndexer) Enqueue(documentId string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.pending[documentId] {
+		return
+	}
+
+	select {
+	case i.queue <- documentId:
+		i.pending[documentId] = true
+	default:
+		log.Printf("Index queue is full, skipping document %s", documentId)
+	}
+}
+
+// EnqueueUnindexed queues every document whose chunks are missing or outdated
+func (i *DocumentIndexer) EnqueueUnindexed() error {
+	ids, err := queries.GetUnindexedDocumentIds(i.app, i.embedder.Model())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		i.Enqueue(id)
+	}
+	return nil
+}
+
+func (i *DocumentIndexer) run() {
+	for documentId := range i.queue {
+		i.mu.Lock()
+		delete(i.pending, documentId)
+		i.mu.Unlock()
+
+		err := i.IndexDocument(context.Background(), documentId)
+		if err == nil {
+			i.mu.Lock()
+			delete(i.attempts, documentId)
+			i.mu.Unlock()
+			continue
+		}
+
+		i.mu.Lock()
+		i.attempts[documentId]++
+		attempt := i.attempts[documentId]
+		if attempt >= indexMaxAttempts {
+			delete(i.attempts, documentId)
+		}
+		i.mu.Unlock()
+
+		log.Printf("Failed to index document %s (attempt %d/%d): %v", documentId, attempt, indexMaxAttempts, err)
+		if attempt < indexMaxAttempts {
+			time.AfterFunc(indexRetryBaseDelay*time.Duration(attempt), func() {
+				i.Enqueue(documentId)
+			})
+		}
+	}
+}
+
+// IndexDocument chunks and embeds a document, replacing its previous chunks. Documents
+// whose content and embedding model did not change are left alone.
+func (i *DocumentIndexer) IndexDocument(ctx context.Context, documentId string) error {
+	document, err := queries.GetDocumentForIndexing(i.app, documentId)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted documents take their chunks with them through the cascading relation
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if document.IsFolder || document.Content == "" {
+		return queries.ReplaceDocumentChunks(i.app, documentId, nil)
+	}
+
+	hash := sha256.Sum256([]byte(document.Content))
+	contentHash := hex.EncodeToString(hash[:])
+
+	indexedHash, indexedModel, err := queries.GetDocumentChunkState(i.app, documentId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if indexedHash == contentHash && indexedModel == i.embedder.Model() {
+		return nil
+	}
+
+	textChunks := ChunkText(document.Content, defaultChunkSize, defaultChunkOverlap)
+
+	var chunks []*queries.DocumentChunk
+	for start := 0; start < len(textChunks); start += indexBatchSize {
+		batch := textChunks[start:min(start+indexBatchSize, len(textChunks))]
+
+		texts := make([]string, len(batch))
+		for j, chunk := range batch {
+			texts[j] = chunk.Content
+		}
+
+		vectors, err := i.embedder.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+
+		for j, chunk := range batch {
+			chunks = append(chunks, &queries.DocumentChunk{
+				DocumentId:  documentId,
+				UserId:      document.UserId,
+				ChunkIndex:  chunk.Index,
+				StartOffset: chunk.Start,
+				EndOffset:   chunk.End,
+				Content:     chunk.Content,
+				ContentHash: contentHash,
+				Model:       i.embedder.Model(),
+				Embedding:   EncodeVector(vectors[j]),
+			})
+		}
+	}
+
+	return queries.ReplaceDocumentChunks(i.app, documentId, chunks)
+}
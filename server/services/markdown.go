@@ -0,0 +1,565 @@
+package services
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// The markdown support below covers what the editor and the models produce: headings,
+// paragraphs, emphasis, code, quotes, lists, tables, links and images. It is shared by
+// the exports so every format renders documents the same way.
+
+type MarkdownBlockKind string
+
+const (
+	BlockHeading   MarkdownBlockKind = "heading"
+	BlockParagraph MarkdownBlockKind = "paragraph"
+	BlockCode      MarkdownBlockKind = "code"
+	BlockQuote     MarkdownBlockKind = "quote"
+	BlockList      MarkdownBlockKind = "list"
+	BlockRule      MarkdownBlockKind = "rule"
+	BlockTable     MarkdownBlockKind = "table"
+)
+
+// MarkdownBlock is a block level element. Text holds the raw inline markdown of headings
+// and paragraphs and the literal content of code blocks. Quotes keep their content in
+// Children, lists have one slice of blocks per item and tables one slice of cells per row.
+type MarkdownBlock struct {
+	Kind     MarkdownBlockKind
+	Level    int
+	Text     string
+	Language string
+	Ordered  bool
+	Children []MarkdownBlock
+	Items    [][]MarkdownBlock
+	Rows     [][]string
+}
+
+// InlineSpan is a run of text sharing the same formatting. Images use Text as the
+// alternative text and Link as the source.
+type InlineSpan struct {
+	Text   string
+	Bold   bool
+	Italic bool
+	Strike bool
+	Code   bool
+	Link   string
+	Image  bool
+}
+
+var (
+	headingPattern       = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	rulePattern          = regexp.MustCompile(`^\s{0,3}([-*_])(\s*([-*_])){2,}\s*$`)
+	listItemPattern      = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	fencePattern         = regexp.MustCompile("^\\s{0,3}(```+|~~~+)\\s*([\\w+-]*)")
+	tableSeparatorRegexp = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// ParseMarkdown splits markdown into blocks
+func ParseMarkdown(text string) []MarkdownBlock {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	return parseBlocks(lines)
+}
+
+func parseBlocks(lines []string) []MarkdownBlock {
+	var blocks []MarkdownBlock
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			match := fencePattern.FindStringSubmatch(line)
+			fence := match[1]
+			var code []string
+			i++
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++ // closing fence
+			blocks = append(blocks, MarkdownBlock{Kind: BlockCode, Text: strings.Join(code, "\n"), Language: match[2]})
+
+		case headingPattern.MatchString(trimmed):
+			match := headingPattern.FindStringSubmatch(trimmed)
+			blocks = append(blocks, MarkdownBlock{Kind: BlockHeading, Level: len(match[1]), Text: match[2]})
+			i++
+
+		case rulePattern.MatchString(line) && isRule(trimmed):
+			blocks = append(blocks, MarkdownBlock{Kind: BlockRule})
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				content := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(content, " "))
+				i++
+			}
+			blocks = append(blocks, MarkdownBlock{Kind: BlockQuote, Children: parseBlocks(quoted)})
+
+		case listItemPattern.MatchString(line):
+			var block MarkdownBlock
+			block, i = parseList(lines, i)
+			blocks = append(blocks, block)
+
+		case strings.Contains(line, "|") && i+1 < len(lines) && tableSeparatorRegexp.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-"):
+			rows := [][]string{splitTableRow(line)}
+			i += 2
+			for i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != "" {
+				rows = append(rows, splitTableRow(lines[i]))
+				i++
+			}
+			blocks = append(blocks, MarkdownBlock{Kind: BlockTable, Rows: rows})
+
+		default:
+			var paragraph []string
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(paragraph) == 0 || !startsBlock(lines[i])) {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+				i++
+			}
+			blocks = append(blocks, MarkdownBlock{Kind: BlockParagraph, Text: strings.Join(paragraph, "\n")})
+		}
+	}
+
+	return blocks
+}
+
+func isRule(trimmed string) bool {
+	// "- - -" is a rule but "- item" is a list
+	compact := strings.ReplaceAll(trimmed, " ", "")
+	return len(compact) >= 3 && strings.Count(compact, compact[:1]) == len(compact)
+}
+
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return fencePattern.MatchString(line) || headingPattern.MatchString(trimmed) || strings.HasPrefix(trimmed, ">") ||
+		listItemPattern.MatchString(line) || (rulePattern.MatchString(line) && isRule(trimmed))
+}
+
+// parseList reads the list starting at lines[start]. Lines indented deeper than the
+// item marker belong to the item and are parsed as nested blocks.
+func parseList(lines []string, start int) (MarkdownBlock, int) {
+	first := listItemPattern.FindStringSubmatch(lines[start])
+	indent := len(first[1])
+	block := MarkdownBlock{Kind: BlockList, Ordered: isOrderedMarker(first[2])}
+
+	i := start
+	for i < len(lines) {
+		match := listItemPattern.FindStringSubmatch(lines[i])
+		if match == nil || len(match[1]) != indent || isOrderedMarker(match[2]) != block.Ordered {
+			break
+		}
+
+		itemLines := []string{match[3]}
+		contentIndent := indent + len(match[2]) + 1
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line continues the item only if indented content follows
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) > indent && strings.TrimSpace(lines[i+1]) != "" {
+					itemLines = append(itemLines, "")
+					i++
+					continue
+				}
+				break
+			}
+
+			spaces := leadingSpaces(line)
+			if spaces <= indent && (listItemPattern.MatchString(line) || startsBlock(line)) {
+				break
+			}
+			if spaces > indent {
+				line = line[min(spaces, contentIndent):]
+			}
+			itemLines = append(itemLines, line)
+			i++
+		}
+
+		block.Items = append(block.Items, parseBlocks(itemLines))
+
+		// Items separated by a blank line still belong to the same list
+		if i+1 < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			if next := listItemPattern.FindStringSubmatch(lines[i+1]); next != nil && len(next[1]) == indent && isOrderedMarker(next[2]) == block.Ordered {
+				i++
+			}
+		}
+	}
+
+	return block, i
+}
+
+func isOrderedMarker(marker string) bool {
+	return !strings.ContainsAny(marker, "-*+")
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// ParseInline splits inline markdown into formatted spans
+func ParseInline(text string) []InlineSpan {
+	return parseInline(text, InlineSpan{})
+}
+
+func parseInline(text string, style InlineSpan) []InlineSpan {
+	var spans []InlineSpan
+	var plain strings.Builder
+
+	flush := func() {
+		if plain.Len() > 0 {
+			span := style
+			span.Text = plain.String()
+			spans = append(spans, span)
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.ContainsRune("\\`*_{}[]()#+-.!~|>", rune(text[i+1])):
+			plain.WriteByte(text[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			ticks := countRun(text[i:], '`')
+			delimiter := strings.Repeat("`", ticks)
+			if end := strings.Index(text[i+ticks:], delimiter); end >= 0 {
+				flush()
+				span := style
+				span.Code = true
+				span.Text = strings.TrimSpace(text[i+ticks : i+ticks+end])
+				spans = append(spans, span)
+				i += ticks + end + ticks
+				continue
+			}
+
+		case c == '!' && i+1 < len(text) && text[i+1] == '[':
+			if label, url, length, ok := parseLinkAt(text[i+1:]); ok {
+				flush()
+				span := style
+				span.Image = true
+				span.Text = label
+				span.Link = url
+				spans = append(spans, span)
+				i += 1 + length
+				continue
+			}
+
+		case c == '[':
+			if label, url, length, ok := parseLinkAt(text[i:]); ok {
+				flush()
+				linkStyle := style
+				linkStyle.Link = url
+				spans = append(spans, parseInline(label, linkStyle)...)
+				i += length
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			run := countRun(text[i:], c)
+			if c == '~' && run < 2 {
+				break
+			}
+			run = min(run, 3)
+			if c == '~' {
+				run = 2
+			}
+
+			delimiter := text[i : i+run]
+			// Underscores inside words are literal, as in snake_case
+			if c == '_' && i > 0 && isWordByte(text[i-1]) {
+				break
+			}
+
+			end := findClosingDelimiter(text, i+run, delimiter)
+			if end < 0 {
+				break
+			}
+
+			flush()
+			inner := style
+			switch {
+			case c == '~':
+				inner.Strike = true
+			case run == 3:
+				inner.Bold = true
+				inner.Italic = true
+			case run == 2:
+				inner.Bold = true
+			default:
+				inner.Italic = true
+			}
+			spans = append(spans, parseInline(text[i+run:end], inner)...)
+			i = end + run
+			continue
+		}
+
+		plain.WriteByte(c)
+		i++
+	}
+
+	flush()
+	return spans
+}
+
+func countRun(text string, c byte) int {
+	n := 0
+	for n < len(text) && text[n] == c {
+		n++
+	}
+	return n
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// findClosingDelimiter finds a run of exactly the delimiter that follows a non space
+// character. Longer or shorter runs belong to nested emphasis and are skipped whole.
+func findClosingDelimiter(text string, from int, delimiter string) int {
+	for i := from; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '`':
+			// Delimiters inside code spans do not count
+			ticks := countRun(text[i:], '`')
+			if end := strings.Index(text[i+ticks:], strings.Repeat("`", ticks)); end >= 0 {
+				i += ticks + end + ticks - 1
+			}
+		case delimiter[0]:
+			run := countRun(text[i:], delimiter[0])
+			if run == len(delimiter) && i > from && text[i-1] != ' ' {
+				return i
+			}
+			i += run - 1
+		}
+	}
+	return -1
+}
+
+// parseLinkAt reads [label](url) at the start of text
+func parseLinkAt(text string) (label, url string, length int, ok bool) {
+	depth := 0
+	closeLabel := -1
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' {
+			i++
+			continue
+		}
+		if text[i] == '[' {
+			depth++
+		} else if text[i] == ']' {
+			depth--
+			if depth == 0 {
+				closeLabel = i
+				break
+			}
+		}
+	}
+
+	if closeLabel < 0 || closeLabel+1 >= len(text) || text[closeLabel+1] != '(' {
+		return "", "", 0, false
+	}
+
+	// Parentheses inside the destination must be balanced
+	closeURL := -1
+	depth = 0
+	for i := closeLabel + 2; i < len(text) && closeURL < 0; i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				closeURL = i - closeLabel - 2
+			}
+			depth--
+		}
+	}
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+
+	target := strings.TrimSpace(text[closeLabel+2 : closeLabel+2+closeURL])
+	// Drop an optional "title"
+	if space := strings.IndexAny(target, " \t"); space >= 0 {
+		target = target[:space]
+	}
+
+	return text[1:closeLabel], strings.Trim(target, "<>"), closeLabel + 2 + closeURL + 1, true
+}
+
+// SafeURL keeps links that cannot run scripts when rendered
+func SafeURL(url string) string {
+	lower := strings.ToLower(strings.TrimSpace(url))
+	for _, scheme := range []string{"http://", "https://", "mailto:", "#", "/", "./", "../"} {
+		if strings.HasPrefix(lower, scheme) {
+			return url
+		}
+	}
+
+	// Relative links have no scheme at all
+	if !strings.Contains(strings.SplitN(lower, "/", 2)[0], ":") {
+		return url
+	}
+	return "#"
+}
+
+// RenderMarkdownHTML converts markdown to an HTML fragment. Raw HTML in the input is
+// escaped, so the output is safe to embed.
+func RenderMarkdownHTML(text string) string {
+	var out strings.Builder
+	renderBlocksHTML(&out, ParseMarkdown(text))
+	return out.String()
+}
+
+func renderBlocksHTML(out *strings.Builder, blocks []MarkdownBlock) {
+	for _, block := range blocks {
+		switch block.Kind {
+		case BlockHeading:
+			tag := "h" + string(rune('0'+block.Level))
+			out.WriteString("<" + tag + ">" + RenderInlineHTML(block.Text) + "</" + tag + ">\n")
+		case BlockParagraph:
+			out.WriteString("<p>" + strings.ReplaceAll(RenderInlineHTML(block.Text), "\n", "<br>\n") + "</p>\n")
+		case BlockCode:
+			class := ""
+			if block.Language != "" {
+				class = ` class="language-` + html.EscapeString(block.Language) + `"`
+			}
+			out.WriteString("<pre><code" + class + ">" + html.EscapeString(block.Text) + "</code></pre>\n")
+		case BlockQuote:
+			out.WriteString("<blockquote>\n")
+			renderBlocksHTML(out, block.Children)
+			out.WriteString("</blockquote>\n")
+		case BlockList:
+			tag := "ul"
+			if block.Ordered {
+				tag = "ol"
+			}
+			out.WriteString("<" + tag + ">\n")
+			for _, item := range block.Items {
+				out.WriteString("<li>")
+				// Tight items render without paragraph tags
+				if len(item) == 1 && item[0].Kind == BlockParagraph {
+					out.WriteString(RenderInlineHTML(item[0].Text))
+				} else {
+					renderBlocksHTML(out, item)
+				}
+				out.WriteString("</li>\n")
+			}
+			out.WriteString("</" + tag + ">\n")
+		case BlockRule:
+			out.WriteString("<hr>\n")
+		case BlockTable:
+			out.WriteString("<table>\n")
+			for r, row := range block.Rows {
+				cellTag := "td"
+				if r == 0 {
+					cellTag = "th"
+				}
+				out.WriteString("<tr>")
+				for _, cell := range row {
+					out.WriteString("<" + cellTag + ">" + RenderInlineHTML(cell) + "</" + cellTag + ">")
+				}
+				out.WriteString("</tr>\n")
+			}
+			out.WriteString("</table>\n")
+		}
+	}
+}
+
+// RenderInlineHTML converts inline markdown to escaped HTML
+func RenderInlineHTML(text string) string {
+	var out strings.Builder
+	for _, span := range ParseInline(text) {
+		if span.Image {
+			out.WriteString(`<img src="` + html.EscapeString(SafeURL(span.Link)) + `" alt="` + html.EscapeString(span.Text) + `">`)
+			continue
+		}
+
+		content := html.EscapeString(span.Text)
+		if span.Code {
+			content = "<code>" + content + "</code>"
+		}
+		if span.Italic {
+			content = "<em>" + content + "</em>"
+		}
+		if span.Bold {
+			content = "<strong>" + content + "</strong>"
+		}
+		if span.Strike {
+			content = "<del>" + content + "</del>"
+		}
+		if span.Link != "" {
+			content = `<a href="` + html.EscapeString(SafeURL(span.Link)) + `">` + content + "</a>"
+		}
+		out.WriteString(content)
+	}
+	return out.String()
+}
+
+// PlainText strips the inline formatting of markdown text
+func PlainText(text string) string {
+	var out strings.Builder
+	for _, span := range ParseInline(text) {
+		out.WriteString(span.Text)
+	}
+	return out.String()
+}
+
+// MarkdownPreview flattens markdown into one line of plain text, cut to the given number
+// of characters. Used for short previews such as the last message of a conversation.
+func MarkdownPreview(text string, length int) string {
+	var words []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") || (line != "" && strings.ContainsRune("-*_", rune(line[0])) && isRule(line)) {
+			continue
+		}
+
+		line = strings.TrimLeft(line, "#> ")
+		if marker, rest, found := strings.Cut(line, " "); found && isListMarker(marker) {
+			line = rest
+		}
+
+		words = append(words, strings.Fields(PlainText(line))...)
+	}
+
+	preview := []rune(strings.Join(words, " "))
+	if len(preview) <= length {
+		return string(preview)
+	}
+	return strings.TrimRight(string(preview[:length]), " ") + "…"
+}
+
+func isListMarker(marker string) bool {
+	if marker == "-" || marker == "*" || marker == "+" {
+		return true
+	}
+
+	digits := strings.TrimRight(marker, ".)")
+	return len(digits) > 0 && len(digits) == len(marker)-1 && strings.Trim(digits, "0123456789") == ""
+}
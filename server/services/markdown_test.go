@@ -0,0 +1,92 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+func TestRenderMarkdownHTML(t *testing.T) {
+	markdown := strings.Join([]string{
+		"# Title",
+		"",
+		"Some **bold**, *italic* and `code` with a [link](https://example.com) and snake_case_name.",
+		"",
+		"- one",
+		"- two",
+		"  - nested",
+		"",
+		"1. first",
+		"2. second",
+		"",
+		"> quoted *text*",
+		"",
+		"```go",
+		"fmt.Println(\"<hi>\")",
+		"```",
+		"",
+		"| a | b |",
+		"|---|---|",
+		"| 1 | 2 |",
+		"",
+		"---",
+		"",
+		"<script>alert(1)</script> [bad](javascript:alert(1))",
+	}, "\n")
+
+	rendered := services.RenderMarkdownHTML(markdown)
+
+	for _, want := range []string{
+		"<h1>Title</h1>",
+		"<strong>bold</strong>",
+		"<em>italic</em>",
+		"<code>code</code>",
+		`<a href="https://example.com">link</a>`,
+		"snake_case_name",
+		"<ul>\n<li>one</li>\n<li><p>two</p>\n<ul>\n<li>nested</li>",
+		"<ol>\n<li>first</li>\n<li>second</li>\n</ol>",
+		"<blockquote>\n<p>quoted <em>text</em></p>\n</blockquote>",
+		`<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>`,
+		"<tr><th>a</th><th>b</th></tr>",
+		"<tr><td>1</td><td>2</td></tr>",
+		"<hr>",
+		"&lt;script&gt;",
+		`<a href="#">bad</a>`,
+	} {
+		if !strings.Contains(rendered, want) {
+			t.Fatalf("rendered HTML does not contain %q:\n%s", want, rendered)
+		}
+	}
+}
+
+func TestParseInlineNestedEmphasis(t *testing.T) {
+	spans := services.ParseInline("a *b **c** d* ~~e~~")
+
+	var bold, italic, strike bool
+	for _, span := range spans {
+		if span.Text == "c" && span.Bold && span.Italic {
+			bold = true
+		}
+		if span.Text == "b " && span.Italic && !span.Bold {
+			italic = true
+		}
+		if span.Text == "e" && span.Strike {
+			strike = true
+		}
+	}
+
+	if !bold || !italic || !strike {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+}
+
+func TestMarkdownPreview(t *testing.T) {
+	text := "## Summary\n\n- **First** point\n2. [Second](https://example.com) point\n\n```go\nfmt.Println()\n```\n\n---\n\nDone."
+
+	if preview := services.MarkdownPreview(text, 200); preview != "Summary First point Second point fmt.Println() Done." {
+		t.Fatalf("unexpected preview %q", preview)
+	}
+	if preview := services.MarkdownPreview(text, 13); preview != "Summary First…" {
+		t.Fatalf("unexpected truncated preview %q", preview)
+	}
+}
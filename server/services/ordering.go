@@ -0,0 +1,168 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"textly/queries"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Documents are ordered within their folder by fractional keys: strings of base 62
+// digits that compare like the fractions 0.<digits>. A key can always be made between
+// two others, so moving a document only ever rewrites its own key. Keys never end with
+// the lowest digit, otherwise nothing could be placed right before them.
+
+const orderDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// OrderKeyBetween returns a key that sorts after a and before b. An empty a means before
+// everything and an empty b after everything.
+func OrderKeyBetween(a, b string) (string, error) {
+	if !validOrderKey(a) || !validOrderKey(b) {
+		return "", fmt.Errorf("invalid order key")
+	}
+	if b == "" {
+		return orderKeyAfter(a), nil
+	}
+	if a >= b {
+		return "", fmt.Errorf("order key %q does not sort before %q", a, b)
+	}
+	return orderKeyMidpoint(a, b), nil
+}
+
+func validOrderKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(orderDigits, key[i]) < 0 {
+			return false
+		}
+	}
+	return !strings.HasSuffix(key, "0")
+}
+
+// orderKeyAfter bumps the first digit that can be, which keeps appended keys short
+func orderKeyAfter(a string) string {
+	for i := 0; i < len(a); i++ {
+		if digit := strings.IndexByte(orderDigits, a[i]); digit < len(orderDigits)-1 {
+			return a[:i] + string(orderDigits[digit+1])
+		}
+	}
+	return a + string(orderDigits[len(orderDigits)/2])
+}
+
+// orderKeyMidpoint finds a key between a and b, with a < b and b not empty
+func orderKeyMidpoint(a, b string) string {
+	// Keep the common prefix, a is padded with zeros
+	n := 0
+	for n < len(b) && orderDigitAt(a, n) == b[n] {
+		n++
+	}
+	if n > 0 {
+		rest := ""
+		if n < len(a) {
+			rest = a[n:]
+		}
+		return b[:n] + orderKeyMidpoint(rest, b[n:])
+	}
+
+	low := strings.IndexByte(orderDigits, orderDigitAt(a, 0))
+	high := strings.IndexByte(orderDigits, b[0])
+	if high-low > 1 {
+		return string(orderDigits[(low+high)/2])
+	}
+
+	// The first digits are adjacent: either the first digit of b alone fits, or the key
+	// continues after a
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(orderDigits[low]) + orderKeyAfter(rest)
+}
+
+func orderDigitAt(key string, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return orderDigits[0]
+}
+
+// SpacedOrderKeys returns n increasing keys spread evenly, used to renumber a folder
+// whose documents have no keys yet
+func SpacedOrderKeys(n int) []string {
+	width, capacity := 1, int64(len(orderDigits))
+	for capacity <= int64(n+1) {
+		width++
+		capacity *= int64(len(orderDigits))
+	}
+
+	step := capacity / int64(n+1)
+	keys := make([]string, n)
+	for i := range keys {
+		value := int64(i+1) * step
+		digits := make([]byte, width)
+		for d := width - 1; d >= 0; d-- {
+			digits[d] = orderDigits[value%int64(len(orderDigits))]
+			value /= int64(len(orderDigits))
+		}
+		keys[i] = strings.TrimRight(string(digits), "0")
+	}
+	return keys
+}
+
+// OrderKeyAt returns the key of a document placed at index among siblings with the given
+// keys, in order. When the siblings have no keys yet, or duplicates, renumbered holds a
+// new key for each of them as well.
+func OrderKeyAt(keys []string, index int) (key string, renumbered []string, err error) {
+	if index < 0 || index > len(keys) {
+		return "", nil, fmt.Errorf("position %d is out of range", index)
+	}
+
+	ordered := true
+	for i, k := range keys {
+		if k == "" || !validOrderKey(k) || (i > 0 && keys[i-1] >= k) {
+			ordered = false
+			break
+		}
+	}
+
+	if ordered {
+		before, after := "", ""
+		if index > 0 {
+			before = keys[index-1]
+		}
+		if index < len(keys) {
+			after = keys[index]
+		}
+		key, err := OrderKeyBetween(before, after)
+		return key, nil, err
+	}
+
+	spaced := SpacedOrderKeys(len(keys) + 1)
+	renumbered = make([]string, 0, len(keys))
+	renumbered = append(renumbered, spaced[:index]...)
+	renumbered = append(renumbered, spaced[index+1:]...)
+	return spaced[index], renumbered, nil
+}
+
+// AppendDocumentOrderKey gives the document a key after every other document of its folder
+func AppendDocumentOrderKey(app core.App, record *core.Record) error {
+	last, err := queries.GetLastDocumentOrderKey(app, record.GetString("user"), record.GetString("parent"))
+	if err != nil {
+		return err
+	}
+
+	if !validOrderKey(last) {
+		last = ""
+	}
+
+	key, err := OrderKeyBetween(last, "")
+	if err != nil {
+		return err
+	}
+
+	record.Set("order_key", key)
+	return nil
+}
@@ -0,0 +1,74 @@
+package services_test
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+func TestOrderKeyBetween(t *testing.T) {
+	for _, c := range [][2]string{{"", ""}, {"", "1"}, {"1", "2"}, {"V", "V1"}, {"z", ""}, {"zz", ""}, {"0V", "1"}, {"V", "W"}} {
+		key, err := services.OrderKeyBetween(c[0], c[1])
+		if err != nil {
+			t.Fatalf("%q %q: %v", c[0], c[1], err)
+		}
+		if key <= c[0] || (c[1] != "" && key >= c[1]) || strings.HasSuffix(key, "0") {
+			t.Fatalf("key %q is not between %q and %q", key, c[0], c[1])
+		}
+	}
+
+	for _, c := range [][2]string{{"V", "V"}, {"W", "V"}, {"V0", ""}, {"a-b", ""}} {
+		if _, err := services.OrderKeyBetween(c[0], c[1]); err == nil {
+			t.Fatalf("expected an error for %q %q", c[0], c[1])
+		}
+	}
+}
+
+func TestOrderKeysStayOrdered(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	keys := []string{}
+
+	for i := 0; i < 2000; i++ {
+		index := random.Intn(len(keys) + 1)
+		key, renumbered, err := services.OrderKeyAt(keys, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renumbered != nil {
+			t.Fatal("ordered keys should not be renumbered")
+		}
+		keys = slices.Insert(keys, index, key)
+	}
+
+	if !slices.IsSorted(keys) || len(slices.Compact(slices.Clone(keys))) != len(keys) {
+		t.Fatal("keys are not strictly increasing")
+	}
+
+	// Appending keeps keys short
+	key := ""
+	for i := 0; i < 1000; i++ {
+		key, _ = services.OrderKeyBetween(key, "")
+	}
+	if len(key) > 40 {
+		t.Fatalf("appended key grew to %d characters", len(key))
+	}
+}
+
+func TestOrderKeyAtRenumbers(t *testing.T) {
+	key, renumbered, err := services.OrderKeyAt([]string{"", "", "V"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := slices.Insert(slices.Clone(renumbered), 1, key)
+	if len(renumbered) != 3 || !slices.IsSorted(all) || len(slices.Compact(slices.Clone(all))) != 4 {
+		t.Fatalf("unexpected renumbering %q %q", key, renumbered)
+	}
+
+	spaced := services.SpacedOrderKeys(5000)
+	if !slices.IsSorted(spaced) || len(slices.Compact(slices.Clone(spaced))) != 5000 || len(spaced[0]) > 3 {
+		t.Fatal("spaced keys are not increasing")
+	}
+}
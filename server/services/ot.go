@@ -0,0 +1,483 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDiffCells bounds the number of line comparisons DiffOperation makes to match lines
+const maxDiffCells = 4_000_000
+
+// TextOperation is an edit of a whole document as a sequence of retained, inserted and
+// deleted characters, as used by operational transformation. Lengths count Unicode code
+// points, not bytes. In JSON it uses the ot.js format: a positive number retains that
+// many characters, a negative number deletes them and a string is inserted.
+type TextOperation struct {
+	Ops []OpComponent
+	// BaseLength is the length of the document the operation applies to
+	BaseLength int
+	// TargetLength is the length of the document after applying it
+	TargetLength int
+}
+
+// OpComponent is one step of a TextOperation. Exactly one of its fields is set.
+type OpComponent struct {
+	Retain int
+	Insert string
+	Delete int
+}
+
+func NewTextOperation() *TextOperation {
+	return &TextOperation{}
+}
+
+func (c OpComponent) isRetain() bool { return c.Retain > 0 }
+func (c OpComponent) isInsert() bool { return c.Insert != "" }
+func (c OpComponent) isDelete() bool { return c.Delete > 0 }
+
+func (o *TextOperation) last(offset int) *OpComponent {
+	if len(o.Ops) < offset {
+		return nil
+	}
+	return &o.Ops[len(o.Ops)-offset]
+}
+
+// Retain skips over n characters
+func (o *TextOperation) Retain(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+
+	o.BaseLength += n
+	o.TargetLength += n
+
+	if last := o.last(1); last != nil && last.isRetain() {
+		last.Retain += n
+	} else {
+		o.Ops = append(o.Ops, OpComponent{Retain: n})
+	}
+	return o
+}
+
+// Insert inserts text at the current position. An insert directly before or after a
+// delete is always stored first so that equal operations have equal components.
+func (o *TextOperation) Insert(text string) *TextOperation {
+	if text == "" {
+		return o
+	}
+
+	o.TargetLength += utf8.RuneCountInString(text)
+
+	last := o.last(1)
+	switch {
+	case last != nil && last.isInsert():
+		last.Insert += text
+	case last != nil && last.isDelete():
+		if previous := o.last(2); previous != nil && previous.isInsert() {
+			previous.Insert += text
+		} else {
+			deleted := *last
+			*last = OpComponent{Insert: text}
+			o.Ops = append(o.Ops, deleted)
+		}
+	default:
+		o.Ops = append(o.Ops, OpComponent{Insert: text})
+	}
+	return o
+}
+
+// Delete removes n characters at the current position
+func (o *TextOperation) Delete(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+
+	o.BaseLength += n
+
+	if last := o.last(1); last != nil && last.isDelete() {
+		last.Delete += n
+	} else {
+		o.Ops = append(o.Ops, OpComponent{Delete: n})
+	}
+	return o
+}
+
+// IsNoop reports whether the operation leaves the document unchanged
+func (o *TextOperation) IsNoop() bool {
+	return len(o.Ops) == 0 || (len(o.Ops) == 1 && o.Ops[0].isRetain())
+}
+
+// Apply applies the operation to the text
+func (o *TextOperation) Apply(text string) (string, error) {
+	runes := []rune(text)
+	if len(runes) != o.BaseLength {
+		return "", fmt.Errorf("operation expects a document of length %d, got %d", o.BaseLength, len(runes))
+	}
+
+	result := make([]rune, 0, o.TargetLength)
+	position := 0
+	for _, op := range o.Ops {
+		switch {
+		case op.isRetain():
+			result = append(result, runes[position:position+op.Retain]...)
+			position += op.Retain
+		case op.isInsert():
+			result = append(result, []rune(op.Insert)...)
+		case op.isDelete():
+			position += op.Delete
+		}
+	}
+
+	return string(result), nil
+}
+
+// TransformIndex moves a position in the document, such as a cursor, to where it is after
+// the operation. Text inserted at the position pushes it forward.
+func (o *TextOperation) TransformIndex(index int) int {
+	return o.transformIndex(index, true)
+}
+
+// transformIndex moves the position like TransformIndex. When pushed is false, text
+// inserted exactly at the position ends up after it instead, which is what the end of
+// a range needs so that it does not grab text typed right behind it.
+func (o *TextOperation) transformIndex(index int, pushed bool) int {
+	remaining, moved := index, index
+	for _, op := range o.Ops {
+		if remaining < 0 || (remaining == 0 && !pushed) {
+			break
+		}
+
+		switch {
+		case op.isRetain():
+			remaining -= op.Retain
+		case op.isInsert():
+			moved += utf8.RuneCountInString(op.Insert)
+		case op.isDelete():
+			moved -= min(remaining, op.Delete)
+			remaining -= op.Delete
+		}
+	}
+	return moved
+}
+
+// opReader walks the components of an operation, splitting them when only part of a
+// component is consumed
+type opReader struct {
+	ops     []OpComponent
+	index   int
+	current *OpComponent
+}
+
+func newOpReader(o *TextOperation) *opReader {
+	r := &opReader{ops: o.Ops}
+	r.next()
+	return r
+}
+
+func (r *opReader) next() {
+	if r.index >= len(r.ops) {
+		r.current = nil
+		return
+	}
+
+	op := r.ops[r.index]
+	r.current = &op
+	r.index++
+}
+
+func (c *OpComponent) length() int {
+	switch {
+	case c.isRetain():
+		return c.Retain
+	case c.isDelete():
+		return c.Delete
+	default:
+		return utf8.RuneCountInString(c.Insert)
+	}
+}
+
+// consume takes n characters of the current component and moves on once it is used up
+func (r *opReader) consume(n int) {
+	op := r.current
+	if op.length() == n {
+		r.next()
+		return
+	}
+
+	switch {
+	case op.isRetain():
+		op.Retain -= n
+	case op.isDelete():
+		op.Delete -= n
+	default:
+		op.Insert = string([]rune(op.Insert)[n:])
+	}
+}
+
+// Compose combines a followed by b into a single operation with the same effect
+func Compose(a, b *TextOperation) (*TextOperation, error) {
+	if a.TargetLength != b.BaseLength {
+		return nil, fmt.Errorf("cannot compose operations: first ends at length %d, second starts at %d", a.TargetLength, b.BaseLength)
+	}
+
+	result := NewTextOperation()
+	first, second := newOpReader(a), newOpReader(b)
+
+	for first.current != nil || second.current != nil {
+		if first.current != nil && first.current.isDelete() {
+			result.Delete(first.current.Delete)
+			first.next()
+			continue
+		}
+		if second.current != nil && second.current.isInsert() {
+			result.Insert(second.current.Insert)
+			second.next()
+			continue
+		}
+		if first.current == nil || second.current == nil {
+			return nil, fmt.Errorf("cannot compose operations: lengths do not match")
+		}
+
+		n := min(first.current.length(), second.current.length())
+		switch {
+		case first.current.isRetain() && second.current.isRetain():
+			result.Retain(n)
+		case first.current.isRetain() && second.current.isDelete():
+			result.Delete(n)
+		case first.current.isInsert() && second.current.isRetain():
+			result.Insert(string([]rune(first.current.Insert)[:n]))
+		}
+		// An insert of the first operation deleted by the second one leaves nothing
+
+		first.consume(n)
+		second.consume(n)
+	}
+
+	return result, nil
+}
+
+// Transform takes two operations made concurrently on the same document and returns
+// a' and b' such that applying a then b' gives the same document as b then a'. When
+// both insert at the same position the text of a comes first.
+func Transform(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.BaseLength != b.BaseLength {
+		return nil, nil, fmt.Errorf("cannot transform operations on documents of length %d and %d", a.BaseLength, b.BaseLength)
+	}
+
+	aPrime, bPrime := NewTextOperation(), NewTextOperation()
+	first, second := newOpReader(a), newOpReader(b)
+
+	for first.current != nil || second.current != nil {
+		if first.current != nil && first.current.isInsert() {
+			aPrime.Insert(first.current.Insert)
+			bPrime.Retain(first.current.length())
+			first.next()
+			continue
+		}
+		if second.current != nil && second.current.isInsert() {
+			aPrime.Retain(second.current.length())
+			bPrime.Insert(second.current.Insert)
+			second.next()
+			continue
+		}
+		if first.current == nil || second.current == nil {
+			return nil, nil, fmt.Errorf("cannot transform operations: lengths do not match")
+		}
+
+		n := min(first.current.length(), second.current.length())
+		switch {
+		case first.current.isRetain() && second.current.isRetain():
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case first.current.isDelete() && second.current.isRetain():
+			aPrime.Delete(n)
+		case first.current.isRetain() && second.current.isDelete():
+			bPrime.Delete(n)
+		}
+		// Text deleted by both operations is already gone
+
+		first.consume(n)
+		second.consume(n)
+	}
+
+	return aPrime, bPrime, nil
+}
+
+// DiffOperation returns an operation that turns before into after. Unchanged lines are
+// retained so that separate edits stay separate, and the changed lines are replaced
+// between their common prefix and suffix.
+func DiffOperation(before, after string) *TextOperation {
+	from, to := []rune(before), []rune(after)
+	prefix, suffix := commonAffixes(from, to)
+
+	operation := NewTextOperation().Retain(prefix)
+	diffLines(operation, from[prefix:len(from)-suffix], to[prefix:len(to)-suffix])
+	return operation.Retain(suffix)
+}
+
+// diffLines matches the lines of from and to with their longest common subsequence. The
+// comparisons grow with the product of the line counts, so very large changes are
+// replaced as a whole instead.
+func diffLines(operation *TextOperation, from, to []rune) {
+	a, b := splitLines(from), splitLines(to)
+	if len(a) == 0 || len(b) == 0 || len(a)*len(b) > maxDiffCells {
+		diffRunes(operation, from, to)
+		return
+	}
+
+	// Lines are compared by number
+	numbers := make(map[string]int)
+	number := func(lines []string) []int {
+		numbered := make([]int, len(lines))
+		for i, line := range lines {
+			if _, ok := numbers[line]; !ok {
+				numbers[line] = len(numbers)
+			}
+			numbered[i] = numbers[line]
+		}
+		return numbered
+	}
+
+	hunkA, hunkB := 0, 0
+	for _, match := range commonLines(number(a), number(b), 0, 0) {
+		diffRunes(operation, []rune(strings.Join(a[hunkA:match[0]], "")), []rune(strings.Join(b[hunkB:match[1]], "")))
+		operation.Retain(utf8.RuneCountInString(a[match[0]]))
+		hunkA, hunkB = match[0]+1, match[1]+1
+	}
+	diffRunes(operation, []rune(strings.Join(a[hunkA:], "")), []rune(strings.Join(b[hunkB:], "")))
+}
+
+// commonLines returns the positions of the lines of a longest common subsequence of a and
+// b, offset by i and j. Like Hirschberg's algorithm it splits a in half where the halves
+// of the subsequence meet, so it only needs memory linear in the number of lines.
+func commonLines(a, b []int, i, j int) [][2]int {
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		return nil
+	case len(a) == 1:
+		for k, line := range b {
+			if line == a[0] {
+				return [][2]int{{i, j + k}}
+			}
+		}
+		return nil
+	}
+
+	half := len(a) / 2
+	upper := commonLengths(a[:half], b, false)
+	lower := commonLengths(a[half:], b, true)
+
+	split := 0
+	for k := range upper {
+		if upper[k]+lower[k] > upper[split]+lower[split] {
+			split = k
+		}
+	}
+
+	return append(commonLines(a[:half], b[:split], i, j), commonLines(a[half:], b[split:], i+half, j+split)...)
+}
+
+// commonLengths returns, for each k, the length of the longest common subsequence of a
+// and b[:k], or of a and b[k:] when reversed, keeping two rows of the table at a time
+func commonLengths(a, b []int, reversed bool) []int {
+	previous, current := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		line := a[i]
+		if reversed {
+			line = a[len(a)-1-i]
+		}
+
+		for k := 1; k <= len(b); k++ {
+			other := b[k-1]
+			if reversed {
+				other = b[len(b)-k]
+			}
+
+			if line == other {
+				current[k] = previous[k-1] + 1
+			} else {
+				current[k] = max(previous[k], current[k-1])
+			}
+		}
+		previous, current = current, previous
+	}
+
+	if reversed {
+		slices.Reverse(previous)
+	}
+	return previous
+}
+
+// diffRunes replaces the part of from between its common prefix and suffix with to
+func diffRunes(operation *TextOperation, from, to []rune) {
+	prefix, suffix := commonAffixes(from, to)
+	operation.
+		Retain(prefix).
+		Insert(string(to[prefix : len(to)-suffix])).
+		Delete(len(from) - prefix - suffix).
+		Retain(suffix)
+}
+
+func commonAffixes(from, to []rune) (prefix, suffix int) {
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// splitLines splits the text after each newline, keeping the newlines
+func splitLines(text []rune) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.SplitAfter(string(text), "\n")
+}
+
+func (o *TextOperation) MarshalJSON() ([]byte, error) {
+	ops := make([]any, 0, len(o.Ops))
+	for _, op := range o.Ops {
+		switch {
+		case op.isRetain():
+			ops = append(ops, op.Retain)
+		case op.isInsert():
+			ops = append(ops, op.Insert)
+		case op.isDelete():
+			ops = append(ops, -op.Delete)
+		}
+	}
+	return json.Marshal(ops)
+}
+
+func (o *TextOperation) UnmarshalJSON(data []byte) error {
+	var ops []any
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return err
+	}
+
+	*o = TextOperation{}
+	for _, op := range ops {
+		switch value := op.(type) {
+		case string:
+			o.Insert(value)
+		case float64:
+			if value != float64(int(value)) || value == 0 {
+				return fmt.Errorf("invalid operation component %v", value)
+			}
+			if value > 0 {
+				o.Retain(int(value))
+			} else {
+				o.Delete(int(-value))
+			}
+		default:
+			return fmt.Errorf("invalid operation component %v", op)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package services_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+const otAlphabet = "abcdé \n😀"
+
+// randomOperation makes a random edit of the text
+func randomOperation(rng *rand.Rand, text string) *services.TextOperation {
+	operation := services.NewTextOperation()
+	remaining := len([]rune(text))
+
+	for remaining > 0 {
+		n := 1 + rng.Intn(min(remaining, 5))
+		switch rng.Intn(3) {
+		case 0:
+			operation.Retain(n)
+			remaining -= n
+		case 1:
+			operation.Delete(n)
+			remaining -= n
+		default:
+			operation.Insert(randomText(rng))
+		}
+	}
+
+	if rng.Intn(2) == 0 {
+		operation.Insert(randomText(rng))
+	}
+	return operation
+}
+
+func randomText(rng *rand.Rand) string {
+	alphabet := []rune(otAlphabet)
+	text := make([]rune, 1+rng.Intn(4))
+	for i := range text {
+		text[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(text)
+}
+
+func TestTextOperationApplyAndJSON(t *testing.T) {
+	var operation services.TextOperation
+	if err := json.Unmarshal([]byte(`[6, "big", -5, 6]`), &operation); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := operation.Apply("hello small world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "hello big world" {
+		t.Fatalf("unexpected result %q", result)
+	}
+
+	data, err := json.Marshal(&operation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `[6,"big",-5,6]` {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+
+	if _, err := operation.Apply("too short"); err == nil {
+		t.Fatalf("expected a length error")
+	}
+
+	if err := json.Unmarshal([]byte(`[1.5]`), &operation); err == nil {
+		t.Fatalf("expected fractional retains to be rejected")
+	}
+}
+
+func TestTransformConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for range 500 {
+		text := randomText(rng) + randomText(rng) + randomText(rng)
+		a, b := randomOperation(rng, text), randomOperation(rng, text)
+
+		aPrime, bPrime, err := services.Transform(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		afterA, _ := a.Apply(text)
+		afterB, _ := b.Apply(text)
+
+		left, err := bPrime.Apply(afterA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := aPrime.Apply(afterB)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if left != right {
+			t.Fatalf("transform diverged on %q: %q vs %q", text, left, right)
+		}
+	}
+}
+
+func TestComposeMatchesSequentialApply(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	for range 500 {
+		text := randomText(rng) + randomText(rng)
+		a := randomOperation(rng, text)
+		afterA, _ := a.Apply(text)
+		b := randomOperation(rng, afterA)
+		expected, _ := b.Apply(afterA)
+
+		composed, err := services.Compose(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := composed.Apply(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != expected {
+			t.Fatalf("compose gave %q, expected %q", result, expected)
+		}
+	}
+}
+
+func TestTransformIndexAndDiff(t *testing.T) {
+	operation := services.NewTextOperation().Retain(2).Insert("xyz").Delete(3).Retain(5)
+
+	for index, expected := range map[int]int{0: 0, 2: 5, 3: 5, 5: 5, 7: 7, 10: 10} {
+		if moved := operation.TransformIndex(index); moved != expected {
+			t.Fatalf("index %d moved to %d, expected %d", index, moved, expected)
+		}
+	}
+
+	diff := services.DiffOperation("the quick fox", "the slow fox")
+	if result, _ := diff.Apply("the quick fox"); result != "the slow fox" {
+		t.Fatalf("diff produced %q", result)
+	}
+	if services.DiffOperation("same", "same").IsNoop() != true {
+		t.Fatalf("diff of equal texts should be a no-op")
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for range 500 {
+		before := randomText(rng) + randomText(rng) + randomText(rng) + randomText(rng)
+		after, _ := randomOperation(rng, before).Apply(before)
+
+		if result, err := services.DiffOperation(before, after).Apply(before); err != nil || result != after {
+			t.Fatalf("diff of %q and %q produced %q (%v)", before, after, result, err)
+		}
+	}
+}
+
+func TestDiffOperationRetainsLongestCommonLines(t *testing.T) {
+	retained := func(operation *services.TextOperation) int {
+		count := 0
+		for _, op := range operation.Ops {
+			count += op.Retain
+		}
+		return count
+	}
+
+	// The lines 2 and 3 stay, and so does the common end of both texts
+	before, after := "1\n2\n3\n4\n5\n6\n", "2\n3\nx\n5\n1\n6\n"
+	operation := services.DiffOperation(before, after)
+	if result, _ := operation.Apply(before); result != after {
+		t.Fatalf("diff produced %q", result)
+	}
+	if count := retained(operation); count != 7 {
+		t.Fatalf("expected 7 retained characters, got %d", count)
+	}
+
+	// Long documents are matched line by line too
+	var lines []string
+	for i := range 2000 {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	before = strings.Join(lines, "")
+	lines[10], lines[1990] = "edited\n", "edited too\n"
+	after = strings.Join(append(lines[:500:500], lines[501:]...), "")
+
+	operation = services.DiffOperation(before, after)
+	if result, _ := operation.Apply(before); result != after {
+		t.Fatal("diff of long documents produced another text")
+	}
+	if count := retained(operation); count < len(after)-30 {
+		t.Fatalf("expected the unchanged lines to be retained, got %d of %d characters", count, len(after))
+	}
+}
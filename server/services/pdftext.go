@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var pdfStreamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+
+// ExtractPDFText does a best effort extraction of the text drawn by the content streams
+// of a PDF. It understands FlateDecode streams and the Tj, TJ, ' and " operators, which
+// covers the documents produced by common editors. Fonts with custom encodings come out
+// garbled, in which case vision models should be used instead.
+func ExtractPDFText(data []byte) string {
+	var text strings.Builder
+
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dictionary := data[match[2]:match[3]]
+		start := match[1]
+
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		content := data[start : start+end]
+
+		if bytes.Contains(dictionary, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, err = io.ReadAll(reader)
+			reader.Close()
+			if err != nil && len(content) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dictionary, []byte("/Filter")) {
+			// Images and other encodings carry no text
+			continue
+		}
+
+		extractContentStreamText(content, &text)
+	}
+
+	return strings.TrimSpace(text.String())
+}
+
+// extractContentStreamText walks the operators of a content stream and writes the
+// strings shown between BT and ET
+func extractContentStreamText(content []byte, text *strings.Builder) {
+	var operands []string
+	inText := false
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			value, next := readPDFLiteral(content, i)
+			operands = append(operands, value)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			value, next := readPDFHex(content, i)
+			operands = append(operands, value)
+			i = next
+		case c == '<':
+			// Inline dictionary
+			i += 2
+		case c == '[' || c == ']':
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFDelimiter(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFDelimiter(content[i]) && content[i] != '(' && content[i] != '<' && content[i] != '[' && content[i] != ']' {
+				i++
+			}
+			token := string(content[start:i])
+
+			if number, err := strconv.ParseFloat(token, 64); err == nil {
+				// Large negative kerning inside TJ arrays separates words
+				if inText && number < -200 && len(operands) > 0 {
+					operands = append(operands, " ")
+				}
+				continue
+			}
+
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				text.WriteString("\n")
+			case "Tj", "TJ":
+				if inText {
+					text.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"", "T*":
+				text.WriteString("\n")
+				if inText && token != "T*" {
+					text.WriteString(strings.Join(operands, ""))
+				}
+			case "Td", "TD":
+				if inText {
+					text.WriteString("\n")
+				}
+			}
+			operands = operands[:0]
+		}
+	}
+}
+
+func isPDFDelimiter(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0 || c == '/' || c == '{' || c == '}' || c == '>'
+}
+
+// readPDFLiteral reads a (string) starting at the opening parenthesis
+func readPDFLiteral(content []byte, start int) (string, int) {
+	var value strings.Builder
+	depth := 0
+
+	i := start
+	for ; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			if i+1 >= len(content) {
+				continue
+			}
+			i++
+			switch next := content[i]; next {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if next >= '0' && next <= '7' {
+					end := i
+					for end < len(content) && end < i+3 && content[end] >= '0' && content[end] <= '7' {
+						end++
+					}
+					code, _ := strconv.ParseUint(string(content[i:end]), 8, 8)
+					value.WriteByte(byte(code))
+					i = end - 1
+				} else {
+					value.WriteByte(next)
+				}
+			}
+		case '(':
+			if depth > 0 {
+				value.WriteByte(c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return value.String(), i + 1
+			}
+			value.WriteByte(c)
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return value.String(), i
+}
+
+// readPDFHex reads a <hex string> starting at the opening bracket, keeping printable bytes
+func readPDFHex(content []byte, start int) (string, int) {
+	end := bytes.IndexByte(content[start:], '>')
+	if end < 0 {
+		return "", len(content)
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return r
+		}
+		return -1
+	}, string(content[start+1:start+end]))
+	if len(digits)%2 == 1 {
+		digits += "0"
+	}
+
+	var value strings.Builder
+	for i := 0; i+1 < len(digits); i += 2 {
+		code, _ := strconv.ParseUint(digits[i:i+2], 16, 8)
+		if code >= 0x20 && code < 0x7f {
+			value.WriteByte(byte(code))
+		}
+	}
+
+	return value.String(), start + end + 1
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"textly/queries"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	defaultConversationRetentionDays = 30
+	conversationPurgeJobId           = "purgeArchivedConversations"
+	conversationPurgeSchedule        = "30 3 * * *"
+)
+
+// ConversationRetention reads CONVERSATION_RETENTION_DAYS, how long archived conversations
+// are kept before they are deleted. Zero disables the purge.
+func ConversationRetention() time.Duration {
+	return retentionFromEnv("CONVERSATION_RETENTION_DAYS", defaultConversationRetentionDays)
+}
+
+// ConversationPurgeDate returns when a conversation archived at the given date will be
+// deleted, or an empty string when archived conversations are kept forever
+func ConversationPurgeDate(archivedAt string) string {
+	return purgeDate(archivedAt, ConversationRetention())
+}
+
+// InitializeConversationPurge schedules the daily purge of archived conversations
+func InitializeConversationPurge(app core.App) {
+	schedulePurge(app, conversationPurgeJobId, conversationPurgeSchedule, "archived conversations", PurgeArchivedConversations)
+}
+
+// PurgeArchivedConversations permanently deletes the conversations archived longer than
+// the retention period and returns how many were deleted
+func PurgeArchivedConversations(app core.App) (int, error) {
+	return purgeBefore(app, ConversationRetention(), queries.GetConversationIdsArchivedBefore, queries.DeleteConversationPermanently)
+}
+
+// retentionFromEnv reads the number of days from the environment variable, falling back
+// to the default when it is missing or not a positive number
+func retentionFromEnv(name string, defaultDays int) time.Duration {
+	days := defaultDays
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value >= 0 {
+		days = value
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// purgeDate returns the date the retention ends for something that started it at the
+// given date, or an empty string when it is kept forever or the date is not set
+func purgeDate(since string, retention time.Duration) string {
+	if retention == 0 {
+		return ""
+	}
+
+	date, err := types.ParseDateTime(since)
+	if err != nil || date.IsZero() {
+		return ""
+	}
+	return date.Add(retention).String()
+}
+
+// schedulePurge runs the purge every day on the schedule and logs what it deleted
+func schedulePurge(app core.App, jobId, schedule, name string, purge func(app core.App) (int, error)) {
+	app.Cron().MustAdd(jobId, schedule, func() {
+		purged, err := purge(app)
+		if err != nil {
+			log.Printf("Failed to purge %s: %v", name, err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d %s", purged, name)
+		}
+	})
+}
+
+// purgeBefore deletes one at a time the ids listed from before the retention period and
+// returns how many were deleted. Nothing is deleted when the retention is zero.
+func purgeBefore(
+	app core.App,
+	retention time.Duration,
+	list func(app core.App, cutoff string) ([]string, error),
+	remove func(app core.App, id string) error,
+) (int, error) {
+	if retention == 0 {
+		return 0, nil
+	}
+
+	cutoff := types.NowDateTime().Add(-retention).String()
+	ids, err := list(app, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		if err := remove(app, id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
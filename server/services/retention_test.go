@@ -0,0 +1,28 @@
+package services_test
+
+import (
+	"testing"
+	"textly/services"
+	"time"
+)
+
+func TestConversationPurgeDate(t *testing.T) {
+	t.Setenv("CONVERSATION_RETENTION_DAYS", "7")
+
+	if retention := services.ConversationRetention(); retention != 7*24*time.Hour {
+		t.Fatalf("unexpected retention %v", retention)
+	}
+	if date := services.ConversationPurgeDate("2026-03-01 10:00:00.000Z"); date != "2026-03-08 10:00:00.000Z" {
+		t.Fatalf("unexpected purge date %q", date)
+	}
+
+	t.Setenv("CONVERSATION_RETENTION_DAYS", "0")
+	if date := services.ConversationPurgeDate("2026-03-01 10:00:00.000Z"); date != "" {
+		t.Fatalf("expected no purge date when the purge is disabled, got %q", date)
+	}
+
+	t.Setenv("CONVERSATION_RETENTION_DAYS", "invalid")
+	if retention := services.ConversationRetention(); retention != 30*24*time.Hour {
+		t.Fatalf("expected the default retention, got %v", retention)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"textly/queries"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	defaultRetrievalTopK = 5
+	minCitationScore     = 0.1
+)
+
+// Citation points at the part of a document that was given to the model as context.
+// Start and End are byte offsets into the document content.
+type Citation struct {
+	Number     int     `json:"number"`
+	DocumentId string  `json:"document_id"`
+	Title      string  `json:"title"`
+	ChunkIndex int     `json:"chunk_index"`
+	Start      int     `json:"start"`
+	End        int     `json:"end"`
+	Score      float64 `json:"score"`
+	Content    string  `json:"content"`
+}
+
+// retrievalTopK reads RAG_TOP_K, the number of chunks added to the prompt
+func retrievalTopK() int {
+	if value, err := strconv.Atoi(os.Getenv("RAG_TOP_K")); err == nil && value > 0 {
+		return value
+	}
+	return defaultRetrievalTopK
+}
+
+// RetrieveCitations finds the chunks of the user's documents closest to the query
+func RetrieveCitations(e *core.RequestEvent, userId, query string) ([]Citation, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	embedder := NewEmbedder()
+	if indexer := GetDocumentIndexer(); indexer != nil {
+		embedder = indexer.Embedder()
+	}
+
+	vectors, err := embedder.Embed(e.Request.Context(), []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := queries.GetClosestChunks(e.App, userId, embedder.Model(), EncodeVector(vectors[0]), minCitationScore, retrievalTopK())
+	if err != nil {
+		return nil, err
+	}
+
+	titles := make(map[string]string)
+	citations := make([]Citation, 0, len(chunks))
+	for _, chunk := range chunks {
+		title, exists := titles[chunk.DocumentId]
+		if !exists {
+			if document, err := queries.GetDocumentById(e, chunk.DocumentId); err == nil {
+				title = document.Title
+			}
+			titles[chunk.DocumentId] = title
+		}
+
+		citations = append(citations, Citation{
+			Number:     len(citations) + 1,
+			DocumentId: chunk.DocumentId,
+			Title:      title,
+			ChunkIndex: chunk.ChunkIndex,
+			Start:      chunk.StartOffset,
+			End:        chunk.EndOffset,
+			Score:      chunk.Score,
+			Content:    chunk.Content,
+		})
+	}
+
+	return citations, nil
+}
+
+// AddCitationsToMessages puts the retrieved excerpts right before the last user message
+// so the model can answer from them and reference them by number
+func AddCitationsToMessages(messages []Message, citations []Citation) []Message {
+	if len(citations) == 0 || len(messages) == 0 {
+		return messages
+	}
+
+	var context strings.Builder
+	context.WriteString("The following excerpts from the user's documents may be relevant to the next message. ")
+	context.WriteString("Use them when they help and cite them inline as [number]. Ignore them when they are not relevant.\n")
+	for _, citation := range citations {
+		fmt.Fprintf(&context, "\n[%d] %s (document %s, bytes %d-%d):\n%s\n", citation.Number, citation.Title, citation.DocumentId, citation.Start, citation.End, citation.Content)
+	}
+
+	last := len(messages) - 1
+	augmented := append([]Message(nil), messages[:last]...)
+	augmented = append(augmented, Message{Role: MessageRoleSystem, Content: context.String()})
+	return append(augmented, messages[last])
+}
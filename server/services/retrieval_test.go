@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+func TestChunkTextOffsets(t *testing.T) {
+	paragraph := strings.Repeat("The quick brown fox jumps over the lazy dög. ", 12)
+	text := paragraph + "\n\n" + paragraph + "\n\n" + paragraph
+
+	chunks := services.ChunkText(text, 400, 80)
+	if len(chunks) < 3 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if chunk.Index != i {
+			t.Fatalf("chunk %d has index %d", i, chunk.Index)
+		}
+		if text[chunk.Start:chunk.End] != chunk.Content {
+			t.Fatalf("chunk %d offsets do not match its content", i)
+		}
+		if len(chunk.Content) > 400 {
+			t.Fatalf("chunk %d is %d bytes long", i, len(chunk.Content))
+		}
+		if i > 0 && chunk.Start >= chunks[i-1].End {
+			t.Fatalf("chunk %d does not overlap the previous one", i)
+		}
+	}
+
+	if chunks[len(chunks)-1].End != len(text) {
+		t.Fatalf("last chunk ends at %d, want %d", chunks[len(chunks)-1].End, len(text))
+	}
+}
+
+func TestLocalEmbedderRanksRelatedText(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "")
+	embedder := services.NewEmbedder()
+
+	vectors, err := embedder.Embed(context.Background(), []string{
+		"how do I bake sourdough bread",
+		"Sourdough bread needs a starter and a long bake in a hot oven.",
+		"Quarterly revenue grew thanks to the new pricing plan.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	related := services.CosineSimilarity(vectors[0], vectors[1])
+	unrelated := services.CosineSimilarity(vectors[0], vectors[2])
+	if related <= unrelated {
+		t.Fatalf("related score %f should beat unrelated score %f", related, unrelated)
+	}
+
+	decoded, err := services.DecodeVector(services.EncodeVector(vectors[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if services.CosineSimilarity(decoded, vectors[1]) < 0.9999 {
+		t.Fatal("vector did not survive encoding")
+	}
+}
+
+func TestAddCitationsToMessages(t *testing.T) {
+	messages := []services.Message{
+		{Role: services.MessageRoleUser, Content: "first"},
+		{Role: services.MessageRoleAssistant, Content: "answer"},
+		{Role: services.MessageRoleUser, Content: "question"},
+	}
+	citations := []services.Citation{{Number: 1, DocumentId: "doc1", Title: "Notes", Start: 10, End: 20, Content: "excerpt"}}
+
+	augmented := services.AddCitationsToMessages(messages, citations)
+	if len(augmented) != 4 || augmented[2].Role != services.MessageRoleSystem || augmented[3].Content != "question" {
+		t.Fatalf("unexpected messages: %+v", augmented)
+	}
+	if !strings.Contains(augmented[2].Content, "[1] Notes (document doc1, bytes 10-20)") {
+		t.Fatalf("citation missing from context: %s", augmented[2].Content)
+	}
+	if len(messages) != 3 {
+		t.Fatal("original messages were modified")
+	}
+}
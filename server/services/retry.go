@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// RetryPolicy controls how provider requests are retried before falling back to the next model
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy builds the retry policy from the environment, falling back to sane defaults.
+//
+//	AI_RETRY_MAX_ATTEMPTS     attempts per model (default 3)
+//	AI_RETRY_INITIAL_BACKOFF  delay before the first retry (default 500ms)
+//	AI_RETRY_MAX_BACKOFF      upper bound for a single delay (default 8s)
+func DefaultRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     8 * time.Second,
+		Multiplier:     2,
+	}
+
+	if attempts, err := strconv.Atoi(os.Getenv("AI_RETRY_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		policy.MaxAttempts = attempts
+	}
+
+	if backoff, err := time.ParseDuration(os.Getenv("AI_RETRY_INITIAL_BACKOFF")); err == nil && backoff >= 0 {
+		policy.InitialBackoff = backoff
+	}
+
+	if backoff, err := time.ParseDuration(os.Getenv("AI_RETRY_MAX_BACKOFF")); err == nil && backoff >= 0 {
+		policy.MaxBackoff = backoff
+	}
+
+	return policy
+}
+
+// Backoff returns the delay to wait before the given retry (1 for the first retry)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	if retry <= 0 {
+		return 0
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// wait blocks for the backoff of the given retry or until the context is done
+func (p RetryPolicy) wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(p.Backoff(retry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRetryableError reports whether a provider error is worth retrying:
+// rate limits, server errors and timeouts
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode == http.StatusRequestTimeout ||
+			apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return false
+}
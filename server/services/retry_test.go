@@ -0,0 +1,52 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"textly/services"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := services.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+
+	expected := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for retry, want := range expected {
+		if got := policy.Backoff(retry); got != want {
+			t.Fatalf("Backoff(%d) = %v, want %v", retry, got, want)
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", &openai.Error{StatusCode: 429}, true},
+		{"server error", &openai.Error{StatusCode: 503}, true},
+		{"bad request", &openai.Error{StatusCode: 400}, false},
+		{"unauthorized", &openai.Error{StatusCode: 401}, false},
+		{"wrapped server error", fmt.Errorf("stream: %w", &openai.Error{StatusCode: 502}), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"canceled", context.Canceled, false},
+		{"other", errors.New("boom"), false},
+	}
+
+	for _, c := range cases {
+		if got := services.IsRetryableError(c.err); got != c.want {
+			t.Fatalf("%s: IsRetryableError = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"textly/queries"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/packages/ssestream"
+	"github.com/openai/openai-go/shared"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	reviewSectionSize = 6000
+	reviewMaxTokens   = int64(4000)
+	reviewTimeout     = 10 * time.Minute
+	maxReviewOutline  = 50
+	maxReviewError    = 2000
+	maxReviewComment  = 10_000
+	// reviewVersion is part of the content hash, so changing the prompt invalidates the
+	// cached reviews
+	reviewVersion = "1"
+)
+
+// Statuses of a document review
+const (
+	ReviewStatusRunning   = "running"
+	ReviewStatusCompleted = "completed"
+	ReviewStatusFailed    = "failed"
+)
+
+const reviewPrompt = `You are an editor reviewing one section of a longer document.
+Report the issues worth fixing in the section: unclear or ambiguous sentences (clarity), spelling, grammar and punctuation mistakes (grammar), contradictions with the rest of the document or inconsistent terms and formatting (consistency), and claims that look wrong or need a source (factual).
+Respond with a JSON object of the form {"issues": [{"quote": "...", "category": "clarity|grammar|consistency|factual", "severity": "low|medium|high", "message": "...", "suggestion": "..."}]}.
+quote must be copied exactly from the section, character for character. Keep it as short as possible while still pointing at the problem, usually a few words.
+message explains the issue in one sentence. suggestion is the text that should replace the quote, or empty when there is no direct fix.
+Report each issue once. Respond with {"issues": []} when the section has no issues.`
+
+var (
+	reviewCategories = []string{"clarity", "grammar", "consistency", "factual"}
+	reviewSeverities = []string{"low", "medium", "high"}
+)
+
+// reviewJobs tracks the reviews running in this process. A review stored as running
+// that is not in it was interrupted by a restart and can be started again. Starting
+// holds the documents and contents whose review is being stored, and commenting the
+// reviews whose comments are being added. The lock only guards the maps, never the
+// database, so reviews of other documents do not wait on each other.
+var reviewJobs = struct {
+	mu         sync.Mutex
+	running    map[string]bool
+	starting   map[string]bool
+	commenting map[string]bool
+}{running: make(map[string]bool), starting: make(map[string]bool), commenting: make(map[string]bool)}
+
+// ErrReviewBusy is returned when another request is starting the same review or adding
+// its comments
+var ErrReviewBusy = errors.New("the review is busy, try again")
+
+// claimReviewJob takes the key in the jobs, reporting false when it is already taken
+func claimReviewJob(jobs map[string]bool, key string) bool {
+	reviewJobs.mu.Lock()
+	defer reviewJobs.mu.Unlock()
+
+	if jobs[key] {
+		return false
+	}
+	jobs[key] = true
+	return true
+}
+
+func releaseReviewJob(jobs map[string]bool, key string) {
+	reviewJobs.mu.Lock()
+	defer reviewJobs.mu.Unlock()
+
+	delete(jobs, key)
+}
+
+func isReviewRunning(id string) bool {
+	reviewJobs.mu.Lock()
+	defer reviewJobs.mu.Unlock()
+
+	return reviewJobs.running[id]
+}
+
+// ReviewIssue is an issue as reported by the model
+type ReviewIssue struct {
+	Quote      string `json:"quote"`
+	Category   string `json:"category"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// ReviewSuggestion is an issue located in the reviewed content. Start and End count
+// characters, like comment anchors. CommentId is set once it was added as a comment.
+type ReviewSuggestion struct {
+	Category   string `json:"category"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+	Quote      string `json:"quote"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	CommentId  string `json:"comment_id,omitempty"`
+}
+
+// ReviewContentHash identifies the content a review was made for
+func ReviewContentHash(content string) string {
+	hash := sha256.Sum256([]byte(reviewVersion + "\n" + content))
+	return hex.EncodeToString(hash[:])
+}
+
+// StartDocumentReview reviews the current content of the document in the background and
+// returns the running review. The review of unchanged content is returned as it is,
+// unless it failed or force is set. With comment, the suggestions are added to the
+// document as assistant comments once the review is complete.
+func StartDocumentReview(app core.App, documentId, userId string, models []string, force, comment bool) (*queries.DocumentReview, error) {
+	document, err := app.FindRecordById("documents", documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	content := document.GetString("content")
+	contentHash := ReviewContentHash(content)
+
+	// Only one request at a time looks up and starts the review of the same content
+	startKey := documentId + ":" + contentHash
+	if !claimReviewJob(reviewJobs.starting, startKey) {
+		return nil, ErrReviewBusy
+	}
+	defer releaseReviewJob(reviewJobs.starting, startKey)
+
+	existing, err := queries.GetDocumentReviewByContentHash(app, documentId, contentHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if existing != nil && (isReviewRunning(existing.Id) || (existing.Status == ReviewStatusCompleted && !force)) {
+		if comment && existing.Status == ReviewStatusCompleted {
+			return existing, AddReviewComments(app, existing, userId)
+		}
+		return existing, nil
+	}
+
+	sections := ReviewSections(content, reviewSectionSize)
+	review, err := queries.StartDocumentReview(app, &queries.DocumentReview{
+		DocumentId:    documentId,
+		UserId:        userId,
+		ContentHash:   contentHash,
+		Status:        ReviewStatusRunning,
+		SectionsTotal: len(sections),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claimReviewJob(reviewJobs.running, review.Id)
+	go runDocumentReview(app, *review, content, sections, models, comment)
+
+	return review, nil
+}
+
+func runDocumentReview(app core.App, review queries.DocumentReview, content string, sections []TextChunk, models []string, comment bool) {
+	defer releaseReviewJob(reviewJobs.running, review.Id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), reviewTimeout)
+	defer cancel()
+
+	outline := documentOutline(content)
+	suggestions := []ReviewSuggestion{}
+	review.Status = ReviewStatusCompleted
+
+	for i, section := range sections {
+		issues, model, cost, err := reviewSection(ctx, models, outline, section.Content)
+		review.Cost += cost
+		if err != nil {
+			review.Status = ReviewStatusFailed
+			review.Error = truncateText(err.Error(), maxReviewError)
+			break
+		}
+
+		review.Model = model
+		review.SectionsDone = i + 1
+		suggestions = append(suggestions, LocateReviewIssues(content, section, issues)...)
+
+		if err := queries.UpdateDocumentReviewProgress(app, review.Id, review.SectionsDone); err != nil {
+			log.Printf("Failed to save progress of review %s: %v", review.Id, err)
+		}
+	}
+
+	slices.SortStableFunc(suggestions, func(a, b ReviewSuggestion) int {
+		return cmp.Or(cmp.Compare(a.Start, b.Start), cmp.Compare(a.End, b.End))
+	})
+
+	data, err := json.Marshal(suggestions)
+	if err != nil {
+		log.Printf("Failed to encode suggestions of review %s: %v", review.Id, err)
+		return
+	}
+	review.Suggestions = data
+
+	if err := queries.FinishDocumentReview(app, &review); err != nil {
+		log.Printf("Failed to save review %s: %v", review.Id, err)
+		return
+	}
+
+	if comment && review.Status == ReviewStatusCompleted {
+		if err := AddReviewComments(app, &review, review.UserId); err != nil {
+			log.Printf("Failed to add comments of review %s: %v", review.Id, err)
+		}
+	}
+}
+
+// reviewSection asks the model for the issues of one section and returns them with the
+// model that answered and the cost of the request
+func reviewSection(ctx context.Context, models []string, outline, section string) ([]ReviewIssue, string, float64, error) {
+	var client = GetOpenAiClient()
+
+	prompt := "Section to review:\n" + section
+	if outline != "" {
+		prompt = "Outline of the whole document:\n" + outline + "\n\n" + prompt
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(reviewPrompt),
+			openai.UserMessage(prompt),
+		},
+		MaxTokens: param.NewOpt(reviewMaxTokens),
+		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONObject: &shared.ResponseFormatJSONObjectParam{},
+		},
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: param.NewOpt(true),
+		},
+	}
+
+	params.SetExtraFields(map[string]any{
+		"include_reasoning": param.NewOpt(false),
+	})
+
+	stream, err := StreamWithFallback(ctx, models, DefaultRetryPolicy(), func(model string) *ssestream.Stream[openai.ChatCompletionChunk] {
+		params.Model = model
+		return client.Chat.Completions.NewStreaming(ctx, params)
+	})
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer stream.Close()
+
+	var response strings.Builder
+	for stream.Next() {
+		chunk := stream.Current()
+		if len(chunk.Choices) > 0 {
+			response.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+
+	_, _, _, cost := stream.Totals()
+	if err := stream.Err(); err != nil {
+		return nil, stream.Model, cost, err
+	}
+
+	issues, err := ParseReviewIssues(response.String())
+	return issues, stream.Model, cost, err
+}
+
+// ReviewSections splits the content into sections of at most about size bytes for review.
+// Sections start at headings where possible, so that a section covers whole parts of the
+// document, and parts longer than size are split like chunks.
+func ReviewSections(content string, size int) []TextChunk {
+	var sections []TextChunk
+	add := func(start, end int) {
+		for _, chunk := range ChunkText(content[start:end], size, 0) {
+			chunk.Index = len(sections)
+			chunk.Start += start
+			chunk.End += start
+			sections = append(sections, chunk)
+		}
+	}
+
+	boundaries := []int{}
+	offset := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if offset > 0 && headingPattern.MatchString(line) {
+			boundaries = append(boundaries, offset)
+		}
+		offset += len(line)
+	}
+	boundaries = append(boundaries, len(content))
+
+	start, previous := 0, 0
+	for _, boundary := range boundaries {
+		if boundary-start > size && previous > start {
+			add(start, previous)
+			start = previous
+		}
+		previous = boundary
+	}
+	add(start, len(content))
+
+	return sections
+}
+
+// ParseReviewIssues reads the issues out of the model's response. Issues without a quote
+// or message, or with an unknown category, are dropped, and unknown severities count as
+// medium.
+func ParseReviewIssues(response string) ([]ReviewIssue, error) {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("review response is not a JSON object")
+	}
+
+	var parsed struct {
+		Issues []ReviewIssue `json:"issues"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid review response: %w", err)
+	}
+
+	issues := make([]ReviewIssue, 0, len(parsed.Issues))
+	for _, issue := range parsed.Issues {
+		issue.Category = strings.ToLower(strings.TrimSpace(issue.Category))
+		issue.Severity = strings.ToLower(strings.TrimSpace(issue.Severity))
+		issue.Message = strings.TrimSpace(issue.Message)
+
+		if issue.Quote == "" || issue.Message == "" || !slices.Contains(reviewCategories, issue.Category) {
+			continue
+		}
+		if !slices.Contains(reviewSeverities, issue.Severity) {
+			issue.Severity = "medium"
+		}
+
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+// LocateReviewIssues finds the quotes of the issues reported for a section of the content.
+// A quote is looked up in the section first and then in the rest of the content, nearest
+// to the section. Issues whose quote cannot be found are dropped.
+func LocateReviewIssues(content string, section TextChunk, issues []ReviewIssue) []ReviewSuggestion {
+	sectionStart := utf8.RuneCountInString(content[:section.Start])
+
+	suggestions := make([]ReviewSuggestion, 0, len(issues))
+	for _, issue := range issues {
+		// Models sometimes wrap the quote in quotation marks or spaces
+		for _, quote := range []string{issue.Quote, strings.Trim(issue.Quote, " \"'“”‘’")} {
+			if quote == "" {
+				continue
+			}
+
+			start, found := 0, false
+			if index := strings.Index(section.Content, quote); index >= 0 {
+				start, found = sectionStart+utf8.RuneCountInString(section.Content[:index]), true
+			} else {
+				start, found = findNearest(content, quote, sectionStart)
+			}
+			if !found {
+				continue
+			}
+
+			suggestion := ReviewSuggestion{
+				Category:   issue.Category,
+				Severity:   issue.Severity,
+				Message:    issue.Message,
+				Suggestion: issue.Suggestion,
+				Quote:      quote,
+				Start:      start,
+				End:        start + utf8.RuneCountInString(quote),
+			}
+
+			duplicate := slices.ContainsFunc(suggestions, func(s ReviewSuggestion) bool {
+				return s.Start == suggestion.Start && s.End == suggestion.End && s.Category == suggestion.Category
+			})
+			if !duplicate {
+				suggestions = append(suggestions, suggestion)
+			}
+			break
+		}
+	}
+
+	return suggestions
+}
+
+// AddReviewComments adds the suggestions of a completed review that have no comment yet
+// as assistant comments on the document, as long as its content is still the reviewed one
+func AddReviewComments(app core.App, review *queries.DocumentReview, userId string) error {
+	if !claimReviewJob(reviewJobs.commenting, review.Id) {
+		return ErrReviewBusy
+	}
+	defer releaseReviewJob(reviewJobs.commenting, review.Id)
+
+	document, err := app.FindRecordById("documents", review.DocumentId)
+	if err != nil {
+		return err
+	}
+
+	if ReviewContentHash(document.GetString("content")) != review.ContentHash {
+		return fmt.Errorf("the document changed since it was reviewed")
+	}
+
+	// Read the suggestions again in case another request added the comments meanwhile
+	current, err := queries.GetDocumentReviewById(app, review.Id)
+	if err != nil {
+		return err
+	}
+
+	var suggestions []ReviewSuggestion
+	if err := json.Unmarshal(current.Suggestions, &suggestions); err != nil {
+		return err
+	}
+
+	// A review run again with force finds the comments of the previous run
+	comments, err := queries.GetAnchoredDocumentComments(app, review.DocumentId)
+	if err != nil {
+		return err
+	}
+
+	added := 0
+	for i, suggestion := range suggestions {
+		if suggestion.CommentId != "" {
+			continue
+		}
+
+		text := reviewCommentText(suggestion)
+		existing := slices.IndexFunc(comments, func(c *queries.DocumentComment) bool {
+			return c.AuthorType == CommentAuthorAssistant && c.AnchorStart == suggestion.Start && c.AnchorEnd == suggestion.End && c.Content == text
+		})
+		if existing >= 0 {
+			suggestions[i].CommentId = comments[existing].Id
+			added++
+			continue
+		}
+
+		comment, err := queries.CreateDocumentComment(app, &queries.DocumentComment{
+			DocumentId:  review.DocumentId,
+			UserId:      userId,
+			AuthorType:  CommentAuthorAssistant,
+			Content:     text,
+			AnchorStart: suggestion.Start,
+			AnchorEnd:   suggestion.End,
+			Quote:       suggestion.Quote,
+		})
+		if err != nil {
+			return err
+		}
+
+		suggestions[i].CommentId = comment.Id
+		added++
+	}
+
+	if added == 0 {
+		review.Suggestions = current.Suggestions
+		return nil
+	}
+
+	data, err := json.Marshal(suggestions)
+	if err != nil {
+		return err
+	}
+	review.Suggestions = data
+
+	return queries.UpdateDocumentReviewSuggestions(app, review.Id, data)
+}
+
+func reviewCommentText(suggestion ReviewSuggestion) string {
+	text := fmt.Sprintf("%s%s (%s): %s", strings.ToUpper(suggestion.Category[:1]), suggestion.Category[1:], suggestion.Severity, suggestion.Message)
+	if suggestion.Suggestion != "" {
+		text += "\n\nSuggestion: " + suggestion.Suggestion
+	}
+	return truncateText(text, maxReviewComment)
+}
+
+// documentOutline lists the headings of the content, which gives the model the context of
+// the section it reviews
+func documentOutline(content string) string {
+	var headings []string
+	for _, line := range strings.Split(content, "\n") {
+		if headingPattern.MatchString(line) {
+			headings = append(headings, strings.TrimSpace(line))
+			if len(headings) == maxReviewOutline {
+				break
+			}
+		}
+	}
+	return strings.Join(headings, "\n")
+}
+
+// truncateText cuts the text to at most n characters
+func truncateText(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n])
+}
@@ -0,0 +1,93 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"textly/services"
+)
+
+func TestReviewSectionsStartAtHeadings(t *testing.T) {
+	intro := "Intro paragraph.\n\n"
+	first := "# First\n" + strings.Repeat("Some words here. ", 20) + "\n\n"
+	second := "## Second\n" + strings.Repeat("More words here. ", 20) + "\n"
+	content := intro + first + second
+
+	sections := services.ReviewSections(content, len(intro+first)+10)
+	if len(sections) != 2 {
+		t.Fatalf("expected 2 sections, got %d", len(sections))
+	}
+	if sections[0].Content != intro+first || sections[1].Content != second {
+		t.Fatalf("unexpected sections %q and %q", sections[0].Content, sections[1].Content)
+	}
+	if sections[1].Start != len(intro+first) || sections[1].End != len(content) {
+		t.Fatalf("unexpected offsets %d-%d", sections[1].Start, sections[1].End)
+	}
+
+	// A part longer than the size is split further
+	long := services.ReviewSections(strings.Repeat("A sentence that goes on. ", 100), 500)
+	if len(long) < 5 {
+		t.Fatalf("expected the long part to be split, got %d sections", len(long))
+	}
+	for _, section := range long {
+		if len(section.Content) > 500 {
+			t.Fatalf("section of %d bytes is over the size", len(section.Content))
+		}
+	}
+}
+
+func TestParseReviewIssues(t *testing.T) {
+	response := "```json\n" + `{"issues": [
+		{"quote": "teh", "category": "Grammar", "severity": "HIGH", "message": "Typo", "suggestion": "the"},
+		{"quote": "x", "category": "style", "severity": "low", "message": "Unknown category"},
+		{"quote": "", "category": "clarity", "severity": "low", "message": "No quote"},
+		{"quote": "it", "category": "clarity", "severity": "urgent", "message": "Unclear reference"}
+	]}` + "\n```"
+
+	issues, err := services.ParseReviewIssues(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issues) != 2 || issues[0].Category != "grammar" || issues[0].Severity != "high" || issues[1].Severity != "medium" {
+		t.Fatalf("unexpected issues %+v", issues)
+	}
+
+	if _, err := services.ParseReviewIssues("I found no issues."); err == nil {
+		t.Fatalf("expected an error for a response without JSON")
+	}
+}
+
+func TestLocateReviewIssues(t *testing.T) {
+	content := "Café intro, teh start.\n# Part\nHere is teh problem. Also “quoted” text."
+	sections := services.ReviewSections(content, 25)
+	section := sections[len(sections)-1]
+
+	issues := []services.ReviewIssue{
+		{Quote: "teh", Category: "grammar", Severity: "low", Message: "Typo"},
+		{Quote: "teh", Category: "grammar", Severity: "low", Message: "Same typo again"},
+		{Quote: `"quoted" text`, Category: "clarity", Severity: "low", Message: "Straight quotes that are curly in the text"},
+		{Quote: "\"Also\"", Category: "clarity", Severity: "low", Message: "Quotes added by the model"},
+		{Quote: "missing", Category: "clarity", Severity: "low", Message: "Not in the text"},
+		{Quote: "intro", Category: "consistency", Severity: "low", Message: "Outside of the section"},
+	}
+
+	suggestions := services.LocateReviewIssues(content, section, issues)
+	if len(suggestions) != 3 {
+		t.Fatalf("expected 3 suggestions, got %+v", suggestions)
+	}
+
+	runes := []rune(content)
+	for _, suggestion := range suggestions {
+		if string(runes[suggestion.Start:suggestion.End]) != suggestion.Quote {
+			t.Fatalf("suggestion %+v does not point at its quote", suggestion)
+		}
+	}
+
+	// The typo inside the section is preferred over the one before it
+	if !strings.HasSuffix(string(runes[:suggestions[0].Start]), "Here is ") {
+		t.Fatalf("typo located at %d instead of inside the section", suggestions[0].Start)
+	}
+	if suggestions[1].Quote != "Also" || suggestions[2].Quote != "intro" {
+		t.Fatalf("unexpected suggestions %+v", suggestions[1:])
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"textly/queries"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	InsertAtStart      = "start"
+	InsertAtEnd        = "end"
+	InsertAtOffset     = "offset"
+	InsertAfterHeading = "after_heading"
+)
+
+// InsertPosition chooses where saved chat content goes in an existing document.
+// Offset counts characters from the start of the content, Heading matches the text of
+// a markdown heading and inserts at the end of its section.
+type InsertPosition struct {
+	Mode    string `json:"mode,omitempty"`
+	Offset  int    `json:"offset,omitempty"`
+	Heading string `json:"heading,omitempty"`
+}
+
+// DocumentSource records which chat content was saved into a document. Offset and Length
+// are in characters and describe the document as it was right after saving.
+type DocumentSource struct {
+	ConversationId string   `json:"conversation_id"`
+	MessageIds     []string `json:"message_ids"`
+	Offset         int      `json:"offset"`
+	Length         int      `json:"length"`
+	SavedAt        string   `json:"saved_at"`
+}
+
+// MessageMarkdown returns the assistant answer of a message, optionally preceded by the
+// prompt as a quote
+func MessageMarkdown(message *queries.ConversationMessage, includePrompt bool) string {
+	response := strings.TrimSpace(message.ResponseMessage)
+	if !includePrompt || strings.TrimSpace(message.UserMessage) == "" {
+		return response
+	}
+
+	lines := strings.Split(strings.TrimSpace(message.UserMessage), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n\n" + response
+}
+
+// InsertIntoDocument places the text into the content at the position, separated from the
+// surrounding paragraphs by blank lines. It returns the new content and the character
+// offset where the text starts.
+func InsertIntoDocument(content, text string, position InsertPosition) (string, int, error) {
+	text = strings.TrimSpace(text)
+
+	index, err := insertIndex(content, position)
+	if err != nil {
+		return "", 0, err
+	}
+
+	before, after := content[:index], content[index:]
+
+	prefix := "\n\n"
+	switch {
+	case before == "", strings.HasSuffix(before, "\n\n"):
+		prefix = ""
+	case strings.HasSuffix(before, "\n"):
+		prefix = "\n"
+	}
+
+	suffix := "\n\n"
+	switch {
+	case after == "":
+		suffix = "\n"
+	case strings.HasPrefix(after, "\n"):
+		suffix = "\n"
+	}
+
+	offset := utf8.RuneCountInString(before + prefix)
+	return before + prefix + text + suffix + after, offset, nil
+}
+
+// insertIndex converts the position into a byte index of the content
+func insertIndex(content string, position InsertPosition) (int, error) {
+	switch position.Mode {
+	case InsertAtStart:
+		return 0, nil
+	case "", InsertAtEnd:
+		return len(content), nil
+	case InsertAtOffset:
+		if position.Offset < 0 || position.Offset > utf8.RuneCountInString(content) {
+			return 0, fmt.Errorf("offset %d is outside of the document", position.Offset)
+		}
+
+		index := 0
+		for range position.Offset {
+			_, size := utf8.DecodeRuneInString(content[index:])
+			index += size
+		}
+		return index, nil
+	case InsertAfterHeading:
+		return sectionEnd(content, position.Heading)
+	default:
+		return 0, fmt.Errorf("unknown position %q, use start, end, offset or after_heading", position.Mode)
+	}
+}
+
+// sectionEnd finds the heading and returns the index where its section ends, which is the
+// next heading of the same or a higher level, or the end of the content
+func sectionEnd(content, heading string) (int, error) {
+	heading = strings.TrimSpace(heading)
+	if heading == "" {
+		return 0, fmt.Errorf("heading is required to insert after a heading")
+	}
+
+	level := 0
+	inFence := false
+	index := 0
+
+	for index < len(content) {
+		end := strings.IndexByte(content[index:], '\n')
+		lineEnd := len(content)
+		if end >= 0 {
+			lineEnd = index + end + 1
+		}
+		line := strings.TrimRight(content[index:lineEnd], "\r\n")
+
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		} else if !inFence {
+			if lineLevel, text := atxHeading(line); lineLevel > 0 {
+				if level > 0 && lineLevel <= level {
+					return index, nil
+				}
+				if level == 0 && strings.EqualFold(text, heading) {
+					level = lineLevel
+				}
+			}
+		}
+
+		index = lineEnd
+	}
+
+	if level == 0 {
+		return 0, fmt.Errorf("heading %q was not found", heading)
+	}
+	return len(content), nil
+}
+
+// atxHeading returns the level and text of a "# Heading" line, or zero for other lines
+func atxHeading(line string) (int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return 0, ""
+	}
+
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(trimmed) && trimmed[level] != ' ' && trimmed[level] != '\t') {
+		return 0, ""
+	}
+
+	text := strings.TrimSpace(trimmed[level:])
+	text = strings.TrimSpace(strings.TrimRight(text, "#"))
+	return level, text
+}
+
+// AddDocumentSource appends the source to the "sources" list of the document metadata,
+// keeping any other metadata as it is
+func AddDocumentSource(metadata types.JSONRaw, source DocumentSource) (types.JSONRaw, error) {
+	fields := make(map[string]any)
+	if raw := strings.TrimSpace(string(metadata)); raw != "" && raw != "null" {
+		if err := json.Unmarshal(metadata, &fields); err != nil {
+			return nil, fmt.Errorf("document metadata is not a JSON object: %w", err)
+		}
+	}
+
+	sources, _ := fields["sources"].([]any)
+	fields["sources"] = append(sources, source)
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return types.JSONRaw(data), nil
+}
@@ -0,0 +1,81 @@
+package services_test
+
+import (
+	"encoding/json"
+	"testing"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func TestInsertIntoDocument(t *testing.T) {
+	content := "# Trip\n\nIntro.\n\n## Ideas\n\nBeach.\n\n## Budget\n\nLow."
+
+	tests := []struct {
+		name     string
+		position services.InsertPosition
+		expected string
+		offset   int
+	}{
+		{"end", services.InsertPosition{}, content + "\n\nNEW\n", len(content) + 2},
+		{"start", services.InsertPosition{Mode: services.InsertAtStart}, "NEW\n\n" + content, 0},
+		{"after heading", services.InsertPosition{Mode: services.InsertAfterHeading, Heading: "ideas"}, "# Trip\n\nIntro.\n\n## Ideas\n\nBeach.\n\nNEW\n\n## Budget\n\nLow.", 34},
+		{"offset", services.InsertPosition{Mode: services.InsertAtOffset, Offset: 15}, "# Trip\n\nIntro.\n\nNEW\n\n## Ideas\n\nBeach.\n\n## Budget\n\nLow.", 16},
+	}
+
+	for _, test := range tests {
+		updated, offset, err := services.InsertIntoDocument(content, " NEW ", test.position)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if updated != test.expected {
+			t.Fatalf("%s: unexpected content %q", test.name, updated)
+		}
+		if offset != test.offset {
+			t.Fatalf("%s: expected offset %d, got %d", test.name, test.offset, offset)
+		}
+	}
+
+	if _, _, err := services.InsertIntoDocument(content, "NEW", services.InsertPosition{Mode: services.InsertAfterHeading, Heading: "Missing"}); err == nil {
+		t.Fatal("expected an error for a missing heading")
+	}
+	if _, _, err := services.InsertIntoDocument("héllo", "NEW", services.InsertPosition{Mode: services.InsertAtOffset, Offset: 6}); err == nil {
+		t.Fatal("expected an error for an offset past the end")
+	}
+}
+
+func TestMessageMarkdownQuotesPrompt(t *testing.T) {
+	message := &queries.ConversationMessage{UserMessage: "Why?\n\nExplain", ResponseMessage: "Because."}
+
+	if got := services.MessageMarkdown(message, true); got != "> Why?\n>\n> Explain\n\nBecause." {
+		t.Fatalf("unexpected markdown %q", got)
+	}
+	if got := services.MessageMarkdown(message, false); got != "Because." {
+		t.Fatalf("unexpected markdown %q", got)
+	}
+}
+
+func TestAddDocumentSourceKeepsMetadata(t *testing.T) {
+	metadata := types.JSONRaw(`{"tags":["travel"],"sources":[{"conversation_id":"old"}]}`)
+
+	updated, err := services.AddDocumentSource(metadata, services.DocumentSource{ConversationId: "conv", MessageIds: []string{"msg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields struct {
+		Tags    []string                  `json:"tags"`
+		Sources []services.DocumentSource `json:"sources"`
+	}
+	if err := json.Unmarshal(updated, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if len(fields.Tags) != 1 || len(fields.Sources) != 2 || fields.Sources[1].MessageIds[0] != "msg" {
+		t.Fatalf("unexpected metadata %s", updated)
+	}
+
+	if _, err := services.AddDocumentSource(types.JSONRaw(`[1]`), services.DocumentSource{}); err == nil {
+		t.Fatal("expected an error for metadata that is not an object")
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"slices"
+	"textly/queries"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Roles on a document, from least to most access. A share grants its role on the
+// document and everything below it, and the owner of a folder can edit everything
+// filed under it.
+const (
+	RoleViewer    = "viewer"
+	RoleCommenter = "commenter"
+	RoleEditor    = "editor"
+	RoleOwner     = "owner"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// IsShareRole reports whether the role can be granted with a share
+func IsShareRole(role string) bool {
+	return role == RoleViewer || role == RoleCommenter || role == RoleEditor
+}
+
+// RoleAllows reports whether the role includes the access of the required role
+func RoleAllows(role, required string) bool {
+	return role != "" && roleRanks[role] >= roleRanks[required]
+}
+
+// AccessGrant is a role held by a user on a document
+type AccessGrant struct {
+	UserId string
+	Role   string
+}
+
+// EffectiveRole returns the highest role granted to the user, or an empty string
+func EffectiveRole(grants []AccessGrant, userId string) string {
+	role := ""
+	for _, grant := range grants {
+		if grant.UserId == userId && roleRanks[grant.Role] > roleRanks[role] {
+			role = grant.Role
+		}
+	}
+	return role
+}
+
+// AccessLists returns the users other than the owner who may view the document and
+// those who may edit it, as stored in its viewers and editors fields
+func AccessLists(grants []AccessGrant, ownerId string) (viewers, editors []string) {
+	viewers, editors = []string{}, []string{}
+
+	for _, grant := range grants {
+		if grant.UserId == "" || grant.UserId == ownerId || slices.Contains(viewers, grant.UserId) {
+			continue
+		}
+
+		viewers = append(viewers, grant.UserId)
+		if RoleAllows(EffectiveRole(grants, grant.UserId), RoleEditor) {
+			editors = append(editors, grant.UserId)
+		}
+	}
+
+	slices.Sort(viewers)
+	slices.Sort(editors)
+	return viewers, editors
+}
+
+// DocumentRole returns the role of the user on the document, taking the shares and
+// owners of its folders into account, or an empty string when they have no access
+func DocumentRole(app core.App, documentId, userId string) (string, error) {
+	document, err := app.FindRecordById("documents", documentId)
+	if err != nil {
+		return "", err
+	}
+
+	if document.GetString("user") == userId {
+		return RoleOwner, nil
+	}
+
+	grants, err := inheritedGrants(app, documentId)
+	if err != nil {
+		return "", err
+	}
+
+	shares, err := queries.GetDocumentSharesByDocumentIds(app, []string{documentId})
+	if err != nil {
+		return "", err
+	}
+
+	return EffectiveRole(append(grants, shareGrants(shares)...), userId), nil
+}
+
+// SyncDocumentAccess recomputes the viewers and editors of the document and everything
+// below it. It runs whenever a share changes or a document moves to another folder.
+func SyncDocumentAccess(app core.App, documentId string) error {
+	grants, err := inheritedGrants(app, documentId)
+	if err != nil {
+		return err
+	}
+
+	subtree, err := queries.GetDocumentSubtree(app, documentId)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(subtree))
+	for i, node := range subtree {
+		ids[i] = node.Id
+	}
+
+	shares, err := queries.GetDocumentSharesByDocumentIds(app, ids)
+	if err != nil {
+		return err
+	}
+
+	sharesByDocument := make(map[string][]*queries.DocumentShare)
+	for _, share := range shares {
+		sharesByDocument[share.DocumentId] = append(sharesByDocument[share.DocumentId], share)
+	}
+
+	// The subtree is ordered by depth, so a folder is always handled before its children
+	grantsBelow := make(map[string][]AccessGrant)
+	for _, node := range subtree {
+		nodeGrants := grants
+		if node.Id != documentId {
+			nodeGrants = grantsBelow[node.Parent]
+		}
+		nodeGrants = append(slices.Clone(nodeGrants), shareGrants(sharesByDocument[node.Id])...)
+
+		viewers, editors := AccessLists(nodeGrants, node.UserId)
+		if err := queries.UpdateDocumentAccess(app, node.Id, viewers, editors); err != nil {
+			return err
+		}
+
+		grantsBelow[node.Id] = append(nodeGrants, AccessGrant{UserId: node.UserId, Role: RoleEditor})
+	}
+
+	return nil
+}
+
+// ClaimDocumentShares gives the user the invitations sent to their email address before
+// they signed up. Anyone can sign up with any address, so nothing is claimed until the
+// address is verified.
+func ClaimDocumentShares(app core.App, user *core.Record) error {
+	if !user.Verified() {
+		return nil
+	}
+
+	documentIds, err := queries.ClaimDocumentShares(app, user.Id, user.Email())
+	if err != nil {
+		return err
+	}
+
+	for _, documentId := range documentIds {
+		if err := SyncDocumentAccess(app, documentId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inheritedGrants collects the roles given on the folders above the document
+func inheritedGrants(app core.App, documentId string) ([]AccessGrant, error) {
+	ancestors, err := queries.GetDocumentAncestors(app, documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(ancestors))
+	grants := make([]AccessGrant, 0, len(ancestors))
+	for i, ancestor := range ancestors {
+		ids[i] = ancestor.Id
+		grants = append(grants, AccessGrant{UserId: ancestor.UserId, Role: RoleEditor})
+	}
+
+	shares, err := queries.GetDocumentSharesByDocumentIds(app, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(grants, shareGrants(shares)...), nil
+}
+
+// shareGrants converts the shares into grants, skipping invitations nobody has claimed yet
+func shareGrants(shares []*queries.DocumentShare) []AccessGrant {
+	grants := make([]AccessGrant, 0, len(shares))
+	for _, share := range shares {
+		if share.UserId != "" {
+			grants = append(grants, AccessGrant{UserId: share.UserId, Role: share.Role})
+		}
+	}
+	return grants
+}
@@ -0,0 +1,116 @@
+//go:build !goexperiment.jsonv2
+
+package services_test
+
+import (
+	"slices"
+	"testing"
+	"textly/queries"
+	"textly/services"
+)
+
+func TestSyncDocumentAccessInheritsFolderShares(t *testing.T) {
+	app := newTestApp(t)
+
+	owner := createTestUser(t, app, "owner@example.com", true)
+	reader := createTestUser(t, app, "reader@example.com", true)
+	author := createTestUser(t, app, "author@example.com", true)
+
+	folder := createTestDocument(t, app, owner.Id, "Projects", "", "", true)
+	subfolder := createTestDocument(t, app, owner.Id, "Acme", "", folder.Id, true)
+	brief := createTestDocument(t, app, owner.Id, "Brief", "Text", subfolder.Id, false)
+	draft := createTestDocument(t, app, author.Id, "Draft", "Text", subfolder.Id, false)
+
+	share := func(documentId, userId, email, role string) {
+		t.Helper()
+		if _, err := queries.SaveDocumentShare(app, &queries.DocumentShare{DocumentId: documentId, UserId: userId, Email: email, Role: role, InvitedBy: owner.Id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := services.SyncDocumentAccess(app, documentId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roleOf := func(documentId, userId string) string {
+		t.Helper()
+		role, err := services.DocumentRole(app, documentId, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return role
+	}
+
+	share(folder.Id, reader.Id, reader.Email(), services.RoleViewer)
+	share(subfolder.Id, reader.Id, reader.Email(), services.RoleEditor)
+
+	if role := roleOf(folder.Id, reader.Id); role != services.RoleViewer {
+		t.Fatalf("expected the folder share to make the reader a viewer, got %q", role)
+	}
+	if role := roleOf(brief.Id, reader.Id); role != services.RoleEditor {
+		t.Fatalf("expected the subfolder share to make the reader an editor, got %q", role)
+	}
+	if role := roleOf(draft.Id, owner.Id); role != services.RoleEditor {
+		t.Fatalf("expected the owner of the folder to edit a document filed in it, got %q", role)
+	}
+
+	stored := reloadTestRecord(t, app, "documents", brief.Id)
+	if !slices.Contains(stored.GetStringSlice("viewers"), reader.Id) || !slices.Contains(stored.GetStringSlice("editors"), reader.Id) {
+		t.Fatalf("expected the reader in the access lists of the brief, got %v and %v", stored.GetStringSlice("viewers"), stored.GetStringSlice("editors"))
+	}
+	stored = reloadTestRecord(t, app, "documents", draft.Id)
+	if !slices.Contains(stored.GetStringSlice("editors"), owner.Id) || slices.Contains(stored.GetStringSlice("viewers"), author.Id) {
+		t.Fatalf("expected the folder owner and not the author in the access lists of the draft, got %v and %v", stored.GetStringSlice("viewers"), stored.GetStringSlice("editors"))
+	}
+
+	// Moving the brief out of the folders takes the inherited access away
+	brief.Set("parent", "")
+	if err := app.Save(brief); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.SyncDocumentAccess(app, brief.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if role := roleOf(brief.Id, reader.Id); role != "" {
+		t.Fatalf("expected no access once the brief left the folder, got %q", role)
+	}
+	if viewers := reloadTestRecord(t, app, "documents", brief.Id).GetStringSlice("viewers"); len(viewers) != 0 {
+		t.Fatalf("expected no viewers once the brief left the folder, got %v", viewers)
+	}
+}
+
+func TestClaimDocumentSharesNeedsVerifiedAddress(t *testing.T) {
+	app := newTestApp(t)
+
+	owner := createTestUser(t, app, "owner@example.com", true)
+	folder := createTestDocument(t, app, owner.Id, "Projects", "", "", true)
+	document := createTestDocument(t, app, owner.Id, "Plan", "Text", folder.Id, false)
+
+	if _, err := queries.SaveDocumentShare(app, &queries.DocumentShare{DocumentId: folder.Id, Email: "invitee@example.com", Role: services.RoleCommenter, InvitedBy: owner.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	invitee := createTestUser(t, app, "Invitee@example.com", false)
+	if err := services.ClaimDocumentShares(app, invitee); err != nil {
+		t.Fatal(err)
+	}
+
+	if role, err := services.DocumentRole(app, document.Id, invitee.Id); err != nil || role != "" {
+		t.Fatalf("expected an unverified address to claim nothing, got %q, %v", role, err)
+	}
+
+	invitee.SetVerified(true)
+	if err := app.Save(invitee); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.ClaimDocumentShares(app, invitee); err != nil {
+		t.Fatal(err)
+	}
+
+	if role, err := services.DocumentRole(app, document.Id, invitee.Id); err != nil || role != services.RoleCommenter {
+		t.Fatalf("expected the verified invitee to comment through the folder, got %q, %v", role, err)
+	}
+	if viewers := reloadTestRecord(t, app, "documents", document.Id).GetStringSlice("viewers"); !slices.Contains(viewers, invitee.Id) {
+		t.Fatalf("expected the invitee among the viewers after claiming, got %v", viewers)
+	}
+}
@@ -0,0 +1,53 @@
+package services_test
+
+import (
+	"slices"
+	"testing"
+	"textly/services"
+)
+
+func TestEffectiveRoleUsesHighestGrant(t *testing.T) {
+	grants := []services.AccessGrant{
+		{UserId: "alice", Role: services.RoleEditor},
+		{UserId: "bob", Role: services.RoleViewer},
+		{UserId: "bob", Role: services.RoleCommenter},
+		{UserId: "", Role: services.RoleEditor},
+	}
+
+	if role := services.EffectiveRole(grants, "bob"); role != services.RoleCommenter {
+		t.Fatalf("expected the folder share to raise bob to commenter, got %q", role)
+	}
+	if role := services.EffectiveRole(grants, "carol"); role != "" {
+		t.Fatalf("expected no access for carol, got %q", role)
+	}
+
+	if !services.RoleAllows(services.RoleOwner, services.RoleEditor) || services.RoleAllows(services.RoleCommenter, services.RoleEditor) {
+		t.Fatalf("unexpected role ordering")
+	}
+	if services.RoleAllows("", services.RoleViewer) {
+		t.Fatalf("no role must not allow viewing")
+	}
+}
+
+func TestAccessListsSkipOwnerAndPendingShares(t *testing.T) {
+	grants := []services.AccessGrant{
+		{UserId: "owner", Role: services.RoleEditor},
+		{UserId: "bob", Role: services.RoleViewer},
+		{UserId: "alice", Role: services.RoleViewer},
+		{UserId: "bob", Role: services.RoleEditor},
+		{UserId: "", Role: services.RoleViewer},
+	}
+
+	viewers, editors := services.AccessLists(grants, "owner")
+	if !slices.Equal(viewers, []string{"alice", "bob"}) {
+		t.Fatalf("unexpected viewers %v", viewers)
+	}
+	if !slices.Equal(editors, []string{"bob"}) {
+		t.Fatalf("unexpected editors %v", editors)
+	}
+
+	viewers, editors = services.AccessLists(nil, "owner")
+	if viewers == nil || editors == nil || len(viewers)+len(editors) != 0 {
+		t.Fatalf("expected empty lists, got %v and %v", viewers, editors)
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"textly/queries"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// maxTemplateDocuments and maxTemplateDepth bound the folder structure of a template
+	maxTemplateDocuments = 500
+	maxTemplateDepth     = 20
+)
+
+var (
+	placeholderPattern     = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+	placeholderNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+)
+
+// TemplatePlaceholder declares a {{variable}} of a template. A placeholder with a prompt
+// is written by the assistant when no value is given, following the prompt, which may
+// use the placeholders declared before it.
+type TemplatePlaceholder struct {
+	Name    string `json:"name"`
+	Label   string `json:"label"`
+	Default string `json:"default"`
+	Prompt  string `json:"prompt"`
+}
+
+// TemplateNode is a document or folder created from a template
+type TemplateNode struct {
+	Title    string         `json:"title"`
+	Content  string         `json:"content"`
+	IsFolder bool           `json:"is_folder"`
+	Children []TemplateNode `json:"children"`
+}
+
+// ParseDocumentTemplate reads the structure and placeholders of a template. The root
+// node is the template itself, with the children of a folder template below it.
+func ParseDocumentTemplate(template *queries.DocumentTemplate) (TemplateNode, []TemplatePlaceholder, error) {
+	root := TemplateNode{Title: template.Title, Content: template.Content, IsFolder: template.IsFolder}
+
+	if len(template.Children) > 0 && string(template.Children) != "null" {
+		if err := json.Unmarshal(template.Children, &root.Children); err != nil {
+			return root, nil, fmt.Errorf("children must be a list of documents and folders: %w", err)
+		}
+	}
+
+	var placeholders []TemplatePlaceholder
+	if len(template.Placeholders) > 0 && string(template.Placeholders) != "null" {
+		if err := json.Unmarshal(template.Placeholders, &placeholders); err != nil {
+			return root, nil, fmt.Errorf("placeholders must be a list of variables: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, placeholder := range placeholders {
+		if !placeholderNamePattern.MatchString(placeholder.Name) {
+			return root, nil, fmt.Errorf("invalid placeholder name %q", placeholder.Name)
+		}
+		if seen[placeholder.Name] {
+			return root, nil, fmt.Errorf("placeholder %q is declared twice", placeholder.Name)
+		}
+		seen[placeholder.Name] = true
+	}
+
+	count := 0
+	if err := validateTemplateNode(root, 0, &count); err != nil {
+		return root, nil, err
+	}
+
+	return root, placeholders, nil
+}
+
+func validateTemplateNode(node TemplateNode, depth int, count *int) error {
+	*count++
+	if *count > maxTemplateDocuments {
+		return fmt.Errorf("a template creates at most %d documents", maxTemplateDocuments)
+	}
+	if depth > maxTemplateDepth {
+		return fmt.Errorf("folders of a template are nested at most %d deep", maxTemplateDepth)
+	}
+	if !node.IsFolder && len(node.Children) > 0 {
+		return fmt.Errorf("document %q cannot have children, only folders can", node.Title)
+	}
+
+	for _, child := range node.Children {
+		if err := validateTemplateNode(child, depth+1, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateDocumentTemplate checks the structure and placeholders of a template record
+// before it is saved
+func ValidateDocumentTemplate(record *core.Record) error {
+	children, _ := record.Get("children").(types.JSONRaw)
+	placeholders, _ := record.Get("placeholders").(types.JSONRaw)
+
+	_, _, err := ParseDocumentTemplate(&queries.DocumentTemplate{
+		Title:        record.GetString("title"),
+		Content:      record.GetString("content"),
+		IsFolder:     record.GetBool("is_folder"),
+		Children:     children,
+		Placeholders: placeholders,
+	})
+	return err
+}
+
+// TemplateBuiltinValues are the placeholders every template can use besides its own
+func TemplateBuiltinValues(now time.Time, userName string) map[string]string {
+	return map[string]string{
+		"date":     now.Format("2006-01-02"),
+		"time":     now.Format("15:04"),
+		"datetime": now.Format("2006-01-02 15:04"),
+		"user":     userName,
+	}
+}
+
+// FillTemplate replaces the {{placeholders}} that have a value. Others are left as written.
+func FillTemplate(text string, values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// TemplateValues combines the given values with the builtin ones and the defaults of the
+// placeholders. It returns the placeholders left for the assistant to write, and those
+// that have no value while they need one.
+func TemplateValues(placeholders []TemplatePlaceholder, given, builtin map[string]string) (values map[string]string, generated []TemplatePlaceholder, missing []string) {
+	values = make(map[string]string, len(builtin)+len(given))
+	for name, value := range builtin {
+		values[name] = value
+	}
+	for name, value := range given {
+		if strings.TrimSpace(value) != "" {
+			values[name] = value
+		}
+	}
+
+	for _, placeholder := range placeholders {
+		if _, ok := given[placeholder.Name]; ok && strings.TrimSpace(given[placeholder.Name]) != "" {
+			continue
+		}
+
+		switch {
+		case placeholder.Default != "":
+			values[placeholder.Name] = FillTemplate(placeholder.Default, values)
+		case strings.TrimSpace(placeholder.Prompt) != "":
+			generated = append(generated, placeholder)
+		default:
+			missing = append(missing, placeholder.Name)
+		}
+	}
+
+	return values, generated, missing
+}
+
+// GenerateTemplateValues has the assistant write the placeholders with a prompt, in the
+// order they are declared, through the same pipeline as the other text assists
+func GenerateTemplateValues(e *core.RequestEvent, placeholders []TemplatePlaceholder, values map[string]string, title, userId string) error {
+	for _, placeholder := range placeholders {
+		text, err := TextAssist(e, TextAssistRequest{
+			Type:    "template",
+			Text:    FillTemplate(placeholder.Prompt, values),
+			Context: title,
+		}, userId)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", placeholder.Name, err)
+		}
+
+		values[placeholder.Name] = strings.TrimSpace(text)
+	}
+	return nil
+}
+
+// PlanTemplateDocuments lists the documents and folders to create from a template with
+// the placeholders filled, parents before their children. The root gets the given title.
+func PlanTemplateDocuments(root TemplateNode, values map[string]string, title string) []ImportItem {
+	var items []ImportItem
+
+	var walk func(node TemplateNode, parent, dir, title string)
+	walk = func(node TemplateNode, parent, dir, title string) {
+		title = strings.Join(strings.Fields(title), " ")
+		if title == "" {
+			title = "Untitled"
+		}
+
+		item := ImportItem{
+			Id:       core.GenerateDefaultRandomId(),
+			Path:     path.Join(dir, title),
+			Parent:   parent,
+			Title:    title,
+			IsFolder: node.IsFolder,
+		}
+		if !node.IsFolder {
+			item.Content = FillTemplate(node.Content, values)
+		}
+		items = append(items, item)
+
+		for _, child := range node.Children {
+			walk(child, item.Id, item.Path, FillTemplate(child.Title, values))
+		}
+	}
+	walk(root, "", "", title)
+
+	return items
+}
@@ -0,0 +1,78 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+	"textly/queries"
+	"textly/services"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func TestCreateFromTemplate(t *testing.T) {
+	template := &queries.DocumentTemplate{
+		Title:    "{{client}} project",
+		IsFolder: true,
+		Children: types.JSONRaw(`[
+			{"title": "Brief {{date}}", "content": "# {{title}}\n\nFor {{ client }} by {{user}}.\n\n{{outline}}\n\n{{unknown}}"},
+			{"title": "Research", "is_folder": true, "children": [{"title": "Sources", "content": "Owner: {{owner}}"}]}
+		]`),
+		Placeholders: types.JSONRaw(`[
+			{"name": "client", "label": "Client"},
+			{"name": "owner", "default": "{{user}}"},
+			{"name": "outline", "prompt": "Write an outline for {{client}}"}
+		]`),
+	}
+
+	root, placeholders, err := services.ParseDocumentTemplate(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builtin := services.TemplateBuiltinValues(time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC), "Ana")
+
+	_, _, missing := services.TemplateValues(placeholders, map[string]string{"client": " "}, builtin)
+	if !reflect.DeepEqual(missing, []string{"client"}) {
+		t.Fatalf("expected client to be missing, got %v", missing)
+	}
+
+	values, generated, missing := services.TemplateValues(placeholders, map[string]string{"client": "Acme"}, builtin)
+	if len(missing) != 0 || len(generated) != 1 || generated[0].Name != "outline" {
+		t.Fatalf("unexpected placeholders %v %v", generated, missing)
+	}
+	if services.FillTemplate(generated[0].Prompt, values) != "Write an outline for Acme" {
+		t.Fatal("the prompt should use the other values")
+	}
+
+	values["outline"] = "1. Goals"
+	values["title"] = "Acme project"
+	items := services.PlanTemplateDocuments(root, values, "Acme project")
+
+	if len(items) != 4 {
+		t.Fatalf("expected 4 documents, got %d", len(items))
+	}
+	if items[0].Parent != "" || !items[0].IsFolder || items[1].Parent != items[0].Id || items[3].Parent != items[2].Id {
+		t.Fatalf("unexpected hierarchy %+v", items)
+	}
+	if items[1].Title != "Brief 2026-03-01" || items[1].Content != "# Acme project\n\nFor Acme by Ana.\n\n1. Goals\n\n{{unknown}}" {
+		t.Fatalf("unexpected document %q %q", items[1].Title, items[1].Content)
+	}
+	if items[3].Path != "Acme project/Research/Sources" || items[3].Content != "Owner: Ana" {
+		t.Fatalf("unexpected nested document %q %q", items[3].Path, items[3].Content)
+	}
+}
+
+func TestParseDocumentTemplateErrors(t *testing.T) {
+	for _, template := range []*queries.DocumentTemplate{
+		{Children: types.JSONRaw(`[{"title": "Not in a folder"}]`)},
+		{IsFolder: true, Children: types.JSONRaw(`[{"title": "Doc", "children": [{"title": "Child"}]}]`)},
+		{Placeholders: types.JSONRaw(`[{"name": "a b"}]`)},
+		{Placeholders: types.JSONRaw(`[{"name": "topic"}, {"name": "topic"}]`)},
+		{Placeholders: types.JSONRaw(`{"name": "topic"}`)},
+	} {
+		if _, _, err := services.ParseDocumentTemplate(template); err == nil {
+			t.Fatalf("expected an error for %s %s", template.Children, template.Placeholders)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+//go:build !goexperiment.jsonv2
+
+// PocketBase v0.27 overflows the stack when built with the encoding/json v2 experiment,
+// so on toolchains where it is the default these tests need GOEXPERIMENT=nojsonv2.
+
+package services_test
+
+import (
+	"testing"
+
+	_ "textly/migrations"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// newTestApp opens a copy of the test data with the migrations applied and without the
+// hooks of the application, so tests call the services themselves
+func newTestApp(t *testing.T) *tests.TestApp {
+	t.Helper()
+
+	app, err := tests.NewTestApp("../test_pb_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	return app
+}
+
+func createTestUser(t *testing.T, app core.App, email string, verified bool) *core.Record {
+	t.Helper()
+
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := core.NewRecord(users)
+	user.SetEmail(email)
+	user.SetPassword("test-password-123")
+	user.SetVerified(verified)
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func createTestDocument(t *testing.T, app core.App, userId, title, content, parent string, isFolder bool) *core.Record {
+	t.Helper()
+
+	documents, err := app.FindCollectionByNameOrId("documents")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := core.NewRecord(documents)
+	document.Set("user", userId)
+	document.Set("title", title)
+	document.Set("content", content)
+	document.Set("parent", parent)
+	document.Set("is_folder", isFolder)
+	if err := app.Save(document); err != nil {
+		t.Fatal(err)
+	}
+
+	return document
+}
+
+// reloadTestRecord reads the record again, to see the changes made with raw queries
+func reloadTestRecord(t *testing.T, app core.App, collection, id string) *core.Record {
+	t.Helper()
+
+	record, err := app.FindRecordById(collection, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return record
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/shared"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxToolRounds bounds how many times the model can call tools before it has to answer
+const maxToolRounds = 5
+
+var toolRules = []string{
+	"You can use the provided tools to search, read and list the user's documents.",
+	"Edits you propose with tools are shown to the user for approval, they are not applied until the user accepts them.",
+}
+
+// Tool is a function the assistant can call while answering. Run receives the raw JSON
+// arguments produced by the model and returns a value that is encoded as the result.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+	Run         func(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error)
+}
+
+// ToolCall is a tool invocation requested by the model together with its outcome
+type ToolCall struct {
+	Id        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+var toolRegistry []Tool
+
+// RegisterTool makes a tool available to every toolbox, replacing a tool with the same name
+func RegisterTool(tool Tool) {
+	for i, registered := range toolRegistry {
+		if registered.Name == tool.Name {
+			toolRegistry[i] = tool
+			return
+		}
+	}
+	toolRegistry = append(toolRegistry, tool)
+}
+
+// RegisteredTools returns the tools in registration order
+func RegisteredTools() []Tool {
+	return append([]Tool(nil), toolRegistry...)
+}
+
+// Toolbox holds the tools available to one chat request and the calls made so far.
+// OnCall and OnResult let the caller stream the progress of each call.
+type Toolbox struct {
+	e        *core.RequestEvent
+	userId   string
+	tools    []Tool
+	Calls    []ToolCall
+	OnCall   func(call ToolCall)
+	OnResult func(call ToolCall)
+}
+
+// NewToolbox creates a toolbox with every registered tool acting on behalf of the user
+func NewToolbox(e *core.RequestEvent, userId string) *Toolbox {
+	return NewToolboxWithTools(e, userId, RegisteredTools())
+}
+
+// NewToolboxWithTools creates a toolbox with only the given tools
+func NewToolboxWithTools(e *core.RequestEvent, userId string, tools []Tool) *Toolbox {
+	return &Toolbox{e: e, userId: userId, tools: tools}
+}
+
+func (t *Toolbox) params() []openai.ChatCompletionToolParam {
+	var params []openai.ChatCompletionToolParam
+	for _, tool := range t.tools {
+		params = append(params, openai.ChatCompletionToolParam{
+			Function: shared.FunctionDefinitionParam{
+				Name:        tool.Name,
+				Description: param.NewOpt(tool.Description),
+				Parameters:  shared.FunctionParameters(tool.Parameters),
+			},
+		})
+	}
+	return params
+}
+
+// Run executes a call and records it. Failures are returned to the model as the call
+// error so it can correct itself instead of aborting the answer.
+func (t *Toolbox) Run(call ToolCall) ToolCall {
+	if t.OnCall != nil {
+		t.OnCall(call)
+	}
+
+	result, err := t.execute(call)
+	if err != nil {
+		call.Error = err.Error()
+	} else if encoded, err := json.Marshal(result); err != nil {
+		call.Error = fmt.Sprintf("failed to encode result: %v", err)
+	} else {
+		call.Result = encoded
+	}
+
+	if call.Error != "" {
+		log.Printf("Tool %s failed: %s", call.Name, call.Error)
+	}
+
+	t.Calls = append(t.Calls, call)
+	if t.OnResult != nil {
+		t.OnResult(call)
+	}
+
+	return call
+}
+
+func (t *Toolbox) execute(call ToolCall) (any, error) {
+	arguments := json.RawMessage(call.Arguments)
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	if !json.Valid(arguments) {
+		return nil, fmt.Errorf("arguments are not valid JSON")
+	}
+
+	for _, tool := range t.tools {
+		if tool.Name == call.Name {
+			return tool.Run(t.e, t.userId, arguments)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown tool %s", call.Name)
+}
+
+// toolMessageContent is what the model sees as the output of a call
+func (c ToolCall) toolMessageContent() string {
+	if c.Error != "" {
+		encoded, _ := json.Marshal(map[string]string{"error": c.Error})
+		return string(encoded)
+	}
+	return string(c.Result)
+}
+
+// toolCallAccumulator assembles the tool calls of one response from the streamed deltas
+type toolCallAccumulator struct {
+	calls []*ToolCall
+	index map[int64]*ToolCall
+}
+
+func (a *toolCallAccumulator) add(chunk openai.ChatCompletionChunk) {
+	if len(chunk.Choices) == 0 {
+		return
+	}
+
+	for _, delta := range chunk.Choices[0].Delta.ToolCalls {
+		if a.index == nil {
+			a.index = make(map[int64]*ToolCall)
+		}
+
+		call, exists := a.index[delta.Index]
+		if !exists {
+			call = &ToolCall{}
+			a.index[delta.Index] = call
+			a.calls = append(a.calls, call)
+		}
+
+		if delta.ID != "" {
+			call.Id = delta.ID
+		}
+		call.Name += delta.Function.Name
+		call.Arguments += delta.Function.Arguments
+	}
+}
+
+func (a *toolCallAccumulator) reset() {
+	a.calls = nil
+	a.index = nil
+}
@@ -0,0 +1,58 @@
+package services_test
+
+import (
+	"encoding/json"
+	"testing"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestToolboxRun(t *testing.T) {
+	echo := services.Tool{
+		Name: "echo",
+		Run: func(e *core.RequestEvent, userId string, arguments json.RawMessage) (any, error) {
+			var args map[string]any
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return nil, err
+			}
+			return map[string]any{"user": userId, "args": args}, nil
+		},
+	}
+
+	toolbox := services.NewToolboxWithTools(nil, "user1", []services.Tool{echo})
+
+	var started, finished int
+	toolbox.OnCall = func(call services.ToolCall) { started++ }
+	toolbox.OnResult = func(call services.ToolCall) { finished++ }
+
+	ok := toolbox.Run(services.ToolCall{Id: "call_1", Name: "echo", Arguments: `{"text":"hi"}`})
+	if ok.Error != "" || string(ok.Result) != `{"args":{"text":"hi"},"user":"user1"}` {
+		t.Fatalf("unexpected echo call: %+v", ok)
+	}
+
+	if invalid := toolbox.Run(services.ToolCall{Id: "call_2", Name: "echo", Arguments: `{"text":`}); invalid.Error == "" {
+		t.Fatalf("invalid arguments should fail: %+v", invalid)
+	}
+
+	if unknown := toolbox.Run(services.ToolCall{Id: "call_3", Name: "missing"}); unknown.Error == "" {
+		t.Fatalf("unknown tool should fail: %+v", unknown)
+	}
+
+	if len(toolbox.Calls) != 3 || started != 3 || finished != 3 {
+		t.Fatalf("calls were not all recorded: %d calls, %d started, %d finished", len(toolbox.Calls), started, finished)
+	}
+}
+
+func TestDocumentToolsRegistered(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, tool := range services.RegisteredTools() {
+		registered[tool.Name] = true
+	}
+
+	for _, name := range []string{"search_documents", "read_document", "list_folder", "propose_edit", "comment_on_document"} {
+		if !registered[name] {
+			t.Fatalf("tool %s is not registered", name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"textly/queries"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	defaultDocumentTrashRetentionDays = 30
+	documentTrashPurgeJobId           = "purgeDocumentTrash"
+	documentTrashPurgeSchedule        = "45 3 * * *"
+)
+
+// DocumentTrashRetention reads DOCUMENT_TRASH_RETENTION_DAYS, how long documents stay in
+// the trash before they are deleted. Zero disables the purge.
+func DocumentTrashRetention() time.Duration {
+	return retentionFromEnv("DOCUMENT_TRASH_RETENTION_DAYS", defaultDocumentTrashRetentionDays)
+}
+
+// DocumentPurgeDate returns when a document moved to the trash at the given date will be
+// deleted, or an empty string when the trash is kept forever
+func DocumentPurgeDate(deletedAt string) string {
+	return purgeDate(deletedAt, DocumentTrashRetention())
+}
+
+// TrashDocument moves the document and everything below it to the trash and returns the
+// date it was trashed at. Documents below it that were already in the trash keep their
+// own date, so they stay there when the folder is restored.
+func TrashDocument(app core.App, documentId string) (string, error) {
+	deletedAt := types.NowDateTime().String()
+
+	ids, err := queries.SetDocumentDeletedAt(app, documentId, "", deletedAt)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("document %s is already in the trash", documentId)
+	}
+
+	return deletedAt, nil
+}
+
+// RestoreDocument takes the document out of the trash with the documents that were
+// trashed along with it, so a folder comes back with its contents. A document whose
+// folder is gone or still in the trash is restored at the top level of its owner.
+func RestoreDocument(app core.App, documentId string) error {
+	document, err := queries.GetTrashedDocumentById(app, documentId)
+	if err != nil {
+		return err
+	}
+
+	reparent := false
+	if document.Parent != "" {
+		parent, err := app.FindRecordById("documents", document.Parent)
+		reparent = err != nil || parent.GetString("deleted_at") != ""
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		if _, err := queries.SetDocumentDeletedAt(txApp, document.Id, document.DeletedAt, ""); err != nil {
+			return err
+		}
+
+		if !reparent {
+			return nil
+		}
+
+		// Moving goes through the record, so that the document is placed last and its
+		// access no longer comes from the old folder
+		record, err := txApp.FindRecordById("documents", document.Id)
+		if err != nil {
+			return err
+		}
+
+		record.Set("parent", "")
+		return txApp.Save(record)
+	})
+}
+
+// InitializeDocumentTrashPurge schedules the daily purge of the document trash
+func InitializeDocumentTrashPurge(app core.App) {
+	schedulePurge(app, documentTrashPurgeJobId, documentTrashPurgeSchedule, "documents from the trash", PurgeDocumentTrash)
+}
+
+// PurgeDocumentTrash permanently deletes the documents in the trash for longer than the
+// retention period, with everything below them, and returns how many were deleted
+func PurgeDocumentTrash(app core.App) (int, error) {
+	return purgeBefore(app, DocumentTrashRetention(), queries.GetDocumentIdsTrashedBefore, queries.DeleteDocumentPermanently)
+}
@@ -0,0 +1,158 @@
+//go:build !goexperiment.jsonv2
+
+package services_test
+
+import (
+	"slices"
+	"testing"
+	"textly/queries"
+	"textly/services"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestTrashDocumentCascadesToTheFolderSubtree(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, app, "owner@example.com", true)
+
+	folder := createTestDocument(t, app, owner.Id, "Projects", "", "", true)
+	subfolder := createTestDocument(t, app, owner.Id, "Acme", "", folder.Id, true)
+	brief := createTestDocument(t, app, owner.Id, "Brief", "Text", subfolder.Id, false)
+	notes := createTestDocument(t, app, owner.Id, "Notes", "Text", folder.Id, false)
+	outside := createTestDocument(t, app, owner.Id, "Outside", "Text", "", false)
+
+	// Notes went to the trash on their own before the folder
+	if _, err := queries.SetDocumentDeletedAt(app, notes.Id, "", "2026-01-01 10:00:00.000Z"); err != nil {
+		t.Fatal(err)
+	}
+
+	deletedAt, err := services.TrashDocument(app, folder.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{folder.Id, subfolder.Id, brief.Id} {
+		if date := reloadTestRecord(t, app, "documents", id).GetString("deleted_at"); date != deletedAt {
+			t.Fatalf("expected %s in the trash since %q, got %q", id, deletedAt, date)
+		}
+	}
+	if date := reloadTestRecord(t, app, "documents", notes.Id).GetString("deleted_at"); date != "2026-01-01 10:00:00.000Z" {
+		t.Fatalf("expected the notes to keep their own trash date, got %q", date)
+	}
+	if date := reloadTestRecord(t, app, "documents", outside.Id).GetString("deleted_at"); date != "" {
+		t.Fatalf("expected the document outside the folder to stay, got %q", date)
+	}
+
+	if _, err := services.TrashDocument(app, folder.Id); err == nil {
+		t.Fatal("expected an error when the folder is already in the trash")
+	}
+
+	trashed, err := queries.GetTrashedDocumentsByUserId(app, owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make([]string, len(trashed))
+	for i, document := range trashed {
+		listed[i] = document.Id
+	}
+	if !slices.Equal(listed, []string{folder.Id, notes.Id}) {
+		t.Fatalf("expected the trash to list the folder and the notes, got %v", listed)
+	}
+
+	if err := services.RestoreDocument(app, folder.Id); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{folder.Id, subfolder.Id, brief.Id} {
+		if date := reloadTestRecord(t, app, "documents", id).GetString("deleted_at"); date != "" {
+			t.Fatalf("expected %s to come back with the folder, got %q", id, date)
+		}
+	}
+	if date := reloadTestRecord(t, app, "documents", notes.Id).GetString("deleted_at"); date == "" {
+		t.Fatal("expected the notes trashed on their own to stay in the trash")
+	}
+}
+
+func TestRestoreDocumentMovesToTheTopWhenTheFolderIsTrashed(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, app, "owner@example.com", true)
+
+	folder := createTestDocument(t, app, owner.Id, "Projects", "", "", true)
+	brief := createTestDocument(t, app, owner.Id, "Brief", "Text", folder.Id, false)
+	kept := createTestDocument(t, app, owner.Id, "Kept", "Text", folder.Id, false)
+
+	if _, err := queries.SetDocumentDeletedAt(app, brief.Id, "", "2026-01-01 10:00:00.000Z"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.TrashDocument(app, folder.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := services.RestoreDocument(app, brief.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := reloadTestRecord(t, app, "documents", brief.Id)
+	if restored.GetString("deleted_at") != "" {
+		t.Fatalf("expected the brief out of the trash, got %q", restored.GetString("deleted_at"))
+	}
+	if restored.GetString("parent") != "" {
+		t.Fatalf("expected the brief at the top level while its folder is in the trash, got parent %q", restored.GetString("parent"))
+	}
+
+	stored := reloadTestRecord(t, app, "documents", kept.Id)
+	if stored.GetString("deleted_at") == "" || stored.GetString("parent") != folder.Id {
+		t.Fatalf("expected the other document to stay in the trashed folder, got %q in %q", stored.GetString("deleted_at"), stored.GetString("parent"))
+	}
+}
+
+func TestPurgeDocumentTrashDeletesDeepestFirst(t *testing.T) {
+	t.Setenv("DOCUMENT_TRASH_RETENTION_DAYS", "30")
+
+	app := newTestApp(t)
+	owner := createTestUser(t, app, "owner@example.com", true)
+
+	folder := createTestDocument(t, app, owner.Id, "Projects", "", "", true)
+	subfolder := createTestDocument(t, app, owner.Id, "Acme", "", folder.Id, true)
+	brief := createTestDocument(t, app, owner.Id, "Brief", "Text", subfolder.Id, false)
+	recent := createTestDocument(t, app, owner.Id, "Recent", "Text", "", false)
+
+	if _, err := queries.SetDocumentDeletedAt(app, folder.Id, "", "2020-01-01 10:00:00.000Z"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.TrashDocument(app, recent.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	app.OnRecordDelete("documents").BindFunc(func(e *core.RecordEvent) error {
+		deleted = append(deleted, e.Record.Id)
+		return e.Next()
+	})
+
+	purged, err := services.PurgeDocumentTrash(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected the folder alone to be purged, got %d", purged)
+	}
+	if !slices.Equal(deleted, []string{brief.Id, subfolder.Id, folder.Id}) {
+		t.Fatalf("expected the folder to be deleted deepest first, got %v", deleted)
+	}
+	if _, err := app.FindRecordById("documents", recent.Id); err != nil {
+		t.Fatalf("expected the document trashed within the retention to stay: %v", err)
+	}
+
+	deleted = nil
+	if err := queries.DeleteDocumentPermanently(app, recent.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deleted, []string{recent.Id}) {
+		t.Fatalf("expected the document alone to be deleted, got %v", deleted)
+	}
+
+	t.Setenv("DOCUMENT_TRASH_RETENTION_DAYS", "0")
+	if purged, err := services.PurgeDocumentTrash(app); err != nil || purged != 0 {
+		t.Fatalf("expected no purge when the retention is zero, got %d and %v", purged, err)
+	}
+}